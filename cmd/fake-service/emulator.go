@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/googleinterns/recomator/pkg/emulator"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/recommender/v1"
+)
+
+// newEmulator creates the emulator of Google APIs with the recommendations,
+// the projects and the resources they refer to, so that they can be applied.
+func newEmulator(recommendations []*gcloudRecommendation) *emulator.Emulator {
+	fake := emulator.New()
+	projects := make(map[string]bool)
+	instances := make(map[string]*compute.Instance) // the key is the path of the instance
+	disks := make(map[string]bool)
+	for _, rec := range recommendations {
+		number := strings.Split(rec.Name, "/")[1]
+		for _, group := range rec.Content.OperationGroups {
+			for _, operation := range group.Operations {
+				// for example projects/my-project/zones/us-central1-a/instances/my-instance
+				path := strings.TrimPrefix(operation.Resource, "//compute.googleapis.com/")
+				segments := strings.Split(path, "/")
+				if len(segments) != 6 || segments[2] != "zones" {
+					continue
+				}
+				project, zone, name := segments[1], segments[3], segments[5]
+				if !projects[project] {
+					projects[project] = true
+					fake.AddProject(project, number, nil)
+				}
+				switch operation.ResourceType {
+				case "compute.googleapis.com/Instance":
+					instance, ok := instances[path]
+					if !ok {
+						instance = &compute.Instance{Name: name}
+						instances[path] = instance
+					}
+					if operation.Action != "test" {
+						continue
+					}
+					if operation.ValueMatcher != nil && operation.Path == "/machineType" {
+						instance.MachineType = strings.TrimPrefix(operation.ValueMatcher.MatchesPattern, ".*")
+					}
+					if status, ok := operation.Value.(string); ok && operation.Path == "/status" {
+						instance.Status = status
+					}
+				case "compute.googleapis.com/Disk":
+					if !disks[path] {
+						disks[path] = true
+						fake.AddDisk(project, zone, &compute.Disk{Name: name})
+					}
+				}
+			}
+		}
+		for _, insight := range rec.AssociatedInsights {
+			fake.AddInsight(&recommender.GoogleCloudRecommenderV1Insight{Name: insight.Insight, Description: rec.Description})
+		}
+		fake.AddRecommendation((*recommender.GoogleCloudRecommenderV1Recommendation)(rec))
+	}
+	for path, instance := range instances {
+		segments := strings.Split(path, "/")
+		fake.AddInstance(segments[1], segments[3], instance)
+	}
+	return fake
+}
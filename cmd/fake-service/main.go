@@ -376,6 +376,10 @@ func main() {
 		c.JSON(http.StatusOK, response)
 	})
 
+	// requests of Google APIs, for example sent by recomator-cli -endpoint http://localhost:8000,
+	// are handled by the emulator with the same recommendations
+	router.NoRoute(gin.WrapH(newEmulator(recommendations).Handler()))
+
 	router.Run(":8000")
 
 }
@@ -16,5 +16,436 @@ limitations under the License.
 
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+const usage = `Usage: recomator-cli [global flags] <command> [flags]
+
+Commands:
+  projects      lists the projects available for the credentials
+  requirements  checks whether the required permissions and APIs are in place
+  list          lists recommendations for projects
+  apply         applies recommendations with the given names
+  snapshots     lists snapshots created by Recomator, deletes the expired ones with -delete-expired
+
+Global flags:
+`
+
+// jsonFormat is the default output format of list command, the whole result as one JSON object.
+const jsonFormat = "json"
+
+const (
+	defaultNumConcurrentCalls = 200
+	progressRefreshTime       = 500 * time.Millisecond
+)
+
+// options contains the flags shared by all commands.
+type options struct {
+	credentialsFile string
+	endpoint        string
+	quiet           bool
+}
+
 func main() {
+	var opts options
+	flags := flag.NewFlagSet("recomator-cli", flag.ExitOnError)
+	flags.StringVar(&opts.credentialsFile, "credentials", "",
+		"path to a service account key file, Application Default Credentials are used if empty")
+	flags.StringVar(&opts.endpoint, "endpoint", "",
+		"URL of an emulator of Google APIs, for example http://localhost:8000 started by cmd/fake-service")
+	flags.BoolVar(&opts.quiet, "quiet", false, "don't show progress")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func(context.Context, automation.GoogleService, options, []string) error{
+		"projects":     projectsCommand,
+		"requirements": requirementsCommand,
+		"list":         listCommand,
+		"apply":        applyCommand,
+		"snapshots":    snapshotsCommand,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	service, err := newService(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating Google service: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := command(interruptibleContext(), service, opts, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// interruptibleContext returns a context that is canceled on the first interrupt signal.
+// Applying is then stopped safely after the current operation, the second signal kills the program.
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping...")
+		signal.Stop(interrupts)
+		cancel()
+	}()
+	return ctx
+}
+
+// newService creates GoogleService authenticated with the key file
+// specified in opts or with Application Default Credentials,
+// or sending requests to the emulator at the endpoint specified in opts.
+func newService(opts options) (automation.GoogleService, error) {
+	if opts.endpoint != "" {
+		return automation.NewEmulatedGoogleService(context.Background(), opts.endpoint)
+	}
+	var clientOptions []option.ClientOption
+	if opts.credentialsFile != "" {
+		clientOptions = append(clientOptions, option.WithCredentialsFile(opts.credentialsFile))
+	}
+	return automation.NewGoogleServiceWithOptions(context.Background(), clientOptions...)
+}
+
+// runWithProgress calls run and, unless opts.quiet is set,
+// shows the progress of the task on stderr until run returns.
+func runWithProgress(opts options, label string, task *automation.Task, run func()) {
+	if opts.quiet {
+		run()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		run()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(progressRefreshTime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			fmt.Fprintf(os.Stderr, "\r%s: done    \n", label)
+			return
+		case <-ticker.C:
+			numerator, denominator := task.GetProgress()
+			if denominator == 0 {
+				// the number of subtasks isn't known yet
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "\r%s: %5.1f%%", label, 100*float64(numerator)/float64(denominator))
+		}
+	}
+}
+
+// parseProjects splits the comma-separated list of projects.
+// If the list is empty, all projects available for the service are returned.
+func parseProjects(ctx context.Context, service automation.GoogleService, projects string) ([]string, error) {
+	if projects == "" {
+		return service.ListProjects(ctx)
+	}
+	var result []string
+	for _, project := range strings.Split(projects, ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			result = append(result, project)
+		}
+	}
+	return result, nil
+}
+
+func projectsCommand(ctx context.Context, service automation.GoogleService, opts options, args []string) error {
+	flags := flag.NewFlagSet("projects", flag.ExitOnError)
+	flags.Parse(args)
+
+	projects, err := service.ListProjects(ctx)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		fmt.Println(project)
+	}
+	return nil
+}
+
+func requirementsCommand(ctx context.Context, service automation.GoogleService, opts options, args []string) error {
+	flags := flag.NewFlagSet("requirements", flag.ExitOnError)
+	projectsFlag := flags.String("projects", "", "comma-separated list of projects, all available projects if empty")
+	flags.Parse(args)
+
+	projects, err := parseProjects(ctx, service, *projectsFlag)
+	if err != nil {
+		return err
+	}
+
+	var result []*automation.ProjectRequirements
+	task := &automation.Task{}
+	runWithProgress(opts, "Checking requirements", task, func() {
+		result, err = automation.ListRequirements(ctx, service, projects, task)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, projectRequirements := range result {
+		var failed []*automation.Requirement
+		for _, requirement := range projectRequirements.Requirements {
+			if !requirement.Satisfied {
+				failed = append(failed, requirement)
+			}
+		}
+		if len(failed) == 0 && len(projectRequirements.Recommenders) == 0 {
+			fmt.Printf("%s: all requirements satisfied\n", projectRequirements.Project)
+			continue
+		}
+		if len(failed) != 0 {
+			fmt.Printf("%s: %d requirements not satisfied\n", projectRequirements.Project, len(failed))
+			for _, requirement := range failed {
+				fmt.Printf("  %s: %s\n", requirement.Name, requirement.ErrorMessage)
+			}
+		}
+		for _, recommender := range projectRequirements.Recommenders {
+			fmt.Printf("%s: %d requirements of %s not satisfied\n", projectRequirements.Project, len(recommender.Requirements), recommender.Recommender)
+			for _, requirement := range recommender.Requirements {
+				fmt.Printf("  %s: %s\n", requirement.Name, requirement.ErrorMessage)
+			}
+		}
+	}
+	return nil
+}
+
+func listCommand(ctx context.Context, service automation.GoogleService, opts options, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	projectsFlag := flags.String("projects", "", "comma-separated list of projects, all available projects if empty")
+	numConcurrentCalls := flags.Int("concurrency", defaultNumConcurrentCalls, "maximum number of concurrent calls to Recommender API")
+	filter := flags.String("filter", "", "Recommender API filter, for example \"stateInfo.state = ACTIVE\"")
+	format := flags.String("format", jsonFormat, "output format: json, csv or jsonl")
+	failedOutput := flags.String("failed-projects-output", "",
+		"file for the requirements of projects that couldn't be listed, used with csv and jsonl formats")
+	flags.Parse(args)
+
+	if *format != jsonFormat && *format != automation.ExportCSV && *format != automation.ExportJSONL {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+
+	projects, err := parseProjects(ctx, service, *projectsFlag)
+	if err != nil {
+		return err
+	}
+
+	var result *automation.ListResult
+	task := &automation.Task{}
+	runWithProgress(opts, "Listing recommendations", task, func() {
+		result, err = automation.ListProjectsRecommendations(ctx, service, projects, *filter, *numConcurrentCalls, task)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *format == jsonFormat {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	if err := automation.ExportRecommendations(os.Stdout, *format, result.Recommendations, result.Priorities); err != nil {
+		return err
+	}
+	for _, recommender := range result.FailedRecommenders {
+		fmt.Fprintf(os.Stderr, "%s: requirements of %s not satisfied, its recommendations not listed\n", recommender.Project, recommender.Recommender)
+	}
+	return exportFailedProjects(*format, *failedOutput, result.FailedProjects)
+}
+
+// exportFailedProjects writes the requirements of failed projects to the file at path.
+// If path is empty, only the names of the projects are shown on stderr.
+func exportFailedProjects(format, path string, failedProjects []*automation.ProjectRequirements) error {
+	if path == "" {
+		for _, project := range failedProjects {
+			fmt.Fprintf(os.Stderr, "%s: requirements not satisfied, recommendations not listed\n", project.Project)
+		}
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := automation.ExportFailedProjects(file, format, failedProjects); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func applyCommand(ctx context.Context, service automation.GoogleService, opts options, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: recomator-cli apply <recommendation name>...")
+		flags.PrintDefaults()
+	}
+	optOutLabel := flags.String("opt-out-label", "", "skip instances and disks with this label set to true")
+	windows := flags.String("maintenance-windows", "", "change resources only in maintenance windows of projects, "+
+		"for example \"project-a=Sat,Sun 00:00-06:00;*=Mon-Fri 01:00-05:00\" (UTC)")
+	minDiskAge := flags.Duration("min-disk-age", automation.DefaultMinDiskAge, "minimum age of deleted disks")
+	snapshotDescription := flags.String("snapshot-description", "", "description of snapshots created before deleting disks")
+	snapshotLocation := flags.String("snapshot-storage-location", "", "storage location of snapshots, for example us")
+	snapshotLabels := flags.String("snapshot-labels", "", "labels added to snapshots, for example team=web,env=prod")
+	snapshotRetention := flags.Duration("snapshot-retention", 0, "how long snapshots are kept, for example 720h, forever if 0")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no recommendation names specified")
+	}
+
+	if *optOutLabel != "" || *windows != "" || *minDiskAge != automation.DefaultMinDiskAge {
+		safeguards := &automation.Safeguards{OptOutLabel: *optOutLabel, MinDiskAge: *minDiskAge}
+		var err error
+		if safeguards.MaintenanceWindows, err = automation.ParseMaintenanceWindows(*windows); err != nil {
+			return err
+		}
+		ctx = automation.WithSafeguards(ctx, safeguards)
+	}
+
+	snapshotOptions := &automation.SnapshotOptions{Description: *snapshotDescription, Retention: *snapshotRetention}
+	if *snapshotLocation != "" {
+		snapshotOptions.StorageLocations = []string{*snapshotLocation}
+	}
+	var err error
+	if snapshotOptions.Labels, err = automation.ParseSnapshotLabels(*snapshotLabels); err != nil {
+		return err
+	}
+	ctx = automation.WithSnapshotOptions(ctx, snapshotOptions)
+
+	numFailed, numSkipped := 0, 0
+	for _, name := range flags.Args() {
+		var results []*automation.OperationResult
+		var err error
+		task := &automation.Task{}
+		runWithProgress(opts, "Applying "+name, task, func() {
+			results, err = automation.ApplyByName(ctx, service, name, task)
+		})
+		if automation.IsSkipped(err) {
+			numSkipped++
+			fmt.Printf("%s: SKIPPED: %v\n", name, err)
+		} else if err != nil {
+			numFailed++
+			fmt.Printf("%s: FAILED: %v\n", name, err)
+			var applyErr *automation.ApplyError
+			if errors.As(err, &applyErr) && applyErr.Rollback != nil {
+				printRollback(applyErr.Rollback)
+			}
+		} else {
+			fmt.Printf("%s: SUCCEEDED\n", name)
+		}
+		printResults(results)
+	}
+
+	if numFailed != 0 {
+		return fmt.Errorf("%d of %d recommendations failed to apply", numFailed, flags.NArg())
+	}
+	if numSkipped != 0 {
+		fmt.Printf("%d of %d recommendations skipped\n", numSkipped, flags.NArg())
+	}
+	return nil
+}
+
+func snapshotsCommand(ctx context.Context, service automation.GoogleService, opts options, args []string) error {
+	flags := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	projectsFlag := flags.String("projects", "", "comma-separated list of projects, all available projects if empty")
+	retention := flags.Duration("retention", 0, "snapshots older than this are expired, for example 720h; "+
+		"if 0, only the expiry dates set when the snapshots were created are checked")
+	expiredOnly := flags.Bool("expired", false, "list only expired snapshots")
+	deleteExpired := flags.Bool("delete-expired", false, "delete expired snapshots")
+	flags.Parse(args)
+
+	projects, err := parseProjects(ctx, service, *projectsFlag)
+	if err != nil {
+		return err
+	}
+
+	numDeleted := 0
+	for _, project := range projects {
+		var snapshots []*compute.Snapshot
+		if *expiredOnly || *deleteExpired {
+			snapshots, err = automation.ListExpiredSnapshots(ctx, service, project, *retention)
+		} else {
+			snapshots, err = automation.ListRecomatorSnapshots(ctx, service, project)
+		}
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			if !*deleteExpired {
+				fmt.Printf("%s\t%s\t%s\t%s\n", project, snapshot.Name, snapshot.CreationTimestamp, snapshot.Description)
+				continue
+			}
+			if err := service.DeleteSnapshot(ctx, project, snapshot.Name); err != nil {
+				return fmt.Errorf("deleting snapshot %s failed: %v", snapshot.Name, err)
+			}
+			numDeleted++
+			fmt.Printf("%s\t%s\tdeleted\n", project, snapshot.Name)
+		}
+	}
+	if *deleteExpired {
+		fmt.Printf("%d expired snapshots deleted\n", numDeleted)
+	}
+	return nil
+}
+
+// printResults prints the changes made by applying a recommendation,
+// so that for example deleted disks can be restored from their snapshots.
+func printResults(results []*automation.OperationResult) {
+	for _, result := range results {
+		fmt.Printf("  %s %s", result.Action, result.Resource)
+		if result.PreviousValue != "" {
+			fmt.Printf(" (was %s)", result.PreviousValue)
+		}
+		if result.SnapshotName != "" {
+			fmt.Printf(", snapshot %s", result.SnapshotName)
+		}
+		fmt.Println()
+	}
+}
+
+// printRollback prints the outcome of reverting changes made by a failed apply.
+func printRollback(rollback *automation.RollbackResult) {
+	if rollback.Succeeded {
+		fmt.Println("  changes were rolled back:")
+	} else {
+		fmt.Println("  rolling back changes failed:")
+	}
+	for _, action := range rollback.Actions {
+		if action.Succeeded {
+			fmt.Printf("    %s: done\n", action.Description)
+		} else {
+			fmt.Printf("    %s: %s\n", action.Description, action.ErrorMessage)
+		}
+	}
 }
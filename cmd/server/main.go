@@ -17,38 +17,280 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/googleinterns/recomator/pkg/audit"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/googleinterns/recomator/pkg/scheduler"
 	"github.com/googleinterns/recomator/pkg/server"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
 )
 
-func main() {
+// Values of authMode setting.
+const (
+	// users log in with OAuth and the server acts with their tokens
+	oauthMode = "oauth"
+	// the server acts with its own credentials, users are identified by ID tokens or IAP JWTs
+	serviceAccountMode = "serviceAccount"
+)
+
+// schedulerActor is who made the changes by applying policies, in the audit log.
+const schedulerActor = "scheduler"
+
+// settings are read from config.json, or from environment variables if there's no such file.
+type settings struct {
+	data map[string]string
+}
+
+func readSettings() (*settings, error) {
 	byt, err := ioutil.ReadFile("config.json")
-	var clientID, clientSecret, redirectURL string
-	if err == nil {
-		var data map[string]string
-		if err := json.Unmarshal(byt, &data); err != nil {
-			log.Fatal(err)
+	if err != nil {
+		return &settings{}, nil
+	}
+	var data map[string]string
+	if err := json.Unmarshal(byt, &data); err != nil {
+		return nil, err
+	}
+	return &settings{data: data}, nil
+}
+
+// get returns the value of key from config.json, or of environment variable envName.
+func (s *settings) get(key, envName string) string {
+	if s.data != nil {
+		return s.data[key]
+	}
+	return os.Getenv(envName)
+}
+
+// requestsLimits returns the limits of requests, set to defaults unless specified.
+func (s *settings) requestsLimits() (*server.RequestsLimits, error) {
+	limits := server.DefaultRequestsLimits()
+	var err error
+	if value := s.get("finishedRequestsRetention", "FINISHED_REQUESTS_RETENTION"); value != "" {
+		if limits.FinishedRetention, err = time.ParseDuration(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := s.get("unfinishedRequestsRetention", "UNFINISHED_REQUESTS_RETENTION"); value != "" {
+		if limits.UnfinishedRetention, err = time.ParseDuration(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := s.get("maxRequestsPerUser", "MAX_REQUESTS_PER_USER"); value != "" {
+		if limits.MaxRequestsPerUser, err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+	}
+	return &limits, nil
+}
+
+// safeguards reads the opt-out label, maintenance windows and the minimum age of deleted disks.
+// The label defaults to automation.DefaultOptOutLabel, "none" disables it.
+func (s *settings) safeguards() (*automation.Safeguards, error) {
+	safeguards := &automation.Safeguards{OptOutLabel: automation.DefaultOptOutLabel}
+	switch label := s.get("optOutLabel", "OPT_OUT_LABEL"); label {
+	case "":
+	case "none":
+		safeguards.OptOutLabel = ""
+	default:
+		safeguards.OptOutLabel = label
+	}
+	if windows := s.get("maintenanceWindows", "MAINTENANCE_WINDOWS"); windows != "" {
+		var err error
+		if safeguards.MaintenanceWindows, err = automation.ParseMaintenanceWindows(windows); err != nil {
+			return nil, err
+		}
+	}
+	if age := s.get("minDiskAge", "MIN_DISK_AGE"); age != "" {
+		var err error
+		if safeguards.MinDiskAge, err = time.ParseDuration(age); err != nil {
+			return nil, err
 		}
-		clientID = data["clientID"]
-		clientSecret = data["clientSecret"]
-		redirectURL = data["redirectURL"]
+	}
+	return safeguards, nil
+}
+
+// snapshotOptions reads the description, storage location, labels and retention
+// of the snapshots created before deleting disks.
+func (s *settings) snapshotOptions() (*automation.SnapshotOptions, error) {
+	options := &automation.SnapshotOptions{Description: s.get("snapshotDescription", "SNAPSHOT_DESCRIPTION")}
+	if location := s.get("snapshotStorageLocation", "SNAPSHOT_STORAGE_LOCATION"); location != "" {
+		options.StorageLocations = []string{location}
+	}
+	var err error
+	if options.Labels, err = automation.ParseSnapshotLabels(s.get("snapshotLabels", "SNAPSHOT_LABELS")); err != nil {
+		return nil, err
+	}
+	if retention := s.get("snapshotRetention", "SNAPSHOT_RETENTION"); retention != "" {
+		if options.Retention, err = time.ParseDuration(retention); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// auditSink returns the sink of the audit log: a JSON Lines file, stdout in Cloud Logging format
+// and a webhook, or all of them. Returns nil if none is configured.
+func (s *settings) auditSink() audit.Sink {
+	var sinks []audit.Sink
+	// the file is first, so that it's queried
+	if path := s.get("auditLogPath", "AUDIT_LOG_PATH"); path != "" {
+		sinks = append(sinks, audit.NewFileSink(path))
+	}
+	if stdout := s.get("auditStdout", "AUDIT_STDOUT"); stdout == "true" {
+		sinks = append(sinks, audit.NewCloudLoggingSink(os.Stdout))
+	}
+	if url := s.get("auditWebhookURL", "AUDIT_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, audit.NewWebhookSink(url, &http.Client{Timeout: 10 * time.Second}))
+	}
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0]
+	}
+	return audit.NewMultiSink(sinks...)
+}
+
+// startScheduler starts running the policies from the file at policiesPath in the background.
+// Recommendations are applied with the server's own credentials, from credentialsFile
+// or Application Default Credentials, or with the emulator of Google APIs at apiEndpoint if it's set.
+// Runs are logged, and appended to the file at runsPath if it's set.
+// Operations refused by safeguards are skipped, created snapshots are configured by snapshotOptions.
+// If auditSink is not nil, changes are recorded there.
+func startScheduler(policiesPath, runsPath, credentialsFile, apiEndpoint string, safeguards *automation.Safeguards,
+	snapshotOptions *automation.SnapshotOptions, auditSink audit.Sink) error {
+	policies, err := scheduler.LoadPolicies(policiesPath)
+	if err != nil {
+		return err
+	}
+	var service automation.GoogleService
+	if apiEndpoint != "" {
+		service, err = automation.NewEmulatedGoogleService(context.Background(), apiEndpoint)
 	} else {
-		clientID = os.Getenv("CLIENT_ID")
-		clientSecret = os.Getenv("CLIENT_SECRET")
-		redirectURL = os.Getenv("REDIRECT_URL")
+		var opts []option.ClientOption
+		if credentialsFile != "" {
+			opts = append(opts, option.WithCredentialsFile(credentialsFile))
+		}
+		service, err = automation.NewGoogleServiceWithOptions(context.Background(), opts...)
+	}
+	if err != nil {
+		return err
+	}
+	if auditSink != nil {
+		service = audit.NewService(service, auditSink, schedulerActor)
+	}
+	var recorder scheduler.RunRecorder
+	if runsPath != "" {
+		recorder = scheduler.NewFileRunRecorder(runsPath)
+	}
+	ctx := automation.WithSafeguards(context.Background(), safeguards)
+	ctx = automation.WithSnapshotOptions(ctx, snapshotOptions.WithUser(schedulerActor))
+	go scheduler.NewScheduler(service, policies, recorder).Run(ctx)
+	return nil
+}
+
+func main() {
+	settings, err := readSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientID := settings.get("clientID", "CLIENT_ID")
+	clientSecret := settings.get("clientSecret", "CLIENT_SECRET")
+	redirectURL := settings.get("redirectURL", "REDIRECT_URL")
+	requestStorePath := settings.get("requestStorePath", "REQUEST_STORE_PATH")
+	tokenStorePath := settings.get("tokenStorePath", "TOKEN_STORE_PATH")
+	tokenEncryptionKey := settings.get("tokenEncryptionKey", "TOKEN_ENCRYPTION_KEY")
+
+	authMode := settings.get("authMode", "AUTH_MODE")
+	allowedProjects := settings.get("allowedProjects", "ALLOWED_PROJECTS")
+
+	limits, err := settings.requestsLimits()
+	if err != nil {
+		log.Fatal(err)
+	}
+	options := server.Options{RequestsLimits: limits}
+
+	conf := &oauth2.Config{}
+	switch authMode {
+	case "", oauthMode:
+		if conf, err = server.NewConfig(clientID, clientSecret, redirectURL); err != nil {
+			log.Fatal(err)
+		}
+	case serviceAccountMode:
+		config := server.ServiceAccountConfig{
+			CredentialsFile: settings.get("credentialsFile", "CREDENTIALS_FILE"),
+			IDTokenAudience: settings.get("idTokenAudience", "ID_TOKEN_AUDIENCE"),
+			IAPAudience:     settings.get("iapAudience", "IAP_AUDIENCE"),
+			AllowedEmails:   server.ParseAllowedEmails(settings.get("allowedEmails", "ALLOWED_EMAILS")),
+			APIEndpoint:     settings.get("apiEndpoint", "API_ENDPOINT"),
+		}
+		// all users share the permissions of the service account, so they must be limited explicitly
+		if allowedProjects == "" {
+			log.Fatal("Allowed projects must be set in the serviceAccount mode, use *=* to allow all projects to all users")
+		}
+		if options.Auth, err = server.NewServiceAccountAuthorizationService(context.Background(), config); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("Unknown auth mode %s, expected %s or %s", authMode, oauthMode, serviceAccountMode)
+	}
+
+	if allowedProjects != "" {
+		if options.ProjectAllowlist, err = server.ParseProjectAllowlist(allowedProjects); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	var conf *oauth2.Config
-	if conf, err = server.NewConfig(clientID, clientSecret, redirectURL); err != nil {
+	options.AuditSink = settings.auditSink()
+	options.Safeguards, err = settings.safeguards()
+	if err != nil {
 		log.Fatal(err)
 	}
+	options.SnapshotOptions, err = settings.snapshotOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// requests are kept only in memory, unless the path of the BoltDB file is specified
+	if requestStorePath != "" {
+		store, err := server.NewBoltRequestStore(requestStorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		options.RequestStore = store
+	}
+
+	// tokens are kept only in memory, unless the directory for them is specified
+	if tokenStorePath != "" {
+		key, err := base64.StdEncoding.DecodeString(tokenEncryptionKey)
+		if err != nil {
+			log.Fatalf("Invalid token encryption key: %v", err)
+		}
+		if options.TokenStore, err = server.NewFileTokenStore(tokenStorePath, key); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if policiesPath := settings.get("policiesPath", "POLICIES_PATH"); policiesPath != "" {
+		err := startScheduler(policiesPath, settings.get("policyRunsPath", "POLICY_RUNS_PATH"),
+			settings.get("credentialsFile", "CREDENTIALS_FILE"), settings.get("apiEndpoint", "API_ENDPOINT"), options.Safeguards, options.SnapshotOptions, options.AuditSink)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	service, err := server.NewSharedService(*conf)
+	service, err := server.NewSharedService(*conf, options)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -19,6 +19,7 @@ const internalStatusMap: Record<string, string> = {
   CLAIMED: "In progress",
   SUCCEEDED: "Success",
   FAILED: "Failed",
+  SKIPPED: "Skipped",
   DISMISSED: "Dismissed"
 };
 
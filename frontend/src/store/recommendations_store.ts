@@ -311,6 +311,19 @@ const actions: ActionTree<IRecommendationsStoreState, IRootStoreState> = {
         });
         return false;
 
+      // safeguards (opt-out label, maintenance window) refused to change the resources
+      case "SKIPPED":
+        commit("setRecommendationStatus", {
+          recName: rec.name,
+          newStatus: "SKIPPED"
+        });
+        commit("setRecommendationError", {
+          recName: rec.name,
+          header: "Recommendation was skipped, nothing has been changed.",
+          desc: `${responseJson.errorMessage}`
+        });
+        return false;
+
       default:
         commit("setRecommendationStatus", {
           recName: rec.name,
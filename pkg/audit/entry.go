@@ -0,0 +1,82 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records the changes made to resources and recommendations
+// in an append-only audit log.
+package audit
+
+import (
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+)
+
+// Entry records one call of a GoogleService method changing a resource or a recommendation.
+// Actor is who made the call, for example the email of the user.
+// Recommendation and Etag identify the recommendation, which was applied or whose state was changed.
+// RequestID is the ID of the request sent to Compute Engine API, if the method uses it.
+// Value is the new value set by the call, for example the machine type or the name of the snapshot.
+// Results are recorded with marking the applied recommendation succeeded or failed,
+// they describe the changes made by applying it, for example the snapshots of deleted disks.
+// With reverting, Results are the reverting changes, and Remaining are the changes of the apply,
+// which are left to revert after reverting failed.
+type Entry struct {
+	Time           time.Time                     `json:"time"`
+	FinishTime     time.Time                     `json:"finishTime"`
+	Actor          string                        `json:"actor"`
+	Recommendation string                        `json:"recommendation,omitempty"`
+	Etag           string                        `json:"etag,omitempty"`
+	Method         string                        `json:"method"`
+	Project        string                        `json:"project,omitempty"`
+	Location       string                        `json:"location,omitempty"`
+	Resource       string                        `json:"resource"`
+	Value          string                        `json:"value,omitempty"`
+	RequestID      string                        `json:"requestId,omitempty"`
+	Succeeded      bool                          `json:"succeeded"`
+	Error          string                        `json:"error,omitempty"`
+	Results        []*automation.OperationResult `json:"results,omitempty"`
+	Remaining      []*automation.OperationResult `json:"remaining,omitempty"`
+}
+
+// Filter selects entries from the audit log. Zero values match every entry.
+// From and To limit the time of entries, From inclusive and To exclusive.
+type Filter struct {
+	Actor          string
+	Project        string
+	Recommendation string
+	From           time.Time
+	To             time.Time
+}
+
+// Matches checks whether the entry is selected by the filter.
+func (f *Filter) Matches(entry *Entry) bool {
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Project != "" && entry.Project != f.Project {
+		return false
+	}
+	if f.Recommendation != "" && entry.Recommendation != f.Recommendation {
+		return false
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
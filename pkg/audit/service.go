@@ -0,0 +1,221 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/recommender/v1"
+)
+
+type gcloudRecommendation = recommender.GoogleCloudRecommenderV1Recommendation
+
+// auditedService writes an entry to the sink for every call of the embedded GoogleService
+// changing a resource or a recommendation. Every such method must be overridden here.
+type auditedService struct {
+	automation.GoogleService
+	sink  Sink
+	actor string
+	now   func() time.Time
+}
+
+// NewService returns GoogleService recording the changes made with service by actor in sink.
+// The calls made by automation.Apply are recorded with the applied recommendation.
+// Errors of the sink are logged, they don't fail the calls.
+func NewService(service automation.GoogleService, sink Sink, actor string) automation.GoogleService {
+	return &auditedService{GoogleService: service, sink: sink, actor: actor, now: time.Now}
+}
+
+// record calls the method with ctx and writes its outcome in entry to the sink.
+// If withRequestID is true, the Compute Engine request ID is set in ctx and recorded.
+func (s *auditedService) record(ctx context.Context, entry *Entry, withRequestID bool, method func(ctx context.Context) error) error {
+	entry.Actor = s.actor
+	if entry.Recommendation == "" {
+		entry.Recommendation, entry.Etag, _ = automation.AppliedRecommendation(ctx)
+	}
+	if withRequestID {
+		entry.RequestID = uuid.New().String()
+		ctx = automation.WithRequestID(ctx, entry.RequestID)
+	}
+	entry.Time = s.now()
+	err := method(ctx)
+	entry.FinishTime = s.now()
+	entry.Succeeded = err == nil
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if errSink := s.sink.Write(entry); errSink != nil {
+		log.Printf("Error writing audit log entry for %s of %s: %v", entry.Method, entry.Resource, errSink)
+	}
+	return err
+}
+
+var projectRegexp = regexp.MustCompile("^projects/([^/]+)/")
+
+// recordMarking records the call changing the state of the recommendation.
+func (s *auditedService) recordMarking(ctx context.Context, method, name, etag, reason string,
+	mark func(ctx context.Context) (*gcloudRecommendation, error)) (*gcloudRecommendation, error) {
+	entry := &Entry{Method: method, Resource: name, Recommendation: name, Etag: etag, Value: reason,
+		Results: automation.OperationResults(ctx)}
+	if match := projectRegexp.FindStringSubmatch(name); match != nil {
+		entry.Project = match[1]
+	}
+	var result *gcloudRecommendation
+	err := s.record(ctx, entry, false, func(ctx context.Context) error {
+		var err error
+		result, err = mark(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (s *auditedService) ChangeMachineType(ctx context.Context, project, zone, instance, machineType string) error {
+	entry := &Entry{Method: "ChangeMachineType", Project: project, Location: zone, Resource: instance, Value: machineType}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.ChangeMachineType(ctx, project, zone, instance, machineType)
+	})
+}
+
+func (s *auditedService) CreateDisk(ctx context.Context, project, zone string, disk *compute.Disk) error {
+	entry := &Entry{Method: "CreateDisk", Project: project, Location: zone, Resource: disk.Name, Value: disk.SourceSnapshot}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.CreateDisk(ctx, project, zone, disk)
+	})
+}
+
+func (s *auditedService) CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error {
+	entry := &Entry{Method: "CreateSnapshot", Project: project, Location: zone, Resource: disk, Value: snapshot.Name}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.CreateSnapshot(ctx, project, zone, disk, snapshot)
+	})
+}
+
+func (s *auditedService) DeleteSnapshot(ctx context.Context, project, snapshot string) error {
+	entry := &Entry{Method: "DeleteSnapshot", Project: project, Resource: snapshot}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.DeleteSnapshot(ctx, project, snapshot)
+	})
+}
+
+func (s *auditedService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
+	entry := &Entry{Method: "DeleteDisk", Project: project, Location: zone, Resource: disk}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.DeleteDisk(ctx, project, zone, disk)
+	})
+}
+
+func (s *auditedService) DeleteImage(ctx context.Context, project, image string) error {
+	entry := &Entry{Method: "DeleteImage", Project: project, Resource: image}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.DeleteImage(ctx, project, image)
+	})
+}
+
+func (s *auditedService) ReleaseAddress(ctx context.Context, project, region, address string) error {
+	entry := &Entry{Method: "ReleaseAddress", Project: project, Location: region, Resource: address}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.ReleaseAddress(ctx, project, region, address)
+	})
+}
+
+func (s *auditedService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	entry := &Entry{Method: "StopInstance", Project: project, Location: zone, Resource: instance}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.StopInstance(ctx, project, zone, instance)
+	})
+}
+
+func (s *auditedService) StartInstance(ctx context.Context, project, zone, instance string) error {
+	entry := &Entry{Method: "StartInstance", Project: project, Location: zone, Resource: instance}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.StartInstance(ctx, project, zone, instance)
+	})
+}
+
+func (s *auditedService) CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error {
+	entry := &Entry{Method: "CreateInstanceTemplate", Project: project, Resource: template.Name}
+	if template.Properties != nil {
+		entry.Value = template.Properties.MachineType
+	}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.CreateInstanceTemplate(ctx, project, template)
+	})
+}
+
+func (s *auditedService) DeleteInstanceTemplate(ctx context.Context, project, template string) error {
+	entry := &Entry{Method: "DeleteInstanceTemplate", Project: project, Resource: template}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.DeleteInstanceTemplate(ctx, project, template)
+	})
+}
+
+func (s *auditedService) SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error {
+	entry := &Entry{Method: "SetInstanceGroupManagerTemplate", Project: project, Location: location, Resource: manager, Value: template}
+	return s.record(ctx, entry, true, func(ctx context.Context) error {
+		return s.GoogleService.SetInstanceGroupManagerTemplate(ctx, project, location, manager, template)
+	})
+}
+
+func (s *auditedService) SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error {
+	entry := &Entry{Method: "SetSQLActivationPolicy", Project: project, Resource: instance, Value: policy}
+	return s.record(ctx, entry, false, func(ctx context.Context) error {
+		return s.GoogleService.SetSQLActivationPolicy(ctx, project, instance, policy)
+	})
+}
+
+func (s *auditedService) ChangeSQLTier(ctx context.Context, project, instance, tier string) error {
+	entry := &Entry{Method: "ChangeSQLTier", Project: project, Resource: instance, Value: tier}
+	return s.record(ctx, entry, false, func(ctx context.Context) error {
+		return s.GoogleService.ChangeSQLTier(ctx, project, instance, tier)
+	})
+}
+
+func (s *auditedService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return s.recordMarking(ctx, "MarkRecommendationClaimed", name, etag, "", func(ctx context.Context) (*gcloudRecommendation, error) {
+		return s.GoogleService.MarkRecommendationClaimed(ctx, name, etag)
+	})
+}
+
+func (s *auditedService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return s.recordMarking(ctx, "MarkRecommendationSucceeded", name, etag, "", func(ctx context.Context) (*gcloudRecommendation, error) {
+		return s.GoogleService.MarkRecommendationSucceeded(ctx, name, etag)
+	})
+}
+
+func (s *auditedService) MarkRecommendationFailed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return s.recordMarking(ctx, "MarkRecommendationFailed", name, etag, "", func(ctx context.Context) (*gcloudRecommendation, error) {
+		return s.GoogleService.MarkRecommendationFailed(ctx, name, etag)
+	})
+}
+
+func (s *auditedService) MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return s.recordMarking(ctx, "MarkRecommendationDismissed", name, etag, reason, func(ctx context.Context) (*gcloudRecommendation, error) {
+		return s.GoogleService.MarkRecommendationDismissed(ctx, name, etag, reason)
+	})
+}
+
+func (s *auditedService) MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return s.recordMarking(ctx, "MarkRecommendationActive", name, etag, reason, func(ctx context.Context) (*gcloudRecommendation, error) {
+		return s.GoogleService.MarkRecommendationActive(ctx, name, etag, reason)
+	})
+}
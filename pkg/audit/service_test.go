@@ -0,0 +1,131 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/recommender/v1"
+)
+
+type mockService struct {
+	automation.GoogleService
+	stopErr error
+}
+
+func (s *mockService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	return s.stopErr
+}
+
+func (s *mockService) StartInstance(ctx context.Context, project, zone, instance string) error {
+	return nil
+}
+
+func (s *mockService) GetInstance(ctx context.Context, project, zone, instance string) (*compute.Instance, error) {
+	return &compute.Instance{Status: "RUNNING"}, nil
+}
+
+func (s *mockService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	rec := stopRecommendation()
+	rec.Etag = etag + "-claimed"
+	return rec, nil
+}
+
+func (s *mockService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return &gcloudRecommendation{Name: name, Etag: etag + "-succeeded"}, nil
+}
+
+func (s *mockService) MarkRecommendationFailed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return &gcloudRecommendation{Name: name, Etag: etag + "-failed"}, nil
+}
+
+func stopRecommendation() *gcloudRecommendation {
+	return &gcloudRecommendation{
+		Name: "projects/my-project/locations/us-east1-b/recommenders/google.compute.instance.IdleResourceRecommender/recommendations/r1",
+		Etag: "etag",
+		Content: &recommender.GoogleCloudRecommenderV1RecommendationContent{
+			OperationGroups: []*recommender.GoogleCloudRecommenderV1OperationGroup{{
+				Operations: []*recommender.GoogleCloudRecommenderV1Operation{{
+					Action:       "replace",
+					Path:         "/status",
+					Resource:     "//compute.googleapis.com/projects/my-project/zones/us-east1-b/instances/vm",
+					ResourceType: "compute.googleapis.com/Instance",
+					Value:        "TERMINATED",
+				}},
+			}},
+		},
+		StateInfo: &recommender.GoogleCloudRecommenderV1RecommendationStateInfo{State: "ACTIVE"},
+	}
+}
+
+func TestAuditedApply(t *testing.T) {
+	sink := &MemorySink{}
+	service := NewService(&mockService{}, sink, "alice@example.com")
+	_, err := automation.Apply(context.Background(), service, stopRecommendation(), &automation.Task{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	entries, _ := sink.Query(Filter{})
+	if !assert.Len(t, entries, 3, "Claiming, stopping and marking succeeded should be recorded") {
+		return
+	}
+	for _, entry := range entries {
+		assert.Equal(t, "alice@example.com", entry.Actor)
+		assert.Equal(t, stopRecommendation().Name, entry.Recommendation)
+		assert.True(t, entry.Succeeded)
+		assert.False(t, entry.FinishTime.Before(entry.Time))
+	}
+	assert.Equal(t, "MarkRecommendationClaimed", entries[0].Method)
+	assert.Equal(t, "etag", entries[0].Etag)
+	assert.Equal(t, "my-project", entries[0].Project)
+	assert.Empty(t, entries[0].RequestID, "Recommender API calls have no request ID")
+
+	stop := entries[1]
+	assert.Equal(t, "StopInstance", stop.Method)
+	assert.Equal(t, "etag", stop.Etag, "Etag passed to Apply should be recorded")
+	assert.Equal(t, "my-project", stop.Project)
+	assert.Equal(t, "us-east1-b", stop.Location)
+	assert.Equal(t, "vm", stop.Resource)
+	assert.NotEmpty(t, stop.RequestID, "Compute Engine request ID should be recorded")
+
+	assert.Equal(t, "MarkRecommendationSucceeded", entries[2].Method)
+	assert.Equal(t, "etag-claimed", entries[2].Etag)
+	if assert.Len(t, entries[2].Results, 1, "Results of applying should be recorded") {
+		assert.Equal(t, []string{"vm"}, entries[2].Results[0].StoppedInstances)
+	}
+	assert.Empty(t, entries[0].Results)
+}
+
+func TestAuditedFailure(t *testing.T) {
+	sink := &MemorySink{}
+	service := NewService(&mockService{stopErr: errors.New("quota exceeded")}, sink, "scheduler")
+	err := service.StopInstance(context.Background(), "my-project", "us-east1-b", "vm")
+	assert.EqualError(t, err, "quota exceeded", "Error of the call should be returned")
+
+	entries, _ := sink.Query(Filter{Actor: "scheduler"})
+	if assert.Len(t, entries, 1) {
+		assert.False(t, entries[0].Succeeded)
+		assert.Equal(t, "quota exceeded", entries[0].Error)
+		assert.Empty(t, entries[0].Recommendation, "Call outside of Apply has no recommendation")
+	}
+}
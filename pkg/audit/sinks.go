@@ -0,0 +1,292 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/googleinterns/recomator/pkg/jsonl"
+)
+
+// Sink is where the entries of the audit log are written.
+type Sink interface {
+	Write(entry *Entry) error
+}
+
+// Querier returns the entries of the audit log selected by the filter, the oldest first.
+type Querier interface {
+	Query(filter Filter) ([]*Entry, error)
+}
+
+// ErrNotQueryable is returned by Query, if entries can't be read back from the sink.
+var ErrNotQueryable = errors.New("the audit log can't be queried")
+
+// Query returns the entries selected by filter from the sink, if it implements Querier.
+// Otherwise ErrNotQueryable is returned.
+func Query(sink Sink, filter Filter) ([]*Entry, error) {
+	querier, ok := sink.(Querier)
+	if !ok {
+		return nil, ErrNotQueryable
+	}
+	return querier.Query(filter)
+}
+
+// Queryable checks whether entries can be read back from the sink with Query.
+func Queryable(sink Sink) bool {
+	if multi, ok := sink.(*multiSink); ok {
+		for _, sink := range multi.sinks {
+			if Queryable(sink) {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := sink.(Querier)
+	return ok
+}
+
+// RevertMethod is the method of the entries recording reverting the changes
+// made by applying a recommendation, written by RecordRevert.
+const RevertMethod = "Revert"
+
+// AppliedResults returns the results of the last successful apply of the recommendation
+// recorded in the sink, which haven't been reverted, or nil if there are none.
+// Results of failed applies aren't returned, as their changes have been rolled back.
+// After a failed revert, only the changes it left to revert are returned.
+// Returns ErrNotQueryable, if the sink doesn't implement Querier.
+func AppliedResults(sink Sink, recommendation string) ([]*automation.OperationResult, error) {
+	entries, err := Query(sink, Filter{Recommendation: recommendation})
+	if err != nil {
+		return nil, err
+	}
+	var results []*automation.OperationResult
+	for _, entry := range entries {
+		switch {
+		case entry.Method == "MarkRecommendationSucceeded" && entry.Succeeded && len(entry.Results) != 0:
+			results = entry.Results
+		case entry.Method == RevertMethod && entry.Succeeded:
+			results = nil
+		case entry.Method == RevertMethod && len(entry.Remaining) != 0:
+			results = entry.Remaining
+		}
+	}
+	return results, nil
+}
+
+// RecordRevert writes the entry of reverting the changes described by applied, made by applying
+// the recommendation, to the sink. start is when reverting started, results are the reverting changes
+// returned by automation.Revert and err is its error. The changes left to revert are recorded, if it failed.
+func RecordRevert(sink Sink, actor, recommendation string, start time.Time,
+	applied, results []*automation.OperationResult, err error) error {
+	entry := &Entry{Time: start, FinishTime: time.Now(), Actor: actor, Recommendation: recommendation,
+		Method: RevertMethod, Resource: recommendation, Succeeded: err == nil, Results: results,
+		Remaining: automation.UnrevertedResults(applied, err)}
+	if match := projectRegexp.FindStringSubmatch(recommendation); match != nil {
+		entry.Project = match[1]
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return sink.Write(entry)
+}
+
+// MemorySink keeps the entries in memory.
+type MemorySink struct {
+	mutex   sync.Mutex
+	entries []*Entry
+}
+
+// Write adds the entry to the kept ones.
+func (s *MemorySink) Write(entry *Entry) error {
+	s.mutex.Lock()
+	s.entries = append(s.entries, entry)
+	s.mutex.Unlock()
+	return nil
+}
+
+// Query returns the kept entries selected by the filter.
+func (s *MemorySink) Query(filter Filter) ([]*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := []*Entry{}
+	for _, entry := range s.entries {
+		if filter.Matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// resultsSink keeps in memory only the entries read by AppliedResults,
+// marking recommendations succeeded with the results of applying them, and reverting them.
+type resultsSink struct {
+	MemorySink
+}
+
+// NewResultsSink creates Sink keeping the results of applying and reverting recommendations in memory,
+// so that AppliedResults can be used without an audit log that can be queried.
+func NewResultsSink() Sink {
+	return &resultsSink{}
+}
+
+func (s *resultsSink) Write(entry *Entry) error {
+	if (entry.Method == "MarkRecommendationSucceeded" && len(entry.Results) != 0) || entry.Method == RevertMethod {
+		return s.MemorySink.Write(entry)
+	}
+	return nil
+}
+
+// fileSink appends the entries to a file as JSON Lines.
+type fileSink struct {
+	file *jsonl.Appender
+}
+
+// NewFileSink creates Sink appending every entry as a line of JSON to the file at path.
+// The entries can be queried, the whole file is read then.
+func NewFileSink(path string) Sink {
+	return &fileSink{file: jsonl.NewAppender(path)}
+}
+
+func (s *fileSink) Write(entry *Entry) error {
+	return s.file.Append(entry)
+}
+
+func (s *fileSink) Query(filter Filter) ([]*Entry, error) {
+	result := []*Entry{}
+	err := s.file.ForEach(func(line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if filter.Matches(&entry) {
+			result = append(result, &entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// cloudLoggingEntry is the entry in the structured logging format of Cloud Logging,
+// the fields of Entry are in jsonPayload of the log entry.
+type cloudLoggingEntry struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	*Entry
+}
+
+// writerSink writes the entries to w in the structured logging format of Cloud Logging.
+type writerSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewCloudLoggingSink creates Sink writing entries to w, usually os.Stdout,
+// as lines of JSON in the structured logging format, that Cloud Logging agents
+// and App Engine parse into log entries.
+func NewCloudLoggingSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(entry *Entry) error {
+	logEntry := cloudLoggingEntry{Severity: "NOTICE", Entry: entry,
+		Message: fmt.Sprintf("%s called %s on %s", entry.Actor, entry.Method, entry.Resource)}
+	if !entry.Succeeded {
+		logEntry.Severity = "ERROR"
+	}
+	line, err := json.Marshal(logEntry)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// webhookSink sends every entry in the body of a POST request.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates Sink sending every entry as JSON in a POST request to url.
+// If client is nil, http.DefaultClient is used.
+func NewWebhookSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &webhookSink{url: url, client: client}
+}
+
+func (s *webhookSink) Write(entry *Entry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// multiSink writes entries to all of its sinks.
+type multiSink struct {
+	sinks []Sink
+}
+
+// NewMultiSink creates Sink writing entries to all the sinks.
+// It's queried with the first of them implementing Querier.
+func NewMultiSink(sinks ...Sink) Sink {
+	return &multiSink{sinks: sinks}
+}
+
+// Write writes the entry to all the sinks, even if some of them fail.
+// The first error is returned.
+func (s *multiSink) Write(entry *Entry) error {
+	var result error
+	for _, sink := range s.sinks {
+		if err := sink.Write(entry); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (s *multiSink) Query(filter Filter) ([]*Entry, error) {
+	for _, sink := range s.sinks {
+		if querier, ok := sink.(Querier); ok {
+			return querier.Query(filter)
+		}
+	}
+	return nil, ErrNotQueryable
+}
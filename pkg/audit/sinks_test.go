@@ -0,0 +1,193 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTime = time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+func testEntries() []*Entry {
+	return []*Entry{
+		{Time: testTime, Actor: "alice@example.com", Method: "StopInstance", Project: "project-a", Resource: "vm", Succeeded: true},
+		{Time: testTime.Add(time.Hour), Actor: "bob@example.com", Method: "DeleteDisk", Project: "project-b", Resource: "disk", Error: "not found"},
+		{Time: testTime.Add(2 * time.Hour), Actor: "alice@example.com", Method: "DeleteImage", Project: "project-b", Resource: "image", Succeeded: true},
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	entries := testEntries()
+	testCases := []struct {
+		filter   Filter
+		expected []bool
+	}{
+		{Filter{}, []bool{true, true, true}},
+		{Filter{Actor: "alice@example.com"}, []bool{true, false, true}},
+		{Filter{Project: "project-b"}, []bool{false, true, true}},
+		{Filter{From: testTime.Add(time.Hour)}, []bool{false, true, true}},
+		{Filter{To: testTime.Add(time.Hour)}, []bool{true, false, false}},
+		{Filter{Actor: "alice@example.com", Project: "project-b"}, []bool{false, false, true}},
+		{Filter{Recommendation: "r1"}, []bool{false, false, false}},
+	}
+	for _, testCase := range testCases {
+		for i, entry := range entries {
+			assert.Equal(t, testCase.expected[i], testCase.filter.Matches(entry), "filter %+v, entry %d", testCase.filter, i)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	sink := NewFileSink(filepath.Join(dir, "audit.jsonl"))
+
+	entries, err := Query(sink, Filter{})
+	assert.NoError(t, err, "Querying log without entries shouldn't fail")
+	assert.Empty(t, entries)
+
+	for _, entry := range testEntries() {
+		assert.NoError(t, sink.Write(entry))
+	}
+	entries, err = Query(sink, Filter{Project: "project-b"})
+	if assert.NoError(t, err) && assert.Len(t, entries, 2) {
+		assert.Equal(t, "DeleteDisk", entries[0].Method)
+		assert.Equal(t, "not found", entries[0].Error)
+		assert.True(t, entries[0].Time.Equal(testTime.Add(time.Hour)))
+		assert.Equal(t, "DeleteImage", entries[1].Method)
+	}
+}
+
+func TestCloudLoggingSink(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := NewCloudLoggingSink(&buffer)
+	for _, entry := range testEntries()[:2] {
+		assert.NoError(t, sink.Write(entry))
+	}
+
+	_, err := Query(sink, Filter{})
+	assert.Equal(t, ErrNotQueryable, err)
+
+	decoder := json.NewDecoder(&buffer)
+	var line map[string]interface{}
+	if assert.NoError(t, decoder.Decode(&line)) {
+		assert.Equal(t, "NOTICE", line["severity"])
+		assert.Equal(t, "alice@example.com called StopInstance on vm", line["message"])
+		assert.Equal(t, "project-a", line["project"])
+		assert.Equal(t, "2020-08-01T12:00:00Z", line["time"])
+	}
+	if assert.NoError(t, decoder.Decode(&line)) {
+		assert.Equal(t, "ERROR", line["severity"], "Failed calls should be logged as errors")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []*Entry
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry Entry
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&entry))
+		received = append(received, &entry)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, nil)
+	assert.NoError(t, sink.Write(testEntries()[0]))
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "StopInstance", received[0].Method)
+	}
+
+	status = http.StatusInternalServerError
+	assert.Error(t, sink.Write(testEntries()[1]), "Error status of the webhook should be returned")
+}
+
+func TestMultiSink(t *testing.T) {
+	var buffer bytes.Buffer
+	memory := &MemorySink{}
+	sink := NewMultiSink(NewCloudLoggingSink(&buffer), memory)
+	for _, entry := range testEntries() {
+		assert.NoError(t, sink.Write(entry))
+	}
+	assert.NotEmpty(t, buffer.String())
+
+	entries, err := Query(sink, Filter{Actor: "bob@example.com"})
+	if assert.NoError(t, err, "The memory sink should be queried") && assert.Len(t, entries, 1) {
+		assert.Equal(t, "DeleteDisk", entries[0].Method)
+	}
+
+	_, err = Query(NewMultiSink(NewCloudLoggingSink(&buffer)), Filter{})
+	assert.Equal(t, ErrNotQueryable, err)
+}
+
+func TestAppliedResults(t *testing.T) {
+	stopped := []*automation.OperationResult{{Action: automation.ActionStopInstance, Project: "project-a", Resource: "vm"}}
+	deleted := []*automation.OperationResult{{Action: automation.ActionDeleteDisk, Project: "project-a", Resource: "disk"}}
+	sink := &MemorySink{}
+	for _, entry := range []*Entry{
+		{Recommendation: "r1", Method: "MarkRecommendationSucceeded", Succeeded: true, Results: stopped},
+		{Recommendation: "r2", Method: "MarkRecommendationSucceeded", Succeeded: true, Results: deleted},
+		{Recommendation: "r1", Method: "MarkRecommendationFailed", Succeeded: true, Results: deleted},
+		{Recommendation: "r3", Method: "MarkRecommendationFailed", Succeeded: true, Results: deleted},
+	} {
+		assert.NoError(t, sink.Write(entry))
+	}
+
+	results, err := AppliedResults(sink, "r1")
+	assert.NoError(t, err)
+	assert.Equal(t, stopped, results, "Results of the last successful apply should be returned")
+
+	results, err = AppliedResults(sink, "r3")
+	assert.NoError(t, err)
+	assert.Nil(t, results, "Results of failed applies shouldn't be returned")
+
+	_, err = AppliedResults(NewCloudLoggingSink(&bytes.Buffer{}), "r1")
+	assert.Equal(t, ErrNotQueryable, err)
+
+	assert.NoError(t, RecordRevert(sink, "alice@example.com", "r2", time.Now(), deleted, nil, errors.New("address can't be restored")))
+	results, err = AppliedResults(sink, "r2")
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, results, "Results should be returned, if nothing has been reverted")
+
+	// the disk has been restored, starting the instance failed
+	applied := append(append([]*automation.OperationResult{}, stopped...), deleted...)
+	assert.NoError(t, sink.Write(&Entry{Recommendation: "r4", Method: "MarkRecommendationSucceeded", Succeeded: true, Results: applied}))
+	revertErr := &automation.RevertError{Err: errors.New("instance not found"), Remaining: stopped}
+	restored := []*automation.OperationResult{{Action: automation.ActionCreateDisk, Project: "project-a", Resource: "disk"}}
+	assert.NoError(t, RecordRevert(sink, "alice@example.com", "r4", time.Now(), applied, restored, revertErr))
+	results, err = AppliedResults(sink, "r4")
+	assert.NoError(t, err)
+	assert.Equal(t, stopped, results, "Only the changes left to revert should be returned")
+
+	assert.NoError(t, RecordRevert(sink, "alice@example.com", "r4", time.Now(), stopped, nil, nil))
+	results, err = AppliedResults(sink, "r4")
+	assert.NoError(t, err)
+	assert.Nil(t, results, "Results of reverted applies shouldn't be returned")
+}
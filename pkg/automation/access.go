@@ -17,6 +17,8 @@ limitations under the License.
 package automation
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"sort"
 	"strings"
@@ -26,7 +28,8 @@ import (
 	"google.golang.org/api/serviceusage/v1"
 )
 
-// requiredAPIs are APIs required for googleService
+// requiredAPIs are APIs required for googleService regardless of recommenders.
+// APIs needed only by some recommenders are checked for each of them.
 var requiredAPIs = []string{"compute.googleapis.com", "recommender.googleapis.com", "cloudresourcemanager.googleapis.com"}
 
 // Requirement contains information about the required permission or api.
@@ -42,14 +45,14 @@ type Requirement struct {
 // services.get permission is required for this method.
 // First requirement in returned list will be related to Service Usage API.
 // If it is not enabled other APIs won't be checked.
-func (s *googleService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
+func (s *googleService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
 	servicesService := serviceusage.NewServicesService(s.serviceUsageService)
 	serviceUsageName := "Service Usage API"
 	result := []*Requirement{}
 	for _, api := range apis {
 		var response *serviceusage.GoogleApiServiceusageV1Service
-		err := DoRequestWithRetries(func() error {
-			resp, err := servicesService.Get("projects/" + project + "/services/" + api).Do()
+		err := DoRequestWithRetries(ctx, func() error {
+			resp, err := servicesService.Get("projects/" + project + "/services/" + api).Context(ctx).Do()
 			response = resp
 			return err
 		})
@@ -92,32 +95,22 @@ func (s *googleService) ListAPIRequirements(project string, apis []string) ([]*R
 	return result, nil
 }
 
-// requiredPermissions are permissions required for googleService
-var requiredPermissions = [][]string{
-	[]string{"compute.instances.setMachineType"},                              // ChangeMachineType
-	[]string{"compute.disks.createSnapshot", "compute.snapshots.create"},      // CreateSnapshot
-	[]string{"compute.disks.delete"},                                          // DeleteDisk
-	[]string{"compute.instances.get"},                                         // GetInstance
-	[]string{"recommender.computeDiskIdleResourceRecommendations.list"},       // ListRecommendations for google.compute.disk.IdleResourceRecommender
-	[]string{"recommender.computeInstanceIdleResourceRecommendations.list"},   // ListRecommendations for google.compute.instance.IdleResourceRecommender
-	[]string{"recommender.computeInstanceMachineTypeRecommendations.list"},    // ListRecommendations for google.compute.instance.MachineTypeRecommender
-	[]string{"recommender.computeDiskIdleResourceRecommendations.get"},        // GetRecommendation for google.compute.disk.IdleResourceRecommender
-	[]string{"recommender.computeInstanceIdleResourceRecommendations.get"},    // GetRecommendation for google.compute.instance.IdleResourceRecommender
-	[]string{"recommender.computeInstanceMachineTypeRecommendations.get"},     // GetRecommendation for google.compute.instance.MachineTypeRecommender
-	[]string{"recommender.computeDiskIdleResourceRecommendations.update"},     // MarkClaimed/Failed/Suceeded for google.compute.disk.IdleResourceRecommender
-	[]string{"recommender.computeInstanceIdleResourceRecommendations.update"}, // MarkClaimed/Failed/Suceeded for google.compute.instance.IdleResourceRecommender
-	[]string{"recommender.computeInstanceMachineTypeRecommendations.update"},  // ListRecommendations for google.compute.instance.MachineTypeRecommender
-	[]string{"compute.regions.list"},                                          // ListRegionsNames
-	[]string{"compute.zones.list"},                                            // ListZonesNames
-	[]string{"compute.instances.start"},                                       // StartInstance
-	[]string{"compute.instances.stop"},                                        // StopInstance
-	[]string{"serviceusage.services.get"},                                     // ListAPIRequirements
+// basePermissions are permissions required for googleService regardless of recommenders.
+var basePermissions = [][]string{
+	[]string{"compute.regions.list"},      // ListRegionsNames
+	[]string{"compute.zones.list"},        // ListZonesNames
+	[]string{"serviceusage.services.get"}, // ListAPIRequirements
 }
 
+// requiredPermissions are permissions required for googleService regardless of recommenders.
+// Permissions needed to list and apply recommendations are checked for each recommender,
+// so that missing permissions of one recommender don't prevent listing the others.
+var requiredPermissions = basePermissions
+
 // ListPermissionRequirements returns the list of permissions and their statuses for the project.
 // No permissions required for this method.
 // If cloud resource manager api is not enabled, will return not satisfied requirement for this API.
-func (s *googleService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *googleService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	var result []*Requirement
 	var allPermissions []string
 
@@ -128,8 +121,8 @@ func (s *googleService) ListPermissionRequirements(project string, permissions [
 	request := cloudresourcemanager.TestIamPermissionsRequest{Permissions: allPermissions}
 	projectsService := cloudresourcemanager.NewProjectsService(s.resourceManagerService)
 	var response *cloudresourcemanager.TestIamPermissionsResponse
-	err := DoRequestWithRetries(func() error {
-		resp, err := projectsService.TestIamPermissions(project, &request).Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		resp, err := projectsService.TestIamPermissions(project, &request).Context(ctx).Do()
 		response = resp
 		return err
 	})
@@ -171,15 +164,27 @@ func (s *googleService) ListPermissionRequirements(project string, permissions [
 }
 
 // ProjectRequirements contains information about permissions for the user for the project.
+// Recommenders are the recommenders, which can't be used in the project, with their unsatisfied requirements.
 type ProjectRequirements struct {
-	Project      string         `json:"project"`
-	Requirements []*Requirement `json:"requirements"`
+	Project      string                     `json:"project"`
+	Requirements []*Requirement             `json:"requirements"`
+	Recommenders []*RecommenderRequirements `json:"recommenders,omitempty"`
+}
+
+// allSatisfied returns whether all the requirements are satisfied.
+func allSatisfied(requirements []*Requirement) bool {
+	for _, requirement := range requirements {
+		if !requirement.Satisfied {
+			return false
+		}
+	}
+	return true
 }
 
-// ListProjectRequirements is a function that lists all permissions and APIs and their statuses for a project.
-// If all statuses are equal to RequirementCompleted, user has all required permissions.
-func ListProjectRequirements(s GoogleService, project string) ([]*Requirement, error) {
-	requirements, err := s.ListPermissionRequirements(project, requiredPermissions)
+// ListProjectRequirements is a function that lists the permissions and APIs required regardless of recommenders
+// and their statuses for a project. If all statuses are equal to RequirementCompleted, user has all required permissions.
+func ListProjectRequirements(ctx context.Context, s GoogleService, project string) ([]*Requirement, error) {
+	requirements, err := s.ListPermissionRequirements(ctx, project, requiredPermissions)
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +195,7 @@ func ListProjectRequirements(s GoogleService, project string) ([]*Requirement, e
 		}
 	}
 
-	apiRequirements, err := s.ListAPIRequirements(project, requiredAPIs)
+	apiRequirements, err := s.ListAPIRequirements(ctx, project, requiredAPIs)
 	if err != nil {
 		return nil, err
 	}
@@ -198,17 +203,95 @@ func ListProjectRequirements(s GoogleService, project string) ([]*Requirement, e
 	return requirements, nil
 }
 
+// RecommenderRequirements contains the requirements of a recommender, which aren't satisfied in the project,
+// so its recommendations can't be listed or applied there.
+type RecommenderRequirements struct {
+	Project      string         `json:"project"`
+	Recommender  string         `json:"recommender"`
+	Requirements []*Requirement `json:"requirements"`
+}
+
+// checkRecommenders returns the recommenders from googleRecommenders, whose permissions are granted
+// and APIs are enabled in the project, and the unsatisfied requirements of the other recommenders.
+// The permissions of all recommenders are checked with one call, the APIs only for recommenders having the permissions.
+func checkRecommenders(ctx context.Context, s GoogleService, project string) ([]*recommenderInfo, []*RecommenderRequirements, error) {
+	permissions := recommendersPermissions()
+	permissionRequirements, err := s.ListPermissionRequirements(ctx, project, permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(permissionRequirements) != len(permissions) {
+		// only the failed requirement of Resource Manager API is returned, if it's not enabled
+		var failed []*RecommenderRequirements
+		for _, recommender := range googleRecommenders {
+			failed = append(failed, &RecommenderRequirements{Project: project, Recommender: recommender.id, Requirements: permissionRequirements})
+		}
+		return nil, failed, nil
+	}
+	byPermissions := make(map[string]*Requirement)
+	for i, group := range permissions {
+		byPermissions[strings.Join(group, ",")] = permissionRequirements[i]
+	}
+
+	// APIs are checked once, even if several recommenders need them
+	apiRequirements := make(map[string]*Requirement)
+	var available []*recommenderInfo
+	var failed []*RecommenderRequirements
+	for _, recommender := range googleRecommenders {
+		var missing []*Requirement
+		for _, group := range recommender.permissions() {
+			if requirement := byPermissions[strings.Join(group, ",")]; !requirement.Satisfied {
+				missing = append(missing, requirement)
+			}
+		}
+		if len(missing) == 0 {
+			for _, api := range recommender.apis {
+				requirement, ok := apiRequirements[api]
+				if !ok {
+					requirements, err := s.ListAPIRequirements(ctx, project, []string{api})
+					if err != nil {
+						return nil, nil, err
+					}
+					if len(requirements) == 0 {
+						return nil, nil, fmt.Errorf("checking API %s in project %s failed: no requirements returned", api, project)
+					}
+					// the last one is the requirement of the API, or of Service Usage API if it's not enabled
+					requirement = requirements[len(requirements)-1]
+					apiRequirements[api] = requirement
+				}
+				if !requirement.Satisfied {
+					missing = append(missing, requirement)
+				}
+			}
+		}
+		if len(missing) != 0 {
+			failed = append(failed, &RecommenderRequirements{Project: project, Recommender: recommender.id, Requirements: missing})
+		} else {
+			available = append(available, recommender)
+		}
+	}
+	return available, failed, nil
+}
+
 // ListRequirements lists the requirements and their statuses for every project.
+// If the requirements of a project are satisfied, the unsatisfied requirements of recommenders
+// are listed in its Recommenders field.
 // task structure tracks how many projects have been processed already.
-func ListRequirements(s GoogleService, projects []string, task *Task) ([]*ProjectRequirements, error) {
+func ListRequirements(ctx context.Context, s GoogleService, projects []string, task *Task) ([]*ProjectRequirements, error) {
 	task.SetNumberOfSubtasks(len(projects))
 	var result []*ProjectRequirements
 	for _, project := range projects {
-		requirements, err := ListProjectRequirements(s, project)
+		requirements, err := ListProjectRequirements(ctx, s, project)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, &ProjectRequirements{project, requirements})
+		projectRequirements := &ProjectRequirements{Project: project, Requirements: requirements}
+		if allSatisfied(requirements) {
+			if _, projectRequirements.Recommenders, err = checkRecommenders(ctx, s, project); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, projectRequirements)
 		task.IncrementDone()
 	}
 	task.SetAllDone()
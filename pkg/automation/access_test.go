@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -29,7 +30,7 @@ type mockAllSatisfiedService struct {
 	GoogleService
 }
 
-func (s *mockAllSatisfiedService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
+func (s *mockAllSatisfiedService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
 	var result []*Requirement
 	for _, api := range apis {
 		result = append(result, &Requirement{Name: api, Satisfied: true})
@@ -37,7 +38,7 @@ func (s *mockAllSatisfiedService) ListAPIRequirements(project string, apis []str
 	return result, nil
 }
 
-func (s *mockAllSatisfiedService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *mockAllSatisfiedService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	var result []*Requirement
 	for _, perm := range permissions {
 		result = append(result, &Requirement{Name: perm[0], Satisfied: true})
@@ -61,8 +62,9 @@ func checkAllRequirementsSatisfied(t *testing.T, reqs []*Requirement) {
 }
 
 func TestAllSatisfied(t *testing.T) {
+	ctx := context.Background()
 	mock := &mockAllSatisfiedService{}
-	reqs, err := ListProjectRequirements(mock, "")
+	reqs, err := ListProjectRequirements(ctx, mock, "")
 	if assert.NoError(t, err, "No error from ListProjectRequirements expected") {
 		checkAllRequirementsSatisfied(t, reqs)
 	}
@@ -75,21 +77,22 @@ type mockService struct {
 	listAPICalled  bool
 }
 
-func (s *mockService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
+func (s *mockService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
 	s.listAPICalled = true
 	return s.apiReqs, nil
 }
 
-func (s *mockService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *mockService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	return s.permissionReqs, nil
 }
 
 var failedRequirements = []*Requirement{&Requirement{Satisfied: false}}
 
 func TestFailAPIRequirements(t *testing.T) {
+	ctx := context.Background()
 	mock := &mockService{permissionReqs: failedRequirements}
 
-	reqs, err := ListProjectRequirements(mock, "")
+	reqs, err := ListProjectRequirements(ctx, mock, "")
 	if assert.NoError(t, err, "ListProjectRequirements should not return error") {
 		assert.ElementsMatch(t, mock.permissionReqs, reqs, "ListProjectRequirements should return permissions requirements")
 		assert.False(t, mock.listAPICalled, "ListAPIRequirements should not be called if permissions reqs are failed")
@@ -97,10 +100,11 @@ func TestFailAPIRequirements(t *testing.T) {
 }
 
 func TestFailPermissionRequirements(t *testing.T) {
+	ctx := context.Background()
 	mock := &mockService{permissionReqs: []*Requirement{&Requirement{Satisfied: true}},
 		apiReqs: failedRequirements}
 
-	reqs, err := ListProjectRequirements(mock, "")
+	reqs, err := ListProjectRequirements(ctx, mock, "")
 	if assert.NoError(t, err, "ListProjectRequirements should not return error") {
 		assert.ElementsMatch(t, append(mock.apiReqs, mock.permissionReqs...), reqs,
 			"ListProjectRequirements should return api & permission requirements, if permission requirements are satisfied")
@@ -112,11 +116,11 @@ type errorAPIService struct {
 	err error
 }
 
-func (s *errorAPIService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
+func (s *errorAPIService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
 	return nil, s.err
 }
 
-func (s *errorAPIService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *errorAPIService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	return nil, nil
 }
 
@@ -125,14 +129,15 @@ type errorPermissionService struct {
 	err error
 }
 
-func (s *errorPermissionService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *errorPermissionService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	return nil, s.err
 }
 
 func TestErrorAPIRequirements(t *testing.T) {
+	ctx := context.Background()
 	for _, mock := range []GoogleService{&errorAPIService{err: errors.New("hi! i'm error")},
 		&errorPermissionService{err: errors.New("another error")}} {
-		reqs, err := ListProjectRequirements(mock, "")
+		reqs, err := ListProjectRequirements(ctx, mock, "")
 		if assert.Error(t, err, "ListProjectRequirements should result in error") {
 			assert.Nil(t, reqs, "Only one of returned values should be non-nil")
 		}
@@ -161,17 +166,18 @@ func getService(project string) GoogleService {
 	return service
 }
 
-func (s *mockProjectService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
-	rec, err := getService(project).ListAPIRequirements(project, apis)
+func (s *mockProjectService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
+	rec, err := getService(project).ListAPIRequirements(ctx, project, apis)
 	return rec, err
 }
 
-func (s *mockProjectService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
-	rec, err := getService(project).ListPermissionRequirements(project, permissions)
+func (s *mockProjectService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
+	rec, err := getService(project).ListPermissionRequirements(ctx, project, permissions)
 	return rec, err
 }
 
 func TestListRequirements(t *testing.T) {
+	ctx := context.Background()
 	rand.Seed(42)
 	for numProjects := 0; numProjects < 5; numProjects++ {
 		for i := 0; i < 100; i++ {
@@ -185,7 +191,7 @@ func TestListRequirements(t *testing.T) {
 			}
 
 			task := &Task{}
-			reqs, err := ListRequirements(&mockProjectService{}, projects, task)
+			reqs, err := ListRequirements(ctx, &mockProjectService{}, projects, task)
 			if assert.NoError(t, err, "No error expected from ListRequirements") {
 				var actualProjects []string
 				for _, req := range reqs {
@@ -209,9 +215,10 @@ func TestListRequirements(t *testing.T) {
 }
 
 func TestErrorListRequirements(t *testing.T) {
+	ctx := context.Background()
 	projects := []string{"ok", errorProject, failedProject}
 	task := &Task{}
-	reqs, err := ListRequirements(&mockProjectService{}, projects, task)
+	reqs, err := ListRequirements(ctx, &mockProjectService{}, projects, task)
 	if assert.Error(t, err) {
 		assert.Nil(t, reqs, "Only one value should be non-nil")
 		done, all := task.GetProgress()
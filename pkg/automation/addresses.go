@@ -0,0 +1,44 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"google.golang.org/api/compute/v1"
+)
+
+// ReleaseAddress calls the addresses.delete method for regional addresses
+// and the globalAddresses.delete method if region is empty or "global".
+// Requires compute.addresses.delete or compute.globalAddresses.delete permission.
+func (s *googleService) ReleaseAddress(ctx context.Context, project, region, address string) error {
+	requestID := newRequestID(ctx)
+	if region == "" || region == globalLocation {
+		globalAddressesService := compute.NewGlobalAddressesService(s.computeService)
+		return DoRequestWithRetries(ctx, func() error {
+			return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+				return globalAddressesService.Delete(project, address).RequestId(requestID).Context(ctx).Do()
+			}, sleepTimeReleasingAddress)
+		})
+	}
+
+	addressesService := compute.NewAddressesService(s.computeService)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return addressesService.Delete(project, region, address).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeReleasingAddress)
+	})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"errors"
 	"strings"
 
@@ -34,57 +35,217 @@ const (
 	zoneParam        = "zones"
 	instanceParam    = "instances"
 	diskParam        = "disks"
+	regionParam      = "regions"
+	addressParam     = "addresses"
+	imageParam       = "images"
 	machineTypeParam = "machineTypes"
+
+	instanceGroupManagerParam = "instanceGroupManagers"
 )
 
-// DoOperation does the action specified in the operation.
-func DoOperation(service GoogleService, operation *gcloudOperation) error {
-	switch strings.ToLower(operation.Action) {
-	case "test":
-		if operation.ResourceType != "compute.googleapis.com/Instance" {
-			return errors.New(operationNotSupportedMessage)
-		}
-		return testInstanceField(service, operation)
-	case "replace":
-		if operation.ResourceType != "compute.googleapis.com/Instance" {
-			return errors.New(operationNotSupportedMessage)
-		}
-		switch operation.Path {
-		case "/machineType":
-			return replaceMachineType(service, operation)
-		case "/status":
-			if operation.Value != "TERMINATED" {
+// Types of resources that operations can change.
+const (
+	instanceType    = "compute.googleapis.com/Instance"
+	snapshotType    = "compute.googleapis.com/Snapshot"
+	diskType        = "compute.googleapis.com/Disk"
+	addressType     = "compute.googleapis.com/Address"
+	imageType       = "compute.googleapis.com/Image"
+	sqlInstanceType = "sqladmin.googleapis.com/Instance"
+
+	instanceTemplateType     = "compute.googleapis.com/InstanceTemplate"
+	instanceGroupManagerType = "compute.googleapis.com/InstanceGroupManager"
+)
+
+// operationKey identifies the handler of an operation.
+// Action is lowercase, empty path matches operations with any path.
+type operationKey struct {
+	action       string
+	resourceType string
+	path         string
+}
+
+// OperationResult describes the change made by an operation, so that the changed resources
+// can be found later, for example the snapshot of a deleted disk.
+// Action is one of the actions of PlannedAction, Location is the zone or the region of the resource, if any.
+// Value is the machine type, activation policy, tier or instance template set by the operation,
+// and PreviousValue is the one before the change, for example the previous machine type.
+// For ActionCreateInstanceTemplate, Resource is the created template, Value is its machine type
+// and PreviousValue is the machine type of the copied template.
+// SnapshotName is the snapshot created by ActionCreateSnapshot, or the snapshot of the disk
+// deleted by ActionDeleteDisk taken earlier in the same operation group, SnapshotSelfLink is its URL.
+// StoppedInstances are the instances stopped by the operation, ActionSetMachineType starts them again.
+// DiskType, DiskSizeGb and Labels describe the disk deleted by ActionDeleteDisk, or created by ActionCreateDisk.
+type OperationResult struct {
+	Action           string   `json:"action"`
+	Project          string   `json:"project"`
+	Location         string   `json:"location,omitempty"`
+	Resource         string   `json:"resource"`
+	Value            string   `json:"value,omitempty"`
+	PreviousValue    string   `json:"previousValue,omitempty"`
+	SnapshotName     string   `json:"snapshotName,omitempty"`
+	SnapshotSelfLink string   `json:"snapshotSelfLink,omitempty"`
+	StoppedInstances []string `json:"stoppedInstances,omitempty"`
+
+	DiskType   string            `json:"diskType,omitempty"`
+	DiskSizeGb int64             `json:"diskSizeGb,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// operationHandler does an operation, registering actions reverting it in rollback.
+// It returns the result describing the change, or nil if nothing has been changed.
+// Permissions are required by the GoogleService methods that the handler calls.
+type operationHandler struct {
+	do          func(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error)
+	permissions [][]string
+}
+
+var (
+	testInstanceKey               = operationKey{"test", instanceType, ""}
+	replaceMachineTypeKey         = operationKey{"replace", instanceType, "/machineType"}
+	replaceInstanceStatusKey      = operationKey{"replace", instanceType, "/status"}
+	addSnapshotKey                = operationKey{"add", snapshotType, ""}
+	removeDiskKey                 = operationKey{"remove", diskType, ""}
+	removeAddressKey              = operationKey{"remove", addressType, ""}
+	removeImageKey                = operationKey{"remove", imageType, ""}
+	replaceSQLActivationPolicyKey = operationKey{"replace", sqlInstanceType, "/settings/activationPolicy"}
+	replaceSQLTierKey             = operationKey{"replace", sqlInstanceType, "/settings/tier"}
+
+	copyInstanceTemplateKey        = operationKey{"copy", instanceTemplateType, ""}
+	replaceTemplateMachineTypeKey  = operationKey{"replace", instanceTemplateType, "/properties/machineType"}
+	replaceGroupTemplateKey        = operationKey{"replace", instanceGroupManagerType, "/instanceTemplate"}
+	replaceGroupVersionTemplateKey = operationKey{"replace", instanceGroupManagerType, "/versions/0/instanceTemplate"}
+)
+
+var operationHandlers = map[operationKey]*operationHandler{
+	testInstanceKey: {
+		do:          testInstanceField,
+		permissions: [][]string{{"compute.instances.get"}},
+	},
+	replaceMachineTypeKey: {
+		do: replaceMachineType,
+		permissions: [][]string{
+			{"compute.instances.get"},
+			{"compute.instances.stop"},
+			{"compute.instances.setMachineType"},
+			{"compute.instances.start"},
+		},
+	},
+	replaceInstanceStatusKey: {
+		do:          stopInstance,
+		permissions: [][]string{{"compute.instances.get"}, {"compute.instances.stop"}, {"compute.instances.start"}},
+	},
+	addSnapshotKey: {
+		do: addSnapshot,
+		permissions: [][]string{{"compute.disks.get"}, {"compute.disks.createSnapshot", "compute.snapshots.create"},
+			{"compute.snapshots.setLabels"}, {"compute.snapshots.get"}},
+	},
+	removeDiskKey: {
+		do:          removeDisk,
+		permissions: [][]string{{"compute.disks.get"}, {"compute.snapshots.get"}, {"compute.disks.delete"}},
+	},
+	removeAddressKey: {
+		do:          removeAddress,
+		permissions: [][]string{{"compute.addresses.delete"}, {"compute.globalAddresses.delete"}},
+	},
+	removeImageKey: {
+		do:          removeImage,
+		permissions: [][]string{{"compute.images.delete"}},
+	},
+	replaceSQLActivationPolicyKey: {
+		do:          replaceSQLActivationPolicy,
+		permissions: [][]string{{"cloudsql.instances.update"}, {"cloudsql.instances.get"}},
+	},
+	replaceSQLTierKey: {
+		do:          replaceSQLTier,
+		permissions: [][]string{{"cloudsql.instances.update"}, {"cloudsql.instances.get"}},
+	},
+	copyInstanceTemplateKey: {
+		do:          copyInstanceTemplate,
+		permissions: [][]string{{"compute.instanceTemplates.get"}},
+	},
+	replaceTemplateMachineTypeKey: {
+		do:          replaceTemplateMachineType,
+		permissions: [][]string{{"compute.instanceTemplates.create"}, {"compute.instanceTemplates.delete"}},
+	},
+	replaceGroupTemplateKey: {
+		do: replaceGroupTemplate,
+		permissions: [][]string{{"compute.instanceGroupManagers.get"}, {"compute.instanceGroupManagers.update"},
+			{"compute.instanceTemplates.useReadOnly"}},
+	},
+	replaceGroupVersionTemplateKey: {
+		do: replaceGroupTemplate,
+		permissions: [][]string{{"compute.instanceGroupManagers.get"}, {"compute.instanceGroupManagers.update"},
+			{"compute.instanceTemplates.useReadOnly"}},
+	},
+}
+
+// findOperationHandler returns the handler for the operation.
+// Handlers registered for the exact path are preferred over the ones matching any path.
+func findOperationHandler(operation *gcloudOperation) (*operationHandler, bool) {
+	key := operationKey{strings.ToLower(operation.Action), operation.ResourceType, operation.Path}
+	if handler, ok := operationHandlers[key]; ok {
+		return handler, true
+	}
+	key.path = ""
+	handler, ok := operationHandlers[key]
+	return handler, ok
+}
+
+// checkOperationsSupported returns an error, if one of the operations of the recommendation has no handler.
+func checkOperationsSupported(recommendation *gcloudRecommendation) error {
+	for _, operationGroup := range recommendation.Content.OperationGroups {
+		for _, operation := range operationGroup.Operations {
+			if _, ok := findOperationHandler(operation); !ok {
 				return errors.New(operationNotSupportedMessage)
 			}
-
-			return stopInstance(service, operation)
-		}
-	case "add":
-		switch operation.ResourceType {
-		case "compute.googleapis.com/Snapshot":
-			return addSnapshot(service, operation)
 		}
+	}
+	return nil
+}
 
-	case "remove":
-		switch operation.ResourceType {
-		case "compute.googleapis.com/Disk":
-			return removeDisk(service, operation)
+// DoOperation does the action specified in the operation.
+// Returns the result describing the change made, nil for test operations.
+// The result is returned also with an error, if the change may have been made,
+// for example if deleting a disk failed after its snapshot had been checked.
+// Actions reverting the changes made are registered in rollback, which can be nil.
+// If ctx has safeguards set by WithSafeguards, *SkippedError is returned
+// instead of changing a resource, that they protect.
+func DoOperation(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	handler, ok := findOperationHandler(operation)
+	if !ok {
+		return nil, errors.New(operationNotSupportedMessage)
+	}
+	if safeguards := safeguardsFromContext(ctx); safeguards != nil {
+		if err := safeguards.Check(ctx, service, operation); err != nil {
+			return nil, err
 		}
 	}
-
-	return errors.New(operationNotSupportedMessage)
+	return handler.do(ctx, service, operation, rollback)
 }
 
-// DoOperations calls DoOperation for each operation specified in the recommendation
-func DoOperations(service GoogleService, recommendation *gcloudRecommendation, task *Task) error {
+// DoOperations calls DoOperation for each operation specified in the recommendation.
+// Returns the results of the operations that changed resources, in order,
+// also the ones done before an error.
+// Actions reverting the changes made are registered in rollback, which can be nil.
+// If ctx is canceled, the operation in progress is finished or stopped safely
+// and the error of ctx is returned before the next one.
+func DoOperations(ctx context.Context, service GoogleService, recommendation *gcloudRecommendation, task *Task, rollback *Rollback) ([]*OperationResult, error) {
+	var results []*OperationResult
 	task.SetNumberOfSubtasks(len(recommendation.Content.OperationGroups))
 	for _, operationGroup := range recommendation.Content.OperationGroups {
+		ctx := withInstanceTemplates(withCreatedSnapshots(ctx))
 		subtask := task.GetNextSubtask()
 		subtask.SetNumberOfSubtasks(len(operationGroup.Operations))
 		for _, operation := range operationGroup.Operations {
-			err := DoOperation(service, operation)
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			result, err := DoOperation(ctx, service, operation, rollback)
+			if result != nil {
+				results = append(results, result)
+			}
 			if err != nil {
-				return err
+				return results, err
 			}
 			subtask.IncrementDone()
 		}
@@ -95,57 +256,106 @@ func DoOperations(service GoogleService, recommendation *gcloudRecommendation, t
 
 	task.SetAllDone()
 
-	return nil
+	return results, nil
+}
+
+type appliedRecommendationKey struct{}
+
+// appliedRecommendation identifies the recommendation being applied or reverted.
+type appliedRecommendation struct {
+	name string
+	etag string
+}
+
+// AppliedRecommendation returns the name and the etag, with which the recommendation
+// being applied with ctx was passed to Apply, or the name passed to Revert, then etag is empty.
+// ok is false, if ctx isn't from Apply or Revert.
+func AppliedRecommendation(ctx context.Context) (name, etag string, ok bool) {
+	applied, ok := ctx.Value(appliedRecommendationKey{}).(appliedRecommendation)
+	return applied.name, applied.etag, ok
+}
+
+type operationResultsKey struct{}
+
+// OperationResults returns the results of the operations done by Apply,
+// when ctx is passed to MarkRecommendationSucceeded or MarkRecommendationFailed after them.
+// It lets decorators of GoogleService, for example the audit log, record the changes made.
+func OperationResults(ctx context.Context) []*OperationResult {
+	results, _ := ctx.Value(operationResultsKey{}).([]*OperationResult)
+	return results
 }
 
 // Apply is the method used to apply recommendations from Recommender API.
-// Supports recommendations from the following recommenders:
-// - google.compute.disk.IdleResourceRecommender
-// - google.compute.instance.IdleResourceRecommender
-// - google.compute.instance.MachineTypeRecommender
-func Apply(service GoogleService, recommendation *gcloudRecommendation, task *Task) error {
+// Supports recommendations from the recommenders in googleRecommenders,
+// which have handlers for their operations.
+// Returns the results of the operations that changed resources, also if applying failed
+// after some of them, for example the snapshot created before deleting a disk failed.
+// If one of the operations fails, the changes already made are reverted if possible,
+// and *ApplyError containing the outcome of the rollback is returned. It's returned
+// with the error of marking the recommendation failed too, if that fails.
+// Canceling ctx stops applying after the current operation, the changes are then
+// reverted in the same way. Rollback and marking the recommendation are never canceled.
+// If the safeguards set in ctx by WithSafeguards refuse one of the operations,
+// *SkippedError is returned before the recommendation is claimed.
+// If one of the operations isn't supported, an error is returned before the recommendation is claimed.
+// The calls to service are made with the context, from which AppliedRecommendation gets the recommendation.
+func Apply(ctx context.Context, service GoogleService, recommendation *gcloudRecommendation, task *Task) ([]*OperationResult, error) {
 	if strings.ToLower(recommendation.StateInfo.State) != "active" {
-		return errors.New("to apply a recommendation, its status must be active")
+		return nil, errors.New("to apply a recommendation, its status must be active")
+	}
+	ctx = context.WithValue(ctx, appliedRecommendationKey{},
+		appliedRecommendation{name: recommendation.Name, etag: recommendation.Etag})
+	if err := checkOperationsSupported(recommendation); err != nil {
+		return nil, err
+	}
+	if err := checkSafeguards(ctx, service, recommendation); err != nil {
+		return nil, err
 	}
 
 	task.SetNumberOfSubtasks(3) // MarkClaimed + DoOperations + MarkSucceeded
 
 	_ = task.GetNextSubtask()
-	newRecommendation, err := service.MarkRecommendationClaimed(recommendation.Name, recommendation.Etag)
+	newRecommendation, err := service.MarkRecommendationClaimed(ctx, recommendation.Name, recommendation.Etag)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	task.IncrementDone()
 	*recommendation = *newRecommendation
 
-	err = DoOperations(service, recommendation, task.GetNextSubtask())
+	rollback := &Rollback{}
+	results, err := DoOperations(ctx, service, recommendation, task.GetNextSubtask(), rollback)
+	ctx = context.WithValue(withoutCancel(ctx), operationResultsKey{}, results)
 	if err != nil {
-		newRecommendation, errMark := service.MarkRecommendationFailed(recommendation.Name, recommendation.Etag)
+		rollbackResult := rollback.Run()
+		newRecommendation, errMark := service.MarkRecommendationFailed(ctx, recommendation.Name, recommendation.Etag)
 		if errMark != nil {
-			return errMark
+			return results, &ApplyError{Err: err, Rollback: rollbackResult, MarkErr: errMark}
 		}
 		*recommendation = *newRecommendation
 
-		return err
+		if rollbackResult != nil {
+			return results, &ApplyError{Err: err, Rollback: rollbackResult}
+		}
+		return results, err
 	}
 	task.IncrementDone()
 
-	newRecommendation, err = service.MarkRecommendationSucceeded(recommendation.Name, recommendation.Etag)
+	newRecommendation, err = service.MarkRecommendationSucceeded(ctx, recommendation.Name, recommendation.Etag)
 	if err != nil {
-		return err
+		return results, err
 	}
 	task.IncrementDone()
 	*recommendation = *newRecommendation
 
 	task.SetAllDone()
-	return nil
+	return results, nil
 }
 
 // ApplyByName gets the recommendation by name and applies the recommendation using the Apply function.
-func ApplyByName(service GoogleService, recommendationName string, task *Task) error {
-	recommendation, err := service.GetRecommendation(recommendationName)
+func ApplyByName(ctx context.Context, service GoogleService, recommendationName string, task *Task) ([]*OperationResult, error) {
+	recommendation, err := service.GetRecommendation(ctx, recommendationName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return Apply(service, recommendation, task)
+	return Apply(ctx, service, recommendation, task)
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -42,6 +43,7 @@ type ApplyMockService struct {
 	calledFunctions   []calledFunction
 	getInstanceResult *compute.Instance
 	recommendation    gcloudRecommendation
+	createdSnapshot   *compute.Snapshot
 }
 
 // Creates an array of type calledFunction, given arrays of functions,
@@ -72,7 +74,7 @@ func recommendationNewEtag(recommendation gcloudRecommendation) gcloudRecommenda
 
 var errorGetRecommendation = fmt.Errorf("GetRecommendation error")
 
-func (s *ApplyMockService) GetRecommendation(name string) (*gcloudRecommendation, error) {
+func (s *ApplyMockService) GetRecommendation(ctx context.Context, name string) (*gcloudRecommendation, error) {
 	var rec *gcloudRecommendation
 	var err error
 	if name != "error" {
@@ -85,58 +87,104 @@ func (s *ApplyMockService) GetRecommendation(name string) (*gcloudRecommendation
 	return rec, err
 }
 
-func (s *ApplyMockService) GetInstance(project string, zone string, instance string) (*compute.Instance, error) {
+func (s *ApplyMockService) GetInstance(ctx context.Context, project string, zone string, instance string) (*compute.Instance, error) {
 	newCalledFunction := calledFunction{"GetInstance", []interface{}{project, zone, instance}, []interface{}{s.getInstanceResult, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return s.getInstanceResult, nil
 }
 
-func (s *ApplyMockService) StopInstance(project string, zone string, instance string) error {
+func (s *ApplyMockService) StopInstance(ctx context.Context, project string, zone string, instance string) error {
 	newCalledFunction := calledFunction{"StopInstance", []interface{}{project, zone, instance}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *ApplyMockService) ChangeMachineType(project string, zone string, instance string, machineType string) error {
+func (s *ApplyMockService) ChangeMachineType(ctx context.Context, project string, zone string, instance string, machineType string) error {
 	newCalledFunction := calledFunction{"ChangeMachineType", []interface{}{project, zone, instance, machineType}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *ApplyMockService) StartInstance(project string, zone string, instance string) error {
+func (s *ApplyMockService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
 	newCalledFunction := calledFunction{"StartInstance", []interface{}{project, zone, instance}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *ApplyMockService) CreateSnapshot(project string, zone string, disk string, name string) error {
+func (s *ApplyMockService) CreateSnapshot(ctx context.Context, project string, zone string, disk string, snapshot *compute.Snapshot) error {
+	s.createdSnapshot = snapshot
 	// it is not possible to say what the name should be equal to
 	newCalledFunction := calledFunction{"CreateSnapshot", []interface{}{project, zone, disk, ""}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *ApplyMockService) DeleteDisk(project string, zone string, disk string) error {
+// idleDisk is returned by ApplyMockService.GetDisk, it can be deleted.
+var idleDisk = &compute.Disk{
+	Name:              "idle-disk",
+	CreationTimestamp: "2020-07-01T10:00:00.000-07:00",
+	Type:              "https://www.googleapis.com/compute/v1/projects/rightsizer-test/zones/europe-west1-d/diskTypes/pd-standard",
+	SizeGb:            10,
+}
+
+func (s *ApplyMockService) GetDisk(ctx context.Context, project string, zone string, disk string) (*compute.Disk, error) {
+	newCalledFunction := calledFunction{"GetDisk", []interface{}{project, zone, disk}, []interface{}{idleDisk, nil}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return idleDisk, nil
+}
+
+func (s *ApplyMockService) GetSnapshot(ctx context.Context, project string, snapshot string) (*compute.Snapshot, error) {
+	result := &compute.Snapshot{Name: snapshot, Status: "READY", SelfLink: snapshotSelfLink(project, snapshot)}
+	// the name of the snapshot is random, as in CreateSnapshot
+	newCalledFunction := calledFunction{"GetSnapshot", []interface{}{project, ""}, []interface{}{"READY", nil}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return result, nil
+}
+
+// snapshotSelfLink returns the URL of the snapshot, as in the snapshots returned by Compute Engine API.
+func snapshotSelfLink(project, snapshot string) string {
+	return "https://www.googleapis.com/compute/v1/projects/" + project + "/global/snapshots/" + snapshot
+}
+
+func (s *ApplyMockService) CreateDisk(ctx context.Context, project string, zone string, disk *compute.Disk) error {
+	newCalledFunction := calledFunction{"CreateDisk", []interface{}{project, zone, disk}, []interface{}{nil}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return nil
+}
+
+func (s *ApplyMockService) DeleteDisk(ctx context.Context, project string, zone string, disk string) error {
 	newCalledFunction := calledFunction{"DeleteDisk", []interface{}{project, zone, disk}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *ApplyMockService) MarkRecommendationClaimed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *ApplyMockService) ReleaseAddress(ctx context.Context, project string, region string, address string) error {
+	newCalledFunction := calledFunction{"ReleaseAddress", []interface{}{project, region, address}, []interface{}{nil}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return nil
+}
+
+func (s *ApplyMockService) DeleteImage(ctx context.Context, project string, image string) error {
+	newCalledFunction := calledFunction{"DeleteImage", []interface{}{project, image}, []interface{}{nil}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return nil
+}
+
+func (s *ApplyMockService) MarkRecommendationClaimed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	s.recommendation = recommendationNewEtag(s.recommendation)
 	newCalledFunction := calledFunction{"MarkRecommendationClaimed", []interface{}{name, etag}, []interface{}{s.recommendation, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return &s.recommendation, nil
 }
 
-func (s *ApplyMockService) MarkRecommendationSucceeded(name string, etag string) (*gcloudRecommendation, error) {
+func (s *ApplyMockService) MarkRecommendationSucceeded(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	s.recommendation = recommendationNewEtag(s.recommendation)
 	newCalledFunction := calledFunction{"MarkRecommendationSucceeded", []interface{}{name, etag}, []interface{}{s.recommendation, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return &s.recommendation, nil
 }
 
-func (s *ApplyMockService) MarkRecommendationFailed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *ApplyMockService) MarkRecommendationFailed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	s.recommendation = recommendationNewEtag(s.recommendation)
 	newCalledFunction := calledFunction{"MarkRecommendationFailed", []interface{}{name, etag}, []interface{}{s.recommendation, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
@@ -145,6 +193,7 @@ func (s *ApplyMockService) MarkRecommendationFailed(name string, etag string) (*
 
 // Checks if the test machine type operation works as expected.
 func TestTestMachineTypeOperation(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "test",
 		Path:         "/machineType",
@@ -154,7 +203,7 @@ func TestTestMachineTypeOperation(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/n1-standard-4"}}
-	err := DoOperation(&service, &operation)
+	_, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
 	expectedFunctions := []string{"GetInstance"}
@@ -167,6 +216,7 @@ func TestTestMachineTypeOperation(t *testing.T) {
 
 // Checks if the test status operation works as expected.
 func TestTestStatusOperation(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "test",
 		Path:         "/status",
@@ -176,7 +226,7 @@ func TestTestStatusOperation(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{Status: "RUNNING"}}
-	err := DoOperation(&service, &operation)
+	_, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
 	expectedFunctions := []string{"GetInstance"}
@@ -189,6 +239,7 @@ func TestTestStatusOperation(t *testing.T) {
 
 // Checks if the replace machine type operation works as expected.
 func TestReplaceMachineTypeOperation(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "replace",
 		Path:         "/machineType",
@@ -197,24 +248,38 @@ func TestReplaceMachineTypeOperation(t *testing.T) {
 		Value:        "zones/us-east1-b/machineTypes/custom-2-5120",
 	}
 
-	service := ApplyMockService{}
-	err := DoOperation(&service, &operation)
+	instance := &compute.Instance{Status: "RUNNING", MachineType: "zones/us-east1-b/machineTypes/n1-standard-4"}
+	service := ApplyMockService{getInstanceResult: instance}
+	result, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
-	expectedFunctions := []string{"StopInstance", "ChangeMachineType", "StartInstance"}
+	expectedFunctions := []string{"GetInstance", "StopInstance", "ChangeMachineType", "StartInstance"}
 	expectedArguments := [][]interface{}{
+		{"rightsizer-test", "us-east1-b", "alicja-test"},
 		{"rightsizer-test", "us-east1-b", "alicja-test"},
 		{"rightsizer-test", "us-east1-b", "alicja-test", "custom-2-5120"},
 		{"rightsizer-test", "us-east1-b", "alicja-test"},
 	}
-	expectedResults := [][]interface{}{{nil}, {nil}, {nil}}
+	expectedResults := [][]interface{}{{instance, nil}, {nil}, {nil}, {nil}}
 
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
 	assert.Equal(t, expected, service.calledFunctions)
+
+	expectedResult := &OperationResult{
+		Action:           ActionSetMachineType,
+		Project:          "rightsizer-test",
+		Location:         "us-east1-b",
+		Resource:         "alicja-test",
+		Value:            "custom-2-5120",
+		PreviousValue:    "n1-standard-4",
+		StoppedInstances: []string{"alicja-test"},
+	}
+	assert.Equal(t, expectedResult, result)
 }
 
 // Checks if the replace status operation works as expected.
 func TestReplaceStatusOperation(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "replace",
 		Path:         "/status",
@@ -223,20 +288,48 @@ func TestReplaceStatusOperation(t *testing.T) {
 		Value:        "TERMINATED",
 	}
 
-	service := ApplyMockService{}
-	err := DoOperation(&service, &operation)
+	service := ApplyMockService{getInstanceResult: &compute.Instance{Status: "RUNNING"}}
+	result, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
-	expectedFunctions := []string{"StopInstance"}
-	expectedArguments := [][]interface{}{{"rightsizer-test", "us-central1-a", "vkovalova-instance-memory-1"}}
-	expectedResults := [][]interface{}{{nil}}
+	expectedFunctions := []string{"GetInstance", "StopInstance"}
+	args := []interface{}{"rightsizer-test", "us-central1-a", "vkovalova-instance-memory-1"}
+	expectedArguments := [][]interface{}{args, args}
+	expectedResults := [][]interface{}{{service.getInstanceResult, nil}, {nil}}
 
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
 	assert.Equal(t, expected, service.calledFunctions)
+
+	if assert.NotNil(t, result) {
+		assert.Equal(t, []string{"vkovalova-instance-memory-1"}, result.StoppedInstances)
+	}
+}
+
+// Checks that stopping an instance, which isn't running, doesn't report it as stopped
+// and doesn't start it on rollback.
+func TestReplaceStatusOperationNotRunning(t *testing.T) {
+	ctx := context.Background()
+	operation := gcloudOperation{
+		Action:       "replace",
+		Path:         "/status",
+		Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/vkovalova-instance-memory-1",
+		ResourceType: "compute.googleapis.com/Instance",
+		Value:        "TERMINATED",
+	}
+
+	service := ApplyMockService{getInstanceResult: &compute.Instance{Status: "TERMINATED"}}
+	rollback := &Rollback{}
+	result, err := DoOperation(ctx, &service, &operation, rollback)
+	assert.NoError(t, err, "DoOperation shouldn't return an error")
+	if assert.NotNil(t, result) {
+		assert.Empty(t, result.StoppedInstances)
+	}
+	assert.Nil(t, rollback.Run(), "the instance shouldn't be started on rollback")
 }
 
 // Checks if the add snapshot operation works as expected.
 func TestAddSnapshotOperation(t *testing.T) {
+	ctx := context.Background()
 	var value interface{}
 	val := `
 	{
@@ -260,19 +353,31 @@ func TestAddSnapshotOperation(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err = DoOperation(&service, &operation)
+	result, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
-	expectedFunctions := []string{"CreateSnapshot"}
-	expectedArguments := [][]interface{}{{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress", ""}}
-	expectedResults := [][]interface{}{{nil}}
+	expectedFunctions := []string{"CreateSnapshot", "GetSnapshot"}
+	expectedArguments := [][]interface{}{{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress", ""}, {"rightsizer-test", ""}}
+	expectedResults := [][]interface{}{{nil}, {"READY", nil}}
 
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
 	assert.Equal(t, expected, service.calledFunctions)
+
+	if assert.NotNil(t, result) {
+		assert.Equal(t, ActionCreateSnapshot, result.Action)
+		assert.Equal(t, "vertical-scaling-krzysztofk-wordpress", result.Resource)
+		assert.NotEmpty(t, result.SnapshotName)
+		assert.Equal(t, snapshotSelfLink("rightsizer-test", result.SnapshotName), result.SnapshotSelfLink)
+	}
+	if assert.NotNil(t, service.createdSnapshot) {
+		assert.Equal(t, result.SnapshotName, service.createdSnapshot.Name)
+		assert.Equal(t, "recomator", service.createdSnapshot.Labels[SnapshotCreatorLabel], "Snapshot should be labelled")
+	}
 }
 
 // Checks if the remove disk operation works as expected.
 func TestRemoveDiskOperation(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "remove",
 		Path:         "/",
@@ -281,11 +386,69 @@ func TestRemoveDiskOperation(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err := DoOperation(&service, &operation)
+	_, err := DoOperation(ctx, &service, &operation, nil)
+	assert.NoError(t, err, "DoOperation shouldn't return an error")
+
+	expectedFunctions := []string{"GetDisk", "DeleteDisk"}
+	expectedArguments := [][]interface{}{
+		{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress"},
+		{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress"},
+	}
+	expectedResults := [][]interface{}{{idleDisk, nil}, {nil}}
+
+	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
+	assert.Equal(t, expected, service.calledFunctions)
+}
+
+// Checks if the remove address operation works as expected
+// for regional and global addresses.
+func TestRemoveAddressOperation(t *testing.T) {
+	ctx := context.Background()
+	operations := []gcloudOperation{
+		{
+			Action:       "remove",
+			Path:         "/",
+			Resource:     "//compute.googleapis.com/projects/rightsizer-test/regions/us-central1/addresses/idle-address",
+			ResourceType: "compute.googleapis.com/Address",
+		},
+		{
+			Action:       "remove",
+			Path:         "/",
+			Resource:     "//compute.googleapis.com/projects/rightsizer-test/global/addresses/idle-global-address",
+			ResourceType: "compute.googleapis.com/Address",
+		},
+	}
+
+	service := ApplyMockService{}
+	for i := range operations {
+		_, err := DoOperation(ctx, &service, &operations[i], nil)
+		assert.NoError(t, err, "DoOperation shouldn't return an error")
+	}
+
+	expectedFunctions := []string{"ReleaseAddress", "ReleaseAddress"}
+	expectedArguments := [][]interface{}{{"rightsizer-test", "us-central1", "idle-address"}, {"rightsizer-test", "", "idle-global-address"}}
+	expectedResults := [][]interface{}{{nil}, {nil}}
+
+	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
+	assert.Equal(t, expected, service.calledFunctions)
+}
+
+// Checks if the remove image operation works as expected.
+func TestRemoveImageOperation(t *testing.T) {
+	ctx := context.Background()
+	operation := gcloudOperation{
+		Action:       "remove",
+		Path:         "/",
+		Resource:     "//compute.googleapis.com/projects/rightsizer-test/global/images/unused-image",
+		ResourceType: "compute.googleapis.com/Image",
+	}
+
+	service := ApplyMockService{}
+	_, err := DoOperation(ctx, &service, &operation, nil)
 	assert.NoError(t, err, "DoOperation shouldn't return an error")
 
-	expectedFunctions := []string{"DeleteDisk"}
-	expectedArguments := [][]interface{}{{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress"}}
+	expectedFunctions := []string{"DeleteImage"}
+	expectedArguments := [][]interface{}{{"rightsizer-test", "unused-image"}}
 	expectedResults := [][]interface{}{{nil}}
 
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
@@ -295,6 +458,7 @@ func TestRemoveDiskOperation(t *testing.T) {
 // Checks if receiving an operation without necessary parameter
 // returns the correct error.
 func TestResourceWithoutNecessaryParams(t *testing.T) {
+	ctx := context.Background()
 	operation := gcloudOperation{
 		Action:       "remove",
 		Path:         "/",
@@ -303,7 +467,7 @@ func TestResourceWithoutNecessaryParams(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err := DoOperation(&service, &operation)
+	_, err := DoOperation(ctx, &service, &operation, nil)
 	assert.EqualError(t, err, fmt.Sprintf("url %s does not contain the parameter %s", operation.Resource, projectParam))
 	var nilCalledFunction []calledFunction = nil
 
@@ -313,6 +477,7 @@ func TestResourceWithoutNecessaryParams(t *testing.T) {
 // Checks if applying recommendation with stopping the machine
 // works as expected.
 func TestStopRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -343,21 +508,24 @@ func TestStopRecommendation(t *testing.T) {
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{Status: "RUNNING"}}
 	task := &Task{}
-	err := DoOperations(&service, &recommendation, task)
+	_, err := DoOperations(ctx, &service, &recommendation, task, nil)
 	assert.NoError(t, err, "DoOperations shouldn't return an error")
 
 	done, all := task.GetProgress()
 	assert.True(t, done == all, "All should be done for DoOperations")
 
 	expectedFunctions := []string{
+		"GetInstance",
 		"GetInstance",
 		"StopInstance",
 	}
 	expectedArguments := [][]interface{}{
 		{"rightsizer-test", "us-central1-a", "vkovalova-instance-memory-1"},
 		{"rightsizer-test", "us-central1-a", "vkovalova-instance-memory-1"},
+		{"rightsizer-test", "us-central1-a", "vkovalova-instance-memory-1"},
 	}
 	expectedResults := [][]interface{}{
+		{&compute.Instance{Status: "RUNNING"}, nil},
 		{&compute.Instance{Status: "RUNNING"}, nil},
 		{nil},
 	}
@@ -369,6 +537,7 @@ func TestStopRecommendation(t *testing.T) {
 // Checks if applying recommmendation with adding snapshot of a machine
 // and then deleting it works as expected.
 func TestSnapshotAndDeleteRecommendation(t *testing.T) {
+	ctx := context.Background()
 	var valueAddSnapshot interface{}
 	value := `
 	{
@@ -413,29 +582,49 @@ func TestSnapshotAndDeleteRecommendation(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err = DoOperations(&service, &recommendation, &Task{})
+	results, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.NoError(t, err, "DoOperations shouldn't return an error")
 
 	expectedFunctions := []string{
 		"CreateSnapshot",
+		"GetSnapshot",
+		"GetDisk",
+		"GetSnapshot",
 		"DeleteDisk",
 	}
 	expectedArguments := [][]interface{}{
 		{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress", ""},
+		{"rightsizer-test", ""},
+		{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress"},
+		{"rightsizer-test", ""},
 		{"rightsizer-test", "europe-west1-d", "vertical-scaling-krzysztofk-wordpress"},
 	}
 	expectedResults := [][]interface{}{
 		{nil},
+		{"READY", nil},
+		{idleDisk, nil},
+		{"READY", nil},
 		{nil},
 	}
 
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
 	assert.Equal(t, expected, service.calledFunctions)
+
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, ActionCreateSnapshot, results[0].Action)
+		assert.Equal(t, ActionDeleteDisk, results[1].Action)
+		assert.NotEmpty(t, results[1].SnapshotName, "deleted disk should have the snapshot name")
+		assert.Equal(t, results[0].SnapshotName, results[1].SnapshotName)
+		assert.Equal(t, results[0].SnapshotSelfLink, results[1].SnapshotSelfLink)
+		assert.Equal(t, "pd-standard", results[1].DiskType, "Type of the deleted disk should be recorded")
+		assert.Equal(t, int64(10), results[1].DiskSizeGb, "Size of the deleted disk should be recorded")
+	}
 }
 
 // Checks if applying a recommendation with replacing machine type
 // works as expected.
 func TestReplaceRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -465,10 +654,11 @@ func TestReplaceRecommendation(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.NoError(t, err, "DoOperations shouldn't return an error")
 
 	expectedFunctions := []string{
+		"GetInstance",
 		"GetInstance",
 		"StopInstance",
 		"ChangeMachineType",
@@ -477,10 +667,12 @@ func TestReplaceRecommendation(t *testing.T) {
 	expectedArguments := [][]interface{}{
 		{"rightsizer-test", "us-central1-a", "sidsharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
+		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver", "e2-medium"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 	}
 	expectedResults := [][]interface{}{
+		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
 		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
 		{nil},
 		{nil},
@@ -494,6 +686,7 @@ func TestReplaceRecommendation(t *testing.T) {
 // Checks, that the attempt to apply a not active recommendation
 // results in the expected error.
 func TestNotActiveRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -523,7 +716,7 @@ func TestNotActiveRecommendation(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err := Apply(&service, &recommendation, &Task{})
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
 	assert.EqualError(t, err, "to apply a recommendation, its status must be active")
 	var nilCalledFunction []calledFunction = nil
 
@@ -533,6 +726,7 @@ func TestNotActiveRecommendation(t *testing.T) {
 // Checks, that the attempt to apply a recommendation with unknown action
 // results in the expected error.
 func TestUnsupportedAction(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -562,7 +756,7 @@ func TestUnsupportedAction(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 
 	assert.EqualError(t, err, operationNotSupportedMessage)
 	var nilCalledFunction []calledFunction = nil
@@ -573,6 +767,7 @@ func TestUnsupportedAction(t *testing.T) {
 // Checks, that the attempt to apply a recommendation with unknown path
 // results in the expected error.
 func TestUnsupportedPath(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -602,7 +797,7 @@ func TestUnsupportedPath(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.EqualError(t, err, operationNotSupportedMessage)
 	expectedFunctions := []string{
 		"GetInstance",
@@ -621,6 +816,7 @@ func TestUnsupportedPath(t *testing.T) {
 // Checks, that the attempt to apply a recommendation with
 // unknown resource type results in the expected error.
 func TestUnsupportedResourceType(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -650,7 +846,7 @@ func TestUnsupportedResourceType(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.EqualError(t, err, operationNotSupportedMessage)
 	var nilCalledFunctions []calledFunction = nil
 
@@ -660,6 +856,7 @@ func TestUnsupportedResourceType(t *testing.T) {
 // Checks, that the attempt to apply a recommendation with unknown
 // unknown replace value results in the expected error.
 func TestUnsupportedReplaceValue(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -689,7 +886,7 @@ func TestUnsupportedReplaceValue(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2", Status: "RUNNING"}}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.EqualError(t, err, operationNotSupportedMessage)
 	expectedFunctions := []string{
 		"GetInstance",
@@ -708,6 +905,7 @@ func TestUnsupportedReplaceValue(t *testing.T) {
 // Checks, that the attempt to apply a recommendation that adds
 // a resource of unknown type results in the expected error.
 func TestUnsupportedAddResourceType(t *testing.T) {
+	ctx := context.Background()
 	var valueAddSnapshot interface{}
 	value := `
 	{
@@ -752,7 +950,7 @@ func TestUnsupportedAddResourceType(t *testing.T) {
 	}
 
 	service := ApplyMockService{}
-	err = DoOperations(&service, &recommendation, &Task{})
+	_, err = DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.EqualError(t, err, operationNotSupportedMessage)
 	var nilCalledFunction []calledFunction = nil
 
@@ -762,6 +960,7 @@ func TestUnsupportedAddResourceType(t *testing.T) {
 // Checks that when a test operation fails, the other one is not performed,
 // and the expected error is returned.
 func TestFailedTest(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -791,7 +990,7 @@ func TestFailedTest(t *testing.T) {
 	}
 
 	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "@#$%!E"}}
-	err := DoOperations(&service, &recommendation, &Task{})
+	_, err := DoOperations(ctx, &service, &recommendation, &Task{}, nil)
 	assert.EqualError(t, err, "machine type is not as expected")
 	expectedFunctions := []string{
 		"GetInstance",
@@ -810,6 +1009,7 @@ func TestFailedTest(t *testing.T) {
 // Test checking  if apply function which encounters error
 // works as expected
 func TestApplyFailed(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -841,7 +1041,7 @@ func TestApplyFailed(t *testing.T) {
 	recommendationCopy := recommendation
 
 	service := ApplyMockService{recommendation: recommendation, getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-123"}}
-	err := Apply(&service, &recommendation, &Task{})
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
 	assert.EqualError(t, err, "machine type is not as expected")
 
 	expectedFunctions := []string{
@@ -869,7 +1069,7 @@ type FailedClaimService struct {
 	calledFunctions []calledFunction
 }
 
-func (s *FailedClaimService) MarkRecommendationClaimed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *FailedClaimService) MarkRecommendationClaimed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	newCalledFunction := calledFunction{"MarkRecommendationClaimed", []interface{}{name, etag}, []interface{}{nil, errors.New("recommendation couldn't be marked claimed")}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 
@@ -879,6 +1079,7 @@ func (s *FailedClaimService) MarkRecommendationClaimed(name string, etag string)
 // Checks, that a failure to mark a recommendation as claimed
 // leads to the expected behaviour.
 func TestFailedClaimRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -908,7 +1109,7 @@ func TestFailedClaimRecommendation(t *testing.T) {
 	}
 
 	service := FailedClaimService{}
-	err := Apply(&service, &recommendation, &Task{})
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
 	assert.EqualError(t, err, "recommendation couldn't be marked claimed")
 
 	expectedFunctions := []string{
@@ -930,40 +1131,41 @@ type FailedSucceedService struct {
 	calledFunctions   []calledFunction
 	getInstanceResult *compute.Instance
 	recommendation    gcloudRecommendation
+	createdSnapshot   *compute.Snapshot
 }
 
-func (s *FailedSucceedService) GetInstance(project string, zone string, instance string) (*compute.Instance, error) {
+func (s *FailedSucceedService) GetInstance(ctx context.Context, project string, zone string, instance string) (*compute.Instance, error) {
 	newCalledFunction := calledFunction{"GetInstance", []interface{}{project, zone, instance}, []interface{}{s.getInstanceResult, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return s.getInstanceResult, nil
 }
 
-func (s *FailedSucceedService) StopInstance(project string, zone string, instance string) error {
+func (s *FailedSucceedService) StopInstance(ctx context.Context, project string, zone string, instance string) error {
 	newCalledFunction := calledFunction{"StopInstance", []interface{}{project, zone, instance}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *FailedSucceedService) ChangeMachineType(project string, zone string, instance string, machineType string) error {
+func (s *FailedSucceedService) ChangeMachineType(ctx context.Context, project string, zone string, instance string, machineType string) error {
 	newCalledFunction := calledFunction{"ChangeMachineType", []interface{}{project, zone, instance, machineType}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *FailedSucceedService) StartInstance(project string, zone string, instance string) error {
+func (s *FailedSucceedService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
 	newCalledFunction := calledFunction{"StartInstance", []interface{}{project, zone, instance}, []interface{}{nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil
 }
 
-func (s *FailedSucceedService) MarkRecommendationClaimed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *FailedSucceedService) MarkRecommendationClaimed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	s.recommendation = recommendationNewEtag(s.recommendation)
 	newCalledFunction := calledFunction{"MarkRecommendationClaimed", []interface{}{name, etag}, []interface{}{s.recommendation, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return &s.recommendation, nil
 }
 
-func (s *FailedSucceedService) MarkRecommendationSucceeded(name string, etag string) (*gcloudRecommendation, error) {
+func (s *FailedSucceedService) MarkRecommendationSucceeded(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	newCalledFunction := calledFunction{"MarkRecommendationSucceeded", []interface{}{name, etag}, []interface{}{nil, errors.New("recommendation couldn't be marked succeeded")}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil, errors.New("recommendation couldn't be marked succeeded")
@@ -972,6 +1174,7 @@ func (s *FailedSucceedService) MarkRecommendationSucceeded(name string, etag str
 // Checks that failing to mark a recommendation as succeeded leads
 // to the expected behaviour.
 func TestFailedSucceedRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -1003,12 +1206,13 @@ func TestFailedSucceedRecommendation(t *testing.T) {
 	recommendationCopy := recommendation
 
 	service := FailedSucceedService{recommendation: recommendation, getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}}
-	err := Apply(&service, &recommendation, &Task{})
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
 	assert.EqualError(t, err, "recommendation couldn't be marked succeeded")
 
 	expectedFunctions := []string{
 		"MarkRecommendationClaimed",
 		"GetInstance",
+		"GetInstance",
 		"StopInstance",
 		"ChangeMachineType",
 		"StartInstance",
@@ -1018,6 +1222,7 @@ func TestFailedSucceedRecommendation(t *testing.T) {
 		{recommendation.Name, recommendationCopy.Etag},
 		{"rightsizer-test", "us-central1-a", "sidsharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
+		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver", "e2-medium"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 		{recommendation.Name, recommendation.Etag},
@@ -1025,6 +1230,7 @@ func TestFailedSucceedRecommendation(t *testing.T) {
 	expectedResults := [][]interface{}{
 		{recommendation, nil},
 		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
+		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
 		{nil},
 		{nil},
 		{nil},
@@ -1041,14 +1247,20 @@ type FailedFailedService struct {
 	recommendation  gcloudRecommendation
 }
 
-func (s *FailedFailedService) MarkRecommendationClaimed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *FailedFailedService) MarkRecommendationClaimed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	s.recommendation = recommendationNewEtag(s.recommendation)
 	newCalledFunction := calledFunction{"MarkRecommendationClaimed", []interface{}{name, etag}, []interface{}{s.recommendation, nil}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return &s.recommendation, nil
 }
 
-func (s *FailedFailedService) MarkRecommendationFailed(name string, etag string) (*gcloudRecommendation, error) {
+func (s *FailedFailedService) GetInstance(ctx context.Context, project string, zone string, instance string) (*compute.Instance, error) {
+	newCalledFunction := calledFunction{"GetInstance", []interface{}{project, zone, instance}, []interface{}{nil, errors.New("instance couldn't be got")}}
+	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+	return nil, errors.New("instance couldn't be got")
+}
+
+func (s *FailedFailedService) MarkRecommendationFailed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
 	newCalledFunction := calledFunction{"MarkRecommendationFailed", []interface{}{name, etag}, []interface{}{nil, errors.New("recommendation couldn't be marked failed")}}
 	s.calledFunctions = append(s.calledFunctions, newCalledFunction)
 	return nil, errors.New("recommendation couldn't be marked failed")
@@ -1057,13 +1269,14 @@ func (s *FailedFailedService) MarkRecommendationFailed(name string, etag string)
 // Checks that failing to mark a recommendation as failed leads
 // to the expected behaviour.
 func TestFailedFailedRecommendation(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
 				&gcloudOperationGroup{
 					Operations: []*gcloudOperation{
 						&gcloudOperation{
-							Action:       "copy",
+							Action:       "test",
 							Path:         "/machineType",
 							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/sidsharan-e2-with-stackdriver",
 							ResourceType: "compute.googleapis.com/Instance",
@@ -1088,19 +1301,27 @@ func TestFailedFailedRecommendation(t *testing.T) {
 	recommendationCopy := recommendation
 
 	service := FailedFailedService{recommendation: recommendation}
-	err := Apply(&service, &recommendation, &Task{})
-	assert.EqualError(t, err, "recommendation couldn't be marked failed")
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError expected") {
+		assert.EqualError(t, applyErr.Err, "instance couldn't be got", "The error of applying should be kept")
+		assert.EqualError(t, applyErr.MarkErr, "recommendation couldn't be marked failed")
+		assert.Nil(t, applyErr.Rollback, "Nothing was changed")
+	}
 
 	expectedFunctions := []string{
 		"MarkRecommendationClaimed",
+		"GetInstance",
 		"MarkRecommendationFailed",
 	}
 	expectedArguments := [][]interface{}{
 		{recommendation.Name, recommendationCopy.Etag},
+		{"rightsizer-test", "us-central1-a", "sidsharan-e2-with-stackdriver"},
 		{recommendation.Name, recommendation.Etag},
 	}
 	expectedResults := [][]interface{}{
 		{recommendation, nil},
+		{nil, errors.New("instance couldn't be got")},
 		{nil, errors.New("recommendation couldn't be marked failed")},
 	}
 
@@ -1111,6 +1332,7 @@ func TestFailedFailedRecommendation(t *testing.T) {
 // Test checking if the correct execution of the apply function
 // works as expected
 func TestApplySucceeded(t *testing.T) {
+	ctx := context.Background()
 	recommendation := gcloudRecommendation{
 		Content: &gcloudContent{
 			OperationGroups: []*gcloudOperationGroup{
@@ -1144,7 +1366,7 @@ func TestApplySucceeded(t *testing.T) {
 	service := ApplyMockService{recommendation: recommendation, getInstanceResult: &compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}}
 
 	task := &Task{}
-	err := Apply(&service, &recommendation, task)
+	_, err := Apply(ctx, &service, &recommendation, task)
 	done, all := task.GetProgress()
 	assert.True(t, done == all, "Apply should be finished now")
 
@@ -1153,6 +1375,7 @@ func TestApplySucceeded(t *testing.T) {
 	expectedFunctions := []string{
 		"MarkRecommendationClaimed",
 		"GetInstance",
+		"GetInstance",
 		"StopInstance",
 		"ChangeMachineType",
 		"StartInstance",
@@ -1162,6 +1385,7 @@ func TestApplySucceeded(t *testing.T) {
 		{recommendationCopy.Name, recommendationCopy.Etag},
 		{"rightsizer-test", "us-central1-a", "sidsharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
+		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver", "e2-medium"},
 		{"rightsizer-test", "us-central1-a", "sidharan-e2-with-stackdriver"},
 		{recommendationCopy.Name, newEtag(recommendationCopy.Etag)},
@@ -1169,6 +1393,7 @@ func TestApplySucceeded(t *testing.T) {
 	expectedResults := [][]interface{}{
 		{recommendationNewEtag(recommendationCopy), nil},
 		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
+		{&compute.Instance{MachineType: "zones/us-east1-b/machineTypes/e2-standard-2"}, nil},
 		{nil},
 		{nil},
 		{nil},
@@ -1189,11 +1414,12 @@ var emptyRecommendation = gcloudRecommendation{
 }
 
 func TestApplyByName(t *testing.T) {
+	ctx := context.Background()
 	recommendation := emptyRecommendation
 
 	mock := &ApplyMockService{recommendation: recommendation}
 	task := &Task{}
-	err := ApplyByName(mock, recommendation.Name, task)
+	_, err := ApplyByName(ctx, mock, recommendation.Name, task)
 	done, all := task.GetProgress()
 	assert.True(t, done == all, "ApplyByName should be finished now")
 
@@ -1221,11 +1447,12 @@ func TestApplyByName(t *testing.T) {
 }
 
 func TestApplyByNameError(t *testing.T) {
+	ctx := context.Background()
 	recommendation := emptyRecommendation
 	recommendation.Name = "error"
 
 	mock := &ApplyMockService{recommendation: recommendation}
-	err := ApplyByName(mock, recommendation.Name, &Task{})
+	_, err := ApplyByName(ctx, mock, recommendation.Name, &Task{})
 
 	assert.EqualError(t, err, errorGetRecommendation.Error(), "Should fail after calling GetRecommendation")
 
@@ -1243,3 +1470,41 @@ func TestApplyByNameError(t *testing.T) {
 	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
 	assert.Equal(t, expected, mock.calledFunctions)
 }
+
+// Checks that a recommendation with an unsupported operation isn't claimed.
+func TestApplyUnsupportedOperation(t *testing.T) {
+	ctx := context.Background()
+	recommendation := gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				&gcloudOperationGroup{
+					Operations: []*gcloudOperation{
+						&gcloudOperation{
+							Action:       "replace",
+							Path:         "/machineType",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/vm",
+							ResourceType: "compute.googleapis.com/Instance",
+							Value:        "zones/us-central1-a/machineTypes/e2-medium",
+						},
+						&gcloudOperation{
+							Action:       "replace",
+							Path:         "/autoscalingPolicy/maxNumReplicas",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/autoscalers/autoscaler",
+							ResourceType: "compute.googleapis.com/Autoscaler",
+							Value:        3,
+						},
+					},
+				},
+			},
+		},
+		Etag:      "\"40204a1000e5befe\"",
+		Name:      "projects/323016592286/locations/us-central1-a/recommenders/google.compute.instance.MachineTypeRecommender/recommendations/r",
+		StateInfo: &gcloudStateInfo{State: "ACTIVE"},
+	}
+
+	service := ApplyMockService{recommendation: recommendation}
+	_, err := Apply(ctx, &service, &recommendation, &Task{})
+	assert.EqualError(t, err, operationNotSupportedMessage)
+	assert.Empty(t, service.calledFunctions, "Recommendation shouldn't be claimed nor resources changed")
+	assert.Equal(t, "ACTIVE", recommendation.StateInfo.State, "State of the recommendation shouldn't change")
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"regexp"
+	"sync"
+)
+
+const (
+	defaultNumBatchWorkers      = 16
+	defaultNumWorkersPerProject = 4
+)
+
+// BatchOptions configure ApplyBatch. Non-positive limits are replaced by defaults.
+type BatchOptions struct {
+	// NumWorkers is the maximum number of recommendations applied concurrently.
+	NumWorkers int
+	// NumWorkersPerProject is the maximum number of recommendations
+	// for one project applied concurrently.
+	NumWorkersPerProject int
+	// OnStart, if not nil, is called when applying the i-th recommendation starts.
+	// It may be called concurrently.
+	OnStart func(i int)
+	// OnFinish, if not nil, is called when applying the i-th recommendation finishes,
+	// results and err are returned by ApplyByName. It may be called concurrently.
+	OnFinish func(i int, results []*OperationResult, err error)
+}
+
+var recommendationProjectRegexp = regexp.MustCompile("^projects/([^/]+)/")
+
+// recommendationProject returns the project (usually its number) from the name of the recommendation,
+// or empty string if the name doesn't contain it.
+func recommendationProject(name string) string {
+	if match := recommendationProjectRegexp.FindStringSubmatch(name); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// ApplyBatch applies the recommendations with the given names, at most options.NumWorkers at once
+// and at most options.NumWorkersPerProject at once for every project.
+// Returns the errors of applying the recommendations, in the order of names, nil for the ones that succeeded.
+// If ctx is canceled, the recommendations not started yet fail with the error of ctx.
+// task structure tracks the progress of the function.
+func ApplyBatch(ctx context.Context, service GoogleService, names []string, options BatchOptions, task *Task) []error {
+	numWorkers := options.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = defaultNumBatchWorkers
+	}
+	numPerProject := options.NumWorkersPerProject
+	if numPerProject <= 0 {
+		numPerProject = defaultNumWorkersPerProject
+	}
+
+	// indices of names to apply, grouped by project
+	var projects []string
+	byProject := make(map[string][]int)
+	for i, name := range names {
+		project := recommendationProject(name)
+		if _, ok := byProject[project]; !ok {
+			projects = append(projects, project)
+		}
+		byProject[project] = append(byProject[project], i)
+	}
+
+	task.SetNumberOfSubtasks(len(names))
+	errs := make([]error, len(names))
+	workers := make(chan struct{}, numWorkers) // semaphore limiting all workers
+	var wg sync.WaitGroup
+	for _, project := range projects {
+		indices := make(chan int, len(byProject[project]))
+		for _, i := range byProject[project] {
+			indices <- i
+		}
+		close(indices)
+
+		for j := 0; j < numPerProject && j < len(byProject[project]); j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indices {
+					workers <- struct{}{}
+					errs[i] = applyBatchItem(ctx, service, names[i], i, options)
+					<-workers
+					task.IncrementDone()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	task.SetAllDone()
+	return errs
+}
+
+// applyBatchItem applies the i-th recommendation of the batch, unless ctx has been canceled.
+func applyBatchItem(ctx context.Context, service GoogleService, name string, i int, options BatchOptions) error {
+	var results []*OperationResult
+	err := ctx.Err()
+	if err == nil {
+		if options.OnStart != nil {
+			options.OnStart(i)
+		}
+		results, err = ApplyByName(ctx, service, name, &Task{})
+	}
+	if options.OnFinish != nil {
+		options.OnFinish(i, results, err)
+	}
+	return err
+}
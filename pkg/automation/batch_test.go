@@ -0,0 +1,134 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// BatchMockService applies recommendations without operations,
+// recording the maximum numbers of recommendations applied concurrently.
+type BatchMockService struct {
+	GoogleService
+	mutex        sync.Mutex
+	running      int
+	maxRunning   int
+	runningIn    map[string]int
+	maxRunningIn map[string]int
+	failingName  string
+}
+
+func newBatchMockService() *BatchMockService {
+	return &BatchMockService{runningIn: make(map[string]int), maxRunningIn: make(map[string]int)}
+}
+
+func (s *BatchMockService) GetRecommendation(ctx context.Context, name string) (*gcloudRecommendation, error) {
+	if name == s.failingName {
+		return nil, errQuotaExceeded
+	}
+	project := recommendationProject(name)
+	s.mutex.Lock()
+	s.running++
+	s.runningIn[project]++
+	if s.running > s.maxRunning {
+		s.maxRunning = s.running
+	}
+	if s.runningIn[project] > s.maxRunningIn[project] {
+		s.maxRunningIn[project] = s.runningIn[project]
+	}
+	s.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mutex.Lock()
+	s.running--
+	s.runningIn[project]--
+	s.mutex.Unlock()
+	return &gcloudRecommendation{Name: name, Content: &gcloudContent{}, StateInfo: &gcloudStateInfo{State: "ACTIVE"}}, nil
+}
+
+func (s *BatchMockService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return &gcloudRecommendation{Name: name, Content: &gcloudContent{}, StateInfo: &gcloudStateInfo{State: "CLAIMED"}}, nil
+}
+
+func (s *BatchMockService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return &gcloudRecommendation{Name: name, Content: &gcloudContent{}, StateInfo: &gcloudStateInfo{State: "SUCCEEDED"}}, nil
+}
+
+func TestApplyBatch(t *testing.T) {
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("projects/%d/locations/zone/recommenders/r/recommendations/%d", i%2, i))
+	}
+	service := newBatchMockService()
+	service.failingName = names[3]
+
+	var mutex sync.Mutex
+	started, finished := 0, 0
+	options := BatchOptions{
+		NumWorkers:           3,
+		NumWorkersPerProject: 2,
+		OnStart: func(i int) {
+			mutex.Lock()
+			started++
+			mutex.Unlock()
+		},
+		OnFinish: func(i int, _ []*OperationResult, err error) {
+			mutex.Lock()
+			finished++
+			mutex.Unlock()
+		},
+	}
+	task := &Task{}
+	errs := ApplyBatch(context.Background(), service, names, options, task)
+
+	if assert.Equal(t, len(names), len(errs)) {
+		for i, err := range errs {
+			if i == 3 {
+				assert.Equal(t, errQuotaExceeded, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+	}
+	assert.Equal(t, 3, service.maxRunning, "At most 3 recommendations should be applied at once")
+	assert.Equal(t, 2, service.maxRunningIn["0"], "At most 2 recommendations per project should be applied at once")
+	assert.Equal(t, 2, service.maxRunningIn["1"], "At most 2 recommendations per project should be applied at once")
+	assert.Equal(t, len(names), started)
+	assert.Equal(t, len(names), finished)
+	done, all := task.GetProgress()
+	assert.Equal(t, done, all, "Task should be done")
+}
+
+// Checks that recommendations are not applied after canceling.
+func TestApplyBatchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service := newBatchMockService()
+	errs := ApplyBatch(ctx, service, []string{"projects/1/a", "projects/2/b"}, BatchOptions{}, &Task{})
+	for _, err := range errs {
+		assert.True(t, errors.Is(err, context.Canceled), "Canceled error expected")
+	}
+	assert.Equal(t, 0, service.maxRunning, "Nothing should be applied")
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"google.golang.org/api/compute/v1"
 )
 
@@ -52,31 +54,205 @@ func randomSnapshotName(zone string, disk string, generator *rand.Rand) (string,
 }
 
 // CreateSnapshot calls the disks.createSnapshot method.
-// Requires compute.disks.createSnapshot or compute.snapshots.create permission.
+// Requires compute.disks.createSnapshot or compute.snapshots.create permission,
+// and compute.snapshots.setLabels, if the snapshot has labels.
 // For a given name, there can only be one snapshot having it.
 // The maximum name length is 63.
-func (s *googleService) CreateSnapshot(project, zone, disk, name string) error {
-	if len(name) > maxSnapshotnameLen {
+func (s *googleService) CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error {
+	if len(snapshot.Name) > maxSnapshotnameLen {
 		return fmt.Errorf("length of the snapshot name must not exceed %d", maxSnapshotnameLen)
 	}
 	disksService := compute.NewDisksService(s.computeService)
-	snapshot := &compute.Snapshot{Name: name}
-	requestID := uuid.New().String()
-	return DoRequestWithRetries(func() error {
-		return AwaitCompletion(func() (*compute.Operation, error) {
-			return disksService.CreateSnapshot(project, zone, disk, snapshot).RequestId(requestID).Do()
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return disksService.CreateSnapshot(project, zone, disk, snapshot).RequestId(requestID).Context(ctx).Do()
 		}, sleepTimeCreatingSnapshots)
 	})
 }
 
+// CreateDisk creates the disk using disks.insert method.
+// To restore a disk from a snapshot, SourceSnapshot of disk must be set.
+// Requires compute.disks.create permission, and compute.snapshots.useReadOnly to use a snapshot.
+func (s *googleService) CreateDisk(ctx context.Context, project, zone string, disk *compute.Disk) error {
+	disksService := compute.NewDisksService(s.computeService)
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return disksService.Insert(project, zone, disk).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeCreatingDisks)
+	})
+}
+
+// GetDisk gets the disk using disks.get method.
+// Requires compute.disks.get permission.
+func (s *googleService) GetDisk(ctx context.Context, project, zone, disk string) (*compute.Disk, error) {
+	disksService := compute.NewDisksService(s.computeService)
+	var result *compute.Disk
+	err := DoRequestWithRetries(ctx, func() error {
+		d, err := disksService.Get(project, zone, disk).Context(ctx).Do()
+		result = d
+		return err
+	})
+	return result, err
+}
+
+// GetSnapshot gets the snapshot using snapshots.get method.
+// Requires compute.snapshots.get permission.
+func (s *googleService) GetSnapshot(ctx context.Context, project, snapshot string) (*compute.Snapshot, error) {
+	snapshotsService := compute.NewSnapshotsService(s.computeService)
+	var result *compute.Snapshot
+	err := DoRequestWithRetries(ctx, func() error {
+		snap, err := snapshotsService.Get(project, snapshot).Context(ctx).Do()
+		result = snap
+		return err
+	})
+	return result, err
+}
+
+// ListSnapshots lists the snapshots using snapshots.list method.
+// If filter is not empty, only the snapshots matching it are listed, for example "labels.created-by = recomator".
+// Requires compute.snapshots.list permission.
+func (s *googleService) ListSnapshots(ctx context.Context, project, filter string) ([]*compute.Snapshot, error) {
+	listCall := compute.NewSnapshotsService(s.computeService).List(project)
+	if filter != "" {
+		listCall = listCall.Filter(filter)
+	}
+	var snapshots []*compute.Snapshot
+	addSnapshots := func(list *compute.SnapshotList) error {
+		snapshots = append(snapshots, list.Items...)
+		return nil
+	}
+	err := DoRequestWithRetries(ctx, func() error {
+		snapshots = nil
+		return listCall.Pages(ctx, addSnapshots)
+	})
+	return snapshots, err
+}
+
+// DeleteSnapshot calls the snapshots.delete method.
+// Requires compute.snapshots.delete permission.
+func (s *googleService) DeleteSnapshot(ctx context.Context, project, snapshot string) error {
+	snapshotsService := compute.NewSnapshotsService(s.computeService)
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return snapshotsService.Delete(project, snapshot).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeDeletingSnapshots)
+	})
+}
+
 // DeleteDisk calls the disks.delete method.
 // Requires compute.disks.delete permission.
-func (s *googleService) DeleteDisk(project, zone, disk string) error {
+func (s *googleService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
 	disksService := compute.NewDisksService(s.computeService)
-	requestID := uuid.New().String()
-	return DoRequestWithRetries(func() error {
-		return AwaitCompletion(func() (*compute.Operation, error) {
-			return disksService.Delete(project, zone, disk).RequestId(requestID).Do()
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return disksService.Delete(project, zone, disk).RequestId(requestID).Context(ctx).Do()
 		}, sleepTimeDeletingDisks)
 	})
 }
+
+// DefaultMinDiskAge is the minimum age of a disk, that can be deleted, unless set in Safeguards.
+// Idle disk recommendations are based on weeks of observation,
+// so a younger disk has likely been created with the same name after the recommendation.
+const DefaultMinDiskAge = 7 * 24 * time.Hour
+
+// minDiskAge returns the minimum age of a disk, that can be deleted with ctx.
+func minDiskAge(ctx context.Context) time.Duration {
+	if safeguards := safeguardsFromContext(ctx); safeguards != nil && safeguards.MinDiskAge != 0 {
+		return safeguards.MinDiskAge
+	}
+	return DefaultMinDiskAge
+}
+
+// maxSnapshotWait is how long deleting a disk waits for its snapshot to become ready.
+const maxSnapshotWait = 30 * time.Minute
+
+// createdSnapshots records the snapshots created by addSnapshot in an operation group,
+// by the paths of their source disks, so that removeDisk can check them.
+type createdSnapshots struct {
+	names map[string]string
+}
+
+type createdSnapshotsKey struct{}
+
+// withCreatedSnapshots returns the context, in which snapshots created
+// by the operations of one group are recorded.
+func withCreatedSnapshots(ctx context.Context) context.Context {
+	return context.WithValue(ctx, createdSnapshotsKey{}, &createdSnapshots{names: make(map[string]string)})
+}
+
+// diskPath returns the path identifying the disk in createdSnapshots.
+func diskPath(project, zone, disk string) string {
+	return fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, disk)
+}
+
+// recordSnapshot records the snapshot of the disk, if ctx has been created by withCreatedSnapshots.
+func recordSnapshot(ctx context.Context, project, zone, disk, snapshot string) {
+	if created, ok := ctx.Value(createdSnapshotsKey{}).(*createdSnapshots); ok {
+		created.names[diskPath(project, zone, disk)] = snapshot
+	}
+}
+
+// recordedSnapshot returns the name of the snapshot of the disk created earlier
+// in the same operation group, or an empty string if there's none.
+func recordedSnapshot(ctx context.Context, project, zone, disk string) string {
+	if created, ok := ctx.Value(createdSnapshotsKey{}).(*createdSnapshots); ok {
+		return created.names[diskPath(project, zone, disk)]
+	}
+	return ""
+}
+
+// checkDiskDeletable returns the disk, or an error if the disk is attached to instances,
+// or if it's younger than the minimum age set in the safeguards, DefaultMinDiskAge by default.
+func checkDiskDeletable(ctx context.Context, service GoogleService, project, zone, disk string) (*compute.Disk, error) {
+	d, err := service.GetDisk(ctx, project, zone, disk)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Users) != 0 {
+		return nil, fmt.Errorf("disk %s is attached to %s, refusing to delete it", disk, strings.Join(d.Users, ", "))
+	}
+	created, err := time.Parse(time.RFC3339, d.CreationTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creation timestamp of disk %s: %v", disk, err)
+	}
+	if minAge, age := minDiskAge(ctx), time.Since(created); age < minAge {
+		return nil, fmt.Errorf("disk %s was created %v ago, refusing to delete disks younger than %v",
+			disk, age.Round(time.Minute), minAge)
+	}
+	return d, nil
+}
+
+// lastPathSegment returns the part of the URL after the last slash,
+// for example the name of the disk type from its URL.
+func lastPathSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+// awaitSnapshotReady waits until the snapshot is READY for at most maxSnapshotWait, and returns it.
+// Returns an error if the snapshot failed, is being deleted or isn't ready in time.
+func awaitSnapshotReady(ctx context.Context, service GoogleService, project, snapshot string) (*compute.Snapshot, error) {
+	deadline := time.Now().Add(maxSnapshotWait)
+	for {
+		snap, err := service.GetSnapshot(ctx, project, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		switch snap.Status {
+		case "READY":
+			return snap, nil
+		case "CREATING", "UPLOADING":
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("snapshot %s is not ready after %v", snapshot, maxSnapshotWait)
+			}
+			if err := sleep(ctx, sleepTimeCreatingSnapshots); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("snapshot %s is %s, not READY", snapshot, snap.Status)
+		}
+	}
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package automation
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"regexp"
@@ -26,6 +29,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
 )
 
 // Tests if the generated names are different
@@ -117,3 +121,136 @@ func TestLongDiskName(t *testing.T) {
 
 	assert.LessOrEqual(t, len(result), maxSnapshotnameLen, fmt.Sprintf("The length of the returned snapshot name must be less than %d", maxSnapshotnameLen))
 }
+
+type DiskChecksMockService struct {
+	GoogleService
+	disk           *compute.Disk
+	snapshotStatus string
+	deleted        bool
+	// if set, cancel is called when the disk is checked before deleting, DeleteDisk returns deleteErr
+	cancel    context.CancelFunc
+	deleteErr error
+	// deleteCtxErr is the error of ctx passed to DeleteDisk
+	deleteCtxErr error
+}
+
+func (s *DiskChecksMockService) CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error {
+	return nil
+}
+
+func (s *DiskChecksMockService) GetDisk(ctx context.Context, project, zone, disk string) (*compute.Disk, error) {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return s.disk, nil
+}
+
+func (s *DiskChecksMockService) GetSnapshot(ctx context.Context, project, snapshot string) (*compute.Snapshot, error) {
+	return &compute.Snapshot{Name: snapshot, Status: s.snapshotStatus, SelfLink: "projects/" + project + "/global/snapshots/" + snapshot}, nil
+}
+
+func (s *DiskChecksMockService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
+	s.deleted = true
+	s.deleteCtxErr = ctx.Err()
+	return s.deleteErr
+}
+
+// snapshotAndDeleteRecommendation returns a recommendation creating a snapshot of a disk and deleting it.
+func snapshotAndDeleteRecommendation(t *testing.T) *gcloudRecommendation {
+	var value interface{}
+	err := json.Unmarshal([]byte(`{"name": "$snapshot-name", "source_disk": "projects/rightsizer-test/zones/europe-west1-d/disks/my-disk"}`), &value)
+	assert.NoError(t, err, "No error expected from json.Unmarshal")
+	return &gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				{
+					Operations: []*gcloudOperation{
+						{
+							Action:       "add",
+							Path:         "/",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/global/snapshots/$snapshot-name",
+							ResourceType: "compute.googleapis.com/Snapshot",
+							Value:        value,
+						},
+						{
+							Action:       "remove",
+							Path:         "/",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/europe-west1-d/disks/my-disk",
+							ResourceType: "compute.googleapis.com/Disk",
+						},
+					},
+				},
+			},
+		},
+		StateInfo: &gcloudStateInfo{State: "ACTIVE"},
+	}
+}
+
+// Checks that disks are deleted only if they are detached, old enough and their snapshots are ready.
+func TestDiskChecksBeforeDeleting(t *testing.T) {
+	old := time.Now().Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+	young := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	testCases := []struct {
+		name           string
+		disk           *compute.Disk
+		snapshotStatus string
+		errorContains  string
+	}{
+		{"deletable", &compute.Disk{CreationTimestamp: old}, "READY", ""},
+		{"attached", &compute.Disk{CreationTimestamp: old, Users: []string{"instances/vm"}}, "READY", "attached to instances/vm"},
+		{"young", &compute.Disk{CreationTimestamp: young}, "READY", "younger than"},
+		{"snapshot failed", &compute.Disk{CreationTimestamp: old}, "FAILED", "is FAILED"},
+	}
+	for _, testCase := range testCases {
+		service := &DiskChecksMockService{disk: testCase.disk, snapshotStatus: testCase.snapshotStatus}
+		_, err := DoOperations(context.Background(), service, snapshotAndDeleteRecommendation(t), &Task{}, nil)
+		if testCase.errorContains == "" {
+			assert.NoError(t, err, testCase.name)
+			assert.True(t, service.deleted, testCase.name)
+		} else {
+			if assert.Error(t, err, testCase.name) {
+				assert.Contains(t, err.Error(), testCase.errorContains, testCase.name)
+			}
+			assert.False(t, service.deleted, testCase.name)
+		}
+	}
+}
+
+// Checks that the minimum age of deleted disks is read from the safeguards.
+func TestMinDiskAge(t *testing.T) {
+	testCases := []struct {
+		name       string
+		age        time.Duration
+		minDiskAge time.Duration
+		deleted    bool
+	}{
+		{"default", 6 * 24 * time.Hour, 0, false},
+		{"lower", time.Hour, 30 * time.Minute, true},
+		{"higher", 30 * 24 * time.Hour, 60 * 24 * time.Hour, false},
+	}
+	for _, testCase := range testCases {
+		disk := &compute.Disk{CreationTimestamp: time.Now().Add(-testCase.age).Format(time.RFC3339)}
+		service := &DiskChecksMockService{disk: disk, snapshotStatus: "READY"}
+		ctx := WithSafeguards(context.Background(), &Safeguards{MinDiskAge: testCase.minDiskAge})
+		_, err := DoOperations(ctx, service, snapshotAndDeleteRecommendation(t), &Task{}, nil)
+		assert.Equal(t, testCase.deleted, err == nil, testCase.name)
+		assert.Equal(t, testCase.deleted, service.deleted, testCase.name)
+	}
+}
+
+// Checks that deleting a disk isn't canceled, and its result with the snapshot is returned also if deleting fails.
+func TestDeletingDiskFails(t *testing.T) {
+	old := time.Now().Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &DiskChecksMockService{disk: &compute.Disk{CreationTimestamp: old}, snapshotStatus: "READY",
+		cancel: cancel, deleteErr: errors.New("operation timed out")}
+	results, err := DoOperations(ctx, service, snapshotAndDeleteRecommendation(t), &Task{}, nil)
+	assert.EqualError(t, err, "operation timed out")
+	assert.NoError(t, service.deleteCtxErr, "Deleting shouldn't be canceled")
+	if assert.Len(t, results, 2, "Results of creating the snapshot and deleting the disk should be returned") {
+		assert.Equal(t, ActionDeleteDisk, results[1].Action)
+		assert.Equal(t, "my-disk", results[1].Resource)
+		assert.NotEmpty(t, results[1].SnapshotSelfLink, "Snapshot of the disk should be in the result")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats of exported recommendations.
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+)
+
+// ExportedRecommendation is the recommendation flattened to one row of the export.
+// Resource is the first resource changed by the recommendation.
+// MonthlySavings is the decimal amount projected for 30 days, empty if there's no cost projection.
+// Priority is from P1, the highest, to P4, empty if it's unknown.
+type ExportedRecommendation struct {
+	Project        string `json:"project"`
+	Location       string `json:"location"`
+	Recommender    string `json:"recommender"`
+	Subtype        string `json:"subtype"`
+	Resource       string `json:"resource"`
+	Description    string `json:"description"`
+	State          string `json:"state"`
+	Priority       string `json:"priority"`
+	MonthlySavings string `json:"monthlySavings"`
+	Currency       string `json:"currency"`
+	Etag           string `json:"etag"`
+	Name           string `json:"name"`
+}
+
+var exportedRecommendationHeader = []string{
+	"project", "location", "recommender", "subtype", "resource", "description",
+	"state", "priority", "monthly_savings", "currency", "etag", "name",
+}
+
+func (r *ExportedRecommendation) record() []string {
+	return []string{
+		r.Project, r.Location, r.Recommender, r.Subtype, r.Resource, r.Description,
+		r.State, r.Priority, r.MonthlySavings, r.Currency, r.Etag, r.Name,
+	}
+}
+
+// ExportedRequirement is a requirement not satisfied for a project, for which recommendations couldn't be listed.
+type ExportedRequirement struct {
+	Project      string `json:"project"`
+	Requirement  string `json:"requirement"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+var exportedRequirementHeader = []string{"project", "requirement", "error_message"}
+
+// formatNanos formats the amount in nanos as a decimal number with at least 2 fractional digits.
+func formatNanos(nanos int64) string {
+	sign := ""
+	if nanos < 0 {
+		sign = "-"
+		nanos = -nanos
+	}
+	fraction := strings.TrimRight(fmt.Sprintf("%09d", nanos%nanosPerUnit), "0")
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	return fmt.Sprintf("%s%d.%s", sign, nanos/nanosPerUnit, fraction)
+}
+
+// FlattenRecommendation returns the fields of the recommendation, that are exported
+// and used to filter recommendations.
+func FlattenRecommendation(recommendation *gcloudRecommendation) *ExportedRecommendation {
+	project, location, recommenderID, _ := recommendationGroups(recommendation)
+	result := &ExportedRecommendation{
+		Project:     project,
+		Location:    location,
+		Recommender: recommenderID,
+		Subtype:     recommendation.RecommenderSubtype,
+		Description: recommendation.Description,
+		Etag:        recommendation.Etag,
+		Name:        recommendation.Name,
+	}
+	if recommendation.StateInfo != nil {
+		result.State = recommendation.StateInfo.State
+	}
+	if recommendation.Content != nil {
+	resources:
+		for _, group := range recommendation.Content.OperationGroups {
+			for _, operation := range group.Operations {
+				if operation.Resource != "" {
+					result.Resource = operation.Resource
+					break resources
+				}
+			}
+		}
+	}
+	if currencyCode, nanos, ok := MonthlySavings(recommendation); ok {
+		result.MonthlySavings = formatNanos(nanos)
+		result.Currency = currencyCode
+	}
+	return result
+}
+
+// exportRows writes the header and records in CSV, or every row as one line of JSON in JSONL.
+func exportRows(w io.Writer, format string, header []string, numRows int, row func(i int) interface{}, record func(i int) []string) error {
+	switch format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for i := 0; i < numRows; i++ {
+			if err := writer.Write(record(i)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case ExportJSONL:
+		encoder := json.NewEncoder(w)
+		for i := 0; i < numRows; i++ {
+			if err := encoder.Encode(row(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown export format %s, expected %s or %s", format, ExportCSV, ExportJSONL)
+	}
+}
+
+// ExportRecommendations writes the recommendations to w in the format ExportCSV or ExportJSONL,
+// one recommendation per row. priorities maps names of recommendations to their priorities,
+// as in ListResult.
+func ExportRecommendations(w io.Writer, format string, recommendations []*gcloudRecommendation, priorities map[string]string) error {
+	rows := make([]*ExportedRecommendation, len(recommendations))
+	for i, recommendation := range recommendations {
+		rows[i] = FlattenRecommendation(recommendation)
+		rows[i].Priority = priorities[recommendation.Name]
+	}
+	return exportRows(w, format, exportedRecommendationHeader, len(rows),
+		func(i int) interface{} { return rows[i] },
+		func(i int) []string { return rows[i].record() })
+}
+
+// ExportFailedProjects writes the requirements not satisfied for failedProjects to w,
+// in the format ExportCSV or ExportJSONL, one requirement per row.
+func ExportFailedProjects(w io.Writer, format string, failedProjects []*ProjectRequirements) error {
+	var rows []*ExportedRequirement
+	for _, project := range failedProjects {
+		for _, requirement := range project.Requirements {
+			if !requirement.Satisfied {
+				rows = append(rows, &ExportedRequirement{
+					Project:      project.Project,
+					Requirement:  requirement.Name,
+					ErrorMessage: requirement.ErrorMessage,
+				})
+			}
+		}
+	}
+	return exportRows(w, format, exportedRequirementHeader, len(rows),
+		func(i int) interface{} { return rows[i] },
+		func(i int) []string { return []string{rows[i].Project, rows[i].Requirement, rows[i].ErrorMessage} })
+}
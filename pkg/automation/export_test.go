@@ -0,0 +1,91 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportRecommendationsCSV(t *testing.T) {
+	rec := recommendationWithCost(
+		"projects/123/locations/us-central1-a/recommenders/google.compute.instance.IdleResourceRecommender/recommendations/1",
+		"//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+		-10, -500000000, "USD", "2592000s")
+	rec.Description = "Save cost by stopping Idle VM 'alicja-test'."
+	rec.Etag = "\"etag\""
+	rec.StateInfo = &gcloudStateInfo{State: "ACTIVE"}
+	rec.RecommenderSubtype = "STOP_VM"
+
+	var buffer bytes.Buffer
+	assert.NoError(t, ExportRecommendations(&buffer, ExportCSV, []*gcloudRecommendation{rec}, map[string]string{rec.Name: "P2"}))
+	expected := "project,location,recommender,subtype,resource,description,state,priority,monthly_savings,currency,etag,name\n" +
+		"rightsizer-test,us-central1-a,google.compute.instance.IdleResourceRecommender,STOP_VM," +
+		"//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test," +
+		"Save cost by stopping Idle VM 'alicja-test'.,ACTIVE,P2,10.50,USD,\"\"\"etag\"\"\"," + rec.Name + "\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestExportRecommendationsJSONL(t *testing.T) {
+	rec := recommendationWithCost(
+		"projects/123/locations/global/recommenders/google.compute.image.IdleResourceRecommender/recommendations/1",
+		"//compute.googleapis.com/projects/rightsizer-test/global/images/image",
+		-2, 0, "USD", "2592000s")
+	rec.StateInfo = &gcloudStateInfo{State: "ACTIVE"}
+	other := recommendationWithCost(
+		"projects/123/locations/global/recommenders/google.compute.image.IdleResourceRecommender/recommendations/2",
+		"//compute.googleapis.com/projects/rightsizer-test/global/images/other",
+		-1, 0, "USD", "2592000s")
+
+	var buffer bytes.Buffer
+	assert.NoError(t, ExportRecommendations(&buffer, ExportJSONL, []*gcloudRecommendation{rec, other}, map[string]string{rec.Name: "P4"}))
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if assert.Len(t, lines, 2, "One line per recommendation expected") {
+		var exported, exportedOther ExportedRecommendation
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &exported))
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &exportedOther))
+		assert.Equal(t, "P4", exported.Priority)
+		assert.Equal(t, "2.00", exported.MonthlySavings)
+		assert.Empty(t, exportedOther.Priority, "Unknown priority should be empty")
+	}
+}
+
+func TestExportFailedProjectsJSONL(t *testing.T) {
+	failed := []*ProjectRequirements{{
+		Project: "project",
+		Requirements: []*Requirement{
+			{Name: "Compute Engine API", Satisfied: true},
+			{Name: "compute.instances.stop", ErrorMessage: "permission denied"},
+		},
+	}}
+	var buffer bytes.Buffer
+	assert.NoError(t, ExportFailedProjects(&buffer, ExportJSONL, failed))
+	assert.Equal(t, `{"project":"project","requirement":"compute.instances.stop","errorMessage":"permission denied"}`+"\n",
+		buffer.String(), "Only failed requirements should be exported")
+
+	assert.Error(t, ExportFailedProjects(&buffer, "xml", failed), "Unknown format")
+}
+
+func TestFormatNanos(t *testing.T) {
+	assert.Equal(t, "0.00", formatNanos(0))
+	assert.Equal(t, "1.50", formatNanos(1500000000))
+	assert.Equal(t, "-0.123456789", formatNanos(-123456789))
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"google.golang.org/api/compute/v1"
+)
+
+// DeleteImage calls the images.delete method.
+// Requires compute.images.delete permission.
+func (s *googleService) DeleteImage(ctx context.Context, project, image string) error {
+	imagesService := compute.NewImagesService(s.computeService)
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return imagesService.Delete(project, image).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeDeletingImage)
+	})
+}
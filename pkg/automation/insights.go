@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/api/recommender/v1"
+)
+
+// gcloudInsight is a type alias for Google Cloud Insight recommender.GoogleCloudRecommenderV1Insight
+type gcloudInsight = recommender.GoogleCloudRecommenderV1Insight
+
+// GetInsight implements projects.locations.insightTypes.insights/get method
+func (s *googleService) GetInsight(ctx context.Context, name string) (*gcloudInsight, error) {
+	service := recommender.NewProjectsLocationsInsightTypesInsightsService(s.recommenderService)
+	var insight *gcloudInsight
+	err := DoRequestWithRetries(ctx, func() error {
+		result, err := service.Get(name).Context(ctx).Do()
+		insight = result
+		return err
+	})
+	return insight, err
+}
+
+// ListInsights returns the list of insights for specified project, location and insight type,
+// for example google.compute.instance.IdleResourceInsight.
+// projects.locations.insightTypes.insights/list method from Recommender API is used.
+// If the error occurred the returned error is not nil.
+func (s *googleService) ListInsights(ctx context.Context, project, location, insightTypeID string) ([]*gcloudInsight, error) {
+	insightsService := recommender.NewProjectsLocationsInsightTypesInsightsService(s.recommenderService)
+	listCall := insightsService.List(fmt.Sprintf("projects/%s/locations/%s/insightTypes/%s", project, location, insightTypeID))
+	var insights []*gcloudInsight
+	addInsights := func(response *recommender.GoogleCloudRecommenderV1ListInsightsResponse) error {
+		insights = append(insights, response.Insights...)
+		return nil
+	}
+	err := DoRequestWithRetries(ctx, func() error {
+		insights = nil
+		err := listCall.Pages(ctx, addInsights)
+		// Check if error is because current location is not available for getting insights.
+		if isInvalidArgumentError(err) {
+			log.Printf("Invalid location error: %v received while getting insights for %s %s %s",
+				err, project, location, insightTypeID)
+			insights = nil
+			return nil
+		}
+		return err
+	})
+	return insights, err
+}
+
+type insightResult struct {
+	name    string
+	insight *gcloudInsight
+	err     error
+}
+
+// ListAssociatedInsights gets the insights associated with the recommendations,
+// for example utilization of the instance behind an idle instance recommendation.
+// Returns the map from the name of the recommendation to its insights.
+// Each insight is got once, with at most numConcurrentCalls concurrent calls to GetInsight,
+// non-positive values are ignored, instead the default value is used.
+// Insights that can't be got, for example because of missing permissions, are skipped.
+// If ctx is canceled, the remaining insights are not got and the error of ctx is returned.
+// task structure tracks the progress of the function.
+func ListAssociatedInsights(ctx context.Context, service GoogleService, recommendations []*gcloudRecommendation, numConcurrentCalls int, task *Task) (map[string][]*gcloudInsight, error) {
+	var names []string
+	added := make(map[string]bool)
+	for _, rec := range recommendations {
+		if rec == nil {
+			continue
+		}
+		for _, reference := range rec.AssociatedInsights {
+			if !added[reference.Insight] {
+				added[reference.Insight] = true
+				names = append(names, reference.Insight)
+			}
+		}
+	}
+
+	numWorkers := numConcurrentCalls
+	const defaultNumWorkers = 16
+	if numWorkers <= 0 {
+		numWorkers = defaultNumWorkers
+	}
+
+	task.SetNumberOfSubtasks(len(names))
+	results := make(chan insightResult, len(names))
+	queries := make(chan string, len(names))
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			for name := range queries {
+				// remaining insights are skipped, if the request has been canceled
+				if err := ctx.Err(); err != nil {
+					results <- insightResult{name: name, err: err}
+					continue
+				}
+				insight, err := service.GetInsight(ctx, name)
+				results <- insightResult{name, insight, err}
+				task.IncrementDone()
+			}
+		}()
+	}
+	for _, name := range names {
+		queries <- name
+	}
+	close(queries)
+
+	insights := make(map[string]*gcloudInsight)
+	for range names {
+		result := <-results
+		if result.err != nil {
+			log.Printf("Error getting insight %s: %v", result.name, result.err)
+			continue
+		}
+		insights[result.name] = result.insight
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	associated := make(map[string][]*gcloudInsight)
+	for _, rec := range recommendations {
+		if rec == nil {
+			continue
+		}
+		for _, reference := range rec.AssociatedInsights {
+			if insight, ok := insights[reference.Insight]; ok {
+				associated[rec.Name] = append(associated[rec.Name], insight)
+			}
+		}
+	}
+	task.SetAllDone()
+	return associated, nil
+}
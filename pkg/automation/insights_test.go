@@ -0,0 +1,84 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/recommender/v1"
+)
+
+type InsightsMockService struct {
+	GoogleService
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+func (s *InsightsMockService) GetInsight(ctx context.Context, name string) (*gcloudInsight, error) {
+	s.mutex.Lock()
+	s.calls[name]++
+	s.mutex.Unlock()
+	if name == "missing" {
+		return nil, errors.New("permission denied")
+	}
+	return &gcloudInsight{Name: name}, nil
+}
+
+func recommendationWithInsights(name string, insights ...string) *gcloudRecommendation {
+	rec := &gcloudRecommendation{Name: name}
+	for _, insight := range insights {
+		rec.AssociatedInsights = append(rec.AssociatedInsights,
+			&recommender.GoogleCloudRecommenderV1RecommendationInsightReference{Insight: insight})
+	}
+	return rec
+}
+
+func TestListAssociatedInsights(t *testing.T) {
+	recs := []*gcloudRecommendation{
+		recommendationWithInsights("r1", "i1", "i2"),
+		recommendationWithInsights("r2", "i2", "missing"),
+		recommendationWithInsights("r3"),
+	}
+	for numConcurrentCalls := 0; numConcurrentCalls <= 3; numConcurrentCalls++ {
+		mock := &InsightsMockService{calls: make(map[string]int)}
+		task := &Task{}
+		result, err := ListAssociatedInsights(context.Background(), mock, recs, numConcurrentCalls, task)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, map[string]int{"i1": 1, "i2": 1, "missing": 1}, mock.calls, "Each insight should be got once")
+		assert.Equal(t, map[string][]*gcloudInsight{
+			"r1": {{Name: "i1"}, {Name: "i2"}},
+			"r2": {{Name: "i2"}},
+		}, result, "Insights that couldn't be got should be skipped")
+		done, all := task.GetProgress()
+		assert.Equal(t, done, all, "Task should be done")
+	}
+}
+
+func TestListAssociatedInsightsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mock := &InsightsMockService{calls: make(map[string]int)}
+	_, err := ListAssociatedInsights(ctx, mock, []*gcloudRecommendation{recommendationWithInsights("r1", "i1")}, 1, &Task{})
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, mock.calls, "No insights should be got after cancellation")
+}
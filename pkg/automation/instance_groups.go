@@ -0,0 +1,189 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// isRegion returns true if location is a region, like us-central1,
+// and false if it's a zone, like us-central1-a.
+func isRegion(location string) bool {
+	return strings.Count(location, "-") == 1
+}
+
+// GetInstanceTemplate gets the instance template using instanceTemplates.get method.
+// Requires compute.instanceTemplates.get permission.
+func (s *googleService) GetInstanceTemplate(ctx context.Context, project, template string) (*compute.InstanceTemplate, error) {
+	templatesService := compute.NewInstanceTemplatesService(s.computeService)
+	var result *compute.InstanceTemplate
+	err := DoRequestWithRetries(ctx, func() error {
+		t, err := templatesService.Get(project, template).Context(ctx).Do()
+		result = t
+		return err
+	})
+	return result, err
+}
+
+// CreateInstanceTemplate calls the instanceTemplates.insert method.
+// Requires compute.instanceTemplates.create permission.
+func (s *googleService) CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error {
+	templatesService := compute.NewInstanceTemplatesService(s.computeService)
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return templatesService.Insert(project, template).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeCreatingInstanceTemplate)
+	})
+}
+
+// DeleteInstanceTemplate calls the instanceTemplates.delete method.
+// Requires compute.instanceTemplates.delete permission.
+func (s *googleService) DeleteInstanceTemplate(ctx context.Context, project, template string) error {
+	templatesService := compute.NewInstanceTemplatesService(s.computeService)
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return templatesService.Delete(project, template).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeDeletingInstanceTemplate)
+	})
+}
+
+// GetInstanceGroupManager gets the managed instance group using instanceGroupManagers.get method,
+// or regionInstanceGroupManagers.get method if location is a region.
+// Requires compute.instanceGroupManagers.get permission.
+func (s *googleService) GetInstanceGroupManager(ctx context.Context, project, location, manager string) (*compute.InstanceGroupManager, error) {
+	var result *compute.InstanceGroupManager
+	err := DoRequestWithRetries(ctx, func() error {
+		var m *compute.InstanceGroupManager
+		var err error
+		if isRegion(location) {
+			m, err = compute.NewRegionInstanceGroupManagersService(s.computeService).Get(project, location, manager).Context(ctx).Do()
+		} else {
+			m, err = compute.NewInstanceGroupManagersService(s.computeService).Get(project, location, manager).Context(ctx).Do()
+		}
+		result = m
+		return err
+	})
+	return result, err
+}
+
+// SetInstanceGroupManagerTemplate calls the instanceGroupManagers.setInstanceTemplate method,
+// or regionInstanceGroupManagers.setInstanceTemplate method if location is a region.
+// template is the URL of the instance template, for example projects/project/global/instanceTemplates/template.
+// Requires compute.instanceGroupManagers.update and compute.instanceTemplates.useReadOnly permissions.
+func (s *googleService) SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error {
+	requestID := newRequestID(ctx)
+	if isRegion(location) {
+		managersService := compute.NewRegionInstanceGroupManagersService(s.computeService)
+		request := &compute.RegionInstanceGroupManagersSetTemplateRequest{InstanceTemplate: template}
+		return DoRequestWithRetries(ctx, func() error {
+			return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+				return managersService.SetInstanceTemplate(project, location, manager, request).RequestId(requestID).Context(ctx).Do()
+			}, sleepTimeSettingInstanceTemplate)
+		})
+	}
+
+	managersService := compute.NewInstanceGroupManagersService(s.computeService)
+	request := &compute.InstanceGroupManagersSetInstanceTemplateRequest{InstanceTemplate: template}
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return managersService.SetInstanceTemplate(project, location, manager, request).RequestId(requestID).Context(ctx).Do()
+		}, sleepTimeSettingInstanceTemplate)
+	})
+}
+
+// maxTemplateSourceLen is the length, to which the name of the copied template is truncated
+// in the name of the new template.
+const maxTemplateSourceLen = 30
+
+// maxTemplateNameLen is the maximum length of the name of an instance template.
+const maxTemplateNameLen = 63
+
+// randomTemplateName returns the name for the copy of the instance template source,
+// with the timestamp and a random sequence generated using the given generator.
+// This function will only be thread safe, if the given generator is thread safe.
+func randomTemplateName(source string, generator *rand.Rand) string {
+	result := strings.TrimSuffix(source[:min(maxTemplateSourceLen, len(source))], "-")
+	result += "-" + getTimestamp() + "-"
+	return result + randomString(min(8, maxTemplateNameLen-len(result)), generator)
+}
+
+// instanceTemplates records the instance templates copied and created in an operation group,
+// so that the later operations of the group can refer to them by the names from the recommendation,
+// which are placeholders like $new-instance-template.
+type instanceTemplates struct {
+	copied  map[string]*compute.InstanceTemplate
+	created map[string]string
+}
+
+type instanceTemplatesKey struct{}
+
+// withInstanceTemplates returns the context, in which instance templates
+// copied and created by the operations are recorded.
+func withInstanceTemplates(ctx context.Context) context.Context {
+	return context.WithValue(ctx, instanceTemplatesKey{}, &instanceTemplates{
+		copied:  make(map[string]*compute.InstanceTemplate),
+		created: make(map[string]string),
+	})
+}
+
+// recordTemplateCopy records source as the template to be created under the name.
+// Returns false, if ctx doesn't record templates.
+func recordTemplateCopy(ctx context.Context, name string, source *compute.InstanceTemplate) bool {
+	templates, ok := ctx.Value(instanceTemplatesKey{}).(*instanceTemplates)
+	if ok {
+		templates.copied[name] = source
+	}
+	return ok
+}
+
+// copiedTemplate returns the template recorded under the name by recordTemplateCopy, or nil.
+func copiedTemplate(ctx context.Context, name string) *compute.InstanceTemplate {
+	if templates, ok := ctx.Value(instanceTemplatesKey{}).(*instanceTemplates); ok {
+		return templates.copied[name]
+	}
+	return nil
+}
+
+// recordTemplateCreated records that the template named name in the recommendation was created as created.
+func recordTemplateCreated(ctx context.Context, name, created string) {
+	if templates, ok := ctx.Value(instanceTemplatesKey{}).(*instanceTemplates); ok {
+		templates.created[name] = created
+	}
+}
+
+// createdTemplate returns the name, with which the template named name in the recommendation
+// was created, or name itself if it wasn't created in the operation group.
+func createdTemplate(ctx context.Context, name string) string {
+	if templates, ok := ctx.Value(instanceTemplatesKey{}).(*instanceTemplates); ok {
+		if created, ok := templates.created[name]; ok {
+			return created
+		}
+	}
+	return name
+}
+
+// templateURL returns the partial URL of the instance template, as set in managed instance groups.
+func templateURL(project, template string) string {
+	return fmt.Sprintf("projects/%s/global/instanceTemplates/%s", project, template)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+// InstanceGroupsMockService keeps instance templates and the template of one managed instance group.
+type InstanceGroupsMockService struct {
+	ApplyMockService
+	templates     map[string]*compute.InstanceTemplate
+	groupTemplate string
+	setErr        error
+	deleted       []string
+}
+
+func newInstanceGroupsMockService(recommendation gcloudRecommendation) *InstanceGroupsMockService {
+	return &InstanceGroupsMockService{
+		ApplyMockService: ApplyMockService{recommendation: recommendation},
+		templates: map[string]*compute.InstanceTemplate{
+			"web-template": {
+				Name:        "web-template",
+				Description: "web servers",
+				Properties:  &compute.InstanceProperties{MachineType: "n1-standard-4", Labels: map[string]string{"app": "web"}},
+			},
+		},
+		groupTemplate: "https://www.googleapis.com/compute/v1/projects/rightsizer-test/global/instanceTemplates/web-template",
+	}
+}
+
+func (s *InstanceGroupsMockService) GetInstanceTemplate(ctx context.Context, project, template string) (*compute.InstanceTemplate, error) {
+	if t, ok := s.templates[template]; ok {
+		return t, nil
+	}
+	return nil, errors.New("instance template not found")
+}
+
+func (s *InstanceGroupsMockService) CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error {
+	s.templates[template.Name] = template
+	return nil
+}
+
+func (s *InstanceGroupsMockService) DeleteInstanceTemplate(ctx context.Context, project, template string) error {
+	delete(s.templates, template)
+	s.deleted = append(s.deleted, template)
+	return nil
+}
+
+func (s *InstanceGroupsMockService) GetInstanceGroupManager(ctx context.Context, project, location, manager string) (*compute.InstanceGroupManager, error) {
+	return &compute.InstanceGroupManager{Name: manager, InstanceTemplate: s.groupTemplate}, nil
+}
+
+func (s *InstanceGroupsMockService) SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error {
+	if s.setErr != nil {
+		return s.setErr
+	}
+	s.groupTemplate = template
+	return nil
+}
+
+// instanceGroupRecommendation returns the machine type recommendation for the regional managed instance group web.
+func instanceGroupRecommendation() gcloudRecommendation {
+	newTemplate := "//compute.googleapis.com/projects/rightsizer-test/global/instanceTemplates/$new-instance-template"
+	return gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				{
+					Operations: []*gcloudOperation{
+						{
+							Action:         "copy",
+							Path:           "/",
+							Resource:       newTemplate,
+							ResourceType:   "compute.googleapis.com/InstanceTemplate",
+							SourcePath:     "/",
+							SourceResource: "//compute.googleapis.com/projects/rightsizer-test/global/instanceTemplates/web-template",
+						},
+						{
+							Action:       "replace",
+							Path:         "/properties/machineType",
+							Resource:     newTemplate,
+							ResourceType: "compute.googleapis.com/InstanceTemplate",
+							Value:        "e2-standard-2",
+						},
+						{
+							Action:       "replace",
+							Path:         "/instanceTemplate",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/regions/us-central1/instanceGroupManagers/web",
+							ResourceType: "compute.googleapis.com/InstanceGroupManager",
+							Value:        newTemplate,
+						},
+					},
+				},
+			},
+		},
+		Etag:      "\"40204a1000e5befe\"",
+		Name:      "projects/323016592286/locations/us-central1/recommenders/google.compute.instanceGroupManager.MachineTypeRecommender/recommendations/r",
+		StateInfo: &gcloudStateInfo{State: "ACTIVE"},
+	}
+}
+
+// Checks that the copy of the template with the new machine type is created and set in the group.
+func TestApplyInstanceGroupMachineType(t *testing.T) {
+	recommendation := instanceGroupRecommendation()
+	service := newInstanceGroupsMockService(recommendation)
+	results, err := Apply(context.Background(), service, &recommendation, &Task{})
+	if !assert.NoError(t, err, "Apply shouldn't return an error") || !assert.Len(t, results, 2) {
+		return
+	}
+
+	created := results[0]
+	assert.Equal(t, ActionCreateInstanceTemplate, created.Action)
+	assert.True(t, strings.HasPrefix(created.Resource, "web-template-"), "name of the copy should be generated")
+	assert.Equal(t, "e2-standard-2", created.Value)
+	assert.Equal(t, "n1-standard-4", created.PreviousValue)
+	if template, ok := service.templates[created.Resource]; assert.True(t, ok, "the template should be created") {
+		assert.Equal(t, "e2-standard-2", template.Properties.MachineType)
+		assert.Equal(t, map[string]string{"app": "web"}, template.Properties.Labels)
+	}
+	assert.Equal(t, "n1-standard-4", service.templates["web-template"].Properties.MachineType,
+		"the copied template shouldn't change")
+
+	expected := &OperationResult{
+		Action:        ActionSetInstanceTemplate,
+		Project:       "rightsizer-test",
+		Location:      "us-central1",
+		Resource:      "web",
+		Value:         created.Resource,
+		PreviousValue: "web-template",
+	}
+	assert.Equal(t, expected, results[1])
+	assert.Equal(t, "projects/rightsizer-test/global/instanceTemplates/"+created.Resource, service.groupTemplate)
+}
+
+// Checks that the created template is deleted, if setting it in the group fails.
+func TestApplyInstanceGroupMachineTypeRollback(t *testing.T) {
+	recommendation := instanceGroupRecommendation()
+	service := newInstanceGroupsMockService(recommendation)
+	service.setErr = errQuotaExceeded
+	results, err := Apply(context.Background(), service, &recommendation, &Task{})
+
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError should be returned") {
+		assert.Equal(t, errQuotaExceeded, applyErr.Err)
+		assert.True(t, applyErr.Rollback.Succeeded)
+	}
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, []string{results[0].Resource}, service.deleted)
+	}
+	assert.Len(t, service.templates, 1, "only the copied template should be left")
+}
+
+// Checks that the template set in the group is referred to by its name in the recommendation
+// only after it has been created.
+func TestReplaceGroupTemplateNotCreated(t *testing.T) {
+	recommendation := instanceGroupRecommendation()
+	operation := recommendation.Content.OperationGroups[0].Operations[2]
+	service := newInstanceGroupsMockService(recommendation)
+	_, err := DoOperation(withInstanceTemplates(context.Background()), service, operation, nil)
+	assert.Error(t, err, "the placeholder template can't be set")
+	assert.Equal(t, "https://www.googleapis.com/compute/v1/projects/rightsizer-test/global/instanceTemplates/web-template", service.groupTemplate)
+}
+
+// Checks that reverting sets the previous template back, unless it's already set.
+func TestRevertInstanceGroupTemplate(t *testing.T) {
+	applied := []*OperationResult{
+		{Action: ActionCreateInstanceTemplate, Project: "rightsizer-test", Resource: "web-template-copy", Value: "e2-standard-2"},
+		{Action: ActionSetInstanceTemplate, Project: "rightsizer-test", Location: "us-central1", Resource: "web",
+			Value: "web-template-copy", PreviousValue: "web-template"},
+	}
+	service := newInstanceGroupsMockService(gcloudRecommendation{})
+	service.groupTemplate = "projects/rightsizer-test/global/instanceTemplates/web-template-copy"
+	results, err := Revert(context.Background(), service, "r", applied, &Task{})
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, "web-template-copy", results[0].PreviousValue)
+	}
+	assert.Equal(t, "projects/rightsizer-test/global/instanceTemplates/web-template", service.groupTemplate)
+
+	results, err = Revert(context.Background(), service, "r", applied, &Task{})
+	assert.NoError(t, err)
+	assert.Empty(t, results, "the previous template is already set")
+}
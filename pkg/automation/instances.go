@@ -17,34 +17,34 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/google/uuid"
 	"google.golang.org/api/compute/v1"
 )
 
 // ChangeMachineType changes machine type using instances.setMachineType method.
 // Requires compute.instances.setMachineType permission.
-func (s *googleService) ChangeMachineType(project string, zone string, instance string, machineType string) error {
+func (s *googleService) ChangeMachineType(ctx context.Context, project string, zone string, instance string, machineType string) error {
 	machineType = fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineType)
 	request := &compute.InstancesSetMachineTypeRequest{MachineType: machineType}
 	instancesService := compute.NewInstancesService(s.computeService)
 
-	requestID := uuid.New().String()
-	return DoRequestWithRetries(func() error {
-		return AwaitCompletion(func() (*compute.Operation, error) {
-			return instancesService.SetMachineType(project, zone, instance, request).RequestId(requestID).Do()
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return instancesService.SetMachineType(project, zone, instance, request).RequestId(requestID).Context(ctx).Do()
 		}, sleepTimeChangingMachineType)
 	})
 }
 
 // GetInstance gets instance using instances.get method.
 // Requires compute.instances.get permission.
-func (s *googleService) GetInstance(project string, zone string, instance string) (*compute.Instance, error) {
+func (s *googleService) GetInstance(ctx context.Context, project string, zone string, instance string) (*compute.Instance, error) {
 	instancesService := compute.NewInstancesService(s.computeService)
 	var instanceVal *compute.Instance
-	err := DoRequestWithRetries(func() error {
-		inst, err := instancesService.Get(project, zone, instance).Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		inst, err := instancesService.Get(project, zone, instance).Context(ctx).Do()
 		instanceVal = inst
 		return err
 	})
@@ -53,24 +53,24 @@ func (s *googleService) GetInstance(project string, zone string, instance string
 
 // StopInstance stops instance using instances.stop method.
 // Requires compute.instances.stop permission.
-func (s *googleService) StopInstance(project string, zone string, instance string) error {
+func (s *googleService) StopInstance(ctx context.Context, project string, zone string, instance string) error {
 	instancesService := compute.NewInstancesService(s.computeService)
-	requestID := uuid.New().String()
-	return DoRequestWithRetries(func() error {
-		return AwaitCompletion(func() (*compute.Operation, error) {
-			return instancesService.Stop(project, zone, instance).RequestId(requestID).Do()
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return instancesService.Stop(project, zone, instance).RequestId(requestID).Context(ctx).Do()
 		}, sleepTimeStoppingInstance)
 	})
 }
 
 // StartInstance starts instance using instances.start method.
 // Requires compute.instances.start permission.
-func (s *googleService) StartInstance(project string, zone string, instance string) error {
+func (s *googleService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
 	instancesService := compute.NewInstancesService(s.computeService)
-	requestID := uuid.New().String()
-	return DoRequestWithRetries(func() error {
-		return AwaitCompletion(func() (*compute.Operation, error) {
-			return instancesService.Start(project, zone, instance).RequestId(requestID).Do()
+	requestID := newRequestID(ctx)
+	return DoRequestWithRetries(ctx, func() error {
+		return AwaitCompletion(ctx, func() (*compute.Operation, error) {
+			return instancesService.Start(project, zone, instance).RequestId(requestID).Context(ctx).Do()
 		}, sleepTimeStartingInstance)
 	})
 }
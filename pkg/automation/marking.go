@@ -17,6 +17,13 @@ limitations under the License.
 package automation
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/recommender/v1"
 )
 
@@ -25,7 +32,7 @@ type gcloudFailedRequest = recommender.GoogleCloudRecommenderV1MarkRecommendatio
 type gcloudSucceededRequest = recommender.GoogleCloudRecommenderV1MarkRecommendationSucceededRequest
 
 // Marks the recommendation defined by the given name and etag as claimed
-func (s *googleService) MarkRecommendationClaimed(name, etag string) (*gcloudRecommendation, error) {
+func (s *googleService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
 	r := recommender.NewProjectsLocationsRecommendersRecommendationsService(s.recommenderService)
 	request := gcloudClaimedRequest{
 		Etag: etag,
@@ -33,8 +40,8 @@ func (s *googleService) MarkRecommendationClaimed(name, etag string) (*gcloudRec
 
 	markClaimedCall := r.MarkClaimed(name, &request)
 	var recommendation *gcloudRecommendation
-	err := DoRequestWithRetries(func() error {
-		rec, err := markClaimedCall.Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		rec, err := markClaimedCall.Context(ctx).Do()
 		recommendation = rec
 		return err
 	})
@@ -42,15 +49,15 @@ func (s *googleService) MarkRecommendationClaimed(name, etag string) (*gcloudRec
 }
 
 // Marks the recommendation defined by the given name and etag as failed
-func (s *googleService) MarkRecommendationFailed(name, etag string) (*gcloudRecommendation, error) {
+func (s *googleService) MarkRecommendationFailed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
 	r := recommender.NewProjectsLocationsRecommendersRecommendationsService(s.recommenderService)
 	request := gcloudFailedRequest{
 		Etag: etag,
 	}
 	markFailedCall := r.MarkFailed(name, &request)
 	var recommendation *gcloudRecommendation
-	err := DoRequestWithRetries(func() error {
-		rec, err := markFailedCall.Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		rec, err := markFailedCall.Context(ctx).Do()
 		recommendation = rec
 		return err
 	})
@@ -58,7 +65,7 @@ func (s *googleService) MarkRecommendationFailed(name, etag string) (*gcloudReco
 }
 
 // Marks the recommendation defined by the given name and etag as succeeded
-func (s *googleService) MarkRecommendationSucceeded(name, etag string) (*gcloudRecommendation, error) {
+func (s *googleService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
 	r := recommender.NewProjectsLocationsRecommendersRecommendationsService(s.recommenderService)
 	request := gcloudSucceededRequest{
 		Etag: etag,
@@ -66,10 +73,66 @@ func (s *googleService) MarkRecommendationSucceeded(name, etag string) (*gcloudR
 
 	markSucceededCall := r.MarkSucceeded(name, &request)
 	var recommendation *gcloudRecommendation
-	err := DoRequestWithRetries(func() error {
-		rec, err := markSucceededCall.Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		rec, err := markSucceededCall.Context(ctx).Do()
 		recommendation = rec
 		return err
 	})
 	return recommendation, err
 }
+
+// reasonMetadataKey is the key in stateMetadata under which the reason
+// for dismissing or restoring the recommendation is stored.
+const reasonMetadataKey = "reason"
+
+// markStateRequest is the body of markDismissed and markActive requests,
+// which aren't available in the recommender package.
+type markStateRequest struct {
+	Etag          string            `json:"etag"`
+	StateMetadata map[string]string `json:"stateMetadata,omitempty"`
+}
+
+// markRecommendationState calls the given mark method of Recommender API for the recommendation,
+// for example markDismissed. If reason is not empty, it's saved in stateMetadata.
+func (s *googleService) markRecommendationState(ctx context.Context, method, name, etag, reason string) (*gcloudRecommendation, error) {
+	request := markStateRequest{Etag: etag}
+	if reason != "" {
+		request.StateMetadata = map[string]string{reasonMetadataKey: reason}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(s.recommenderService.BasePath, "/") + "/v1/" + name + ":" + method + "?alt=json"
+
+	var recommendation *gcloudRecommendation
+	err = DoRequestWithRetries(ctx, func() error {
+		httpRequest, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		response, err := s.recommenderClient.Do(httpRequest.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer googleapi.CloseBody(response)
+		if err := googleapi.CheckResponse(response); err != nil {
+			return err
+		}
+		recommendation = &gcloudRecommendation{}
+		return json.NewDecoder(response.Body).Decode(recommendation)
+	})
+	return recommendation, err
+}
+
+// Marks the recommendation defined by the given name and etag as dismissed,
+// the recommendation won't be applied. reason is optional.
+func (s *googleService) MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return s.markRecommendationState(ctx, "markDismissed", name, etag, reason)
+}
+
+// Marks the dismissed recommendation defined by the given name and etag as active again. reason is optional.
+func (s *googleService) MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return s.markRecommendationState(ctx, "markActive", name, etag, reason)
+}
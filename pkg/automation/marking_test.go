@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+// Checks that markDismissed and markActive are called with the etag and the reason.
+func TestMarkRecommendationState(t *testing.T) {
+	var paths []string
+	var requests []markStateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request markStateRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		paths = append(paths, r.URL.Path)
+		requests = append(requests, request)
+		json.NewEncoder(w).Encode(&gcloudRecommendation{Name: "projects/p/recommendation", Etag: "new"})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	service, err := NewGoogleServiceWithOptions(ctx, option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if !assert.NoError(t, err) {
+		return
+	}
+	rec, err := service.MarkRecommendationDismissed(ctx, "projects/p/recommendation", "etag", "not needed")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new", rec.Etag)
+	}
+	_, err = service.MarkRecommendationActive(ctx, "projects/p/recommendation", "etag", "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/v1/projects/p/recommendation:markDismissed", "/v1/projects/p/recommendation:markActive"}, paths)
+	assert.Equal(t, []markStateRequest{
+		{Etag: "etag", StateMetadata: map[string]string{reasonMetadataKey: "not needed"}},
+		{Etag: "etag"},
+	}, requests)
+}
@@ -17,12 +17,16 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
+	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/recommender/v1"
+	"google.golang.org/api/sqladmin/v1beta4"
 )
 
 type gcloudOperation = recommender.GoogleCloudRecommenderV1Operation
@@ -32,7 +36,7 @@ type gcloudOperation = recommender.GoogleCloudRecommenderV1Operation
 // The value specified by the path field in the operation struct must match value or valueMatcher,
 // depending on which one is defined. More can be read here:
 // https://cloud.google.com/recommender/docs/reference/rest/v1/projects.locations.recommenders.recommendations#operation
-func testInstanceField(service GoogleService, operation *gcloudOperation) error {
+func testInstanceField(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
 	path := operation.Resource
 
 	project, errProject := extractFromURL(path, projectParam)
@@ -40,12 +44,12 @@ func testInstanceField(service GoogleService, operation *gcloudOperation) error
 	instance, errInstance := extractFromURL(path, instanceParam)
 	err := chooseNotNil(errProject, errZone, errInstance)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	machineInstance, err := service.GetInstance(project, zone, instance)
+	machineInstance, err := service.GetInstance(ctx, project, zone, instance)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var result bool
@@ -59,27 +63,29 @@ func testInstanceField(service GoogleService, operation *gcloudOperation) error
 		field = "status"
 		result, err = testMatching(machineInstance.Status, operation.Value, operation.ValueMatcher)
 	default:
-		return errors.New(operationNotSupportedMessage)
+		return nil, errors.New(operationNotSupportedMessage)
 	}
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if result == false {
-		return fmt.Errorf("%s is not as expected", field)
+		return nil, fmt.Errorf("%s is not as expected", field)
 	}
 
-	return nil
+	return nil, nil
 }
 
 // Assumes, that the operation's action is replace and path is /machineType.
 // Replaces the machine type with a new one.
-func replaceMachineType(service GoogleService, operation *gcloudOperation) error {
+// Registers restoring the previous machine type and starting the instance,
+// if it was running, in rollback.
+func replaceMachineType(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
 	path1 := operation.Resource
 	path2, ok := operation.Value.(string)
 	if !ok {
-		return errors.New("wrong value type for operation replace machine type")
+		return nil, errors.New("wrong value type for operation replace machine type")
 	}
 
 	project, errProject := extractFromURL(path1, projectParam)
@@ -89,25 +95,61 @@ func replaceMachineType(service GoogleService, operation *gcloudOperation) error
 	zone, errZone := extractFromURL(path2, zoneParam)
 	err := chooseNotNil(errProject, errInstance, errMachine, errZone)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = service.StopInstance(project, zone, instance)
+	machineInstance, err := service.GetInstance(ctx, project, zone, instance)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = service.ChangeMachineType(project, zone, instance, machineType)
+	// The instance must not be left stopped, so the rest isn't canceled.
+	ctx = withoutCancel(ctx)
+	err = service.StopInstance(ctx, project, zone, instance)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if machineInstance.Status == "RUNNING" {
+		rollback.Register(fmt.Sprintf("start instance %s", instance), func() error {
+			return service.StartInstance(ctx, project, zone, instance)
+		})
+	}
+
+	err = service.ChangeMachineType(ctx, project, zone, instance, machineType)
+	if err != nil {
+		return nil, err
+	}
+	result := &OperationResult{
+		Action:   ActionSetMachineType,
+		Project:  project,
+		Location: zone,
+		Resource: instance,
+		Value:    machineType,
+	}
+	if machineInstance.Status == "RUNNING" {
+		result.StoppedInstances = []string{instance}
+	}
+	previousMachineType, err := extractFromURL(machineInstance.MachineType, machineTypeParam)
+	if err == nil {
+		result.PreviousValue = previousMachineType
+		rollback.Register(fmt.Sprintf("set machine type of instance %s back to %s", instance, previousMachineType), func() error {
+			return service.ChangeMachineType(ctx, project, zone, instance, previousMachineType)
+		})
 	}
 
-	return service.StartInstance(project, zone, instance)
+	if err := service.StartInstance(ctx, project, zone, instance); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// Assumes that operation's action is replace, path is status and value
-// is terminated. Stops the given machine.
-func stopInstance(service GoogleService, operation *gcloudOperation) error {
+// Assumes that operation's action is replace and path is /status.
+// If the value is TERMINATED, stops the given machine.
+// Registers starting the machine again in rollback.
+func stopInstance(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	if operation.Value != "TERMINATED" {
+		return nil, errors.New(operationNotSupportedMessage)
+	}
 	path := operation.Resource
 
 	project, errProject := extractFromURL(path, projectParam)
@@ -115,24 +157,50 @@ func stopInstance(service GoogleService, operation *gcloudOperation) error {
 	instance, errInstance := extractFromURL(path, instanceParam)
 	err := chooseNotNil(errProject, errZone, errInstance)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	machineInstance, err := service.GetInstance(ctx, project, zone, instance)
+	if err != nil {
+		return nil, err
 	}
 
-	return service.StopInstance(project, zone, instance)
+	// Stopping isn't canceled, so that starting the instance again can be registered in rollback.
+	ctx = withoutCancel(ctx)
+	err = service.StopInstance(ctx, project, zone, instance)
+	if err != nil {
+		return nil, err
+	}
+	result := &OperationResult{
+		Action:   ActionStopInstance,
+		Project:  project,
+		Location: zone,
+		Resource: instance,
+	}
+	// An instance that wasn't running is neither reported as stopped nor started on rollback.
+	if machineInstance.Status == "RUNNING" {
+		rollback.Register(fmt.Sprintf("start instance %s", instance), func() error {
+			return service.StartInstance(ctx, project, zone, instance)
+		})
+		result.StoppedInstances = []string{instance}
+	}
+	return result, nil
 }
 
 // Assumes that operation's action is add, and ResourceType
 // is compute.googleapis.com/Snapshot. Adds a snapshot of the given machine.
-func addSnapshot(service GoogleService, operation *gcloudOperation) error {
+// The snapshot is configured by the options set by WithSnapshotOptions.
+// The result contains the generated name of the snapshot.
+func addSnapshot(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
 	value, ok := operation.Value.(map[string]interface{})
 
 	if !ok {
-		return errors.New("wrong value type for operation add snapshot")
+		return nil, errors.New("wrong value type for operation add snapshot")
 	}
 
 	path, ok := value["source_disk"].(string)
 	if !ok {
-		return fmt.Errorf("wrong source disk type for operation add snapshot: %t", value["source_disk"])
+		return nil, fmt.Errorf("wrong source disk type for operation add snapshot: %t", value["source_disk"])
 	}
 
 	project, errProject := extractFromURL(path, projectParam)
@@ -140,22 +208,36 @@ func addSnapshot(service GoogleService, operation *gcloudOperation) error {
 	disk, errDisk := extractFromURL(path, diskParam)
 	err := chooseNotNil(errProject, errZone, errDisk)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	generator := rand.New(rand.NewSource(time.Now().UnixNano()))
 	name, err := randomSnapshotName(zone, disk, generator)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return service.CreateSnapshot(project, zone, disk, name)
+	if err := service.CreateSnapshot(ctx, project, zone, disk, newSnapshot(ctx, disk, name)); err != nil {
+		return nil, err
+	}
+	recordSnapshot(ctx, project, zone, disk, name)
+	result := &OperationResult{Action: ActionCreateSnapshot, Project: project, Location: zone, Resource: disk, SnapshotName: name}
+	// the snapshot has been created, so not knowing its link doesn't fail the operation
+	if snapshot, err := service.GetSnapshot(ctx, project, name); err == nil {
+		result.SnapshotSelfLink = snapshot.SelfLink
+	}
+	return result, nil
 }
 
 // Assumes that the operation's action is remove and its resource type
 // is compute.googleapis.com/Disk. Removes the given disk.
-func removeDisk(service GoogleService, operation *gcloudOperation) error {
+// As deleting can't be reverted, the disk is not deleted if it's attached to instances or too young,
+// or if the snapshot of it created earlier in the operation group isn't ready.
+// The result contains that snapshot, and the type, size and labels of the disk,
+// so that the disk can be restored. It's returned also if deleting fails,
+// as the disk may have been deleted anyway.
+func removeDisk(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
 	path := operation.Resource
 
 	project, errProject := extractFromURL(path, projectParam)
@@ -163,8 +245,252 @@ func removeDisk(service GoogleService, operation *gcloudOperation) error {
 	disk, errDisk := extractFromURL(path, diskParam)
 	err := chooseNotNil(errProject, errZone, errDisk)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	d, err := checkDiskDeletable(ctx, service, project, zone, disk)
+	if err != nil {
+		return nil, err
+	}
+	result := &OperationResult{
+		Action:     ActionDeleteDisk,
+		Project:    project,
+		Location:   zone,
+		Resource:   disk,
+		DiskType:   lastPathSegment(d.Type),
+		DiskSizeGb: d.SizeGb,
+		Labels:     d.Labels,
+	}
+	if name := recordedSnapshot(ctx, project, zone, disk); name != "" {
+		snapshot, err := awaitSnapshotReady(ctx, service, project, name)
+		if err != nil {
+			return nil, err
+		}
+		result.SnapshotName = name
+		result.SnapshotSelfLink = snapshot.SelfLink
+	}
+	// Deleting isn't canceled, so that the result is known when it finishes.
+	if err := service.DeleteDisk(withoutCancel(ctx), project, zone, disk); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Assumes that the operation's action is remove and its resource type
+// is compute.googleapis.com/Address. Releases the given regional or global address.
+func removeAddress(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	path := operation.Resource
+
+	project, errProject := extractFromURL(path, projectParam)
+	address, errAddress := extractFromURL(path, addressParam)
+	err := chooseNotNil(errProject, errAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	region, err := extractFromURL(path, regionParam)
+	if err != nil {
+		region = "" // global address
+	}
+
+	if err := service.ReleaseAddress(ctx, project, region, address); err != nil {
+		return nil, err
+	}
+	return &OperationResult{Action: ActionReleaseAddress, Project: project, Location: region, Resource: address}, nil
+}
+
+// Assumes that the operation's action is remove and its resource type
+// is compute.googleapis.com/Image. Deletes the given image.
+func removeImage(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	path := operation.Resource
+
+	project, errProject := extractFromURL(path, projectParam)
+	image, errImage := extractFromURL(path, imageParam)
+	err := chooseNotNil(errProject, errImage)
+	if err != nil {
+		return nil, err
 	}
 
-	return service.DeleteDisk(project, zone, disk)
+	if err := service.DeleteImage(ctx, project, image); err != nil {
+		return nil, err
+	}
+	return &OperationResult{Action: ActionDeleteImage, Project: project, Resource: image}, nil
+}
+
+// Assumes that the operation's action is replace, its resource type
+// is sqladmin.googleapis.com/Instance and path is /settings/activationPolicy.
+// Sets the activation policy of the Cloud SQL instance, NEVER stops the instance.
+// Registers restoring the previous activation policy in rollback.
+func replaceSQLActivationPolicy(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	return replaceSQLSetting(ctx, service, operation, rollback, ActionSetSQLActivationPolicy, "activation policy",
+		func(settings *sqladmin.Settings) string { return settings.ActivationPolicy },
+		service.SetSQLActivationPolicy)
+}
+
+// Assumes that the operation's action is replace, its resource type
+// is sqladmin.googleapis.com/Instance and path is /settings/tier.
+// Changes the tier (machine type) of the Cloud SQL instance.
+// Registers restoring the previous tier in rollback.
+func replaceSQLTier(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	return replaceSQLSetting(ctx, service, operation, rollback, ActionSetSQLTier, "tier",
+		func(settings *sqladmin.Settings) string { return settings.Tier },
+		service.ChangeSQLTier)
+}
+
+// replaceSQLSetting sets the setting of the Cloud SQL instance to the operation's value
+// using set. The previous value, read with get, is restored in rollback.
+// The result describes the change as action.
+func replaceSQLSetting(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback, action, setting string,
+	get func(*sqladmin.Settings) string, set func(ctx context.Context, project, instance, value string) error) (*OperationResult, error) {
+	value, ok := operation.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong value type for operation replace %s", setting)
+	}
+
+	project, errProject := extractFromURL(operation.Resource, projectParam)
+	instance, errInstance := extractFromURL(operation.Resource, instanceParam)
+	err := chooseNotNil(errProject, errInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlInstance, err := service.GetSQLInstance(ctx, project, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	err = set(ctx, project, instance, value)
+	if err != nil {
+		return nil, err
+	}
+	result := &OperationResult{Action: action, Project: project, Resource: instance, Value: value}
+	if sqlInstance.Settings != nil {
+		previous := get(sqlInstance.Settings)
+		result.PreviousValue = previous
+		rollback.Register(fmt.Sprintf("set %s of Cloud SQL instance %s back to %s", setting, instance, previous), func() error {
+			return set(withoutCancel(ctx), project, instance, previous)
+		})
+	}
+	return result, nil
+}
+
+// Assumes that the operation's action is copy and its resource type
+// is compute.googleapis.com/InstanceTemplate. Gets the source template and records it
+// for replaceTemplateMachineType, which creates the copy, as templates can't be changed once created.
+// Nothing is changed, so the result is nil.
+func copyInstanceTemplate(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	project, err := extractFromURL(operation.SourceResource, projectParam)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := service.GetInstanceTemplate(ctx, project, lastPathSegment(operation.SourceResource))
+	if err != nil {
+		return nil, err
+	}
+	if !recordTemplateCopy(ctx, lastPathSegment(operation.Resource), source) {
+		return nil, errors.New("instance templates can only be copied in operation groups")
+	}
+	return nil, nil
+}
+
+// Assumes that the operation's action is replace, its resource type
+// is compute.googleapis.com/InstanceTemplate and path is /properties/machineType.
+// Creates the template copied earlier in the operation group with the machine type.
+// If the name of the template is a placeholder starting with $, a new name is generated.
+// Registers deleting the created template in rollback.
+func replaceTemplateMachineType(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	value, ok := operation.Value.(string)
+	if !ok {
+		return nil, errors.New("wrong value type for operation replace machine type of instance template")
+	}
+	machineType := lastPathSegment(value)
+
+	project, err := extractFromURL(operation.Resource, projectParam)
+	if err != nil {
+		return nil, err
+	}
+	name := lastPathSegment(operation.Resource)
+	source := copiedTemplate(ctx, name)
+	if source == nil || source.Properties == nil {
+		return nil, fmt.Errorf("instance template %s must be copied earlier in the operation group", name)
+	}
+
+	properties := *source.Properties
+	properties.MachineType = machineType
+	template := &compute.InstanceTemplate{Name: name, Description: source.Description, Properties: &properties}
+	if strings.HasPrefix(name, "$") {
+		generator := rand.New(rand.NewSource(time.Now().UnixNano()))
+		template.Name = randomTemplateName(source.Name, generator)
+	}
+
+	if err := service.CreateInstanceTemplate(ctx, project, template); err != nil {
+		return nil, err
+	}
+	recordTemplateCreated(ctx, name, template.Name)
+	rollback.Register(fmt.Sprintf("delete instance template %s", template.Name), func() error {
+		return service.DeleteInstanceTemplate(withoutCancel(ctx), project, template.Name)
+	})
+	return &OperationResult{
+		Action:        ActionCreateInstanceTemplate,
+		Project:       project,
+		Resource:      template.Name,
+		Value:         machineType,
+		PreviousValue: lastPathSegment(source.Properties.MachineType),
+	}, nil
+}
+
+// Assumes that the operation's action is replace, its resource type
+// is compute.googleapis.com/InstanceGroupManager and path is /instanceTemplate
+// or /versions/0/instanceTemplate. Sets the instance template of the zonal or regional
+// managed instance group, which may be the one created earlier in the operation group.
+// Registers setting the previous template back in rollback.
+func replaceGroupTemplate(ctx context.Context, service GoogleService, operation *gcloudOperation, rollback *Rollback) (*OperationResult, error) {
+	value, ok := operation.Value.(string)
+	if !ok {
+		return nil, errors.New("wrong value type for operation replace instance template")
+	}
+	path := operation.Resource
+
+	project, errProject := extractFromURL(path, projectParam)
+	manager, errManager := extractFromURL(path, instanceGroupManagerParam)
+	err := chooseNotNil(errProject, errManager)
+	if err != nil {
+		return nil, err
+	}
+	location, err := extractFromURL(path, zoneParam)
+	if err != nil {
+		location, err = extractFromURL(path, regionParam)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	template := createdTemplate(ctx, lastPathSegment(value))
+	if strings.HasPrefix(template, "$") {
+		return nil, fmt.Errorf("instance template %s must be created earlier in the operation group", template)
+	}
+	group, err := service.GetInstanceGroupManager(ctx, project, location, manager)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := service.SetInstanceGroupManagerTemplate(ctx, project, location, manager, templateURL(project, template)); err != nil {
+		return nil, err
+	}
+	result := &OperationResult{
+		Action:   ActionSetInstanceTemplate,
+		Project:  project,
+		Location: location,
+		Resource: manager,
+		Value:    template,
+	}
+	if group.InstanceTemplate != "" {
+		previous := group.InstanceTemplate
+		result.PreviousValue = lastPathSegment(previous)
+		rollback.Register(fmt.Sprintf("set instance template of managed instance group %s back to %s", manager, result.PreviousValue), func() error {
+			return service.SetInstanceGroupManagerTemplate(withoutCancel(ctx), project, location, manager, previous)
+		})
+	}
+	return result, nil
 }
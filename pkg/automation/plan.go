@@ -0,0 +1,240 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Actions that can be listed in ApplyPlan.
+const (
+	ActionStopInstance   = "STOP_INSTANCE"
+	ActionStartInstance  = "START_INSTANCE"
+	ActionSetMachineType = "SET_MACHINE_TYPE"
+	ActionCreateSnapshot = "CREATE_SNAPSHOT"
+	ActionCreateDisk     = "CREATE_DISK"
+	ActionDeleteDisk     = "DELETE_DISK"
+	ActionDeleteSnapshot = "DELETE_SNAPSHOT"
+	ActionReleaseAddress = "RELEASE_ADDRESS"
+	ActionDeleteImage    = "DELETE_IMAGE"
+
+	ActionSetSQLActivationPolicy = "SET_SQL_ACTIVATION_POLICY"
+	ActionSetSQLTier             = "SET_SQL_TIER"
+
+	ActionCreateInstanceTemplate = "CREATE_INSTANCE_TEMPLATE"
+	ActionDeleteInstanceTemplate = "DELETE_INSTANCE_TEMPLATE"
+	ActionSetInstanceTemplate    = "SET_INSTANCE_TEMPLATE"
+)
+
+// PlannedAction describes one change to a resource, that would be made
+// while applying a recommendation.
+// Value is the new machine type for ActionSetMachineType, the new policy for ActionSetSQLActivationPolicy,
+// the new tier for ActionSetSQLTier, the name of the snapshot for ActionCreateSnapshot,
+// the source snapshot for ActionCreateDisk, the machine type of the template for ActionCreateInstanceTemplate
+// and the template for ActionSetInstanceTemplate, otherwise it's empty.
+// Zone is the zone or the region of the managed instance group for ActionSetInstanceTemplate.
+type PlannedAction struct {
+	Action      string `json:"action"`
+	Project     string `json:"project"`
+	Zone        string `json:"zone,omitempty"`
+	Resource    string `json:"resource"`
+	Value       string `json:"value,omitempty"`
+	Description string `json:"description"`
+}
+
+// ApplyPlan is the list of actions that Apply would do for the recommendation.
+type ApplyPlan struct {
+	Recommendation string           `json:"recommendation"`
+	Etag           string           `json:"etag"`
+	Actions        []*PlannedAction `json:"actions"`
+}
+
+var errPlanningMarking = errors.New("the state of the recommendation can't be changed while planning")
+
+// planningService records the calls that would change resources instead of making them.
+// Read-only calls are passed to the embedded GoogleService.
+type planningService struct {
+	GoogleService
+	actions   []*PlannedAction
+	snapshots map[string]bool
+}
+
+func (s *planningService) addAction(action, project, zone, resource, value, description string) {
+	s.actions = append(s.actions, &PlannedAction{
+		Action:      action,
+		Project:     project,
+		Zone:        zone,
+		Resource:    resource,
+		Value:       value,
+		Description: description,
+	})
+}
+
+func (s *planningService) ChangeMachineType(ctx context.Context, project, zone, instance, machineType string) error {
+	s.addAction(ActionSetMachineType, project, zone, instance, machineType,
+		fmt.Sprintf("set machine type of instance %s to %s", instance, machineType))
+	return nil
+}
+
+func (s *planningService) CreateDisk(ctx context.Context, project, zone string, disk *compute.Disk) error {
+	s.addAction(ActionCreateDisk, project, zone, disk.Name, disk.SourceSnapshot,
+		fmt.Sprintf("create disk %s", disk.Name))
+	return nil
+}
+
+func (s *planningService) CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error {
+	s.addAction(ActionCreateSnapshot, project, zone, disk, snapshot.Name,
+		fmt.Sprintf("create snapshot %s of disk %s", snapshot.Name, disk))
+	if s.snapshots == nil {
+		s.snapshots = make(map[string]bool)
+	}
+	s.snapshots[snapshot.Name] = true
+	return nil
+}
+
+// GetSnapshot returns planned snapshots as ready, others are got from the embedded GoogleService.
+func (s *planningService) GetSnapshot(ctx context.Context, project, snapshot string) (*compute.Snapshot, error) {
+	if s.snapshots[snapshot] {
+		return &compute.Snapshot{Name: snapshot, Status: "READY"}, nil
+	}
+	return s.GoogleService.GetSnapshot(ctx, project, snapshot)
+}
+
+func (s *planningService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
+	s.addAction(ActionDeleteDisk, project, zone, disk, "", fmt.Sprintf("delete disk %s", disk))
+	return nil
+}
+
+func (s *planningService) DeleteSnapshot(ctx context.Context, project, snapshot string) error {
+	s.addAction(ActionDeleteSnapshot, project, "", snapshot, "", fmt.Sprintf("delete snapshot %s", snapshot))
+	return nil
+}
+
+func (s *planningService) ReleaseAddress(ctx context.Context, project, region, address string) error {
+	s.addAction(ActionReleaseAddress, project, "", address, "", fmt.Sprintf("release address %s", address))
+	return nil
+}
+
+func (s *planningService) CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error {
+	machineType := ""
+	if template.Properties != nil {
+		machineType = template.Properties.MachineType
+	}
+	s.addAction(ActionCreateInstanceTemplate, project, "", template.Name, machineType,
+		fmt.Sprintf("create instance template %s with machine type %s", template.Name, machineType))
+	return nil
+}
+
+func (s *planningService) DeleteInstanceTemplate(ctx context.Context, project, template string) error {
+	s.addAction(ActionDeleteInstanceTemplate, project, "", template, "", fmt.Sprintf("delete instance template %s", template))
+	return nil
+}
+
+func (s *planningService) SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error {
+	s.addAction(ActionSetInstanceTemplate, project, location, manager, lastPathSegment(template),
+		fmt.Sprintf("set instance template of managed instance group %s to %s", manager, lastPathSegment(template)))
+	return nil
+}
+
+func (s *planningService) DeleteImage(ctx context.Context, project, image string) error {
+	s.addAction(ActionDeleteImage, project, "", image, "", fmt.Sprintf("delete image %s", image))
+	return nil
+}
+
+func (s *planningService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	s.addAction(ActionStopInstance, project, zone, instance, "", fmt.Sprintf("stop instance %s", instance))
+	return nil
+}
+
+func (s *planningService) StartInstance(ctx context.Context, project, zone, instance string) error {
+	s.addAction(ActionStartInstance, project, zone, instance, "", fmt.Sprintf("start instance %s", instance))
+	return nil
+}
+
+func (s *planningService) SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error {
+	s.addAction(ActionSetSQLActivationPolicy, project, "", instance, policy,
+		fmt.Sprintf("set activation policy of Cloud SQL instance %s to %s", instance, policy))
+	return nil
+}
+
+func (s *planningService) ChangeSQLTier(ctx context.Context, project, instance, tier string) error {
+	s.addAction(ActionSetSQLTier, project, "", instance, tier,
+		fmt.Sprintf("set tier of Cloud SQL instance %s to %s", instance, tier))
+	return nil
+}
+
+func (s *planningService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return nil, errPlanningMarking
+}
+
+func (s *planningService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return nil, errPlanningMarking
+}
+
+func (s *planningService) MarkRecommendationFailed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	return nil, errPlanningMarking
+}
+
+func (s *planningService) MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return nil, errPlanningMarking
+}
+
+func (s *planningService) MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error) {
+	return nil, errPlanningMarking
+}
+
+// Plan returns the actions that Apply would do for the recommendation, without doing them.
+// Every operation goes through DoOperation, but only read-only calls, like GetInstance in test operations
+// or GetDisk before deleting a disk, are made to Google APIs, so if the resources are not in the expected state,
+// the error is returned.
+// The state of the recommendation is not changed.
+func Plan(ctx context.Context, service GoogleService, recommendation *gcloudRecommendation) (*ApplyPlan, error) {
+	if strings.ToLower(recommendation.StateInfo.State) != "active" {
+		return nil, errors.New("to apply a recommendation, its status must be active")
+	}
+
+	planner := &planningService{GoogleService: service}
+	for _, operationGroup := range recommendation.Content.OperationGroups {
+		ctx := withCreatedSnapshots(ctx)
+		for _, operation := range operationGroup.Operations {
+			_, err := DoOperation(ctx, planner, operation, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ApplyPlan{
+		Recommendation: recommendation.Name,
+		Etag:           recommendation.Etag,
+		Actions:        planner.actions,
+	}, nil
+}
+
+// PlanByName gets the recommendation by name and returns the plan of applying it using the Plan function.
+func PlanByName(ctx context.Context, service GoogleService, recommendationName string) (*ApplyPlan, error) {
+	recommendation, err := service.GetRecommendation(ctx, recommendationName)
+	if err != nil {
+		return nil, err
+	}
+	return Plan(ctx, service, recommendation)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+// Checks that planning a machine type change only calls GetInstance
+// and lists stopping, changing machine type and starting the instance.
+func TestPlanReplaceRecommendation(t *testing.T) {
+	ctx := context.Background()
+	recommendation := gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				&gcloudOperationGroup{
+					Operations: []*gcloudOperation{
+						&gcloudOperation{
+							Action:       "test",
+							Path:         "/machineType",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+							ResourceType: "compute.googleapis.com/Instance",
+							ValueMatcher: &gcloudValueMatcher{MatchesPattern: ".*zones/us-central1-a/machineTypes/e2-standard-2"},
+						},
+						&gcloudOperation{
+							Action:       "replace",
+							Path:         "/machineType",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+							ResourceType: "compute.googleapis.com/Instance",
+							Value:        "zones/us-central1-a/machineTypes/e2-medium",
+						},
+					},
+				},
+			},
+		},
+		Etag:      "\"40204a1000e5befe\"",
+		Name:      "projects/323016592286/locations/us-central1-a/recommenders/google.compute.instance.MachineTypeRecommender/recommendations/5df355d9-2f50-4567-a909-bcfcebcf7d66",
+		StateInfo: &gcloudStateInfo{State: "Active"},
+	}
+
+	service := ApplyMockService{getInstanceResult: &compute.Instance{MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}}
+	plan, err := Plan(ctx, &service, &recommendation)
+	if !assert.NoError(t, err, "Plan shouldn't return an error") {
+		return
+	}
+
+	assert.Equal(t, recommendation.Name, plan.Recommendation)
+	assert.Equal(t, recommendation.Etag, plan.Etag)
+	actions := []string{}
+	for _, action := range plan.Actions {
+		actions = append(actions, action.Action)
+		assert.Equal(t, "rightsizer-test", action.Project)
+		assert.Equal(t, "us-central1-a", action.Zone)
+		assert.Equal(t, "alicja-test", action.Resource)
+	}
+	assert.Equal(t, []string{ActionStopInstance, ActionSetMachineType, ActionStartInstance}, actions)
+	assert.Equal(t, "e2-medium", plan.Actions[1].Value)
+
+	expectedFunctions := []string{"GetInstance", "GetInstance"}
+	expectedArguments := [][]interface{}{{"rightsizer-test", "us-central1-a", "alicja-test"}, {"rightsizer-test", "us-central1-a", "alicja-test"}}
+	expectedResults := [][]interface{}{{service.getInstanceResult, nil}, {service.getInstanceResult, nil}}
+	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
+	assert.Equal(t, expected, service.calledFunctions, "Only read-only calls should be made")
+	assert.Equal(t, "Active", recommendation.StateInfo.State, "State of the recommendation shouldn't change")
+}
+
+// Checks that the plan of snapshot and delete recommendation contains
+// the generated snapshot name and doesn't call Google APIs.
+func TestPlanSnapshotAndDeleteRecommendation(t *testing.T) {
+	ctx := context.Background()
+	var valueAddSnapshot interface{}
+	value := `
+	{
+		"name": "$snapshot-name",
+		"source_disk": "projects/rightsizer-test/zones/europe-west1-d/disks/my-disk",
+		"storage_locations": [
+			"us-central1-f"
+		]
+	}
+	`
+
+	err := json.Unmarshal([]byte(value), &valueAddSnapshot)
+	if !assert.NoError(t, err, "No error expected from json.Unmarshal") {
+		return
+	}
+
+	recommendation := gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				&gcloudOperationGroup{
+					Operations: []*gcloudOperation{
+						&gcloudOperation{
+							Action:       "add",
+							Path:         "/",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/global/snapshots/$snapshot-name",
+							ResourceType: "compute.googleapis.com/Snapshot",
+							Value:        valueAddSnapshot,
+						},
+						&gcloudOperation{
+							Action:       "remove",
+							Path:         "/",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/europe-west1-d/disks/my-disk",
+							ResourceType: "compute.googleapis.com/Disk",
+						},
+					},
+				},
+			},
+		},
+		Etag:      "\"856260fc666866a3\"",
+		Name:      "projects/323016592286/locations/europe-west1-d/recommenders/google.compute.disk.IdleResourceRecommender/recommendations/1e32196d-fc39-4358-9c9b-cec17a85f4ea",
+		StateInfo: &gcloudStateInfo{State: "Active"},
+	}
+
+	service := ApplyMockService{}
+	plan, err := Plan(ctx, &service, &recommendation)
+	if !assert.NoError(t, err, "Plan shouldn't return an error") {
+		return
+	}
+
+	if assert.Equal(t, 2, len(plan.Actions), "Two actions expected") {
+		assert.Equal(t, ActionCreateSnapshot, plan.Actions[0].Action)
+		assert.Equal(t, "my-disk", plan.Actions[0].Resource)
+		assert.Regexp(t, "^my-disk-europe-west1-d-", plan.Actions[0].Value)
+		assert.Equal(t, ActionDeleteDisk, plan.Actions[1].Action)
+		assert.Equal(t, "my-disk", plan.Actions[1].Resource)
+	}
+	// the disk is checked before deleting it, the planned snapshot isn't got
+	expected := newCalledFunctions([]string{"GetDisk"},
+		[][]interface{}{{"rightsizer-test", "europe-west1-d", "my-disk"}},
+		[][]interface{}{{idleDisk, nil}})
+	assert.Equal(t, expected, service.calledFunctions, "Only read-only calls to Google APIs expected")
+}
+
+// Checks that a failed test operation makes Plan return an error.
+func TestPlanFailedTest(t *testing.T) {
+	ctx := context.Background()
+	recommendation := gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				&gcloudOperationGroup{
+					Operations: []*gcloudOperation{
+						&gcloudOperation{
+							Action:       "test",
+							Path:         "/status",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+							ResourceType: "compute.googleapis.com/Instance",
+							Value:        "RUNNING",
+						},
+						&gcloudOperation{
+							Action:       "replace",
+							Path:         "/status",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+							ResourceType: "compute.googleapis.com/Instance",
+							Value:        "TERMINATED",
+						},
+					},
+				},
+			},
+		},
+		Name:      "name",
+		StateInfo: &gcloudStateInfo{State: "Active"},
+	}
+
+	service := ApplyMockService{getInstanceResult: &compute.Instance{Status: "TERMINATED"}}
+	_, err := Plan(ctx, &service, &recommendation)
+	assert.EqualError(t, err, "status is not as expected")
+}
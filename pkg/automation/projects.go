@@ -17,17 +17,22 @@ limitations under the License.
 package automation
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
 	"google.golang.org/api/cloudresourcemanager/v1"
 )
 
 // ListProjects lists the projects IDs for projects user has resourcemanager.projects.get permission
-func (s *googleService) ListProjects() ([]string, error) {
+func (s *googleService) ListProjects(ctx context.Context) ([]string, error) {
 	projectsService := cloudresourcemanager.NewProjectsService(s.resourceManagerService)
 	var projects []string
 	var err error
-	DoRequestWithRetries(func() error {
+	DoRequestWithRetries(ctx, func() error {
 		projects = nil
-		err = projectsService.List().Pages(s.ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
+		err = projectsService.List().Pages(ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
 			for _, project := range r.Projects {
 				projects = append(projects, project.ProjectId)
 			}
@@ -40,3 +45,37 @@ func (s *googleService) ListProjects() ([]string, error) {
 	}
 	return projects, nil
 }
+
+// ListProjectsByLabels lists the projects IDs for projects user has resourcemanager.projects.get permission,
+// which have all the given labels, for example env=dev. If labels is empty, all such projects are listed.
+func (s *googleService) ListProjectsByLabels(ctx context.Context, labels map[string]string) ([]string, error) {
+	projectsService := cloudresourcemanager.NewProjectsService(s.resourceManagerService)
+	listCall := projectsService.List()
+	if filter := labelsFilter(labels); filter != "" {
+		listCall = listCall.Filter(filter)
+	}
+	var projects []string
+	err := DoRequestWithRetries(ctx, func() error {
+		projects = nil
+		return listCall.Pages(ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
+			for _, project := range r.Projects {
+				projects = append(projects, project.ProjectId)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// labelsFilter returns the Resource Manager filter matching projects with all the labels.
+func labelsFilter(labels map[string]string) string {
+	var terms []string
+	for key, value := range labels {
+		terms = append(terms, fmt.Sprintf("labels.%s:%s", key, value))
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, " ")
+}
@@ -14,9 +14,14 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
@@ -27,11 +32,11 @@ import (
 type gcloudRecommendation = recommender.GoogleCloudRecommenderV1Recommendation
 
 // GetRecommendation implements projects.locations.recommenders.recommendations/get method
-func (s *googleService) GetRecommendation(name string) (*gcloudRecommendation, error) {
+func (s *googleService) GetRecommendation(ctx context.Context, name string) (*gcloudRecommendation, error) {
 	service := recommender.NewProjectsLocationsRecommendersRecommendationsService(s.recommenderService)
 	var recommendation *gcloudRecommendation
-	err := DoRequestWithRetries(func() error {
-		rec, err := service.Get(name).Do()
+	err := DoRequestWithRetries(ctx, func() error {
+		rec, err := service.Get(name).Context(ctx).Do()
 		recommendation = rec
 		return err
 	})
@@ -55,22 +60,86 @@ func isInvalidArgumentError(err error) bool {
 	return false
 }
 
+// isInvalidFilterError returns whether the received error is caused by invalid filter expression.
+func isInvalidFilterError(err error) bool {
+	googleErr, ok := err.(*googleapi.Error)
+	return ok && strings.Contains(strings.ToLower(googleErr.Message), "filter")
+}
+
+// listRecommendationsResponse is the response of projects.locations.recommenders.recommendations/list method,
+// in which recommendations are decoded later, as their priorities are missing in gcloudRecommendation.
+type listRecommendationsResponse struct {
+	Recommendations []json.RawMessage `json:"recommendations"`
+	NextPageToken   string            `json:"nextPageToken"`
+}
+
 // ListRecommendations returns the list of recommendations for specified project, zone, recommender.
-// projects.locations.recommenders.recommendations/list method from Recommender API is used.
+// If filter is not empty, only the recommendations matching it are returned,
+// for example "stateInfo.state = ACTIVE".
+// projects.locations.recommenders.recommendations/list method from Recommender API is used,
+// it's called with recommenderClient, so that the priorities of recommendations are recorded,
+// if ctx is returned by withPriorities.
 // If the error occurred the returned error is not nil.
-func (s *googleService) ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error) {
-	recommendationsService := recommender.NewProjectsLocationsRecommendersRecommendationsService(s.recommenderService)
-	listCall := recommendationsService.List(fmt.Sprintf("projects/%s/locations/%s/recommenders/%s", project, location, recommenderID))
+func (s *googleService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error) {
+	query := url.Values{"alt": {"json"}}
+	if filter != "" {
+		query.Set("filter", filter)
+	}
+	parent := fmt.Sprintf("projects/%s/locations/%s/recommenders/%s", project, location, recommenderID)
+	listURL := strings.TrimSuffix(s.recommenderService.BasePath, "/") + "/v1/" + parent + "/recommendations"
+
 	var recommendations []*gcloudRecommendation
-	addRecommendations := func(response *recommender.GoogleCloudRecommenderV1ListRecommendationsResponse) error {
-		recommendations = append(recommendations, response.Recommendations...)
-		return nil
+	var priorities map[string]string
+	// listPage adds the recommendations from the page with the token, and returns the token of the next page.
+	listPage := func(pageToken string) (string, error) {
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		httpRequest, err := http.NewRequest(http.MethodGet, listURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		response, err := s.recommenderClient.Do(httpRequest.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		defer googleapi.CloseBody(response)
+		if err := googleapi.CheckResponse(response); err != nil {
+			return "", err
+		}
+		var page listRecommendationsResponse
+		if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+			return "", err
+		}
+		for _, raw := range page.Recommendations {
+			recommendation := &gcloudRecommendation{}
+			var priority struct {
+				Priority string `json:"priority"`
+			}
+			if err := json.Unmarshal(raw, recommendation); err != nil {
+				return "", err
+			}
+			if err := json.Unmarshal(raw, &priority); err != nil {
+				return "", err
+			}
+			recommendations = append(recommendations, recommendation)
+			if priority.Priority != "" {
+				priorities[recommendation.Name] = priority.Priority
+			}
+		}
+		return page.NextPageToken, nil
 	}
-	err := DoRequestWithRetries(func() error {
+	err := DoRequestWithRetries(ctx, func() error {
 		recommendations = nil
-		err := listCall.Pages(s.ctx, addRecommendations)
+		priorities = make(map[string]string)
+		query.Del("pageToken")
+		pageToken, err := listPage("")
+		for err == nil && pageToken != "" {
+			pageToken, err = listPage(pageToken)
+		}
 		// Check if error is because current location is not available for getting recommendations.
-		if isInvalidArgumentError(err) {
+		// Errors caused by invalid filter are returned.
+		if isInvalidArgumentError(err) && !(filter != "" && isInvalidFilterError(err)) {
 			log.Printf("Invalid location error: %v received while getting recommendations for %s %s %s",
 				err, project, location, recommenderID)
 			recommendations = nil
@@ -78,13 +147,67 @@ func (s *googleService) ListRecommendations(project, location, recommenderID str
 		}
 		return err
 	})
+	if err == nil {
+		prioritiesFromContext(ctx).add(priorities)
+	}
 	return recommendations, err
 }
 
+// priorityRecorder records the priorities of recommendations listed by googleService,
+// which are missing in gcloudRecommendation of the recommender package.
+type priorityRecorder struct {
+	mutex      sync.Mutex
+	priorities map[string]string
+}
+
+type prioritiesKey struct{}
+
+// withPriorities returns ctx, in which ListRecommendations of googleService records the priorities
+// of the listed recommendations in the returned recorder. Decorators of GoogleService pass ctx on,
+// so the priorities are recorded also with them.
+func withPriorities(ctx context.Context) (context.Context, *priorityRecorder) {
+	recorder := &priorityRecorder{priorities: make(map[string]string)}
+	return context.WithValue(ctx, prioritiesKey{}, recorder), recorder
+}
+
+// prioritiesFromContext returns the recorder set by withPriorities, or nil.
+func prioritiesFromContext(ctx context.Context) *priorityRecorder {
+	recorder, _ := ctx.Value(prioritiesKey{}).(*priorityRecorder)
+	return recorder
+}
+
+// add records the priorities of recommendations, keyed by their names. Nothing is done if r is nil.
+func (r *priorityRecorder) add(priorities map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, priority := range priorities {
+		r.priorities[name] = priority
+	}
+}
+
+// get returns the recorded priorities of the recommendations, which have them.
+func (r *priorityRecorder) get(recommendations []*gcloudRecommendation) map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.priorities) == 0 {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, recommendation := range recommendations {
+		if priority, ok := r.priorities[recommendation.Name]; ok {
+			result[recommendation.Name] = priority
+		}
+	}
+	return result
+}
+
 // ListZonesNames returns list of zone names for the specified project.
 // Uses zones/list method from Compute API.
 // If the error occurred the returned error is not nil.
-func (s *googleService) ListZonesNames(project string) ([]string, error) {
+func (s *googleService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	zonesService := compute.NewZonesService(s.computeService)
 	listCall := zonesService.List(project)
 
@@ -95,9 +218,9 @@ func (s *googleService) ListZonesNames(project string) ([]string, error) {
 		}
 		return nil
 	}
-	err := DoRequestWithRetries(func() error {
+	err := DoRequestWithRetries(ctx, func() error {
 		zones = nil
-		return listCall.Pages(s.ctx, addZones)
+		return listCall.Pages(ctx, addZones)
 	})
 	return zones, err
 }
@@ -105,7 +228,7 @@ func (s *googleService) ListZonesNames(project string) ([]string, error) {
 // ListRegionsNames returns list of region names for the specified project.
 // Uses regions/list method from Compute API.
 // If the error occurred the returned error is not nil.
-func (s *googleService) ListRegionsNames(project string) ([]string, error) {
+func (s *googleService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	regionsService := compute.NewRegionsService(s.computeService)
 	listCall := regionsService.List(project)
 
@@ -116,22 +239,22 @@ func (s *googleService) ListRegionsNames(project string) ([]string, error) {
 		}
 		return nil
 	}
-	err := DoRequestWithRetries(func() error {
+	err := DoRequestWithRetries(ctx, func() error {
 		regions = nil
-		return listCall.Pages(s.ctx, addRegions)
+		return listCall.Pages(ctx, addRegions)
 	})
 	return regions, err
 }
 
 // ListLocations return the list of all locations per project(zones and regions).
 // Exactly one of returned values will be non-nil.
-func ListLocations(service GoogleService, project string) ([]string, error) {
-	zones, err := service.ListZonesNames(project)
+func ListLocations(ctx context.Context, service GoogleService, project string) ([]string, error) {
+	zones, err := service.ListZonesNames(ctx, project)
 	if err != nil {
 		return nil, err
 	}
 
-	regions, err := service.ListRegionsNames(project)
+	regions, err := service.ListRegionsNames(ctx, project)
 	if err != nil {
 		return nil, err
 	}
@@ -140,12 +263,6 @@ func ListLocations(service GoogleService, project string) ([]string, error) {
 	return locations, nil
 }
 
-var googleRecommenders = []string{
-	"google.compute.disk.IdleResourceRecommender",
-	"google.compute.instance.IdleResourceRecommender",
-	"google.compute.instance.MachineTypeRecommender",
-}
-
 type recommendationsResult struct {
 	recommendations []*gcloudRecommendation
 	err             error
@@ -173,12 +290,25 @@ func concatResults(results <-chan recommendationsResult, numberOfResults int) ([
 }
 
 // ListRecommendations returns the list of recommendations for a Cloud project from googleRecommenders.
+// Each recommender is queried only in the kinds of locations (zones, regions, global) it supports.
+// If filter is not empty, it's passed to Recommender API, for example "stateInfo.state = ACTIVE".
+// If ctx is canceled, the remaining queries are not made and the error of ctx is returned.
 // Requires the recommender.*.list IAM permissions for the recommenders.
 // numConcurrentCalls specifies the maximum number of concurrent calls to ListRecommendations method,
 // non-positive values are ignored, instead the default value is used.
 // task structure tracks the progress of the function.
-func ListRecommendations(service GoogleService, project string, numConcurrentCalls int, task *Task) ([]*gcloudRecommendation, error) {
-	locations, err := ListLocations(service, project)
+func ListRecommendations(ctx context.Context, service GoogleService, project, filter string, numConcurrentCalls int, task *Task) ([]*gcloudRecommendation, error) {
+	return listRecommendations(ctx, service, project, filter, googleRecommenders, numConcurrentCalls, task)
+}
+
+// listRecommendations works like ListRecommendations, but lists only recommendations of recommenders.
+func listRecommendations(ctx context.Context, service GoogleService, project, filter string, recommenders []*recommenderInfo, numConcurrentCalls int, task *Task) ([]*gcloudRecommendation, error) {
+	zones, err := service.ListZonesNames(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	regions, err := service.ListRegionsNames(ctx, project)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +324,14 @@ func ListRecommendations(service GoogleService, project string, numConcurrentCal
 		recommenderID string
 	}
 
-	numberOfQueries := len(locations) * len(googleRecommenders)
+	var allQueries []query
+	for _, recommender := range recommenders {
+		for _, location := range recommender.listLocations(zones, regions) {
+			allQueries = append(allQueries, query{location: location, recommenderID: recommender.id})
+		}
+	}
+
+	numberOfQueries := len(allQueries)
 	task.SetNumberOfSubtasks(numberOfQueries)
 
 	results := make(chan recommendationsResult, numberOfQueries)
@@ -203,17 +340,20 @@ func ListRecommendations(service GoogleService, project string, numConcurrentCal
 	for i := 0; i < numWorkers; i++ {
 		go func() {
 			for query := range queries {
-				recs, err := service.ListRecommendations(project, query.location, query.recommenderID)
+				// remaining queries are skipped, if the request has been canceled
+				if err := ctx.Err(); err != nil {
+					results <- recommendationsResult{nil, err}
+					continue
+				}
+				recs, err := service.ListRecommendations(ctx, project, query.location, query.recommenderID, filter)
 				results <- recommendationsResult{recs, err}
 				task.IncrementDone()
 			}
 		}()
 	}
 
-	for _, recommenderID := range googleRecommenders {
-		for _, location := range locations {
-			queries <- query{location: location, recommenderID: recommenderID}
-		}
+	for _, query := range allQueries {
+		queries <- query
 	}
 
 	close(queries)
@@ -228,19 +368,27 @@ func ListRecommendations(service GoogleService, project string, numConcurrentCal
 // ListResult contains information about listing recommendations for all projects.
 // If user doesn't have enough permissions for the project, the requirements, including failed ones, are listed in failedProjects.
 // Otherwise, recommendations for the project are appended to recommendations.
+// If only some recommenders can't be used in the project, recommendations of the others are listed
+// and the unsatisfied requirements of these recommenders are appended to FailedRecommenders.
+// Insights maps names of recommendations to their associated insights.
+// Priorities maps names of recommendations to their priorities, from P1, the highest, to P4.
 type ListResult struct {
-	Recommendations []*gcloudRecommendation
-	FailedProjects  []*ProjectRequirements
+	Recommendations    []*gcloudRecommendation     `json:"recommendations"`
+	FailedProjects     []*ProjectRequirements      `json:"failedProjects"`
+	FailedRecommenders []*RecommenderRequirements  `json:"failedRecommenders,omitempty"`
+	Insights           map[string][]*gcloudInsight `json:"insights,omitempty"`
+	Priorities         map[string]string           `json:"priorities,omitempty"`
 }
 
-// Lists requirements for the project, if all satisfied - lists recommendations.
-// Adds results to listResult.
+// Lists requirements for the project, if all satisfied - lists recommendations and their insights
+// of the recommenders, whose requirements are satisfied. Adds results to listResult.
 // Otherwise, adds project's requirements in FailedProjects field.
-func listRecommendationsIfRequirementsSatisfied(service GoogleService, project string, numConcurrentCalls int, listResult *ListResult, task *Task) error {
-	task.SetNumberOfSubtasks(2) // CheckRequirements and ListRecommendations
+// Unsatisfied requirements of recommenders are added in FailedRecommenders field.
+func listRecommendationsIfRequirementsSatisfied(ctx context.Context, service GoogleService, project, filter string, numConcurrentCalls int, listResult *ListResult, task *Task) error {
+	task.SetNumberOfSubtasks(3) // CheckRequirements, ListRecommendations and ListAssociatedInsights
 
 	task.GetNextSubtask()
-	projectRequirements, err := ListProjectRequirements(service, project)
+	projectRequirements, err := ListProjectRequirements(ctx, service, project)
 
 	if err != nil {
 		return err
@@ -248,33 +396,55 @@ func listRecommendationsIfRequirementsSatisfied(service GoogleService, project s
 
 	task.IncrementDone()
 
-	for _, req := range projectRequirements {
-		if !req.Satisfied {
-			listResult.FailedProjects = append(listResult.FailedProjects,
-				&ProjectRequirements{Project: project, Requirements: projectRequirements})
-			task.SetAllDone()
-			return nil
-		}
+	if !allSatisfied(projectRequirements) {
+		listResult.FailedProjects = append(listResult.FailedProjects,
+			&ProjectRequirements{Project: project, Requirements: projectRequirements})
+		task.SetAllDone()
+		return nil
+	}
+	recommenders, failedRecommenders, err := checkRecommenders(ctx, service, project)
+	if err != nil {
+		return err
 	}
-	newRecs, err := ListRecommendations(service, project, numConcurrentCalls, task.GetNextSubtask())
+	listResult.FailedRecommenders = append(listResult.FailedRecommenders, failedRecommenders...)
+	listCtx, priorities := withPriorities(ctx)
+	newRecs, err := listRecommendations(listCtx, service, project, filter, recommenders, numConcurrentCalls, task.GetNextSubtask())
+	if err != nil {
+		return err
+	}
+	task.IncrementDone()
+	insights, err := ListAssociatedInsights(ctx, service, newRecs, numConcurrentCalls, task.GetNextSubtask())
 	if err != nil {
 		return err
 	}
 	task.IncrementDone()
 	listResult.Recommendations = append(listResult.Recommendations, newRecs...)
+	for name, recInsights := range insights {
+		if listResult.Insights == nil {
+			listResult.Insights = make(map[string][]*gcloudInsight)
+		}
+		listResult.Insights[name] = recInsights
+	}
+	for name, priority := range priorities.get(newRecs) {
+		if listResult.Priorities == nil {
+			listResult.Priorities = make(map[string]string)
+		}
+		listResult.Priorities[name] = priority
+	}
 	return nil
 }
 
 // ListProjectsRecommendations gets recommendations for the specified projects.
 // If the user has enough permissions to apply and list recommendations, recommendations for project are listed.
 // Otherwise, projects requirements, including failed ones, are added to `failedProjects` to help show warnings to the user.
+// filter is passed to Recommender API, if it's not empty, to list only matching recommendations.
 // task structure tracks how many subtasks have been done already.
-func ListProjectsRecommendations(service GoogleService, projects []string, numConcurrentCalls int, task *Task) (*ListResult, error) {
+func ListProjectsRecommendations(ctx context.Context, service GoogleService, projects []string, filter string, numConcurrentCalls int, task *Task) (*ListResult, error) {
 	task.SetNumberOfSubtasks(len(projects)) // subtasks are calls to listRecommendationsIfRequirementsSatisfied for each project
 
 	var listResult ListResult
 	for _, project := range projects {
-		err := listRecommendationsIfRequirementsSatisfied(service, project, numConcurrentCalls, &listResult, task.GetNextSubtask())
+		err := listRecommendationsIfRequirementsSatisfied(ctx, service, project, filter, numConcurrentCalls, &listResult, task.GetNextSubtask())
 		if err != nil {
 			return nil, err
 		}
@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -33,6 +34,7 @@ type MockService struct {
 	zones                                 []string
 	regions                               []string
 	callsToList                           []query
+	filters                               []string
 }
 
 type query struct {
@@ -40,43 +42,44 @@ type query struct {
 	recommenderID string
 }
 
-func (s *MockService) ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error) {
+func (s *MockService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error) {
 	s.mutex.Lock()
 	s.numberOfTimesListRecommendationsCalls++
 	s.callsToList = append(s.callsToList, query{location, recommenderID})
+	s.filters = append(s.filters, filter)
 	s.mutex.Unlock()
 	return []*gcloudRecommendation{nil}, nil
 }
 
-func (s *MockService) ListZonesNames(project string) ([]string, error) {
+func (s *MockService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return s.zones, nil
 }
 
-func (s *MockService) ListRegionsNames(project string) ([]string, error) {
+func (s *MockService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return s.regions, nil
 }
 
-func makeQueries(locations []string) []query {
+func makeQueries(zones, regions []string) []query {
 	var queries []query
 	for _, rec := range googleRecommenders {
-		for _, loc := range locations {
-			queries = append(queries, query{loc, rec})
+		for _, loc := range rec.listLocations(zones, regions) {
+			queries = append(queries, query{loc, rec.id})
 		}
 	}
 	return queries
 }
 
 func TestListRecommendations(t *testing.T) {
+	ctx := context.Background()
 	for numConcurrentCalls := 0; numConcurrentCalls <= 7; numConcurrentCalls++ {
 		zones := []string{"zone1", "zone2", "zone3"}
 		regions := []string{"region1", "region2", "region3"}
 		mock := &MockService{zones: zones, regions: regions}
 		task := &Task{}
-		result, err := ListRecommendations(mock, "", numConcurrentCalls, task)
+		result, err := ListRecommendations(ctx, mock, "", "", numConcurrentCalls, task)
 
 		if assert.NoError(t, err, "Unexpected error from ListRecommendations") {
-			locations := append(mock.zones, mock.regions...)
-			queries := makeQueries(locations)
+			queries := makeQueries(mock.zones, mock.regions)
 			assert.Equal(t, len(queries), len(result), "One recommendation from each query was expected")
 			assert.Equal(t, len(queries), mock.numberOfTimesListRecommendationsCalls, "Wrong number of ListRecommendations calls")
 			assert.ElementsMatch(t, queries, mock.callsToList, "ListRecommendations was called for different locations and recommenders")
@@ -90,26 +93,39 @@ func TestListRecommendations(t *testing.T) {
 	}
 }
 
+func TestListRecommendationsFilter(t *testing.T) {
+	mock := &MockService{zones: []string{"zone1"}, regions: []string{"region1"}}
+	filter := "stateInfo.state = ACTIVE"
+	_, err := ListRecommendations(context.Background(), mock, "", filter, 2, &Task{})
+	if assert.NoError(t, err, "Unexpected error from ListRecommendations") {
+		assert.NotEmpty(t, mock.filters)
+		for _, f := range mock.filters {
+			assert.Equal(t, filter, f, "Filter should be passed to each call")
+		}
+	}
+}
+
 type ErrorZonesService struct {
 	GoogleService
 	err     error
 	regions []string
 }
 
-func (s *ErrorZonesService) ListZonesNames(project string) ([]string, error) {
+func (s *ErrorZonesService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return []string{}, s.err
 }
 
-func (s *ErrorZonesService) ListRegionsNames(project string) ([]string, error) {
+func (s *ErrorZonesService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return s.regions, nil
 }
 
 func TestErrorInListZones(t *testing.T) {
+	ctx := context.Background()
 	errorMessage := "error listing zones"
 	regions := []string{"region1", "region2", "region3"}
 
 	task := &Task{}
-	_, err := ListRecommendations(&ErrorZonesService{err: fmt.Errorf(errorMessage), regions: regions}, "", 2, task)
+	_, err := ListRecommendations(ctx, &ErrorZonesService{err: fmt.Errorf(errorMessage), regions: regions}, "", "", 2, task)
 	assert.EqualError(t, err, errorMessage, "Expected error calling ListZones")
 
 	done, all := task.GetProgress()
@@ -122,20 +138,21 @@ type ErrorRegionsService struct {
 	zones []string
 }
 
-func (s *ErrorRegionsService) ListZonesNames(project string) ([]string, error) {
+func (s *ErrorRegionsService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return s.zones, nil
 }
 
-func (s *ErrorRegionsService) ListRegionsNames(project string) ([]string, error) {
+func (s *ErrorRegionsService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return []string{}, s.err
 }
 
 func TestErrorInListRegions(t *testing.T) {
+	ctx := context.Background()
 	errorMessage := "error listing regions"
 	zones := []string{"zone1", "zone2", "zone3"}
 
 	task := &Task{}
-	_, err := ListRecommendations(&ErrorRegionsService{err: fmt.Errorf(errorMessage), zones: zones}, "", 2, task)
+	_, err := ListRecommendations(ctx, &ErrorRegionsService{err: fmt.Errorf(errorMessage), zones: zones}, "", "", 2, task)
 	assert.EqualError(t, err, errorMessage, "Expected error calling ListRegions")
 
 	done, all := task.GetProgress()
@@ -152,15 +169,15 @@ type ErrorRecommendationService struct {
 	regions             []string
 }
 
-func (s *ErrorRecommendationService) ListZonesNames(project string) ([]string, error) {
+func (s *ErrorRecommendationService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return s.zones, nil
 }
 
-func (s *ErrorRecommendationService) ListRegionsNames(project string) ([]string, error) {
+func (s *ErrorRecommendationService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return s.regions, nil
 }
 
-func (s *ErrorRecommendationService) ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error) {
+func (s *ErrorRecommendationService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error) {
 	s.mutex.Lock()
 	s.numberOfTimesCalled++
 	s.mutex.Unlock()
@@ -172,6 +189,7 @@ func (s *ErrorRecommendationService) ListRecommendations(project, location, reco
 }
 
 func TestErrorInRecommendations(t *testing.T) {
+	ctx := context.Background()
 	errorMessage := "error listing recommendations"
 	zones := []string{}
 	for i := 1; i <= 5; i++ {
@@ -184,6 +202,7 @@ func TestErrorInRecommendations(t *testing.T) {
 	}
 
 	locations := append(zones, regions...)
+	numQueries := len(makeQueries(zones, regions))
 
 	for _, location := range locations {
 		for numConcurrentCalls := 1; numConcurrentCalls <= 10; numConcurrentCalls++ {
@@ -195,9 +214,8 @@ func TestErrorInRecommendations(t *testing.T) {
 			}
 
 			task := &Task{}
-			_, err := ListRecommendations(service, "", numConcurrentCalls, task)
+			_, err := ListRecommendations(ctx, service, "", "", numConcurrentCalls, task)
 			assert.EqualError(t, err, errorMessage, "Expected error calling ListRecommendations")
-			numQueries := len(locations) * len(googleRecommenders)
 			assert.Equal(t, numQueries, service.numberOfTimesCalled, "ListRecommendations called wrong number of times")
 
 			done, all := task.GetProgress()
@@ -210,12 +228,12 @@ type BenchmarkService struct {
 	GoogleService
 }
 
-func (s *BenchmarkService) ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error) {
+func (s *BenchmarkService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error) {
 	time.Sleep(time.Millisecond * 100)
 	return []*gcloudRecommendation{}, nil
 }
 
-func (s *BenchmarkService) ListZonesNames(project string) ([]string, error) {
+func (s *BenchmarkService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	zones := []string{}
 	for i := 0; i < 100; i++ {
 		zones = append(zones, fmt.Sprintf("zone %d", i))
@@ -223,7 +241,7 @@ func (s *BenchmarkService) ListZonesNames(project string) ([]string, error) {
 	return zones, nil
 }
 
-func (s *BenchmarkService) ListRegionsNames(project string) ([]string, error) {
+func (s *BenchmarkService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	regions := []string{}
 	for i := 0; i < 25; i++ {
 		regions = append(regions, fmt.Sprintf("region %d", i))
@@ -232,10 +250,11 @@ func (s *BenchmarkService) ListRegionsNames(project string) ([]string, error) {
 }
 
 func BenchmarkGoroutines(b *testing.B) {
+	ctx := context.Background()
 	for _, numConcurrentCalls := range []int{4, 8, 16, 32, 64, 128} {
 		b.Run(fmt.Sprintf("%d goroutines:", numConcurrentCalls), func(b *testing.B) {
 			s := &BenchmarkService{}
-			ListRecommendations(s, "", numConcurrentCalls, &Task{})
+			ListRecommendations(ctx, s, "", "", numConcurrentCalls, &Task{})
 		})
 	}
 }
@@ -251,18 +270,21 @@ type MockProjectsService struct {
 	numberOfListRecommendationsCalls int
 	apiCalls                         []string
 	permissionCalls                  []string
-	mutex                            sync.Mutex
+	// permissions and APIs, whose requirements aren't satisfied in all projects
+	deniedPermission string
+	disabledAPI      string
+	mutex            sync.Mutex
 }
 
-func (s *MockProjectsService) ListZonesNames(project string) ([]string, error) {
+func (s *MockProjectsService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return []string{"one zone"}, nil
 }
 
-func (s *MockProjectsService) ListRegionsNames(project string) ([]string, error) {
+func (s *MockProjectsService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return nil, nil
 }
 
-func (s *MockProjectsService) ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error) {
+func (s *MockProjectsService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error) {
 	s.mutex.Lock()
 	s.numberOfListRecommendationsCalls++
 	s.queries = append(s.queries, projectRecommender{project, recommenderID})
@@ -270,39 +292,60 @@ func (s *MockProjectsService) ListRecommendations(project, location, recommender
 	return []*gcloudRecommendation{nil}, nil
 }
 
-func makeProjectsQueries(projects []string) []projectRecommender {
+func isSkipped(skipped []string, recommenderID string) bool {
+	for _, id := range skipped {
+		if id == recommenderID {
+			return true
+		}
+	}
+	return false
+}
+
+func makeProjectsQueries(projects []string, skipped ...string) []projectRecommender {
 	var result []projectRecommender
 	for _, pr := range projects {
 		for _, rec := range googleRecommenders {
-			result = append(result, projectRecommender{pr, rec})
+			if isSkipped(skipped, rec.id) {
+				continue
+			}
+			for range rec.listLocations([]string{"one zone"}, nil) {
+				result = append(result, projectRecommender{pr, rec.id})
+			}
 		}
 	}
 	return result
 }
 
-var okRequirements = []*Requirement{&Requirement{Satisfied: true}}
-
-func (s *MockProjectsService) ListAPIRequirements(project string, apis []string) ([]*Requirement, error) {
+func (s *MockProjectsService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.apiCalls = append(s.apiCalls, project)
 	if project == failedProject {
 		return failedRequirements, nil
 	}
-	return okRequirements, nil
+	result := []*Requirement{}
+	for _, api := range apis {
+		result = append(result, &Requirement{Name: api, Satisfied: api != s.disabledAPI})
+	}
+	return result, nil
 }
 
-func (s *MockProjectsService) ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error) {
+func (s *MockProjectsService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.permissionCalls = append(s.permissionCalls, project)
 	if project == failedProject {
 		return failedRequirements, nil
 	}
-	return okRequirements, nil
+	result := []*Requirement{}
+	for _, group := range permissions {
+		result = append(result, &Requirement{Name: group[0], Satisfied: group[0] != s.deniedPermission})
+	}
+	return result, nil
 }
 
 func TestListProjectsRecommendations(t *testing.T) {
+	ctx := context.Background()
 	for numConcurrentCalls := 0; numConcurrentCalls < 10; numConcurrentCalls++ {
 		for numProjects := 0; numProjects < 5; numProjects++ {
 			for numFailed := 0; numFailed <= numProjects; numFailed++ {
@@ -317,7 +360,7 @@ func TestListProjectsRecommendations(t *testing.T) {
 				projects := append(okProjects, failedProjects...)
 				task := &Task{}
 				mock := &MockProjectsService{}
-				res, err := ListProjectsRecommendations(mock, projects, numConcurrentCalls, task)
+				res, err := ListProjectsRecommendations(ctx, mock, projects, "", numConcurrentCalls, task)
 				if assert.NoError(t, err) {
 					done, all := task.GetProgress()
 					assert.True(t, done == all, "Task List all recommendations should be finished already")
@@ -329,8 +372,10 @@ func TestListProjectsRecommendations(t *testing.T) {
 					assert.Equal(t, len(queries), mock.numberOfListRecommendationsCalls, "List recommendations called wrong number of times")
 					assert.ElementsMatch(t, queries, mock.queries, "List Recommendations was called with wrong parameters")
 
-					assert.ElementsMatch(t, okProjects, mock.apiCalls, "List api requirements was called for different projects")
-					assert.ElementsMatch(t, projects, mock.permissionCalls, "List permission requirements was called for different projects")
+					// requirements of ok projects are checked again for recommenders, APIs of recommenders are checked once
+					assert.ElementsMatch(t, append(okProjects, okProjects...), mock.apiCalls, "List api requirements was called for different projects")
+					assert.ElementsMatch(t, append(projects, okProjects...), mock.permissionCalls, "List permission requirements was called for different projects")
+					assert.Empty(t, res.FailedRecommenders, "No recommender should fail")
 
 					assert.Equal(t, len(queries), len(res.Recommendations), "Wrong number of overall recommendations")
 					var failedProjectsRequirements []*ProjectRequirements
@@ -344,6 +389,37 @@ func TestListProjectsRecommendations(t *testing.T) {
 	}
 }
 
+func TestListProjectsRecommendationsMissingPermission(t *testing.T) {
+	mock := &MockProjectsService{deniedPermission: "recommender.cloudsqlIdleInstanceRecommendations.list"}
+	projects := []string{"project 0", "project 1"}
+	res, err := ListProjectsRecommendations(context.Background(), mock, projects, "", 2, &Task{})
+	if assert.NoError(t, err) {
+		queries := makeProjectsQueries(projects, "google.cloudsql.instance.IdleRecommender")
+		assert.ElementsMatch(t, queries, mock.queries, "Recommendations should be listed only for recommenders with permissions")
+		assert.Empty(t, res.FailedProjects, "Projects should not fail")
+		denied := &Requirement{Name: mock.deniedPermission, Satisfied: false}
+		assert.ElementsMatch(t, []*RecommenderRequirements{
+			{Project: "project 0", Recommender: "google.cloudsql.instance.IdleRecommender", Requirements: []*Requirement{denied}},
+			{Project: "project 1", Recommender: "google.cloudsql.instance.IdleRecommender", Requirements: []*Requirement{denied}},
+		}, res.FailedRecommenders, "Missing permission should be reported for the recommender")
+	}
+}
+
+func TestListProjectsRecommendationsDisabledAPI(t *testing.T) {
+	mock := &MockProjectsService{disabledAPI: "sqladmin.googleapis.com"}
+	projects := []string{"project 0"}
+	res, err := ListProjectsRecommendations(context.Background(), mock, projects, "", 2, &Task{})
+	if assert.NoError(t, err) {
+		queries := makeProjectsQueries(projects, "google.cloudsql.instance.IdleRecommender", "google.cloudsql.instance.OverprovisionedRecommender")
+		assert.ElementsMatch(t, queries, mock.queries, "Recommendations should be listed only for recommenders with enabled APIs")
+		disabled := &Requirement{Name: mock.disabledAPI, Satisfied: false}
+		assert.ElementsMatch(t, []*RecommenderRequirements{
+			{Project: "project 0", Recommender: "google.cloudsql.instance.IdleRecommender", Requirements: []*Requirement{disabled}},
+			{Project: "project 0", Recommender: "google.cloudsql.instance.OverprovisionedRecommender", Requirements: []*Requirement{disabled}},
+		}, res.FailedRecommenders, "Disabled API should be reported for the recommenders")
+	}
+}
+
 func TestNilError(t *testing.T) {
 	var err error
 	assert.False(t, isInvalidArgumentError(err), "Nil error is not of *googleapi.Error type")
@@ -387,3 +463,10 @@ func TestErrorWrongStatus(t *testing.T) {
 	`}
 	assert.False(t, isInvalidArgumentError(err), "Should return false, expected INVALID_ARGUMENT status")
 }
+
+func TestErrorInvalidFilter(t *testing.T) {
+	err := &googleapi.Error{Code: 400, Message: "Invalid filter: stateInfo.state = UNKNOWN"}
+	assert.True(t, isInvalidFilterError(err), "Should return true")
+	err = &googleapi.Error{Code: 400, Message: "Location not available"}
+	assert.False(t, isInvalidFilterError(err), "Should return false, message doesn't mention the filter")
+}
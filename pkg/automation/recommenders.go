@@ -0,0 +1,149 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import "strings"
+
+// locationType is a set of kinds of locations, in which a recommender
+// generates recommendations.
+type locationType int
+
+const (
+	zonal locationType = 1 << iota
+	regional
+	global
+)
+
+const globalLocation = "global"
+
+// recommenderInfo describes a recommender supported by Recomator.
+type recommenderInfo struct {
+	id string
+	// prefix of the IAM permissions for recommendations of this recommender,
+	// for example "recommender.computeDiskIdleResourceRecommendations".
+	permissionPrefix string
+	locations        locationType
+	// keys of the operation handlers needed to apply the recommendations.
+	operations []operationKey
+	// APIs needed besides requiredAPIs to apply the recommendations,
+	// for example "sqladmin.googleapis.com".
+	apis []string
+}
+
+// googleRecommenders is the registry of supported recommenders.
+// To support a new recommender, add it here together with the handlers of its operations,
+// the permissions and APIs checked for each recommender are computed from this list.
+var googleRecommenders = []*recommenderInfo{
+	{
+		id:               "google.compute.disk.IdleResourceRecommender",
+		permissionPrefix: "recommender.computeDiskIdleResourceRecommendations",
+		locations:        zonal | regional,
+		operations:       []operationKey{addSnapshotKey, removeDiskKey},
+	},
+	{
+		id:               "google.compute.instance.IdleResourceRecommender",
+		permissionPrefix: "recommender.computeInstanceIdleResourceRecommendations",
+		locations:        zonal,
+		operations:       []operationKey{testInstanceKey, replaceInstanceStatusKey},
+	},
+	{
+		id:               "google.compute.instance.MachineTypeRecommender",
+		permissionPrefix: "recommender.computeInstanceMachineTypeRecommendations",
+		locations:        zonal,
+		operations:       []operationKey{testInstanceKey, replaceMachineTypeKey},
+	},
+	{
+		id:               "google.compute.address.IdleResourceRecommender",
+		permissionPrefix: "recommender.computeAddressIdleResourceRecommendations",
+		locations:        regional | global,
+		operations:       []operationKey{removeAddressKey},
+	},
+	{
+		id:               "google.compute.image.IdleResourceRecommender",
+		permissionPrefix: "recommender.computeImageIdleResourceRecommendations",
+		locations:        global,
+		operations:       []operationKey{removeImageKey},
+	},
+	{
+		id:               "google.compute.instanceGroupManager.MachineTypeRecommender",
+		permissionPrefix: "recommender.computeInstanceGroupManagerMachineTypeRecommendations",
+		locations:        zonal | regional,
+		operations:       []operationKey{copyInstanceTemplateKey, replaceTemplateMachineTypeKey, replaceGroupTemplateKey},
+	},
+	{
+		id:               "google.cloudsql.instance.IdleRecommender",
+		permissionPrefix: "recommender.cloudsqlIdleInstanceRecommendations",
+		locations:        regional,
+		operations:       []operationKey{replaceSQLActivationPolicyKey},
+		apis:             []string{"sqladmin.googleapis.com"},
+	},
+	{
+		id:               "google.cloudsql.instance.OverprovisionedRecommender",
+		permissionPrefix: "recommender.cloudsqlOverprovisionedInstanceRecommendations",
+		locations:        regional,
+		operations:       []operationKey{replaceSQLTierKey},
+		apis:             []string{"sqladmin.googleapis.com"},
+	},
+}
+
+// listLocations returns the locations from zones and regions,
+// in which the recommender generates recommendations.
+func (r *recommenderInfo) listLocations(zones, regions []string) []string {
+	var locations []string
+	if r.locations&zonal != 0 {
+		locations = append(locations, zones...)
+	}
+	if r.locations&regional != 0 {
+		locations = append(locations, regions...)
+	}
+	if r.locations&global != 0 {
+		locations = append(locations, globalLocation)
+	}
+	return locations
+}
+
+// permissions returns the permissions needed to list, get and apply recommendations.
+func (r *recommenderInfo) permissions() [][]string {
+	permissions := [][]string{
+		{r.permissionPrefix + ".list"},   // ListRecommendations
+		{r.permissionPrefix + ".get"},    // GetRecommendation
+		{r.permissionPrefix + ".update"}, // MarkClaimed/Failed/Succeeded/Dismissed/Active
+	}
+	for _, key := range r.operations {
+		if handler, ok := operationHandlers[key]; ok {
+			permissions = append(permissions, handler.permissions...)
+		}
+	}
+	return permissions
+}
+
+// recommendersPermissions returns permissions needed for all recommenders in googleRecommenders.
+// Each group of alternative permissions is listed once.
+func recommendersPermissions() [][]string {
+	var result [][]string
+	added := make(map[string]bool)
+	for _, recommender := range googleRecommenders {
+		for _, group := range recommender.permissions() {
+			key := strings.Join(group, ",")
+			if !added[key] {
+				added[key] = true
+				result = append(result, group)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+// Checks that every recommender has operations and every operation listed for it has a handler.
+func TestRecommendersOperationsHaveHandlers(t *testing.T) {
+	for _, recommender := range googleRecommenders {
+		assert.NotEmpty(t, recommender.operations, "No operations of %s", recommender.id)
+		for _, key := range recommender.operations {
+			_, ok := operationHandlers[key]
+			assert.True(t, ok, "No handler for operation %v of %s", key, recommender.id)
+		}
+	}
+}
+
+func TestListLocations(t *testing.T) {
+	zones := []string{"zone1", "zone2"}
+	regions := []string{"region1"}
+	recommender := &recommenderInfo{locations: zonal}
+	assert.Equal(t, zones, recommender.listLocations(zones, regions))
+	recommender = &recommenderInfo{locations: regional | global}
+	assert.Equal(t, []string{"region1", globalLocation}, recommender.listLocations(zones, regions))
+}
+
+// Checks that permissions of all recommenders are required and none is listed twice.
+func TestRecommendersPermissions(t *testing.T) {
+	permissions := recommendersPermissions()
+	listed := make(map[string]bool)
+	for _, group := range permissions {
+		key := strings.Join(group, ",")
+		assert.False(t, listed[key], "Permission %s listed twice", key)
+		listed[key] = true
+	}
+
+	for _, recommender := range googleRecommenders {
+		assert.True(t, listed[recommender.permissionPrefix+".list"], "List permission of %s should be required", recommender.id)
+	}
+	assert.True(t, listed["cloudsql.instances.update"], "Permissions of operation handlers should be required")
+}
+
+// Checks that handlers for a specific path are preferred and unknown operations are not supported.
+func TestFindOperationHandler(t *testing.T) {
+	ctx := context.Background()
+	handler, ok := findOperationHandler(&gcloudOperation{Action: "Replace", ResourceType: instanceType, Path: "/machineType"})
+	if assert.True(t, ok) {
+		assert.Equal(t, operationHandlers[replaceMachineTypeKey], handler)
+	}
+	handler, ok = findOperationHandler(&gcloudOperation{Action: "add", ResourceType: snapshotType, Path: "/"})
+	if assert.True(t, ok) {
+		assert.Equal(t, operationHandlers[addSnapshotKey], handler)
+	}
+	_, ok = findOperationHandler(&gcloudOperation{Action: "replace", ResourceType: instanceType, Path: "/labels"})
+	assert.False(t, ok)
+
+	_, err := DoOperation(ctx, &ApplyMockService{}, &gcloudOperation{Action: "move", ResourceType: diskType}, nil)
+	assert.EqualError(t, err, operationNotSupportedMessage)
+}
+
+type SQLMockService struct {
+	ApplyMockService
+	tier string
+}
+
+func (s *SQLMockService) GetSQLInstance(ctx context.Context, project, instance string) (*sqladmin.DatabaseInstance, error) {
+	s.calledFunctions = append(s.calledFunctions, calledFunction{"GetSQLInstance", []interface{}{project, instance}, nil})
+	return &sqladmin.DatabaseInstance{Settings: &sqladmin.Settings{Tier: s.tier, ActivationPolicy: "ALWAYS"}}, nil
+}
+
+func (s *SQLMockService) ChangeSQLTier(ctx context.Context, project, instance, tier string) error {
+	s.calledFunctions = append(s.calledFunctions, calledFunction{"ChangeSQLTier", []interface{}{project, instance, tier}, nil})
+	return nil
+}
+
+func (s *SQLMockService) SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error {
+	s.calledFunctions = append(s.calledFunctions, calledFunction{"SetSQLActivationPolicy", []interface{}{project, instance, policy}, nil})
+	return nil
+}
+
+// Checks that Cloud SQL operations change the settings and register restoring the previous ones.
+func TestSQLOperations(t *testing.T) {
+	ctx := context.Background()
+	resource := "//sqladmin.googleapis.com/projects/rightsizer-test/instances/sql-test"
+	service := &SQLMockService{tier: "db-n1-standard-4"}
+	rollback := &Rollback{}
+
+	_, err := DoOperation(ctx, service, &gcloudOperation{
+		Action:       "replace",
+		Path:         "/settings/tier",
+		Resource:     resource,
+		ResourceType: sqlInstanceType,
+		Value:        "db-n1-standard-1",
+	}, rollback)
+	assert.NoError(t, err)
+	_, err = DoOperation(ctx, service, &gcloudOperation{
+		Action:       "replace",
+		Path:         "/settings/activationPolicy",
+		Resource:     resource,
+		ResourceType: sqlInstanceType,
+		Value:        "NEVER",
+	}, rollback)
+	assert.NoError(t, err)
+
+	expected := []string{"GetSQLInstance", "ChangeSQLTier", "GetSQLInstance", "SetSQLActivationPolicy"}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+	assert.Equal(t, []interface{}{"rightsizer-test", "sql-test", "db-n1-standard-1"}, service.calledFunctions[1].arguments)
+
+	result := rollback.Run()
+	if assert.NotNil(t, result) {
+		assert.True(t, result.Succeeded)
+	}
+	assert.Equal(t, []interface{}{"rightsizer-test", "sql-test", "ALWAYS"}, service.calledFunctions[4].arguments)
+	assert.Equal(t, []interface{}{"rightsizer-test", "sql-test", "db-n1-standard-4"}, service.calledFunctions[5].arguments)
+}
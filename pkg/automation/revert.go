@@ -0,0 +1,250 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// checkRevertible returns an error, if the change described by the result can't be reverted,
+// for example releasing an address, as another one would be reserved.
+func checkRevertible(result *OperationResult) error {
+	switch result.Action {
+	case ActionCreateSnapshot, ActionStopInstance, ActionCreateInstanceTemplate:
+		return nil
+	case ActionDeleteDisk:
+		if result.SnapshotName == "" {
+			return fmt.Errorf("disk %s was deleted without a snapshot, it can't be restored", result.Resource)
+		}
+		return nil
+	case ActionSetMachineType, ActionSetSQLActivationPolicy, ActionSetSQLTier, ActionSetInstanceTemplate:
+		if result.PreviousValue == "" {
+			return fmt.Errorf("the previous value of %s is unknown, it can't be restored", result.Resource)
+		}
+		return nil
+	case ActionReleaseAddress:
+		return fmt.Errorf("released address %s can't be restored", result.Resource)
+	case ActionDeleteImage:
+		return fmt.Errorf("deleted image %s can't be restored", result.Resource)
+	}
+	return fmt.Errorf("%s of %s can't be reverted", result.Action, result.Resource)
+}
+
+// restoreDisk creates the disk deleted by ActionDeleteDisk from its snapshot,
+// with the same type, size and labels.
+func restoreDisk(ctx context.Context, service GoogleService, result *OperationResult) (*OperationResult, error) {
+	disk := &compute.Disk{
+		Name:           result.Resource,
+		SourceSnapshot: result.SnapshotSelfLink,
+		SizeGb:         result.DiskSizeGb,
+		Labels:         result.Labels,
+	}
+	if disk.SourceSnapshot == "" {
+		disk.SourceSnapshot = fmt.Sprintf("projects/%s/global/snapshots/%s", result.Project, result.SnapshotName)
+	}
+	if result.DiskType != "" {
+		disk.Type = fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", result.Project, result.Location, result.DiskType)
+	}
+	if err := service.CreateDisk(ctx, result.Project, result.Location, disk); err != nil {
+		return nil, err
+	}
+	return &OperationResult{
+		Action:           ActionCreateDisk,
+		Project:          result.Project,
+		Location:         result.Location,
+		Resource:         result.Resource,
+		SnapshotName:     result.SnapshotName,
+		SnapshotSelfLink: result.SnapshotSelfLink,
+		DiskType:         result.DiskType,
+		DiskSizeGb:       result.DiskSizeGb,
+		Labels:           result.Labels,
+	}, nil
+}
+
+// restoreMachineType sets the previous machine type of the instance.
+// The instance is stopped for the change, and started again if it was running.
+// Nothing is changed, if the instance already has the previous machine type.
+func restoreMachineType(ctx context.Context, service GoogleService, result *OperationResult) (*OperationResult, error) {
+	project, zone, instance := result.Project, result.Location, result.Resource
+	machineInstance, err := service.GetInstance(ctx, project, zone, instance)
+	if err != nil {
+		return nil, err
+	}
+	if machineType, err := extractFromURL(machineInstance.MachineType, machineTypeParam); err == nil && machineType == result.PreviousValue {
+		return nil, nil
+	}
+
+	// The instance must not be left stopped, so the rest isn't canceled.
+	ctx = withoutCancel(ctx)
+	if err := service.StopInstance(ctx, project, zone, instance); err != nil {
+		return nil, err
+	}
+	if err := service.ChangeMachineType(ctx, project, zone, instance, result.PreviousValue); err != nil {
+		return nil, err
+	}
+	restored := &OperationResult{
+		Action:        ActionSetMachineType,
+		Project:       project,
+		Location:      zone,
+		Resource:      instance,
+		Value:         result.PreviousValue,
+		PreviousValue: result.Value,
+	}
+	if machineInstance.Status == "RUNNING" {
+		if err := service.StartInstance(ctx, project, zone, instance); err != nil {
+			return nil, err
+		}
+		restored.StoppedInstances = []string{instance}
+	}
+	return restored, nil
+}
+
+// restoreGroupTemplate sets the previous instance template of the managed instance group.
+// Nothing is changed, if the group already has the previous template.
+func restoreGroupTemplate(ctx context.Context, service GoogleService, result *OperationResult) (*OperationResult, error) {
+	project, location, manager := result.Project, result.Location, result.Resource
+	group, err := service.GetInstanceGroupManager(ctx, project, location, manager)
+	if err != nil {
+		return nil, err
+	}
+	if lastPathSegment(group.InstanceTemplate) == result.PreviousValue {
+		return nil, nil
+	}
+
+	if err := service.SetInstanceGroupManagerTemplate(ctx, project, location, manager, templateURL(project, result.PreviousValue)); err != nil {
+		return nil, err
+	}
+	return &OperationResult{
+		Action:        ActionSetInstanceTemplate,
+		Project:       project,
+		Location:      location,
+		Resource:      manager,
+		Value:         result.PreviousValue,
+		PreviousValue: result.Value,
+	}, nil
+}
+
+// revertResult reverts the change described by the result, that passed checkRevertible.
+// Returns the result of the reverting change, or nil if nothing has been changed.
+func revertResult(ctx context.Context, service GoogleService, result *OperationResult) (*OperationResult, error) {
+	switch result.Action {
+	case ActionDeleteDisk:
+		return restoreDisk(ctx, service, result)
+	case ActionSetMachineType:
+		return restoreMachineType(ctx, service, result)
+	case ActionSetInstanceTemplate:
+		return restoreGroupTemplate(ctx, service, result)
+	case ActionStopInstance:
+		// the instance wasn't running before it was stopped
+		if len(result.StoppedInstances) == 0 {
+			return nil, nil
+		}
+		if err := service.StartInstance(ctx, result.Project, result.Location, result.Resource); err != nil {
+			return nil, err
+		}
+		return &OperationResult{Action: ActionStartInstance, Project: result.Project, Location: result.Location, Resource: result.Resource}, nil
+	case ActionSetSQLActivationPolicy, ActionSetSQLTier:
+		set := service.SetSQLActivationPolicy
+		if result.Action == ActionSetSQLTier {
+			set = service.ChangeSQLTier
+		}
+		if err := set(ctx, result.Project, result.Resource, result.PreviousValue); err != nil {
+			return nil, err
+		}
+		return &OperationResult{
+			Action:        result.Action,
+			Project:       result.Project,
+			Resource:      result.Resource,
+			Value:         result.PreviousValue,
+			PreviousValue: result.Value,
+		}, nil
+	}
+	// the snapshot is kept, the disk is restored from it, and the created instance template is kept
+	return nil, nil
+}
+
+// RevertError is returned by Revert, if reverting failed after it had started.
+// Remaining are the results passed to Revert, whose changes haven't been reverted,
+// in the same order, including the one, reverting which failed.
+type RevertError struct {
+	Err       error
+	Remaining []*OperationResult
+}
+
+func (e *RevertError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that made reverting fail.
+func (e *RevertError) Unwrap() error {
+	return e.Err
+}
+
+// UnrevertedResults returns the results passed to Revert, whose changes haven't been reverted,
+// given the error returned by Revert. Retrying Revert with them doesn't repeat the reverted changes.
+func UnrevertedResults(applied []*OperationResult, err error) []*OperationResult {
+	if err == nil {
+		return nil
+	}
+	var revertErr *RevertError
+	if errors.As(err, &revertErr) {
+		return revertErr.Remaining
+	}
+	return applied
+}
+
+// Revert reverts the changes made by applying the recommendation with the given name,
+// described by results returned by Apply. The changes are reverted in reverse order:
+// deleted disks are created from their snapshots with the same type, size and labels,
+// previous machine types and Cloud SQL settings are restored and stopped instances are started.
+// Created snapshots are kept. If one of the changes can't be reverted, for example
+// a released address, an error is returned before anything is changed.
+// Returns the results of the reverting changes, also if reverting failed after some of them,
+// then *RevertError with the changes left to revert is returned.
+// Canceling ctx stops reverting after the current change.
+// The state of the recommendation isn't changed.
+func Revert(ctx context.Context, service GoogleService, recommendationName string, results []*OperationResult, task *Task) ([]*OperationResult, error) {
+	for _, result := range results {
+		if err := checkRevertible(result); err != nil {
+			return nil, err
+		}
+	}
+	ctx = context.WithValue(ctx, appliedRecommendationKey{}, appliedRecommendation{name: recommendationName})
+
+	task.SetNumberOfSubtasks(len(results))
+	var reverted []*OperationResult
+	for i := len(results) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return reverted, &RevertError{Err: err, Remaining: results[:i+1]}
+		}
+		_ = task.GetNextSubtask()
+		result, err := revertResult(ctx, service, results[i])
+		if err != nil {
+			return reverted, &RevertError{Err: err, Remaining: results[:i+1]}
+		}
+		if result != nil {
+			reverted = append(reverted, result)
+		}
+		task.IncrementDone()
+	}
+	task.SetAllDone()
+	return reverted, nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestRevert(t *testing.T) {
+	applied := []*OperationResult{
+		{Action: ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "idle-vm", StoppedInstances: []string{"idle-vm"}},
+		{Action: ActionSetMachineType, Project: "project", Location: "us-east1-b", Resource: "vm", Value: "e2-small", PreviousValue: "n1-standard-4"},
+		{Action: ActionCreateSnapshot, Project: "project", Location: "us-east1-b", Resource: "disk", SnapshotName: "snap"},
+		{
+			Action:           ActionDeleteDisk,
+			Project:          "project",
+			Location:         "us-east1-b",
+			Resource:         "disk",
+			SnapshotName:     "snap",
+			SnapshotSelfLink: "https://www.googleapis.com/compute/v1/projects/project/global/snapshots/snap",
+			DiskType:         "pd-ssd",
+			DiskSizeGb:       100,
+			Labels:           map[string]string{"team": "web"},
+		},
+	}
+	service := &ApplyMockService{getInstanceResult: &compute.Instance{Status: "RUNNING"}}
+	task := &Task{}
+	results, err := Revert(context.Background(), service, "r1", applied, task)
+	if !assert.NoError(t, err, "Revert shouldn't return an error") {
+		return
+	}
+
+	restoredDisk := &compute.Disk{
+		Name:           "disk",
+		SourceSnapshot: "https://www.googleapis.com/compute/v1/projects/project/global/snapshots/snap",
+		Type:           "projects/project/zones/us-east1-b/diskTypes/pd-ssd",
+		SizeGb:         100,
+		Labels:         map[string]string{"team": "web"},
+	}
+	expectedFunctions := []string{"CreateDisk", "GetInstance", "StopInstance", "ChangeMachineType", "StartInstance", "StartInstance"}
+	expectedArguments := [][]interface{}{
+		{"project", "us-east1-b", restoredDisk},
+		{"project", "us-east1-b", "vm"},
+		{"project", "us-east1-b", "vm"},
+		{"project", "us-east1-b", "vm", "n1-standard-4"},
+		{"project", "us-east1-b", "vm"},
+		{"project", "us-east1-b", "idle-vm"},
+	}
+	expectedResults := [][]interface{}{{nil}, {service.getInstanceResult, nil}, {nil}, {nil}, {nil}, {nil}}
+	expected := newCalledFunctions(expectedFunctions, expectedArguments, expectedResults)
+	assert.Equal(t, expected, service.calledFunctions, "Changes should be reverted in reverse order")
+
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, ActionCreateDisk, results[0].Action)
+		assert.Equal(t, "snap", results[0].SnapshotName)
+		assert.Equal(t, ActionSetMachineType, results[1].Action)
+		assert.Equal(t, "n1-standard-4", results[1].Value)
+		assert.Equal(t, "e2-small", results[1].PreviousValue)
+		assert.Equal(t, ActionStartInstance, results[2].Action)
+	}
+	done, all := task.GetProgress()
+	assert.Equal(t, all, done, "Task should be done")
+}
+
+// Checks that the machine type isn't changed again, if it has been restored already.
+func TestRevertRestoredMachineType(t *testing.T) {
+	applied := []*OperationResult{
+		{Action: ActionSetMachineType, Project: "project", Location: "us-east1-b", Resource: "vm", Value: "e2-small", PreviousValue: "n1-standard-4"},
+	}
+	service := &ApplyMockService{getInstanceResult: &compute.Instance{
+		Status:      "RUNNING",
+		MachineType: "https://www.googleapis.com/compute/v1/projects/project/zones/us-east1-b/machineTypes/n1-standard-4",
+	}}
+	results, err := Revert(context.Background(), service, "r1", applied, &Task{})
+	if assert.NoError(t, err, "Revert shouldn't return an error") {
+		assert.Empty(t, results, "Nothing should be changed")
+		if assert.Len(t, service.calledFunctions, 1) {
+			assert.Equal(t, "GetInstance", service.calledFunctions[0].functionName)
+		}
+	}
+}
+
+// Checks that an instance, which wasn't running before it was stopped, isn't started by revert.
+func TestRevertNotRunningInstance(t *testing.T) {
+	applied := []*OperationResult{
+		{Action: ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "vm"},
+	}
+	service := &ApplyMockService{}
+	results, err := Revert(context.Background(), service, "r1", applied, &Task{})
+	if assert.NoError(t, err, "Revert shouldn't return an error") {
+		assert.Empty(t, results, "Nothing should be changed")
+		assert.Empty(t, service.calledFunctions, "The instance shouldn't be started")
+	}
+}
+
+func TestRevertIrreversible(t *testing.T) {
+	testCases := []*OperationResult{
+		{Action: ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "vm"},
+		{Action: ActionReleaseAddress, Project: "project", Location: "us-east1", Resource: "address"},
+		{Action: ActionDeleteImage, Project: "project", Resource: "image"},
+		{Action: ActionDeleteDisk, Project: "project", Location: "us-east1-b", Resource: "disk"},
+		{Action: ActionSetMachineType, Project: "project", Location: "us-east1-b", Resource: "vm", Value: "e2-small"},
+	}
+	for _, irreversible := range testCases[1:] {
+		service := &ApplyMockService{}
+		_, err := Revert(context.Background(), service, "r1", []*OperationResult{testCases[0], irreversible}, &Task{})
+		assert.Error(t, err, "%s without the previous state can't be reverted", irreversible.Action)
+		assert.Empty(t, service.calledFunctions, "Nothing should be changed, if a change can't be reverted")
+	}
+}
+
+type failedStartService struct {
+	ApplyMockService
+}
+
+func (s *failedStartService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
+	return errors.New("instance not found")
+}
+
+// Checks that the changes left to revert are returned, if reverting failed after some of them.
+func TestRevertFailed(t *testing.T) {
+	applied := []*OperationResult{
+		{Action: ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "idle-vm", StoppedInstances: []string{"idle-vm"}},
+		{Action: ActionDeleteDisk, Project: "project", Location: "us-east1-b", Resource: "disk", SnapshotName: "snap", DiskType: "pd-standard"},
+	}
+	service := &failedStartService{}
+	results, err := Revert(context.Background(), service, "r1", applied, &Task{})
+	var revertErr *RevertError
+	if !assert.True(t, errors.As(err, &revertErr), "RevertError should be returned") {
+		return
+	}
+	if assert.Len(t, results, 1, "The disk should be restored") {
+		assert.Equal(t, ActionCreateDisk, results[0].Action)
+	}
+	assert.Equal(t, applied[:1], revertErr.Remaining, "Stopping the instance should be left to revert")
+	assert.Equal(t, applied[:1], UnrevertedResults(applied, err))
+	assert.Equal(t, applied, UnrevertedResults(applied, errors.New("address can't be restored")))
+	assert.Nil(t, UnrevertedResults(applied, nil))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import "fmt"
+
+// compensatingAction reverts one change made by an operation handler.
+type compensatingAction struct {
+	description string
+	do          func() error
+}
+
+// Rollback collects compensating actions registered by operation handlers,
+// so that changes made while applying a recommendation can be reverted if applying fails.
+// Rollback is not thread-safe, operations of one recommendation are done consequently.
+type Rollback struct {
+	actions []compensatingAction
+}
+
+// Register adds the action reverting the change described by description.
+// It is safe to call Register on nil Rollback, then the action is ignored.
+func (r *Rollback) Register(description string, action func() error) {
+	if r == nil {
+		return
+	}
+	r.actions = append(r.actions, compensatingAction{description: description, do: action})
+}
+
+// RollbackAction is the outcome of one compensating action.
+type RollbackAction struct {
+	Description  string `json:"description"`
+	Succeeded    bool   `json:"succeeded"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// RollbackResult is the outcome of running all compensating actions.
+// Succeeded is true if all of the actions succeeded.
+type RollbackResult struct {
+	Succeeded bool              `json:"succeeded"`
+	Actions   []*RollbackAction `json:"actions"`
+}
+
+// Run does the registered actions in reverse order of registration.
+// If one of the actions fails, the remaining ones are still done.
+// Returns nil if no actions were registered.
+func (r *Rollback) Run() *RollbackResult {
+	if r == nil || len(r.actions) == 0 {
+		return nil
+	}
+
+	result := &RollbackResult{Succeeded: true}
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := &RollbackAction{Description: r.actions[i].description, Succeeded: true}
+		if err := r.actions[i].do(); err != nil {
+			action.Succeeded = false
+			action.ErrorMessage = err.Error()
+			result.Succeeded = false
+		}
+		result.Actions = append(result.Actions, action)
+	}
+	r.actions = nil
+	return result
+}
+
+// ApplyError is returned by Apply if applying the recommendation failed
+// after some changes had been made. Rollback contains the outcome of reverting them.
+// It's also returned if marking the recommendation failed didn't succeed,
+// then MarkErr is the error of marking and Rollback is nil, if nothing was changed.
+type ApplyError struct {
+	Err      error
+	Rollback *RollbackResult
+	MarkErr  error
+}
+
+func (e *ApplyError) Error() string {
+	if e.MarkErr != nil {
+		return fmt.Sprintf("%v (marking the recommendation failed: %v)", e.Err, e.MarkErr)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that made applying the recommendation fail.
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
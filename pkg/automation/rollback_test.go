@@ -0,0 +1,320 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// RollbackMockService fails the first call to the function named failingFunction.
+type RollbackMockService struct {
+	ApplyMockService
+	failingFunction string
+	failed          bool
+}
+
+func (s *RollbackMockService) fail(functionName string) bool {
+	if s.failingFunction == functionName && !s.failed {
+		s.failed = true
+		return true
+	}
+	return false
+}
+
+func (s *RollbackMockService) ChangeMachineType(ctx context.Context, project string, zone string, instance string, machineType string) error {
+	if s.fail("ChangeMachineType") {
+		newCalledFunction := calledFunction{"ChangeMachineType", []interface{}{project, zone, instance, machineType}, []interface{}{errQuotaExceeded}}
+		s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+		return errQuotaExceeded
+	}
+	return s.ApplyMockService.ChangeMachineType(ctx, project, zone, instance, machineType)
+}
+
+func (s *RollbackMockService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
+	if s.fail("StartInstance") {
+		newCalledFunction := calledFunction{"StartInstance", []interface{}{project, zone, instance}, []interface{}{errQuotaExceeded}}
+		s.calledFunctions = append(s.calledFunctions, newCalledFunction)
+		return errQuotaExceeded
+	}
+	return s.ApplyMockService.StartInstance(ctx, project, zone, instance)
+}
+
+func machineTypeRecommendation() gcloudRecommendation {
+	return gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{
+				&gcloudOperationGroup{
+					Operations: []*gcloudOperation{
+						&gcloudOperation{
+							Action:       "replace",
+							Path:         "/machineType",
+							Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test",
+							ResourceType: "compute.googleapis.com/Instance",
+							Value:        "zones/us-central1-a/machineTypes/e2-medium",
+						},
+					},
+				},
+			},
+		},
+		Etag:      "\"40204a1000e5befe\"",
+		Name:      "projects/323016592286/locations/us-central1-a/recommenders/google.compute.instance.MachineTypeRecommender/recommendations/5df355d9-2f50-4567-a909-bcfcebcf7d66",
+		StateInfo: &gcloudStateInfo{State: "Active"},
+	}
+}
+
+func calledFunctionNames(functions []calledFunction) []string {
+	var result []string
+	for _, function := range functions {
+		result = append(result, function.functionName)
+	}
+	return result
+}
+
+// Checks that the stopped instance is started again,
+// if changing the machine type fails.
+func TestRollbackFailedMachineTypeChange(t *testing.T) {
+	ctx := context.Background()
+	recommendation := machineTypeRecommendation()
+	service := &RollbackMockService{failingFunction: "ChangeMachineType"}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "RUNNING", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	assert.EqualError(t, err, errQuotaExceeded.Error())
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError expected") {
+		assert.Equal(t, errQuotaExceeded, applyErr.Err)
+		assert.True(t, applyErr.Rollback.Succeeded, "Rollback should succeed")
+		assert.Equal(t, 1, len(applyErr.Rollback.Actions), "Only starting the instance should be done")
+	}
+
+	expected := []string{
+		"MarkRecommendationClaimed",
+		"GetInstance",
+		"StopInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"MarkRecommendationFailed",
+	}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+	assert.Equal(t, []interface{}{"rightsizer-test", "us-central1-a", "alicja-test"}, service.calledFunctions[4].arguments)
+}
+
+type failedMarkService struct {
+	RollbackMockService
+}
+
+func (s *failedMarkService) MarkRecommendationFailed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
+	return nil, errors.New("recommendation couldn't be marked failed")
+}
+
+// Checks that the outcome of the rollback is returned, if marking the recommendation failed fails.
+func TestRollbackFailedMarking(t *testing.T) {
+	ctx := context.Background()
+	recommendation := machineTypeRecommendation()
+	service := &failedMarkService{RollbackMockService{failingFunction: "ChangeMachineType"}}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "RUNNING", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError expected") {
+		assert.Equal(t, errQuotaExceeded, applyErr.Err)
+		assert.EqualError(t, applyErr.MarkErr, "recommendation couldn't be marked failed")
+		if assert.NotNil(t, applyErr.Rollback, "Rollback should be returned") {
+			assert.True(t, applyErr.Rollback.Succeeded, "Rollback should succeed")
+			assert.Equal(t, 1, len(applyErr.Rollback.Actions), "Only starting the instance should be done")
+		}
+	}
+	assert.Contains(t, err.Error(), errQuotaExceeded.Error())
+	assert.Contains(t, err.Error(), "marked failed")
+}
+
+// Checks that the previous machine type is restored before starting the instance,
+// if starting the instance with the new machine type fails.
+func TestRollbackFailedStart(t *testing.T) {
+	ctx := context.Background()
+	recommendation := machineTypeRecommendation()
+	service := &RollbackMockService{failingFunction: "StartInstance"}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "RUNNING", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError expected") {
+		assert.True(t, applyErr.Rollback.Succeeded, "Rollback should succeed")
+		assert.Equal(t, 2, len(applyErr.Rollback.Actions), "Machine type should be restored and instance started")
+	}
+
+	expected := []string{
+		"MarkRecommendationClaimed",
+		"GetInstance",
+		"StopInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"MarkRecommendationFailed",
+	}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+	assert.Equal(t, []interface{}{"rightsizer-test", "us-central1-a", "alicja-test", "e2-standard-2"}, service.calledFunctions[5].arguments)
+}
+
+// Checks that a stopped instance is not started by the rollback.
+func TestRollbackStoppedInstance(t *testing.T) {
+	ctx := context.Background()
+	recommendation := machineTypeRecommendation()
+	service := &RollbackMockService{failingFunction: "ChangeMachineType"}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "TERMINATED", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	assert.Equal(t, errQuotaExceeded, err, "No rollback expected")
+
+	expected := []string{
+		"MarkRecommendationClaimed",
+		"GetInstance",
+		"StopInstance",
+		"ChangeMachineType",
+		"MarkRecommendationFailed",
+	}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+}
+
+// Checks that all actions are done in reverse order, even if one of them fails.
+func TestRollbackRun(t *testing.T) {
+	var order []int
+	rollback := &Rollback{}
+	for i := 0; i < 3; i++ {
+		i := i
+		rollback.Register("action", func() error {
+			order = append(order, i)
+			if i == 1 {
+				return errQuotaExceeded
+			}
+			return nil
+		})
+	}
+
+	result := rollback.Run()
+	assert.Equal(t, []int{2, 1, 0}, order, "Actions should be done in reverse order")
+	assert.False(t, result.Succeeded, "One of the actions failed")
+	assert.Equal(t, errQuotaExceeded.Error(), result.Actions[1].ErrorMessage)
+	assert.True(t, result.Actions[2].Succeeded)
+
+	var nilRollback *Rollback
+	nilRollback.Register("action", nil)
+	assert.Nil(t, nilRollback.Run(), "Nothing should be done for nil rollback")
+}
+
+// CancelMockService cancels the context in the call to the function named cancelingFunction
+// and records whether the context of every call was canceled.
+type CancelMockService struct {
+	ApplyMockService
+	cancel            context.CancelFunc
+	cancelingFunction string
+	canceledCalls     []string
+}
+
+func (s *CancelMockService) record(ctx context.Context, functionName string) {
+	if functionName == s.cancelingFunction {
+		s.cancel()
+	}
+	if ctx.Err() != nil {
+		s.canceledCalls = append(s.canceledCalls, functionName)
+	}
+}
+
+func (s *CancelMockService) StopInstance(ctx context.Context, project string, zone string, instance string) error {
+	s.record(ctx, "StopInstance")
+	return s.ApplyMockService.StopInstance(ctx, project, zone, instance)
+}
+
+func (s *CancelMockService) ChangeMachineType(ctx context.Context, project string, zone string, instance string, machineType string) error {
+	s.record(ctx, "ChangeMachineType")
+	return s.ApplyMockService.ChangeMachineType(ctx, project, zone, instance, machineType)
+}
+
+func (s *CancelMockService) StartInstance(ctx context.Context, project string, zone string, instance string) error {
+	s.record(ctx, "StartInstance")
+	return s.ApplyMockService.StartInstance(ctx, project, zone, instance)
+}
+
+func (s *CancelMockService) MarkRecommendationFailed(ctx context.Context, name string, etag string) (*gcloudRecommendation, error) {
+	s.record(ctx, "MarkRecommendationFailed")
+	return s.ApplyMockService.MarkRecommendationFailed(ctx, name, etag)
+}
+
+// Checks that canceling while the machine type is changed doesn't leave the instance stopped.
+func TestCancelChangingMachineType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	recommendation := machineTypeRecommendation()
+	service := &CancelMockService{cancel: cancel, cancelingFunction: "StopInstance"}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "RUNNING", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	assert.NoError(t, err, "The only operation had been started before canceling")
+	assert.Equal(t, []string(nil), service.canceledCalls, "No call should get canceled context")
+	expected := []string{
+		"MarkRecommendationClaimed",
+		"GetInstance",
+		"StopInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"MarkRecommendationSucceeded",
+	}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+}
+
+// Checks that after canceling, the next operation is not done,
+// the changes are reverted and the recommendation is marked failed.
+func TestCancelBetweenOperations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	recommendation := machineTypeRecommendation()
+	operations := recommendation.Content.OperationGroups[0].Operations
+	recommendation.Content.OperationGroups[0].Operations = append(operations, operations[0])
+	service := &CancelMockService{cancel: cancel, cancelingFunction: "StartInstance"}
+	service.recommendation = recommendation
+	service.getInstanceResult = &compute.Instance{Status: "RUNNING", MachineType: "zones/us-central1-a/machineTypes/e2-standard-2"}
+
+	_, err := Apply(ctx, service, &recommendation, &Task{})
+	assert.True(t, errors.Is(err, context.Canceled), "Canceled error expected")
+	var applyErr *ApplyError
+	if assert.True(t, errors.As(err, &applyErr), "ApplyError expected") {
+		assert.True(t, applyErr.Rollback.Succeeded)
+	}
+	assert.Equal(t, []string(nil), service.canceledCalls, "Rollback and marking shouldn't get canceled context")
+	expected := []string{
+		"MarkRecommendationClaimed",
+		"GetInstance",
+		"StopInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"ChangeMachineType",
+		"StartInstance",
+		"MarkRecommendationFailed",
+	}
+	assert.Equal(t, expected, calledFunctionNames(service.calledFunctions))
+}
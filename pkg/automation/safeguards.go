@@ -0,0 +1,312 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultOptOutLabel is the label, which set to "true" on an instance or a disk
+// makes Recomator leave the resource unchanged.
+const DefaultOptOutLabel = "recomator-skip"
+
+// anyProject is the key of maintenance windows of projects without their own windows.
+const anyProject = "*"
+
+// SkippedError is returned when the safeguards refuse to change a resource.
+// The recommendation should be shown as skipped, rather than failed.
+type SkippedError struct {
+	Resource string
+	Reason   string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("%s was skipped: %s", e.Resource, e.Reason)
+}
+
+// IsSkipped checks whether the error, or an error wrapped by it, is *SkippedError.
+func IsSkipped(err error) bool {
+	var skipped *SkippedError
+	return errors.As(err, &skipped)
+}
+
+// MaintenanceWindow is the time of the week, when resources can be changed.
+// Start and End are times of the day in UTC, as durations since midnight.
+// If End is not after Start, the window ends on the next day.
+// Days are the days on which the window starts, every day if empty.
+type MaintenanceWindow struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w *MaintenanceWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains checks whether t is in the window.
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	offset := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.Start < w.End {
+		return w.startsOn(t.Weekday()) && offset >= w.Start && offset < w.End
+	}
+	// the window continues after midnight
+	previousDay := (t.Weekday() + 6) % 7
+	return (w.startsOn(t.Weekday()) && offset >= w.Start) || (w.startsOn(previousDay) && offset < w.End)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWeekdays parses a list of days like "Mon-Fri,Sun", "*" means every day.
+func parseWeekdays(spec string) ([]time.Weekday, error) {
+	if spec == "*" {
+		return nil, nil
+	}
+	var days []time.Weekday
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(strings.ToLower(strings.TrimSpace(part)), "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("invalid day %q", part)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseTimeOfDay parses time like "22:30" as a duration since midnight.
+func parseTimeOfDay(spec string) (time.Duration, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", spec)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseMaintenanceWindow parses a window like "Sat,Sun 22:00-06:00" or "* 01:00-05:00",
+// with days on which the window starts and times in UTC.
+func ParseMaintenanceWindow(spec string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected days and time range", spec)
+	}
+	days, err := parseWeekdays(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid time range %q", fields[1])
+	}
+	start, err := parseTimeOfDay(times[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(times[1])
+	if err != nil {
+		return nil, err
+	}
+	return &MaintenanceWindow{Days: days, Start: start, End: end}, nil
+}
+
+// ParseMaintenanceWindows parses maintenance windows of projects, in the form like
+// "project-a=Sat,Sun 00:00-06:00;project-a=Wed 22:00-02:00;*=Mon-Fri 01:00-05:00",
+// where * stands for projects without their own windows.
+func ParseMaintenanceWindows(spec string) (map[string][]*MaintenanceWindow, error) {
+	result := make(map[string][]*MaintenanceWindow)
+	for _, entry := range strings.Split(spec, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid maintenance window entry %q, expected project=window", entry)
+		}
+		window, err := ParseMaintenanceWindow(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		project := strings.TrimSpace(parts[0])
+		result[project] = append(result[project], window)
+	}
+	return result, nil
+}
+
+// Safeguards prevent DoOperation from changing resources, that shouldn't be changed now.
+type Safeguards struct {
+	// OptOutLabel is the label, which set to "true" on an instance or a disk
+	// makes its operations skipped. If empty, labels are not checked.
+	OptOutLabel string
+	// MaintenanceWindows are the windows of projects, outside of which operations are skipped.
+	// The key "*" applies to projects not listed. Projects without windows can be changed anytime.
+	MaintenanceWindows map[string][]*MaintenanceWindow
+	// MinDiskAge is the minimum age of a disk, that can be deleted. Zero means DefaultMinDiskAge.
+	MinDiskAge time.Duration
+
+	now func() time.Time
+}
+
+type safeguardsKey struct{}
+
+// WithSafeguards returns the context, with which DoOperation checks the safeguards
+// before changing resources.
+func WithSafeguards(ctx context.Context, safeguards *Safeguards) context.Context {
+	return context.WithValue(ctx, safeguardsKey{}, safeguards)
+}
+
+func safeguardsFromContext(ctx context.Context) *Safeguards {
+	safeguards, _ := ctx.Value(safeguardsKey{}).(*Safeguards)
+	return safeguards
+}
+
+// inMaintenanceWindow checks whether the project can be changed now.
+func (s *Safeguards) inMaintenanceWindow(project string) bool {
+	windows, ok := s.MaintenanceWindows[project]
+	if !ok {
+		windows = s.MaintenanceWindows[anyProject]
+	}
+	if len(windows) == 0 {
+		return true
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	for _, window := range windows {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// optedOut checks whether the labels contain the opt-out label.
+func (s *Safeguards) optedOut(labels map[string]string) bool {
+	return s.OptOutLabel != "" && strings.EqualFold(labels[s.OptOutLabel], "true")
+}
+
+// operationTarget returns the path of the resource changed by the operation.
+// For snapshots, it's the source disk.
+func operationTarget(operation *gcloudOperation) string {
+	if operation.ResourceType == snapshotType {
+		value, _ := operation.Value.(map[string]interface{})
+		if sourceDisk, ok := value["source_disk"].(string); ok {
+			return sourceDisk
+		}
+	}
+	return operation.Resource
+}
+
+// resourceLabels returns the labels of the instance or the disk at path.
+// Other resources have no labels checked.
+func resourceLabels(ctx context.Context, service GoogleService, resourceType, path string) (map[string]string, error) {
+	project, errProject := extractFromURL(path, projectParam)
+	zone, errZone := extractFromURL(path, zoneParam)
+	switch resourceType {
+	case instanceType:
+		instance, errInstance := extractFromURL(path, instanceParam)
+		if err := chooseNotNil(errProject, errZone, errInstance); err != nil {
+			return nil, err
+		}
+		machineInstance, err := service.GetInstance(ctx, project, zone, instance)
+		if err != nil {
+			return nil, err
+		}
+		return machineInstance.Labels, nil
+	case diskType, snapshotType:
+		disk, errDisk := extractFromURL(path, diskParam)
+		if err := chooseNotNil(errProject, errZone, errDisk); err != nil {
+			return nil, err
+		}
+		d, err := service.GetDisk(ctx, project, zone, disk)
+		if err != nil {
+			return nil, err
+		}
+		return d.Labels, nil
+	}
+	return nil, nil
+}
+
+// Check returns *SkippedError if the operation changes a resource with the opt-out label,
+// or in a project outside of its maintenance window. Test operations are never skipped.
+func (s *Safeguards) Check(ctx context.Context, service GoogleService, operation *gcloudOperation) error {
+	if strings.ToLower(operation.Action) == "test" {
+		return nil
+	}
+	target := operationTarget(operation)
+	project, err := extractFromURL(target, projectParam)
+	if err != nil {
+		return err
+	}
+	if !s.inMaintenanceWindow(project) {
+		return &SkippedError{Resource: target, Reason: fmt.Sprintf("outside of maintenance window of project %s", project)}
+	}
+	if s.OptOutLabel == "" {
+		return nil
+	}
+	labels, err := resourceLabels(ctx, service, operation.ResourceType, target)
+	if err != nil {
+		return err
+	}
+	if s.optedOut(labels) {
+		return &SkippedError{Resource: target, Reason: fmt.Sprintf("labelled %s=true", s.OptOutLabel)}
+	}
+	return nil
+}
+
+// checkSafeguards checks the operations of the recommendation with the safeguards from ctx, if any.
+func checkSafeguards(ctx context.Context, service GoogleService, recommendation *gcloudRecommendation) error {
+	safeguards := safeguardsFromContext(ctx)
+	if safeguards == nil || recommendation.Content == nil {
+		return nil
+	}
+	for _, group := range recommendation.Content.OperationGroups {
+		for _, operation := range group.Operations {
+			if err := safeguards.Check(ctx, service, operation); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
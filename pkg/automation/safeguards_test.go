@@ -0,0 +1,172 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+type SafeguardsMockService struct {
+	GoogleService
+	labels  map[string]string
+	claimed bool
+	deleted bool
+	stopped bool
+}
+
+func (s *SafeguardsMockService) GetInstance(ctx context.Context, project, zone, instance string) (*compute.Instance, error) {
+	return &compute.Instance{Name: instance, Labels: s.labels}, nil
+}
+
+func (s *SafeguardsMockService) GetDisk(ctx context.Context, project, zone, disk string) (*compute.Disk, error) {
+	return &compute.Disk{Name: disk, Labels: s.labels, CreationTimestamp: idleDisk.CreationTimestamp}, nil
+}
+
+func (s *SafeguardsMockService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	s.stopped = true
+	return nil
+}
+
+func (s *SafeguardsMockService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
+	s.deleted = true
+	return nil
+}
+
+func (s *SafeguardsMockService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error) {
+	s.claimed = true
+	return &gcloudRecommendation{Name: name, Etag: etag}, nil
+}
+
+func removeDiskOperation() *gcloudOperation {
+	return &gcloudOperation{
+		Action:       "remove",
+		Path:         "/",
+		Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/europe-west1-d/disks/idle-disk",
+		ResourceType: "compute.googleapis.com/Disk",
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2020-08-01 is a Saturday
+	weekend, err := ParseMaintenanceWindow("Sat,Sun 22:00-06:00")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, weekend.Contains(time.Date(2020, 8, 1, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, weekend.Contains(time.Date(2020, 8, 2, 5, 59, 0, 0, time.UTC)))
+	assert.True(t, weekend.Contains(time.Date(2020, 8, 3, 1, 0, 0, 0, time.UTC)), "window starting on Sunday ends on Monday")
+	assert.False(t, weekend.Contains(time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, weekend.Contains(time.Date(2020, 8, 1, 1, 0, 0, 0, time.UTC)), "Friday has no window")
+	assert.False(t, weekend.Contains(time.Date(2020, 8, 3, 22, 30, 0, 0, time.UTC)))
+
+	daily, err := ParseMaintenanceWindow("* 01:00-05:00")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, daily.Contains(time.Date(2020, 8, 5, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, daily.Contains(time.Date(2020, 8, 5, 5, 0, 0, 0, time.UTC)))
+
+	workdays, err := ParseMaintenanceWindow("mon-fri 10:00-12:00")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, workdays.Days)
+}
+
+func TestParseMaintenanceWindowsErrors(t *testing.T) {
+	for _, spec := range []string{"p=Sat", "p=Sat 22:00", "p=Xyz 01:00-02:00", "p=Sat 25:00-02:00", "Sat 01:00-02:00"} {
+		_, err := ParseMaintenanceWindows(spec)
+		assert.Error(t, err, spec)
+	}
+
+	windows, err := ParseMaintenanceWindows("project-a=Sat 00:00-06:00;project-a=Wed 22:00-02:00;*=* 01:00-05:00")
+	if assert.NoError(t, err) {
+		assert.Len(t, windows["project-a"], 2)
+		assert.Len(t, windows["*"], 1)
+	}
+}
+
+func TestSafeguardsMaintenanceWindow(t *testing.T) {
+	windows, err := ParseMaintenanceWindows("rightsizer-test=Sat 00:00-06:00;*=* 00:00-23:59")
+	if !assert.NoError(t, err) {
+		return
+	}
+	saturdayNight := time.Date(2020, 8, 1, 3, 0, 0, 0, time.UTC)
+	safeguards := &Safeguards{MaintenanceWindows: windows, now: func() time.Time { return saturdayNight }}
+	ctx := WithSafeguards(context.Background(), safeguards)
+
+	service := &SafeguardsMockService{}
+	_, err = DoOperation(ctx, service, removeDiskOperation(), nil)
+	assert.NoError(t, err)
+	assert.True(t, service.deleted)
+
+	safeguards.now = func() time.Time { return saturdayNight.Add(12 * time.Hour) }
+	service = &SafeguardsMockService{}
+	_, err = DoOperation(ctx, service, removeDiskOperation(), nil)
+	assert.True(t, IsSkipped(err), "operation outside of maintenance window should be skipped")
+	assert.False(t, service.deleted)
+}
+
+func TestSafeguardsOptOutLabel(t *testing.T) {
+	ctx := WithSafeguards(context.Background(), &Safeguards{OptOutLabel: DefaultOptOutLabel})
+
+	service := &SafeguardsMockService{labels: map[string]string{DefaultOptOutLabel: "true"}}
+	_, err := DoOperation(ctx, service, removeDiskOperation(), nil)
+	assert.True(t, IsSkipped(err), "disk with opt-out label should be skipped")
+	assert.False(t, service.deleted)
+
+	stopOperation := &gcloudOperation{
+		Action:       "replace",
+		Path:         "/status",
+		Resource:     "//compute.googleapis.com/projects/rightsizer-test/zones/us-east1-b/instances/alicja-test",
+		ResourceType: "compute.googleapis.com/Instance",
+		Value:        "TERMINATED",
+	}
+	_, err = DoOperation(ctx, service, stopOperation, nil)
+	assert.True(t, IsSkipped(err), "instance with opt-out label should be skipped")
+	assert.False(t, service.stopped)
+
+	service = &SafeguardsMockService{labels: map[string]string{DefaultOptOutLabel: "false"}}
+	_, err = DoOperation(ctx, service, removeDiskOperation(), nil)
+	assert.NoError(t, err)
+	assert.True(t, service.deleted)
+}
+
+func TestApplySkippedNotClaimed(t *testing.T) {
+	ctx := WithSafeguards(context.Background(), &Safeguards{OptOutLabel: DefaultOptOutLabel})
+	service := &SafeguardsMockService{labels: map[string]string{DefaultOptOutLabel: "TRUE"}}
+	recommendation := &gcloudRecommendation{
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{{Operations: []*gcloudOperation{removeDiskOperation()}}},
+		},
+		Name:      "name",
+		StateInfo: &gcloudStateInfo{State: "ACTIVE"},
+	}
+	_, err := Apply(ctx, service, recommendation, &Task{})
+	var skipped *SkippedError
+	if assert.Error(t, err) && assert.True(t, errors.As(err, &skipped)) {
+		assert.Equal(t, "//compute.googleapis.com/projects/rightsizer-test/zones/europe-west1-d/disks/idle-disk", skipped.Resource)
+	}
+	assert.False(t, service.claimed, "skipped recommendation shouldn't be claimed")
+	assert.False(t, service.deleted)
+}
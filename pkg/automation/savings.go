@@ -0,0 +1,164 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	nanosPerUnit = 1000000000
+	month        = 30 * 24 * time.Hour
+)
+
+// Money is an amount of money in one currency.
+// Nanos has the same sign as Units and its absolute value is less than 10^9.
+type Money struct {
+	CurrencyCode string `json:"currencyCode"`
+	Units        int64  `json:"units"`
+	Nanos        int32  `json:"nanos"`
+}
+
+// moneyTotals sums amounts of money in nanos, key is the currency code.
+type moneyTotals map[string]int64
+
+func (t moneyTotals) add(currencyCode string, nanos int64) {
+	t[currencyCode] += nanos
+}
+
+// groupedTotals are moneyTotals grouped by a key, for example by project.
+type groupedTotals map[string]moneyTotals
+
+func (g groupedTotals) add(key, currencyCode string, nanos int64) {
+	if _, ok := g[key]; !ok {
+		g[key] = moneyTotals{}
+	}
+	g[key].add(currencyCode, nanos)
+}
+
+func (g groupedTotals) toMoney() map[string][]*Money {
+	result := make(map[string][]*Money)
+	for key, totals := range g {
+		result[key] = totals.toMoney()
+	}
+	return result
+}
+
+// toMoney returns the totals as Money, sorted by currency code.
+func (t moneyTotals) toMoney() []*Money {
+	result := []*Money{}
+	for currencyCode, nanos := range t {
+		result = append(result, &Money{
+			CurrencyCode: currencyCode,
+			Units:        nanos / nanosPerUnit,
+			Nanos:        int32(nanos % nanosPerUnit),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CurrencyCode < result[j].CurrencyCode })
+	return result
+}
+
+// SavingsSummary contains projected monthly savings of recommendations,
+// in total and grouped by project, location (zone, region or global), recommender and resource type.
+// Every amount is listed separately for each currency.
+// Savings are negative for recommendations increasing costs.
+type SavingsSummary struct {
+	Total            []*Money            `json:"total"`
+	ByProject        map[string][]*Money `json:"byProject"`
+	ByLocation       map[string][]*Money `json:"byLocation"`
+	ByRecommender    map[string][]*Money `json:"byRecommender"`
+	ByResourceType   map[string][]*Money `json:"byResourceType"`
+	NumWithoutImpact int                 `json:"numWithoutImpact"`
+}
+
+var recommendationNameRegexp = regexp.MustCompile("^projects/([^/]+)/locations/([^/]+)/recommenders/([^/]+)/")
+
+// MonthlySavings returns the currency code and the savings projected by the recommendation
+// for 30 days, in nanos. Returns false if the recommendation has no cost projection.
+func MonthlySavings(recommendation *gcloudRecommendation) (string, int64, bool) {
+	impact := recommendation.PrimaryImpact
+	if impact == nil || impact.CostProjection == nil || impact.CostProjection.Cost == nil {
+		return "", 0, false
+	}
+	cost := impact.CostProjection.Cost
+	// savings are the negated cost
+	nanos := -(cost.Units*nanosPerUnit + cost.Nanos)
+
+	duration, err := time.ParseDuration(impact.CostProjection.Duration)
+	if err == nil && duration > 0 && duration != month {
+		nanos = int64(math.Round(float64(nanos) * float64(month) / float64(duration)))
+	}
+	return cost.CurrencyCode, nanos, true
+}
+
+// recommendationGroups returns the project, location, recommender and resource type of the recommendation.
+// The project is the ID from the resource, if there's one, otherwise the number from the name.
+func recommendationGroups(recommendation *gcloudRecommendation) (project, location, recommenderID, resourceType string) {
+	if match := recommendationNameRegexp.FindStringSubmatch(recommendation.Name); match != nil {
+		project, location, recommenderID = match[1], match[2], match[3]
+	}
+	if recommendation.Content == nil {
+		return
+	}
+	for _, group := range recommendation.Content.OperationGroups {
+		for _, operation := range group.Operations {
+			if operation.ResourceType == "" {
+				continue
+			}
+			resourceType = operation.ResourceType
+			if id, err := extractFromURL(operation.Resource, "projects"); err == nil {
+				project = id
+			}
+			return
+		}
+	}
+	return
+}
+
+// SummarizeSavings totals the projected monthly savings of the recommendations.
+// Cost projections for other durations are scaled to 30 days.
+// Recommendations without cost projection are only counted in NumWithoutImpact.
+func SummarizeSavings(recommendations []*gcloudRecommendation) *SavingsSummary {
+	total := moneyTotals{}
+	byProject, byLocation, byRecommender, byResourceType := groupedTotals{}, groupedTotals{}, groupedTotals{}, groupedTotals{}
+	numWithoutImpact := 0
+	for _, recommendation := range recommendations {
+		currencyCode, nanos, ok := MonthlySavings(recommendation)
+		if !ok {
+			numWithoutImpact++
+			continue
+		}
+		total.add(currencyCode, nanos)
+		project, location, recommenderID, resourceType := recommendationGroups(recommendation)
+		byProject.add(project, currencyCode, nanos)
+		byLocation.add(location, currencyCode, nanos)
+		byRecommender.add(recommenderID, currencyCode, nanos)
+		byResourceType.add(resourceType, currencyCode, nanos)
+	}
+
+	return &SavingsSummary{
+		Total:            total.toMoney(),
+		ByProject:        byProject.toMoney(),
+		ByLocation:       byLocation.toMoney(),
+		ByRecommender:    byRecommender.toMoney(),
+		ByResourceType:   byResourceType.toMoney(),
+		NumWithoutImpact: numWithoutImpact,
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/recommender/v1"
+)
+
+func recommendationWithCost(name, resource string, units, nanos int64, currencyCode, duration string) *gcloudRecommendation {
+	return &gcloudRecommendation{
+		Name: name,
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{{
+				Operations: []*gcloudOperation{{Resource: resource, ResourceType: "compute.googleapis.com/Instance"}},
+			}},
+		},
+		PrimaryImpact: &recommender.GoogleCloudRecommenderV1Impact{
+			CostProjection: &recommender.GoogleCloudRecommenderV1CostProjection{
+				Cost:     &recommender.GoogleTypeMoney{CurrencyCode: currencyCode, Units: units, Nanos: nanos},
+				Duration: duration,
+			},
+		},
+	}
+}
+
+func TestSummarizeSavings(t *testing.T) {
+	const (
+		name     = "projects/123/locations/us-central1-a/recommenders/google.compute.instance.IdleResourceRecommender/recommendations/1"
+		resource = "//compute.googleapis.com/projects/rightsizer-test/zones/us-central1-a/instances/alicja-test"
+	)
+	recommendations := []*gcloudRecommendation{
+		recommendationWithCost(name, resource, -10, -600000000, "USD", "2592000s"),
+		recommendationWithCost(name, resource, -1, -700000000, "USD", "2592000s"),
+		// a week, scaled to 30 days
+		recommendationWithCost(name, resource, -7, 0, "USD", "604800s"),
+		recommendationWithCost(name, resource, -5, 0, "EUR", "2592000s"),
+		{Name: name},
+	}
+
+	summary := SummarizeSavings(recommendations)
+	expectedTotal := []*Money{
+		{CurrencyCode: "EUR", Units: 5},
+		{CurrencyCode: "USD", Units: 42, Nanos: 300000000},
+	}
+	assert.Equal(t, expectedTotal, summary.Total, "Nanos should be carried to units and durations scaled")
+	assert.Equal(t, expectedTotal, summary.ByProject["rightsizer-test"], "Project ID should be taken from the resource")
+	assert.Equal(t, expectedTotal, summary.ByLocation["us-central1-a"])
+	assert.Equal(t, expectedTotal, summary.ByRecommender["google.compute.instance.IdleResourceRecommender"])
+	assert.Equal(t, expectedTotal, summary.ByResourceType["compute.googleapis.com/Instance"])
+	assert.Equal(t, 1, summary.NumWithoutImpact)
+}
+
+func TestMoneyTotalsNegative(t *testing.T) {
+	totals := moneyTotals{}
+	totals.add("USD", -1500000000)
+	assert.Equal(t, []*Money{{CurrencyCode: "USD", Units: -1, Nanos: -500000000}}, totals.toMoney(),
+		"Nanos should have the same sign as units")
+}
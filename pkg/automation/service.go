@@ -19,8 +19,10 @@ package automation
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/compute/v1"
@@ -28,117 +30,240 @@ import (
 	"google.golang.org/api/option"
 	"google.golang.org/api/recommender/v1"
 	"google.golang.org/api/serviceusage/v1"
+	"google.golang.org/api/sqladmin/v1beta4"
+	htransport "google.golang.org/api/transport/http"
 )
 
 // GoogleService is the inferface that prodives methods required to list recommendations and apply them
 type GoogleService interface {
 	// changes the machine type of an instance
-	ChangeMachineType(project, zone, instance, machineType string) error
+	ChangeMachineType(ctx context.Context, project, zone, instance, machineType string) error
+
+	// creates a disk, for example from a snapshot
+	CreateDisk(ctx context.Context, project, zone string, disk *compute.Disk) error
 
 	// creates a snapshot of a disk
-	CreateSnapshot(project, zone, disk, name string) error
+	CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error
 
 	// deletes persistent disk
-	DeleteDisk(project, zone, disk string) error
+	DeleteDisk(ctx context.Context, project, zone, disk string) error
+
+	// deletes custom image
+	DeleteImage(ctx context.Context, project, image string) error
+
+	// deletes snapshot
+	DeleteSnapshot(ctx context.Context, project, snapshot string) error
+
+	// gets the specified disk resource
+	GetDisk(ctx context.Context, project, zone, disk string) (*compute.Disk, error)
 
 	// gets the specified instance resource
-	GetInstance(project string, zone string, instance string) (*compute.Instance, error)
+	GetInstance(ctx context.Context, project string, zone string, instance string) (*compute.Instance, error)
+
+	// gets the specified instance template
+	GetInstanceTemplate(ctx context.Context, project, template string) (*compute.InstanceTemplate, error)
+
+	// creates an instance template
+	CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error
+
+	// deletes instance template
+	DeleteInstanceTemplate(ctx context.Context, project, template string) error
+
+	// gets the specified managed instance group, location is its zone or region
+	GetInstanceGroupManager(ctx context.Context, project, location, manager string) (*compute.InstanceGroupManager, error)
+
+	// sets the instance template of the managed instance group, location is its zone or region
+	SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error
+
+	// gets the specified snapshot resource
+	GetSnapshot(ctx context.Context, project, snapshot string) (*compute.Snapshot, error)
 
 	// gets recommendation by name
-	GetRecommendation(name string) (*gcloudRecommendation, error)
+	GetRecommendation(ctx context.Context, name string) (*gcloudRecommendation, error)
+
+	// gets insight by name
+	GetInsight(ctx context.Context, name string) (*gcloudInsight, error)
 
 	// lists whether the requirements have been met for all APIs (APIs enabled).
-	ListAPIRequirements(project string, apis []string) ([]*Requirement, error)
+	ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*Requirement, error)
 
 	// lists whether the requirements have been met for all required permissions.
-	ListPermissionRequirements(project string, permissions [][]string) ([]*Requirement, error)
+	ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*Requirement, error)
 
 	// lists projects
-	ListProjects() ([]string, error)
+	ListProjects(ctx context.Context) ([]string, error)
+
+	// lists projects having all the labels
+	ListProjectsByLabels(ctx context.Context, labels map[string]string) ([]string, error)
 
 	// listing recommendations for specified project, zone and recommender
-	ListRecommendations(project, location, recommenderID string) ([]*gcloudRecommendation, error)
+	ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*gcloudRecommendation, error)
+
+	// lists snapshots in the project matching the filter, all if it's empty
+	ListSnapshots(ctx context.Context, project, filter string) ([]*compute.Snapshot, error)
+
+	// listing insights for specified project, location and insight type
+	ListInsights(ctx context.Context, project, location, insightTypeID string) ([]*gcloudInsight, error)
 
 	// listing every zone available for the project methods
-	ListZonesNames(project string) ([]string, error)
+	ListZonesNames(ctx context.Context, project string) ([]string, error)
 
 	// listing every region available for the project methods
-	ListRegionsNames(project string) ([]string, error)
+	ListRegionsNames(ctx context.Context, project string) ([]string, error)
 
 	// marks recommendation for the project with given etag and name claimed
-	MarkRecommendationClaimed(name, etag string) (*gcloudRecommendation, error)
+	MarkRecommendationClaimed(ctx context.Context, name, etag string) (*gcloudRecommendation, error)
 
 	// marks recommendation for the project with given etag and name succeeded
-	MarkRecommendationSucceeded(name, etag string) (*gcloudRecommendation, error)
+	MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*gcloudRecommendation, error)
 
 	// marks recommendation for the project with given etag and name failed
-	MarkRecommendationFailed(name, etag string) (*gcloudRecommendation, error)
+	MarkRecommendationFailed(ctx context.Context, name, etag string) (*gcloudRecommendation, error)
+
+	// marks recommendation for the project with given etag and name dismissed, reason is optional
+	MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error)
+
+	// marks dismissed recommendation for the project with given etag and name active again, reason is optional
+	MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*gcloudRecommendation, error)
+
+	// releases static IP address, region is empty for global addresses
+	ReleaseAddress(ctx context.Context, project, region, address string) error
 
 	// stops the specified instance
-	StopInstance(project, zone, instance string) error
+	StopInstance(ctx context.Context, project, zone, instance string) error
+
+	// gets the specified Cloud SQL instance
+	GetSQLInstance(ctx context.Context, project, instance string) (*sqladmin.DatabaseInstance, error)
+
+	// sets activation policy of the specified Cloud SQL instance
+	SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error
+
+	// changes tier of the specified Cloud SQL instance
+	ChangeSQLTier(ctx context.Context, project, instance, tier string) error
 
 	// starts the specified instance
-	StartInstance(project, zone, instance string) error
+	StartInstance(ctx context.Context, project, zone, instance string) error
 }
 
-// googleService implements GoogleService interface for Recommender and Compute APIs.
+// googleService implements GoogleService interface for Recommender, Compute and Cloud SQL Admin APIs.
+// recommenderClient is used for the Recommender API methods missing in the recommender package.
 type googleService struct {
-	ctx                    context.Context
 	computeService         *compute.Service
 	recommenderService     *recommender.Service
+	recommenderClient      *http.Client
 	resourceManagerService *cloudresourcemanager.Service
 	serviceUsageService    *serviceusage.Service
+	sqlAdminService        *sqladmin.Service
 }
 
 // NewGoogleService creates new googleServices.
 // If creation failed the error will be non-nil.
 func NewGoogleService(ctx context.Context, conf *oauth2.Config, tok *oauth2.Token) (GoogleService, error) {
 	client := conf.Client(ctx, tok)
-	clientOption := option.WithHTTPClient(client)
-	computeService, err := compute.NewService(ctx, clientOption)
+	return NewGoogleServiceWithOptions(ctx, option.WithHTTPClient(client))
+}
+
+// NewGoogleServiceWithOptions creates new googleServices using the given client options,
+// for example option.WithCredentialsFile to authenticate with a service account key file.
+// If no credentials are specified in opts, Application Default Credentials are used.
+// If creation failed the error will be non-nil.
+func NewGoogleServiceWithOptions(ctx context.Context, opts ...option.ClientOption) (GoogleService, error) {
+	computeService, err := compute.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	recommenderService, err := recommender.NewService(ctx, clientOption)
+	recommenderService, err := recommender.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	resourceManagerService, err := cloudresourcemanager.NewService(ctx, clientOption)
+	recommenderClient, _, err := htransport.NewClient(ctx, append([]option.ClientOption{option.WithScopes(recommender.CloudPlatformScope)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
 
-	serviceUsageService, err := serviceusage.NewService(ctx, clientOption)
+	resourceManagerService, err := cloudresourcemanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceUsageService, err := serviceusage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlAdminService, err := sqladmin.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &googleService{
-		ctx:                    ctx,
 		computeService:         computeService,
 		recommenderService:     recommenderService,
+		recommenderClient:      recommenderClient,
 		resourceManagerService: resourceManagerService,
 		serviceUsageService:    serviceUsageService,
+		sqlAdminService:        sqlAdminService,
 	}, nil
 }
 
+// NewEmulatedGoogleService creates googleServices sending the requests of all APIs to endpoint without credentials,
+// with the same paths as Google APIs have, for example to the emulator started by cmd/fake-service.
+// If creation failed the error will be non-nil.
+func NewEmulatedGoogleService(ctx context.Context, endpoint string) (GoogleService, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/"
+	service, err := NewGoogleServiceWithOptions(ctx, option.WithEndpoint(endpoint), option.WithoutAuthentication())
+	if err != nil {
+		return nil, err
+	}
+	// unlike the other APIs, the path of Compute Engine API is a part of its base path
+	service.(*googleService).computeService.BasePath = endpoint + "compute/v1/projects/"
+	return service, nil
+}
+
 const (
 	sleepTimeCreatingSnapshots   = 20 * time.Second
+	sleepTimeCreatingDisks       = 5 * time.Second
 	sleepTimeDeletingDisks       = 5 * time.Second
+	sleepTimeDeletingSnapshots   = 5 * time.Second
 	sleepTimeChangingMachineType = time.Second
 	sleepTimeStoppingInstance    = time.Second
 	sleepTimeStartingInstance    = time.Second
+	sleepTimeReleasingAddress    = time.Second
+	sleepTimeDeletingImage       = 5 * time.Second
+
+	sleepTimeCreatingInstanceTemplate = time.Second
+	sleepTimeDeletingInstanceTemplate = time.Second
+	sleepTimeSettingInstanceTemplate  = time.Second
 )
 
+type requestIDKey struct{}
+
+// WithRequestID returns the context, with which the next Compute Engine method of GoogleService
+// sends requestID, instead of generating it. It lets the caller know the ID of the request,
+// for example to record it in the audit log.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// newRequestID returns the request ID set in ctx by WithRequestID, or a new random one.
+// The same ID is used for retries of a request, so that the request is done at most once.
+func newRequestID(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDKey{}).(string); ok && requestID != "" {
+		return requestID
+	}
+	return uuid.New().String()
+}
+
 // for anonymous functions passed to AwaitCompletion
 type operationGenerator func() (*compute.Operation, error)
 
 // AwaitCompletion takes a function that needs to be called repeatedly
 // to check if a process (some Google Service request) has finished.
 // Such a function is usually constructed by wrapping a requestId(x).Do() call.
-func AwaitCompletion(gen operationGenerator, sleepTime time.Duration) error {
+// Stops waiting and returns the error of ctx, if ctx is done.
+func AwaitCompletion(ctx context.Context, gen operationGenerator, sleepTime time.Duration) error {
 	for {
 		oper, err := gen()
 		if err != nil {
@@ -147,7 +272,9 @@ func AwaitCompletion(gen operationGenerator, sleepTime time.Duration) error {
 		if oper.Status == "DONE" {
 			return nil
 		}
-		time.Sleep(sleepTime)
+		if err := sleep(ctx, sleepTime); err != nil {
+			return err
+		}
 	}
 }
 
@@ -159,8 +286,8 @@ var httpStatusesToRetry = []int{http.StatusTooManyRequests, http.StatusBadGatewa
 // DoRequestWithRetries calls the specified function while it returns error with
 // error code listed in httpStatusesToRetry, and tries again after some time.
 // Stops, when maxSleepTime is reached or code not in in httpStatusesToRetry.
-// Returns the last received result from apiCall.
-func DoRequestWithRetries(call apiCall) error {
+// Returns the last received result from apiCall, or the error of ctx if ctx is done while waiting.
+func DoRequestWithRetries(ctx context.Context, call apiCall) error {
 	sleepTime := 1 * time.Second
 	maxSleepTime := 1 * time.Minute // maximum time we'll try to wait for
 	for {
@@ -173,7 +300,9 @@ func DoRequestWithRetries(call apiCall) error {
 				}
 			}
 			if retry && sleepTime <= maxSleepTime {
-				time.Sleep(sleepTime)
+				if err := sleep(ctx, sleepTime); err != nil {
+					return err
+				}
 				sleepTime *= 2
 				continue
 			}
@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -26,19 +27,22 @@ import (
 )
 
 func TestAwaitCompletionFastFailure(t *testing.T) {
-	err := AwaitCompletion(fastFailingOperationGen, time.Millisecond)
+	ctx := context.Background()
+	err := AwaitCompletion(ctx, fastFailingOperationGen, time.Millisecond)
 	assert.EqualError(t, err, "Oh no")
 }
 
 func TestAwaitingCompletionEndsWithSuccess(t *testing.T) {
+	ctx := context.Background()
 	calledTimes := 0
-	err := AwaitCompletion(longOperationGen(true, &calledTimes), time.Millisecond)
+	err := AwaitCompletion(ctx, longOperationGen(true, &calledTimes), time.Millisecond)
 	assert.Nil(t, err)
 	assert.Equal(t, calledTimes, 3)
 }
 func TestAwaitingCompletionEndsWithFailure(t *testing.T) {
+	ctx := context.Background()
 	calledTimes := 0
-	err := AwaitCompletion(longOperationGen(false, &calledTimes), time.Millisecond)
+	err := AwaitCompletion(ctx, longOperationGen(false, &calledTimes), time.Millisecond)
 	assert.EqualError(t, err, "Oh no")
 	assert.Equal(t, calledTimes, 3)
 }
@@ -64,3 +68,26 @@ func longOperationGen(succeeds bool, genCounter *int) operationGenerator {
 		}
 	}
 }
+
+// Checks that waiting stops as soon as the context is canceled.
+func TestAwaitCompletionCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calledTimes := 0
+	err := AwaitCompletion(ctx, func() (*compute.Operation, error) {
+		calledTimes++
+		cancel()
+		return &compute.Operation{Status: "PROCESSING"}, nil
+	}, time.Hour)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calledTimes)
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := context.Background()
+	first, second := newRequestID(ctx), newRequestID(ctx)
+	assert.NotEmpty(t, first)
+	assert.NotEqual(t, first, second, "New request IDs should be random")
+
+	ctx = WithRequestID(ctx, "my-request")
+	assert.Equal(t, "my-request", newRequestID(ctx), "Request ID from the context should be used")
+}
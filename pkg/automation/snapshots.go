@@ -0,0 +1,205 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Labels set on the snapshots created by Recomator.
+const (
+	// SnapshotCreatorLabel is set to "recomator" on every snapshot created by Recomator.
+	SnapshotCreatorLabel = "created-by"
+	snapshotCreator      = "recomator"
+
+	snapshotRecommendationLabel = "recomator-recommendation"
+	snapshotUserLabel           = "recomator-user"
+	snapshotDateLabel           = "recomator-date"
+	// the date, after which the snapshot is expired
+	snapshotExpiryLabel = "recomator-expires"
+)
+
+// labelDateFormat is the format of dates in labels, which can't contain colons.
+const labelDateFormat = "2006-01-02"
+
+const maxLabelValueLen = 63
+
+// SnapshotOptions configure the snapshots created before deleting disks.
+// Besides Labels, the snapshots are labelled with created-by=recomator,
+// the ID of the recommendation, the user and the date.
+type SnapshotOptions struct {
+	// Description of the snapshots. If empty, it names the disk and the recommendation.
+	Description string
+	// StorageLocations of the snapshots, for example "us" for multi-regional storage in the US.
+	// If empty, the snapshots are stored in the multi-region nearest to the disk.
+	StorageLocations []string
+	// Labels are added to the ones set by Recomator.
+	Labels map[string]string
+	// User is who applies the recommendation.
+	User string
+	// Retention is how long the snapshots are kept. They're labelled with the date,
+	// after which ListExpiredSnapshots lists them. Zero keeps them until deleted manually.
+	Retention time.Duration
+
+	now func() time.Time
+}
+
+// WithUser returns a copy of the options, with which snapshots are labelled with the user.
+// o can be nil, then the default options are used.
+func (o *SnapshotOptions) WithUser(user string) *SnapshotOptions {
+	var result SnapshotOptions
+	if o != nil {
+		result = *o
+	}
+	result.User = user
+	return &result
+}
+
+// ParseSnapshotLabels parses comma-separated labels, for example "team=web,env=prod".
+// Keys and values may contain only lowercase letters, digits, underscores and dashes.
+func ParseSnapshotLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(s, ",") {
+		if label = strings.TrimSpace(label); label == "" {
+			continue
+		}
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label %s, expected key=value", label)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if labelValue(key) != key || labelValue(value) != value {
+			return nil, fmt.Errorf("invalid label %s, only lowercase letters, digits, underscores and dashes are allowed", label)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+type snapshotOptionsKey struct{}
+
+// WithSnapshotOptions returns the context, with which DoOperation creates snapshots configured by options.
+func WithSnapshotOptions(ctx context.Context, options *SnapshotOptions) context.Context {
+	return context.WithValue(ctx, snapshotOptionsKey{}, options)
+}
+
+func snapshotOptionsFromContext(ctx context.Context) *SnapshotOptions {
+	options, _ := ctx.Value(snapshotOptionsKey{}).(*SnapshotOptions)
+	if options == nil {
+		return &SnapshotOptions{}
+	}
+	return options
+}
+
+// labelValue converts s to a valid value of a label,
+// at most 63 lowercase letters, digits, underscores and dashes.
+func labelValue(s string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('-')
+		}
+	}
+	value := builder.String()
+	return value[:min(maxLabelValueLen, len(value))]
+}
+
+// newSnapshot returns the snapshot of the disk with the given name,
+// configured by the options set in ctx by WithSnapshotOptions.
+func newSnapshot(ctx context.Context, disk, name string) *compute.Snapshot {
+	options := snapshotOptionsFromContext(ctx)
+	now := time.Now
+	if options.now != nil {
+		now = options.now
+	}
+	created := now().UTC()
+	recommendation, _, _ := AppliedRecommendation(ctx)
+
+	labels := make(map[string]string)
+	for key, value := range options.Labels {
+		labels[key] = value
+	}
+	labels[SnapshotCreatorLabel] = snapshotCreator
+	labels[snapshotDateLabel] = created.Format(labelDateFormat)
+	if recommendation != "" {
+		labels[snapshotRecommendationLabel] = labelValue(lastPathSegment(recommendation))
+	}
+	if options.User != "" {
+		labels[snapshotUserLabel] = labelValue(options.User)
+	}
+	if options.Retention > 0 {
+		labels[snapshotExpiryLabel] = created.Add(options.Retention).Format(labelDateFormat)
+	}
+
+	description := options.Description
+	if description == "" {
+		description = fmt.Sprintf("Created by Recomator before deleting disk %s", disk)
+		if recommendation != "" {
+			description += fmt.Sprintf(" to apply recommendation %s", recommendation)
+		}
+	}
+	return &compute.Snapshot{
+		Name:             name,
+		Description:      description,
+		Labels:           labels,
+		StorageLocations: options.StorageLocations,
+	}
+}
+
+// ListRecomatorSnapshots lists the snapshots created by Recomator in the project,
+// by the created-by=recomator label.
+func ListRecomatorSnapshots(ctx context.Context, service GoogleService, project string) ([]*compute.Snapshot, error) {
+	return service.ListSnapshots(ctx, project, fmt.Sprintf("labels.%s = %s", SnapshotCreatorLabel, snapshotCreator))
+}
+
+// snapshotExpired checks whether the snapshot is past the date in its expiry label,
+// or, if retention is positive, whether it's been created more than retention before now.
+func snapshotExpired(snapshot *compute.Snapshot, retention time.Duration, now time.Time) bool {
+	if expires, ok := snapshot.Labels[snapshotExpiryLabel]; ok && now.UTC().Format(labelDateFormat) > expires {
+		return true
+	}
+	if retention <= 0 {
+		return false
+	}
+	created, err := time.Parse(time.RFC3339, snapshot.CreationTimestamp)
+	return err == nil && now.Sub(created) >= retention
+}
+
+// ListExpiredSnapshots lists the snapshots created by Recomator in the project,
+// which are past the date in their expiry label, set when SnapshotOptions.Retention was configured,
+// or, if retention is positive, which have been created more than retention ago.
+func ListExpiredSnapshots(ctx context.Context, service GoogleService, project string, retention time.Duration) ([]*compute.Snapshot, error) {
+	snapshots, err := ListRecomatorSnapshots(ctx, service, project)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []*compute.Snapshot
+	for _, snapshot := range snapshots {
+		if snapshotExpired(snapshot, retention, now) {
+			expired = append(expired, snapshot)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestNewSnapshot(t *testing.T) {
+	now := time.Date(2020, 8, 1, 23, 30, 0, 0, time.UTC)
+	options := &SnapshotOptions{
+		StorageLocations: []string{"us"},
+		Labels:           map[string]string{"team": "web"},
+		Retention:        30 * 24 * time.Hour,
+		now:              func() time.Time { return now },
+	}
+	ctx := WithSnapshotOptions(context.Background(), options.WithUser("Alice.Smith@example.com"))
+	ctx = context.WithValue(ctx, appliedRecommendationKey{},
+		appliedRecommendation{name: "projects/p/locations/us-east1-b/recommenders/r/recommendations/0d9c3b47-5a43"})
+
+	snapshot := newSnapshot(ctx, "disk", "snap")
+	assert.Equal(t, "snap", snapshot.Name)
+	assert.Equal(t, []string{"us"}, snapshot.StorageLocations)
+	assert.Contains(t, snapshot.Description, "disk")
+	expectedLabels := map[string]string{
+		"team":                      "web",
+		SnapshotCreatorLabel:        "recomator",
+		snapshotRecommendationLabel: "0d9c3b47-5a43",
+		snapshotUserLabel:           "alice-smith-example-com",
+		snapshotDateLabel:           "2020-08-01",
+		snapshotExpiryLabel:         "2020-08-31",
+	}
+	assert.Equal(t, expectedLabels, snapshot.Labels)
+	assert.Empty(t, options.User, "Options should be copied by WithUser")
+
+	snapshot = newSnapshot(context.Background(), "disk", "snap")
+	assert.Equal(t, "recomator", snapshot.Labels[SnapshotCreatorLabel], "Snapshots should always be labelled")
+	assert.NotContains(t, snapshot.Labels, snapshotExpiryLabel, "Snapshots shouldn't expire by default")
+}
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "scheduler", labelValue("scheduler"))
+	assert.Equal(t, "bob-example-com", labelValue("Bob@example.com"))
+	assert.Len(t, labelValue(string(make([]byte, 100))), maxLabelValueLen)
+}
+
+func TestParseSnapshotLabels(t *testing.T) {
+	labels, err := ParseSnapshotLabels("team=web, env=prod,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "web", "env": "prod"}, labels)
+
+	for _, invalid := range []string{"team", "=web", "Team=web", "team=web app"} {
+		_, err := ParseSnapshotLabels(invalid)
+		assert.Error(t, err, "%s should be invalid", invalid)
+	}
+}
+
+type SnapshotsMockService struct {
+	GoogleService
+	filter    string
+	snapshots []*compute.Snapshot
+}
+
+func (s *SnapshotsMockService) ListSnapshots(ctx context.Context, project, filter string) ([]*compute.Snapshot, error) {
+	s.filter = filter
+	return s.snapshots, nil
+}
+
+func TestListExpiredSnapshots(t *testing.T) {
+	now := time.Now()
+	tomorrow := now.AddDate(0, 0, 1).UTC().Format(labelDateFormat)
+	yesterday := now.AddDate(0, 0, -1).UTC().Format(labelDateFormat)
+	service := &SnapshotsMockService{snapshots: []*compute.Snapshot{
+		{Name: "new", CreationTimestamp: now.Format(time.RFC3339)},
+		{Name: "old", CreationTimestamp: now.AddDate(0, 0, -40).Format(time.RFC3339)},
+		{Name: "expired", CreationTimestamp: now.Format(time.RFC3339), Labels: map[string]string{snapshotExpiryLabel: yesterday}},
+		{Name: "expires-tomorrow", CreationTimestamp: now.Format(time.RFC3339), Labels: map[string]string{snapshotExpiryLabel: tomorrow}},
+	}}
+
+	names := func(snapshots []*compute.Snapshot) []string {
+		var result []string
+		for _, snapshot := range snapshots {
+			result = append(result, snapshot.Name)
+		}
+		return result
+	}
+
+	expired, err := ListExpiredSnapshots(context.Background(), service, "project", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "labels.created-by = recomator", service.filter, "Only snapshots created by Recomator should be listed")
+	assert.Equal(t, []string{"expired"}, names(expired), "Without retention only expiry labels should be checked")
+
+	expired, err = ListExpiredSnapshots(context.Background(), service, "project", 30*24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old", "expired"}, names(expired))
+}
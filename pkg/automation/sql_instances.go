@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package automation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+const sleepTimePatchingSQLInstance = 5 * time.Second
+
+// GetSQLInstance gets Cloud SQL instance using instances.get method.
+// Requires cloudsql.instances.get permission.
+func (s *googleService) GetSQLInstance(ctx context.Context, project, instance string) (*sqladmin.DatabaseInstance, error) {
+	var instanceVal *sqladmin.DatabaseInstance
+	err := DoRequestWithRetries(ctx, func() error {
+		inst, err := s.sqlAdminService.Instances.Get(project, instance).Context(ctx).Do()
+		instanceVal = inst
+		return err
+	})
+	return instanceVal, err
+}
+
+// SetSQLActivationPolicy changes activation policy of Cloud SQL instance
+// using instances.patch method. Setting it to NEVER stops the instance.
+// Requires cloudsql.instances.update permission.
+func (s *googleService) SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error {
+	return s.patchSQLInstance(ctx, project, instance, &sqladmin.DatabaseInstance{
+		Settings: &sqladmin.Settings{ActivationPolicy: policy},
+	})
+}
+
+// ChangeSQLTier changes tier (machine type) of Cloud SQL instance using instances.patch method.
+// Requires cloudsql.instances.update permission.
+func (s *googleService) ChangeSQLTier(ctx context.Context, project, instance, tier string) error {
+	return s.patchSQLInstance(ctx, project, instance, &sqladmin.DatabaseInstance{
+		Settings: &sqladmin.Settings{Tier: tier},
+	})
+}
+
+// patchSQLInstance patches the instance and waits until the operation is done.
+// Unlike Compute API, Cloud SQL Admin API doesn't support request ids,
+// so the patch request itself is not retried after it has been accepted.
+func (s *googleService) patchSQLInstance(ctx context.Context, project, instance string, patch *sqladmin.DatabaseInstance) error {
+	var operation *sqladmin.Operation
+	err := DoRequestWithRetries(ctx, func() error {
+		oper, err := s.sqlAdminService.Instances.Patch(project, instance, patch).Context(ctx).Do()
+		operation = oper
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for operation.Status != "DONE" {
+		if err := sleep(ctx, sleepTimePatchingSQLInstance); err != nil {
+			return err
+		}
+		err = DoRequestWithRetries(ctx, func() error {
+			oper, err := s.sqlAdminService.Operations.Get(project, operation.Name).Context(ctx).Do()
+			if err == nil {
+				operation = oper
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if operation.Error != nil && len(operation.Error.Errors) != 0 {
+		return errors.New(operation.Error.Errors[0].Message)
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package automation
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"regexp"
@@ -70,6 +71,39 @@ func extractFromURL(url, parameterName string) (string, error) {
 	return partialResult[1], nil
 }
 
+// Waits for the given duration or until ctx is done,
+// in which case the error of ctx is returned.
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// detachedContext keeps the values of its parent, but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// Returns a context that is not canceled when ctx is.
+// It's used for calls that must finish even if the request has been canceled,
+// for example starting an instance that has been stopped.
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
 // Given a list of errors, the function returns one
 // that is not nil. If all of them are nil, then it returns nil
 func chooseNotNil(errorList ...error) error {
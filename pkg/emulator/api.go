@@ -0,0 +1,205 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package emulator
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is an error returned in the format of Google APIs, so that it's parsed into *googleapi.Error.
+type apiError struct {
+	code    int
+	status  string // for example NOT_FOUND
+	reason  string // for example notFound
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func notFound(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, "NOT_FOUND", "notFound", fmt.Sprintf(format, args...)}
+}
+
+func alreadyExists(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusConflict, "ALREADY_EXISTS", "alreadyExists", fmt.Sprintf(format, args...)}
+}
+
+func invalidArgument(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "INVALID_ARGUMENT", "invalid", fmt.Sprintf(format, args...)}
+}
+
+func failedPrecondition(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "FAILED_PRECONDITION", "failedPrecondition", fmt.Sprintf(format, args...)}
+}
+
+func permissionDenied(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusForbidden, "PERMISSION_DENIED", "forbidden", fmt.Sprintf(format, args...)}
+}
+
+// writeError sends err in the format of Google APIs.
+func writeError(c *gin.Context, err *apiError) {
+	c.JSON(err.code, gin.H{"error": gin.H{
+		"code":    err.code,
+		"message": err.message,
+		"status":  err.status,
+		"errors":  []gin.H{{"message": err.message, "reason": err.reason}},
+	}})
+}
+
+const (
+	computeURL     = "https://www.googleapis.com/compute/v1/"
+	globalLocation = "global"
+	// defaultPageSize is the number of items in a page of a list, if the page size isn't given
+	defaultPageSize = 500
+)
+
+func projectLink(project string) string {
+	return computeURL + "projects/" + project
+}
+
+func zoneLink(project, zone string) string {
+	return projectLink(project) + "/zones/" + zone
+}
+
+func regionLink(project, region string) string {
+	return projectLink(project) + "/regions/" + region
+}
+
+func globalLink(project string) string {
+	return projectLink(project) + "/global"
+}
+
+// machineTypeLink returns the URL of the machine type given by its name, partial or full URL.
+func machineTypeLink(project, zone, machineType string) string {
+	return zoneLink(project, zone) + "/machineTypes/" + lastSegment(machineType)
+}
+
+// lastSegment returns the part of the path after the last slash, for example the name of a resource.
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// page returns the bounds of the page of n items starting at pageToken, which is the index of its first item,
+// and the token of the next page, empty if it's the last one. The page size is given by the sizeParam query parameter.
+func page(c *gin.Context, n int, sizeParam string) (start, end int, nextPageToken string, err *apiError) {
+	size := defaultPageSize
+	if value := c.Query(sizeParam); value != "" {
+		if size, _ = strconv.Atoi(value); size <= 0 {
+			return 0, 0, "", invalidArgument("invalid %s: %s", sizeParam, value)
+		}
+	}
+	if token := c.Query("pageToken"); token != "" {
+		var convErr error
+		if start, convErr = strconv.Atoi(token); convErr != nil || start < 0 || start > n {
+			return 0, 0, "", invalidArgument("invalid page token: %s", token)
+		}
+	}
+	end = start + size
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+// filterTerm is a comparison of a field to a value in a filter,
+// for example stateInfo.state = ACTIVE, or labels.env:dev in Resource Manager API.
+type filterTerm struct {
+	field    string
+	negation bool
+	value    string
+}
+
+var (
+	filterTermRegexp      = regexp.MustCompile(`^\(?\s*([\w.-]+)\s*(=|!=|:)\s*"?([^\s()"]*)"?\s*\)?$`)
+	filterSeparatorRegexp = regexp.MustCompile(`\s+AND\s+|\)\s*\(|\s+`)
+)
+
+// parseFilter parses the filter into alternatives of conjunctions of terms,
+// for example "stateInfo.state = ACTIVE OR stateInfo.state = CLAIMED",
+// or "(labels.env = dev) (labels.team = web)". Parentheses can't be nested.
+func parseFilter(filter string) ([][]filterTerm, *apiError) {
+	var alternatives [][]filterTerm
+	for _, alternative := range strings.Split(filter, " OR ") {
+		var terms []filterTerm
+		// joins the parts of each term split by spaces around the operator
+		var parts []string
+		for _, part := range filterSeparatorRegexp.Split(strings.TrimSpace(alternative), -1) {
+			if part == "" {
+				continue
+			}
+			n := len(parts)
+			if n > 0 && (strings.HasSuffix(parts[n-1], "=") || strings.HasSuffix(parts[n-1], ":") ||
+				strings.HasPrefix(part, "=") || strings.HasPrefix(part, "!=") || strings.HasPrefix(part, ":")) {
+				parts[n-1] += part
+				continue
+			}
+			parts = append(parts, part)
+		}
+		for _, part := range parts {
+			match := filterTermRegexp.FindStringSubmatch(part)
+			if match == nil {
+				return nil, invalidArgument("invalid filter: %s", filter)
+			}
+			terms = append(terms, filterTerm{field: match[1], negation: match[2] == "!=", value: match[3]})
+		}
+		if len(terms) == 0 {
+			return nil, invalidArgument("invalid filter: %s", filter)
+		}
+		alternatives = append(alternatives, terms)
+	}
+	return alternatives, nil
+}
+
+// matchesFilter returns whether the item, whose fields are got by field, matches the parsed filter.
+// Returns an error if a field isn't supported. Value * matches any non-empty field.
+func matchesFilter(alternatives [][]filterTerm, field func(name string) (string, bool)) (bool, *apiError) {
+	if len(alternatives) == 0 {
+		return true, nil
+	}
+	matches := false
+	for _, terms := range alternatives {
+		all := true
+		for _, term := range terms {
+			value, ok := field(term.field)
+			if !ok {
+				return false, invalidArgument("invalid filter: unsupported field %s", term.field)
+			}
+			equal := value == term.value || (term.value == "*" && value != "")
+			if equal == term.negation {
+				all = false
+			}
+		}
+		matches = matches || all
+	}
+	return matches, nil
+}
+
+// labelField returns the value of the label, if name is labels.KEY.
+func labelField(labels map[string]string, name string) (string, bool) {
+	if strings.HasPrefix(name, "labels.") {
+		return labels[strings.TrimPrefix(name, "labels.")], true
+	}
+	return "", false
+}
@@ -0,0 +1,524 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package emulator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/api/compute/v1"
+)
+
+// registerCompute adds the routes of Compute Engine API used by automation.GoogleService.
+func (e *Emulator) registerCompute(router gin.IRouter) {
+	router.GET("/compute/v1/projects/:project/zones", e.listZones)
+	router.GET("/compute/v1/projects/:project/regions", e.listRegions)
+
+	router.GET("/compute/v1/projects/:project/zones/:zone/instances", e.listInstances)
+	router.GET("/compute/v1/projects/:project/zones/:zone/instances/:name", e.getInstance)
+	router.POST("/compute/v1/projects/:project/zones/:zone/instances/:name/stop", e.setInstanceStatus("stop", "TERMINATED"))
+	router.POST("/compute/v1/projects/:project/zones/:zone/instances/:name/start", e.setInstanceStatus("start", "RUNNING"))
+	router.POST("/compute/v1/projects/:project/zones/:zone/instances/:name/setMachineType", e.setMachineType)
+
+	router.GET("/compute/v1/projects/:project/zones/:zone/disks", e.listDisks)
+	router.POST("/compute/v1/projects/:project/zones/:zone/disks", e.insertDisk)
+	router.GET("/compute/v1/projects/:project/zones/:zone/disks/:name", e.getDisk)
+	router.DELETE("/compute/v1/projects/:project/zones/:zone/disks/:name", e.deleteDisk)
+	router.POST("/compute/v1/projects/:project/zones/:zone/disks/:name/createSnapshot", e.createSnapshot)
+
+	router.GET("/compute/v1/projects/:project/global/snapshots", e.listSnapshots)
+	router.GET("/compute/v1/projects/:project/global/snapshots/:name", e.getSnapshot)
+	router.DELETE("/compute/v1/projects/:project/global/snapshots/:name", e.deleteSnapshot)
+
+	router.GET("/compute/v1/projects/:project/global/images/:name", e.getImage)
+	router.DELETE("/compute/v1/projects/:project/global/images/:name", e.deleteImage)
+	router.GET("/compute/v1/projects/:project/global/addresses/:name", e.getAddress)
+	router.DELETE("/compute/v1/projects/:project/global/addresses/:name", e.deleteAddress)
+	router.GET("/compute/v1/projects/:project/regions/:region/addresses/:name", e.getAddress)
+	router.DELETE("/compute/v1/projects/:project/regions/:region/addresses/:name", e.deleteAddress)
+
+	router.GET("/compute/v1/projects/:project/zones/:zone/operations/:name", e.getOperation)
+	router.GET("/compute/v1/projects/:project/regions/:region/operations/:name", e.getOperation)
+	router.GET("/compute/v1/projects/:project/global/operations/:name", e.getOperation)
+}
+
+// location returns the zone or the region in the path, or global.
+func location(c *gin.Context) string {
+	if zone := c.Param("zone"); zone != "" {
+		return zone
+	}
+	if region := c.Param("region"); region != "" {
+		return region
+	}
+	return globalLocation
+}
+
+// locationLink returns the URL of the zone or the region in the path, or of the global scope.
+func locationLink(c *gin.Context, project string) string {
+	if zone := c.Param("zone"); zone != "" {
+		return zoneLink(project, zone)
+	}
+	if region := c.Param("region"); region != "" {
+		return regionLink(project, region)
+	}
+	return globalLink(project)
+}
+
+// runOperation calls do and responds with a finished operation of the given type on the target.
+// If the request ID has been used before, the operation started by it is returned, and do is not called again.
+// If do fails, its error is sent instead. e.mutex must be held.
+func (e *Emulator) runOperation(c *gin.Context, project, operationType, targetLink string, do func() *apiError) {
+	requestID := c.Query("requestId")
+	if operation, ok := e.requestOperations[requestID]; ok && requestID != "" {
+		c.JSON(http.StatusOK, operation)
+		return
+	}
+	if err := do(); err != nil {
+		writeError(c, err)
+		return
+	}
+	now := timestamp(time.Now())
+	name := "operation-" + uuid.New().String()
+	operation := &compute.Operation{
+		Kind:          "compute#operation",
+		Name:          name,
+		OperationType: operationType,
+		TargetLink:    targetLink,
+		Status:        "DONE",
+		Progress:      100,
+		InsertTime:    now,
+		StartTime:     now,
+		EndTime:       now,
+		SelfLink:      locationLink(c, project) + "/operations/" + name,
+	}
+	if zone := c.Param("zone"); zone != "" {
+		operation.Zone = zoneLink(project, zone)
+	}
+	if region := c.Param("region"); region != "" {
+		operation.Region = regionLink(project, region)
+	}
+	e.operations[key(project, location(c), name)] = operation
+	if requestID != "" {
+		e.requestOperations[requestID] = operation
+	}
+	c.JSON(http.StatusOK, operation)
+}
+
+func (e *Emulator) getOperation(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	operation, ok := e.operations[key(project, location(c), c.Param("name"))]
+	if !ok {
+		writeError(c, notFound("The resource '%s/operations/%s' was not found", locationLink(c, project), c.Param("name")))
+		return
+	}
+	c.JSON(http.StatusOK, operation)
+}
+
+func (e *Emulator) listZones(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	var zones []*compute.Zone
+	for region, regionZones := range e.regions {
+		for _, zone := range regionZones {
+			zones = append(zones, &compute.Zone{
+				Kind:     "compute#zone",
+				Name:     zone,
+				Region:   regionLink(project, region),
+				Status:   "UP",
+				SelfLink: zoneLink(project, zone),
+			})
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	start, end, nextPageToken, err := page(c, len(zones), "maxResults")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &compute.ZoneList{Kind: "compute#zoneList", Items: zones[start:end], NextPageToken: nextPageToken})
+}
+
+func (e *Emulator) listRegions(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	var regions []*compute.Region
+	for region, regionZones := range e.regions {
+		var zones []string
+		for _, zone := range regionZones {
+			zones = append(zones, zoneLink(project, zone))
+		}
+		regions = append(regions, &compute.Region{
+			Kind:     "compute#region",
+			Name:     region,
+			Zones:    zones,
+			Status:   "UP",
+			SelfLink: regionLink(project, region),
+		})
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Name < regions[j].Name })
+	start, end, nextPageToken, err := page(c, len(regions), "maxResults")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &compute.RegionList{Kind: "compute#regionList", Items: regions[start:end], NextPageToken: nextPageToken})
+}
+
+// resourceNotFound returns the error of a missing resource in the collection of the location in the path.
+func resourceNotFound(c *gin.Context, project, collection string) *apiError {
+	return notFound("The resource '%s/%s/%s' was not found", locationLink(c, project), collection, c.Param("name"))
+}
+
+// sortedKeys returns the keys starting with prefix in ascending order.
+func sortedKeys(keys []string, prefix string) []string {
+	var result []string
+	for _, k := range keys {
+		if strings.HasPrefix(k, prefix) {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (e *Emulator) listInstances(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	var keys []string
+	for k := range e.instances {
+		keys = append(keys, k)
+	}
+	keys = sortedKeys(keys, key(project, c.Param("zone"), ""))
+	start, end, nextPageToken, err := page(c, len(keys), "maxResults")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	list := &compute.InstanceList{Kind: "compute#instanceList", NextPageToken: nextPageToken}
+	for _, k := range keys[start:end] {
+		list.Items = append(list.Items, e.instances[k])
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (e *Emulator) getInstance(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	instance, ok := e.instances[key(project, c.Param("zone"), c.Param("name"))]
+	if !ok {
+		writeError(c, resourceNotFound(c, project, "instances"))
+		return
+	}
+	c.JSON(http.StatusOK, instance)
+}
+
+// setInstanceStatus returns the handler of the method, which sets the status of the instance.
+func (e *Emulator) setInstanceStatus(method, status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		project := e.projectID(c.Param("project"))
+		instance, ok := e.instances[key(project, c.Param("zone"), c.Param("name"))]
+		if !ok {
+			writeError(c, resourceNotFound(c, project, "instances"))
+			return
+		}
+		e.runOperation(c, project, method, instance.SelfLink, func() *apiError {
+			instance.Status = status
+			return nil
+		})
+	}
+}
+
+func (e *Emulator) setMachineType(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	instance, ok := e.instances[key(project, c.Param("zone"), c.Param("name"))]
+	if !ok {
+		writeError(c, resourceNotFound(c, project, "instances"))
+		return
+	}
+	var request compute.InstancesSetMachineTypeRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.MachineType == "" {
+		writeError(c, invalidArgument("Invalid value for field 'resource.machineType'"))
+		return
+	}
+	e.runOperation(c, project, "setMachineType", instance.SelfLink, func() *apiError {
+		if instance.Status != "TERMINATED" {
+			return failedPrecondition("The resource '%s' is not ready", instance.SelfLink)
+		}
+		instance.MachineType = machineTypeLink(project, c.Param("zone"), request.MachineType)
+		return nil
+	})
+}
+
+func (e *Emulator) listDisks(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	var keys []string
+	for k := range e.disks {
+		keys = append(keys, k)
+	}
+	keys = sortedKeys(keys, key(project, c.Param("zone"), ""))
+	start, end, nextPageToken, err := page(c, len(keys), "maxResults")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	list := &compute.DiskList{Kind: "compute#diskList", NextPageToken: nextPageToken}
+	for _, k := range keys[start:end] {
+		list.Items = append(list.Items, e.disks[k])
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (e *Emulator) getDisk(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	disk, ok := e.disks[key(project, c.Param("zone"), c.Param("name"))]
+	if !ok {
+		writeError(c, resourceNotFound(c, project, "disks"))
+		return
+	}
+	c.JSON(http.StatusOK, disk)
+}
+
+// insertDisk creates a blank disk, or a disk from the snapshot in sourceSnapshot.
+func (e *Emulator) insertDisk(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	zone := c.Param("zone")
+	var disk compute.Disk
+	if err := c.ShouldBindJSON(&disk); err != nil || disk.Name == "" {
+		writeError(c, invalidArgument("Invalid value for field 'resource.name'"))
+		return
+	}
+	e.runOperation(c, project, "insert", zoneLink(project, zone)+"/disks/"+disk.Name, func() *apiError {
+		if _, ok := e.disks[key(project, zone, disk.Name)]; ok {
+			return alreadyExists("The resource '%s/disks/%s' already exists", zoneLink(project, zone), disk.Name)
+		}
+		if disk.SourceSnapshot != "" {
+			snapshotProject := project
+			if i := strings.Index(disk.SourceSnapshot, "projects/"); i >= 0 {
+				snapshotProject = e.projectID(strings.Split(disk.SourceSnapshot[i:], "/")[1])
+			}
+			snapshot, ok := e.snapshots[key(snapshotProject, globalLocation, lastSegment(disk.SourceSnapshot))]
+			if !ok {
+				return notFound("The resource '%s' was not found", disk.SourceSnapshot)
+			}
+			if disk.SizeGb == 0 {
+				disk.SizeGb = snapshot.DiskSizeGb
+			}
+			disk.SourceSnapshot = snapshot.SelfLink
+		}
+		disk.Status = ""
+		disk.CreationTimestamp = ""
+		disk.Users = nil
+		e.putDisk(project, zone, &disk)
+		return nil
+	})
+}
+
+func (e *Emulator) deleteDisk(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	k := key(project, c.Param("zone"), c.Param("name"))
+	disk, ok := e.disks[k]
+	e.runOperation(c, project, "delete", zoneLink(project, c.Param("zone"))+"/disks/"+c.Param("name"), func() *apiError {
+		if !ok {
+			return resourceNotFound(c, project, "disks")
+		}
+		if len(disk.Users) != 0 {
+			return &apiError{http.StatusBadRequest, "FAILED_PRECONDITION", "resourceInUseByAnotherResource",
+				fmt.Sprintf("The disk resource '%s' is already being used by '%s'", disk.SelfLink, disk.Users[0])}
+		}
+		delete(e.disks, k)
+		return nil
+	})
+}
+
+// createSnapshot creates a snapshot of the disk, which is READY at once.
+func (e *Emulator) createSnapshot(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	disk, ok := e.disks[key(project, c.Param("zone"), c.Param("name"))]
+	if !ok {
+		writeError(c, resourceNotFound(c, project, "disks"))
+		return
+	}
+	var snapshot compute.Snapshot
+	if err := c.ShouldBindJSON(&snapshot); err != nil || snapshot.Name == "" {
+		writeError(c, invalidArgument("Invalid value for field 'resource.name'"))
+		return
+	}
+	e.runOperation(c, project, "createSnapshot", disk.SelfLink, func() *apiError {
+		if _, ok := e.snapshots[key(project, globalLocation, snapshot.Name)]; ok {
+			return alreadyExists("The resource '%s/snapshots/%s' already exists", globalLink(project), snapshot.Name)
+		}
+		snapshot.SourceDisk = disk.SelfLink
+		snapshot.DiskSizeGb = disk.SizeGb
+		snapshot.StorageBytes = disk.SizeGb << 30
+		if len(snapshot.StorageLocations) == 0 {
+			snapshot.StorageLocations = []string{regionOf(c.Param("zone"))}
+		}
+		snapshot.Status = ""
+		snapshot.CreationTimestamp = ""
+		e.putSnapshot(project, &snapshot)
+		return nil
+	})
+}
+
+// listSnapshots lists snapshots matching the filter, which may compare name, status and labels.
+func (e *Emulator) listSnapshots(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	var filter [][]filterTerm
+	if value := c.Query("filter"); value != "" {
+		var err *apiError
+		if filter, err = parseFilter(value); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	var keys []string
+	for k, snapshot := range e.snapshots {
+		matches, err := matchesFilter(filter, func(name string) (string, bool) {
+			switch name {
+			case "name":
+				return snapshot.Name, true
+			case "status":
+				return snapshot.Status, true
+			}
+			return labelField(snapshot.Labels, name)
+		})
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if matches {
+			keys = append(keys, k)
+		}
+	}
+	keys = sortedKeys(keys, key(project, globalLocation, ""))
+	start, end, nextPageToken, err := page(c, len(keys), "maxResults")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	list := &compute.SnapshotList{Kind: "compute#snapshotList", NextPageToken: nextPageToken}
+	for _, k := range keys[start:end] {
+		list.Items = append(list.Items, e.snapshots[k])
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func (e *Emulator) getSnapshot(c *gin.Context) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	snapshot, ok := e.snapshots[key(project, globalLocation, c.Param("name"))]
+	if !ok {
+		writeError(c, resourceNotFound(c, project, "snapshots"))
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (e *Emulator) deleteSnapshot(c *gin.Context) {
+	e.deleteResource(c, "snapshots", func(k string) bool {
+		_, ok := e.snapshots[k]
+		delete(e.snapshots, k)
+		return ok
+	})
+}
+
+func (e *Emulator) getImage(c *gin.Context) {
+	e.getResource(c, "images", func(k string) (interface{}, bool) {
+		image, ok := e.images[k]
+		return image, ok
+	})
+}
+
+func (e *Emulator) deleteImage(c *gin.Context) {
+	e.deleteResource(c, "images", func(k string) bool {
+		_, ok := e.images[k]
+		delete(e.images, k)
+		return ok
+	})
+}
+
+func (e *Emulator) getAddress(c *gin.Context) {
+	e.getResource(c, "addresses", func(k string) (interface{}, bool) {
+		address, ok := e.addresses[k]
+		return address, ok
+	})
+}
+
+func (e *Emulator) deleteAddress(c *gin.Context) {
+	e.deleteResource(c, "addresses", func(k string) bool {
+		_, ok := e.addresses[k]
+		delete(e.addresses, k)
+		return ok
+	})
+}
+
+// getResource responds with the resource named in the path, found by lookup.
+func (e *Emulator) getResource(c *gin.Context, collection string, lookup func(k string) (interface{}, bool)) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	resource, ok := lookup(key(project, location(c), c.Param("name")))
+	if !ok {
+		writeError(c, resourceNotFound(c, project, collection))
+		return
+	}
+	c.JSON(http.StatusOK, resource)
+}
+
+// deleteResource deletes the resource named in the path with remove, which returns whether it existed.
+// Repeated requests with the same request ID return the same operation.
+func (e *Emulator) deleteResource(c *gin.Context, collection string, remove func(k string) bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	project := e.projectID(c.Param("project"))
+	k := key(project, location(c), c.Param("name"))
+	targetLink := locationLink(c, project) + "/" + collection + "/" + c.Param("name")
+	e.runOperation(c, project, "delete", targetLink, func() *apiError {
+		if !remove(k) {
+			return resourceNotFound(c, project, collection)
+		}
+		return nil
+	})
+}
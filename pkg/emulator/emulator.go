@@ -0,0 +1,415 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package emulator provides an in-memory stand-in for the parts of Recommender, Compute Engine,
+// Resource Manager and Service Usage APIs used by automation.GoogleService.
+// automation.NewEmulatedGoogleService creates the service sending requests to it,
+// so that recommendations can be listed and applied without GCP.
+package emulator
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/recommender/v1"
+)
+
+type (
+	gcloudRecommendation = recommender.GoogleCloudRecommenderV1Recommendation
+	gcloudInsight        = recommender.GoogleCloudRecommenderV1Insight
+)
+
+// defaultRegions are the regions and zones listed for every project,
+// zones of added resources are listed too.
+var defaultRegions = map[string][]string{
+	"us-central1":  {"us-central1-a", "us-central1-b", "us-central1-c", "us-central1-f"},
+	"us-east1":     {"us-east1-b", "us-east1-c", "us-east1-d"},
+	"europe-west1": {"europe-west1-b", "europe-west1-c", "europe-west1-d"},
+}
+
+// Emulator keeps the state of the emulated APIs in memory.
+// All its methods are safe for concurrent use.
+type Emulator struct {
+	mutex    sync.Mutex
+	projects []*cloudresourcemanager.Project
+	regions  map[string][]string // zones of each region
+	// compute resources, the keys are built by key from the project, the location and the name
+	instances map[string]*compute.Instance
+	disks     map[string]*compute.Disk
+	snapshots map[string]*compute.Snapshot
+	addresses map[string]*compute.Address
+	images    map[string]*compute.Image
+	// operations by their names, and by the request IDs of the calls that started them
+	operations        map[string]*compute.Operation
+	requestOperations map[string]*compute.Operation
+	recommendations   map[string]*gcloudRecommendation
+	insights          map[string]*gcloudInsight
+	// priorities of recommendations by their names, they're missing in gcloudRecommendation
+	priorities map[string]string
+	// services disabled and permissions denied in each project, by project ID
+	disabledServices  map[string]map[string]bool
+	deniedPermissions map[string]map[string]bool
+}
+
+// New creates an Emulator without any projects or resources.
+func New() *Emulator {
+	e := &Emulator{
+		regions:           make(map[string][]string),
+		instances:         make(map[string]*compute.Instance),
+		disks:             make(map[string]*compute.Disk),
+		snapshots:         make(map[string]*compute.Snapshot),
+		addresses:         make(map[string]*compute.Address),
+		images:            make(map[string]*compute.Image),
+		operations:        make(map[string]*compute.Operation),
+		requestOperations: make(map[string]*compute.Operation),
+		recommendations:   make(map[string]*gcloudRecommendation),
+		insights:          make(map[string]*gcloudInsight),
+		priorities:        make(map[string]string),
+		disabledServices:  make(map[string]map[string]bool),
+		deniedPermissions: make(map[string]map[string]bool),
+	}
+	for region, zones := range defaultRegions {
+		e.regions[region] = append([]string(nil), zones...)
+	}
+	return e
+}
+
+// Handler returns the handler serving the REST APIs at the same paths as Google APIs have,
+// Compute Engine API under /compute/v1/ and the other APIs under /v1/.
+func (e *Emulator) Handler() http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	e.registerCompute(router)
+	router.GET("/v1/*path", e.handleV1)
+	router.POST("/v1/*path", e.handleV1)
+	return router
+}
+
+// key returns the key of a resource in the maps of Emulator.
+func key(project, location, name string) string {
+	return project + "/" + location + "/" + name
+}
+
+// regionOf returns the region of the zone, for example us-central1 for us-central1-a.
+func regionOf(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i >= 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// addZone adds the zone, if it's not listed yet, e.mutex must be held.
+func (e *Emulator) addZone(zone string) {
+	region := regionOf(zone)
+	for _, z := range e.regions[region] {
+		if z == zone {
+			return
+		}
+	}
+	e.regions[region] = append(e.regions[region], zone)
+}
+
+// newEtag returns a random etag of a recommendation.
+func newEtag() string {
+	return fmt.Sprintf("\"%016x\"", rand.Uint64())
+}
+
+// timestamp formats t like timestamps of Compute Engine resources.
+func timestamp(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+// AddProject adds the project, which is listed by Resource Manager API.
+// Recommendations may refer to it by its ID or number. If number is empty, a random one is generated.
+func (e *Emulator) AddProject(id, number string, labels map[string]string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	projectNumber := rand.Int63n(900000000000) + 100000000000
+	if number != "" {
+		projectNumber, _ = strconv.ParseInt(number, 10, 64)
+	}
+	e.projects = append(e.projects, &cloudresourcemanager.Project{
+		ProjectId:      id,
+		ProjectNumber:  projectNumber,
+		Name:           id,
+		Labels:         labels,
+		LifecycleState: "ACTIVE",
+	})
+}
+
+// findProject returns the project with the given ID or number, e.mutex must be held.
+func (e *Emulator) findProject(project string) (*cloudresourcemanager.Project, bool) {
+	for _, p := range e.projects {
+		if p.ProjectId == project || strconv.FormatInt(p.ProjectNumber, 10) == project {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// projectID returns the ID of the project with the given ID or number,
+// or project itself if such a project hasn't been added. e.mutex must be held.
+func (e *Emulator) projectID(project string) string {
+	if p, ok := e.findProject(project); ok {
+		return p.ProjectId
+	}
+	return project
+}
+
+// DisableService makes Service Usage API report the service, for example compute.googleapis.com,
+// as disabled in the project.
+func (e *Emulator) DisableService(project, service string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.disabledServices[project] == nil {
+		e.disabledServices[project] = make(map[string]bool)
+	}
+	e.disabledServices[project][service] = true
+}
+
+// DenyPermissions makes Resource Manager API report the permissions as not granted in the project.
+// Other permissions are granted.
+func (e *Emulator) DenyPermissions(project string, permissions ...string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.deniedPermissions[project] == nil {
+		e.deniedPermissions[project] = make(map[string]bool)
+	}
+	for _, permission := range permissions {
+		e.deniedPermissions[project][permission] = true
+	}
+}
+
+// AddInstance adds the instance in the zone. If they're empty, its status is set to RUNNING
+// and its machine type to n1-standard-1. The machine type may be given as its name.
+func (e *Emulator) AddInstance(project, zone string, instance *compute.Instance) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.addZone(zone)
+	i := *instance
+	i.Kind = "compute#instance"
+	i.Zone = zoneLink(project, zone)
+	i.SelfLink = zoneLink(project, zone) + "/instances/" + i.Name
+	if i.Status == "" {
+		i.Status = "RUNNING"
+	}
+	if i.MachineType == "" {
+		i.MachineType = "n1-standard-1"
+	}
+	i.MachineType = machineTypeLink(project, zone, i.MachineType)
+	if i.CreationTimestamp == "" {
+		i.CreationTimestamp = timestamp(time.Now())
+	}
+	e.instances[key(project, zone, i.Name)] = &i
+}
+
+// AddDisk adds the disk in the zone. If they're empty, its type is set to pd-standard,
+// its size to 10 GB and its creation time to 30 days ago, so that it may be deleted.
+func (e *Emulator) AddDisk(project, zone string, disk *compute.Disk) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.addZone(zone)
+	d := *disk
+	if d.CreationTimestamp == "" {
+		d.CreationTimestamp = timestamp(time.Now().AddDate(0, 0, -30))
+	}
+	e.putDisk(project, zone, &d)
+}
+
+// putDisk fills the defaults of the disk and stores it, e.mutex must be held.
+func (e *Emulator) putDisk(project, zone string, d *compute.Disk) {
+	d.Kind = "compute#disk"
+	d.Zone = zoneLink(project, zone)
+	d.SelfLink = zoneLink(project, zone) + "/disks/" + d.Name
+	if d.Type == "" {
+		d.Type = "pd-standard"
+	}
+	d.Type = zoneLink(project, zone) + "/diskTypes/" + lastSegment(d.Type)
+	if d.SizeGb == 0 {
+		d.SizeGb = 10
+	}
+	if d.Status == "" {
+		d.Status = "READY"
+	}
+	if d.CreationTimestamp == "" {
+		d.CreationTimestamp = timestamp(time.Now())
+	}
+	e.disks[key(project, zone, d.Name)] = d
+}
+
+// AddSnapshot adds the snapshot. If they're empty, its status is set to READY
+// and its creation time to now.
+func (e *Emulator) AddSnapshot(project string, snapshot *compute.Snapshot) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	s := *snapshot
+	e.putSnapshot(project, &s)
+}
+
+// putSnapshot fills the defaults of the snapshot and stores it, e.mutex must be held.
+func (e *Emulator) putSnapshot(project string, s *compute.Snapshot) {
+	s.Kind = "compute#snapshot"
+	s.SelfLink = globalLink(project) + "/snapshots/" + s.Name
+	if s.Status == "" {
+		s.Status = "READY"
+	}
+	if s.CreationTimestamp == "" {
+		s.CreationTimestamp = timestamp(time.Now())
+	}
+	e.snapshots[key(project, globalLocation, s.Name)] = s
+}
+
+// AddAddress adds the static address in the region, or a global one if region is empty or global.
+func (e *Emulator) AddAddress(project, region string, address *compute.Address) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if region == "" {
+		region = globalLocation
+	}
+	a := *address
+	a.Kind = "compute#address"
+	if a.Status == "" {
+		a.Status = "RESERVED"
+	}
+	if region == globalLocation {
+		a.SelfLink = globalLink(project) + "/addresses/" + a.Name
+	} else {
+		a.Region = regionLink(project, region)
+		a.SelfLink = regionLink(project, region) + "/addresses/" + a.Name
+	}
+	e.addresses[key(project, region, a.Name)] = &a
+}
+
+// AddImage adds the custom image.
+func (e *Emulator) AddImage(project string, image *compute.Image) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	i := *image
+	i.Kind = "compute#image"
+	i.SelfLink = globalLink(project) + "/images/" + i.Name
+	if i.Status == "" {
+		i.Status = "READY"
+	}
+	e.images[key(project, globalLocation, i.Name)] = &i
+}
+
+// AddRecommendation adds the recommendation, it's found by the project ID or number in its name.
+// If they're empty, its state is set to ACTIVE and a random etag is generated.
+func (e *Emulator) AddRecommendation(recommendation *gcloudRecommendation) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	r := *recommendation
+	var stateInfo recommender.GoogleCloudRecommenderV1RecommendationStateInfo
+	if r.StateInfo != nil {
+		stateInfo = *r.StateInfo
+	}
+	if stateInfo.State == "" {
+		stateInfo.State = "ACTIVE"
+	}
+	r.StateInfo = &stateInfo
+	if r.Etag == "" {
+		r.Etag = newEtag()
+	}
+	e.recommendations[r.Name] = &r
+}
+
+// SetRecommendationPriority sets the priority of the added recommendation, for example P1.
+func (e *Emulator) SetRecommendationPriority(name, priority string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.priorities[name] = priority
+}
+
+// AddInsight adds the insight, it's found by the project ID or number in its name.
+func (e *Emulator) AddInsight(insight *gcloudInsight) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	i := *insight
+	if i.Etag == "" {
+		i.Etag = newEtag()
+	}
+	e.insights[i.Name] = &i
+}
+
+// Instance returns the instance in the zone, ok is false if it doesn't exist.
+func (e *Emulator) Instance(project, zone, name string) (instance compute.Instance, ok bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if i, ok := e.instances[key(project, zone, name)]; ok {
+		return *i, true
+	}
+	return compute.Instance{}, false
+}
+
+// Disk returns the disk in the zone, ok is false if it doesn't exist.
+func (e *Emulator) Disk(project, zone, name string) (disk compute.Disk, ok bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if d, ok := e.disks[key(project, zone, name)]; ok {
+		return *d, true
+	}
+	return compute.Disk{}, false
+}
+
+// Snapshots returns all snapshots in the project.
+func (e *Emulator) Snapshots(project string) []compute.Snapshot {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var snapshots []compute.Snapshot
+	for k, s := range e.snapshots {
+		if strings.HasPrefix(k, project+"/") {
+			snapshots = append(snapshots, *s)
+		}
+	}
+	return snapshots
+}
+
+// Address returns whether the address exists in the region, or globally if region is empty or global.
+func (e *Emulator) Address(project, region, name string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if region == "" {
+		region = globalLocation
+	}
+	_, ok := e.addresses[key(project, region, name)]
+	return ok
+}
+
+// Image returns whether the image exists.
+func (e *Emulator) Image(project, name string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, ok := e.images[key(project, globalLocation, name)]
+	return ok
+}
+
+// Recommendation returns the recommendation, ok is false if it doesn't exist.
+func (e *Emulator) Recommendation(name string) (recommendation gcloudRecommendation, ok bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if r, ok := e.recommendations[name]; ok {
+		return *r, true
+	}
+	return gcloudRecommendation{}, false
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	stopInstanceName = "projects/123/locations/us-central1-a/recommenders/google.compute.instance.IdleResourceRecommender/recommendations/r1"
+	deleteDiskName   = "projects/123/locations/us-central1-a/recommenders/google.compute.disk.IdleResourceRecommender/recommendations/r2"
+)
+
+const stopInstanceJSON = `{
+	"name": "` + stopInstanceName + `",
+	"recommenderSubtype": "STOP_VM",
+	"content": {"operationGroups": [{"operations": [
+		{"action": "test", "path": "/status", "value": "RUNNING", "resourceType": "compute.googleapis.com/Instance",
+			"resource": "//compute.googleapis.com/projects/my-project/zones/us-central1-a/instances/vm"},
+		{"action": "replace", "path": "/status", "value": "TERMINATED", "resourceType": "compute.googleapis.com/Instance",
+			"resource": "//compute.googleapis.com/projects/my-project/zones/us-central1-a/instances/vm"}
+	]}]}
+}`
+
+const deleteDiskJSON = `{
+	"name": "` + deleteDiskName + `",
+	"recommenderSubtype": "SNAPSHOT_AND_DELETE_DISK",
+	"content": {"operationGroups": [{"operations": [
+		{"action": "add", "path": "/", "resourceType": "compute.googleapis.com/Snapshot",
+			"resource": "//compute.googleapis.com/projects/my-project/global/snapshots/$snapshot-name",
+			"value": {"name": "$snapshot-name", "source_disk": "projects/my-project/zones/us-central1-a/disks/disk"}},
+		{"action": "remove", "path": "/", "resourceType": "compute.googleapis.com/Disk",
+			"resource": "//compute.googleapis.com/projects/my-project/zones/us-central1-a/disks/disk"}
+	]}]}
+}`
+
+// newTestEmulator starts an emulator with a project, an instance, a disk and recommendations to stop
+// the instance and to delete the disk, and returns GoogleService sending requests to it.
+func newTestEmulator(t *testing.T) (*Emulator, automation.GoogleService, func()) {
+	e := New()
+	e.AddProject("my-project", "123", map[string]string{"env": "dev"})
+	e.AddInstance("my-project", "us-central1-a", &compute.Instance{Name: "vm"})
+	e.AddDisk("my-project", "us-central1-a", &compute.Disk{Name: "disk", SizeGb: 20, Labels: map[string]string{"app": "web"}})
+	for _, recommendationJSON := range []string{stopInstanceJSON, deleteDiskJSON} {
+		var recommendation gcloudRecommendation
+		if err := json.Unmarshal([]byte(recommendationJSON), &recommendation); err != nil {
+			t.Fatal(err)
+		}
+		e.AddRecommendation(&recommendation)
+	}
+
+	server := httptest.NewServer(e.Handler())
+	service, err := automation.NewEmulatedGoogleService(context.Background(), server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return e, service, server.Close
+}
+
+func TestListRecommendations(t *testing.T) {
+	e, service, closeServer := newTestEmulator(t)
+	defer closeServer()
+	ctx := context.Background()
+
+	projects, err := service.ListProjectsByLabels(ctx, map[string]string{"env": "dev"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"my-project"}, projects)
+	}
+
+	e.SetRecommendationPriority(stopInstanceName, "P2")
+	result, err := automation.ListProjectsRecommendations(ctx, service, []string{"my-project"}, "stateInfo.state = ACTIVE", 0, &automation.Task{})
+	if assert.NoError(t, err) {
+		assert.Len(t, result.Recommendations, 2, "Both recommendations should be listed")
+		assert.Empty(t, result.FailedProjects)
+		assert.Equal(t, map[string]string{stopInstanceName: "P2"}, result.Priorities, "Priorities should be decoded")
+	}
+
+	e.DenyPermissions("my-project", "compute.instances.stop")
+	result, err = automation.ListProjectsRecommendations(ctx, service, []string{"my-project"}, "", 0, &automation.Task{})
+	if assert.NoError(t, err) {
+		if assert.Len(t, result.Recommendations, 1, "Recommendations of other recommenders should be listed") {
+			assert.Equal(t, deleteDiskName, result.Recommendations[0].Name)
+		}
+		assert.Empty(t, result.FailedProjects)
+		var failed []string
+		for _, recommender := range result.FailedRecommenders {
+			failed = append(failed, recommender.Recommender)
+		}
+		assert.Contains(t, failed, "google.compute.instance.IdleResourceRecommender", "Recommender without permissions should fail")
+	}
+
+	e.DenyPermissions("my-project", "compute.zones.list")
+	result, err = automation.ListProjectsRecommendations(ctx, service, []string{"my-project"}, "", 0, &automation.Task{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, result.Recommendations)
+		assert.Len(t, result.FailedProjects, 1, "Project without permissions should fail")
+	}
+
+	_, err = service.ListRecommendations(ctx, "my-project", "us-central1-a", "google.compute.instance.IdleResourceRecommender", "state ~ ACTIVE")
+	assert.Error(t, err, "Invalid filter should be refused")
+}
+
+func TestApplyAndRevert(t *testing.T) {
+	e, service, closeServer := newTestEmulator(t)
+	defer closeServer()
+	ctx := context.Background()
+
+	_, err := automation.ApplyByName(ctx, service, stopInstanceName, &automation.Task{})
+	assert.NoError(t, err)
+	instance, _ := e.Instance("my-project", "us-central1-a", "vm")
+	assert.Equal(t, "TERMINATED", instance.Status, "The instance should be stopped")
+	recommendation, _ := e.Recommendation(stopInstanceName)
+	assert.Equal(t, "SUCCEEDED", recommendation.StateInfo.State)
+
+	results, err := automation.ApplyByName(ctx, service, deleteDiskName, &automation.Task{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, ok := e.Disk("my-project", "us-central1-a", "disk")
+	assert.False(t, ok, "The disk should be deleted")
+	snapshots, err := automation.ListRecomatorSnapshots(ctx, service, "my-project")
+	if assert.NoError(t, err) && assert.Len(t, snapshots, 1, "The disk should be snapshotted") {
+		assert.Equal(t, int64(20), snapshots[0].DiskSizeGb)
+	}
+
+	_, err = automation.Revert(ctx, service, deleteDiskName, results, &automation.Task{})
+	assert.NoError(t, err)
+	disk, ok := e.Disk("my-project", "us-central1-a", "disk")
+	if assert.True(t, ok, "The disk should be restored") {
+		assert.Equal(t, int64(20), disk.SizeGb)
+		assert.Equal(t, map[string]string{"app": "web"}, disk.Labels)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := parseFilter("stateInfo.state = ACTIVE OR stateInfo.state=CLAIMED")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]filterTerm{{{field: "stateInfo.state", value: "ACTIVE"}}, {{field: "stateInfo.state", value: "CLAIMED"}}}, filter)
+
+	filter, err = parseFilter("(labels.env = dev) (labels.team != web)")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]filterTerm{{{field: "labels.env", value: "dev"}, {field: "labels.team", negation: true, value: "web"}}}, filter)
+
+	_, err = parseFilter("state ~ ACTIVE")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package emulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/recommender/v1"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// handleV1 dispatches the requests of Recommender, Resource Manager and Service Usage APIs,
+// whose paths start with /v1/. Custom methods are separated from the name by a colon,
+// for example /v1/projects/my-project:testIamPermissions.
+func (e *Emulator) handleV1(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("path"), "/")
+	method := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, method = name[:i], name[i+1:]
+	}
+	segments := strings.Split(name, "/")
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	get := c.Request.Method == http.MethodGet
+	switch {
+	case get && name == "projects":
+		e.listProjects(c)
+	case !get && len(segments) == 2 && segments[0] == "projects" && method == "testIamPermissions":
+		e.testIamPermissions(c, segments[1])
+	case get && len(segments) == 4 && segments[0] == "projects" && segments[2] == "services":
+		e.getService(c, segments[1], segments[3])
+	case get && len(segments) == 7 && segments[4] == "recommenders" && segments[6] == "recommendations":
+		e.listRecommendations(c, segments)
+	case get && len(segments) == 8 && segments[4] == "recommenders" && method == "":
+		e.getRecommendation(c, name)
+	case !get && len(segments) == 8 && segments[4] == "recommenders":
+		e.markRecommendation(c, name, method)
+	case get && len(segments) == 7 && segments[4] == "insightTypes" && segments[6] == "insights":
+		e.listInsights(c, segments)
+	case get && len(segments) == 8 && segments[4] == "insightTypes" && method == "":
+		e.getInsight(c, name)
+	default:
+		writeError(c, notFound("Method not found: %s %s", c.Request.Method, c.Request.URL.Path))
+	}
+}
+
+// listProjects lists the added projects matching the filter, which may compare labels, id and name.
+// e.mutex must be held.
+func (e *Emulator) listProjects(c *gin.Context) {
+	var filter [][]filterTerm
+	if value := c.Query("filter"); value != "" {
+		var err *apiError
+		if filter, err = parseFilter(value); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	var projects []*cloudresourcemanager.Project
+	for _, project := range e.projects {
+		matches, err := matchesFilter(filter, func(name string) (string, bool) {
+			switch name {
+			case "id", "projectId":
+				return project.ProjectId, true
+			case "name":
+				return project.Name, true
+			case "lifecycleState":
+				return project.LifecycleState, true
+			}
+			return labelField(project.Labels, name)
+		})
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if matches {
+			projects = append(projects, project)
+		}
+	}
+	start, end, nextPageToken, err := page(c, len(projects), "pageSize")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &cloudresourcemanager.ListProjectsResponse{Projects: projects[start:end], NextPageToken: nextPageToken})
+}
+
+// testIamPermissions returns the requested permissions, except the ones denied by DenyPermissions.
+// e.mutex must be held.
+func (e *Emulator) testIamPermissions(c *gin.Context, projectID string) {
+	project, ok := e.findProject(projectID)
+	if !ok {
+		writeError(c, permissionDenied("The caller does not have permission"))
+		return
+	}
+	var request cloudresourcemanager.TestIamPermissionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeError(c, invalidArgument("Invalid JSON payload received: %v", err))
+		return
+	}
+	response := &cloudresourcemanager.TestIamPermissionsResponse{}
+	for _, permission := range request.Permissions {
+		if !e.deniedPermissions[project.ProjectId][permission] {
+			response.Permissions = append(response.Permissions, permission)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// serviceTitles are the titles of the services, other services are titled with their names.
+var serviceTitles = map[string]string{
+	"cloudresourcemanager.googleapis.com": "Cloud Resource Manager API",
+	"compute.googleapis.com":              "Compute Engine API",
+	"recommender.googleapis.com":          "Recommender API",
+	"serviceusage.googleapis.com":         "Service Usage API",
+	"sqladmin.googleapis.com":             "Cloud SQL Admin API",
+}
+
+// getService returns the state of the service, which is enabled unless disabled by DisableService.
+// e.mutex must be held.
+func (e *Emulator) getService(c *gin.Context, projectID, service string) {
+	project, ok := e.findProject(projectID)
+	if !ok {
+		writeError(c, permissionDenied("Permission denied to get service [%s]", service))
+		return
+	}
+	parent := "projects/" + strconv.FormatInt(project.ProjectNumber, 10)
+	state := "ENABLED"
+	if e.disabledServices[project.ProjectId][service] {
+		state = "DISABLED"
+	}
+	title, ok := serviceTitles[service]
+	if !ok {
+		title = service
+	}
+	c.JSON(http.StatusOK, &serviceusage.GoogleApiServiceusageV1Service{
+		Name:   parent + "/services/" + service,
+		Parent: parent,
+		State:  state,
+		Config: &serviceusage.GoogleApiServiceusageV1ServiceConfig{Name: service, Title: title},
+	})
+}
+
+// sameName returns whether the names of two resources are equal,
+// when the projects in them are given by the ID or the number. e.mutex must be held.
+func (e *Emulator) sameName(name, other string) bool {
+	segments, otherSegments := strings.Split(name, "/"), strings.Split(other, "/")
+	if len(segments) != len(otherSegments) || len(segments) < 2 {
+		return false
+	}
+	for i := range segments {
+		if i == 1 && segments[0] == "projects" {
+			if e.projectID(segments[i]) != e.projectID(otherSegments[i]) {
+				return false
+			}
+		} else if segments[i] != otherSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// listRecommendations lists the recommendations of the recommender in the location, given by segments of the path.
+// The filter may compare stateInfo.state and recommenderSubtype. e.mutex must be held.
+func (e *Emulator) listRecommendations(c *gin.Context, segments []string) {
+	var filter [][]filterTerm
+	if value := c.Query("filter"); value != "" {
+		var err *apiError
+		if filter, err = parseFilter(value); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	parent := strings.Join(segments, "/")
+	var recommendations []*gcloudRecommendation
+	for name, recommendation := range e.recommendations {
+		if i := strings.LastIndex(name, "/"); i < 0 || !e.sameName(name[:i], parent) {
+			continue
+		}
+		matches, err := matchesFilter(filter, func(field string) (string, bool) {
+			switch field {
+			case "stateInfo.state", "state_info.state":
+				return recommendation.StateInfo.State, true
+			case "recommenderSubtype", "recommender_subtype":
+				return recommendation.RecommenderSubtype, true
+			}
+			return "", false
+		})
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if matches {
+			recommendations = append(recommendations, recommendation)
+		}
+	}
+	sort.Slice(recommendations, func(i, j int) bool { return recommendations[i].Name < recommendations[j].Name })
+	start, end, nextPageToken, err := page(c, len(recommendations), "pageSize")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	response := listRecommendationsResponse{Recommendations: []interface{}{}, NextPageToken: nextPageToken}
+	for _, recommendation := range recommendations[start:end] {
+		response.Recommendations = append(response.Recommendations, e.recommendationJSON(recommendation))
+	}
+	c.JSON(http.StatusOK, &response)
+}
+
+// listRecommendationsResponse is the response of recommendations list method,
+// in which recommendations may have priorities.
+type listRecommendationsResponse struct {
+	Recommendations []interface{} `json:"recommendations,omitempty"`
+	NextPageToken   string        `json:"nextPageToken,omitempty"`
+}
+
+// recommendationJSON returns the recommendation with its priority set by SetRecommendationPriority,
+// which can't be set in gcloudRecommendation. e.mutex must be held.
+func (e *Emulator) recommendationJSON(recommendation *gcloudRecommendation) interface{} {
+	priority, ok := e.priorities[recommendation.Name]
+	if !ok {
+		return recommendation
+	}
+	data, err := recommendation.MarshalJSON()
+	if err != nil {
+		return recommendation
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return recommendation
+	}
+	fields["priority"] = priority
+	return fields
+}
+
+// findRecommendation returns the recommendation with the name, e.mutex must be held.
+func (e *Emulator) findRecommendation(name string) (*gcloudRecommendation, bool) {
+	if recommendation, ok := e.recommendations[name]; ok {
+		return recommendation, true
+	}
+	for other, recommendation := range e.recommendations {
+		if e.sameName(name, other) {
+			return recommendation, true
+		}
+	}
+	return nil, false
+}
+
+// getRecommendation responds with the recommendation, e.mutex must be held.
+func (e *Emulator) getRecommendation(c *gin.Context, name string) {
+	recommendation, ok := e.findRecommendation(name)
+	if !ok {
+		writeError(c, notFound("Recommendation %s not found", name))
+		return
+	}
+	c.JSON(http.StatusOK, e.recommendationJSON(recommendation))
+}
+
+// stateTransitions lists the states, from which each mark method moves recommendations, and the new state.
+var stateTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	"markClaimed":   {[]string{"ACTIVE", "CLAIMED", "SUCCEEDED", "FAILED"}, "CLAIMED"},
+	"markSucceeded": {[]string{"ACTIVE", "CLAIMED", "SUCCEEDED", "FAILED"}, "SUCCEEDED"},
+	"markFailed":    {[]string{"ACTIVE", "CLAIMED", "SUCCEEDED", "FAILED"}, "FAILED"},
+	"markDismissed": {[]string{"ACTIVE"}, "DISMISSED"},
+	"markActive":    {[]string{"DISMISSED"}, "ACTIVE"},
+}
+
+// markStateRequest is the body of all mark methods.
+type markStateRequest struct {
+	Etag          string            `json:"etag"`
+	StateMetadata map[string]string `json:"stateMetadata"`
+}
+
+// markRecommendation changes the state of the recommendation with the mark method, for example markClaimed.
+// The etag in the request must be the current one, and a new etag is generated. e.mutex must be held.
+func (e *Emulator) markRecommendation(c *gin.Context, name, method string) {
+	transition, ok := stateTransitions[method]
+	if !ok {
+		writeError(c, notFound("Method not found: %s", method))
+		return
+	}
+	recommendation, ok := e.findRecommendation(name)
+	if !ok {
+		writeError(c, notFound("Recommendation %s not found", name))
+		return
+	}
+	var request markStateRequest
+	if err := c.ShouldBindJSON(&request); err != nil || request.Etag == "" {
+		writeError(c, invalidArgument("Invalid etag"))
+		return
+	}
+	if request.Etag != recommendation.Etag {
+		writeError(c, failedPrecondition("Fingerprint mismatch, the recommendation has been changed: %s", name))
+		return
+	}
+	allowed := false
+	for _, state := range transition.from {
+		allowed = allowed || recommendation.StateInfo.State == state
+	}
+	if !allowed {
+		writeError(c, failedPrecondition("Recommendation %s in state %s can't be changed with %s",
+			name, recommendation.StateInfo.State, method))
+		return
+	}
+	recommendation.StateInfo = &recommender.GoogleCloudRecommenderV1RecommendationStateInfo{
+		State:         transition.to,
+		StateMetadata: request.StateMetadata,
+	}
+	recommendation.Etag = newEtag()
+	c.JSON(http.StatusOK, e.recommendationJSON(recommendation))
+}
+
+// listInsights lists the insights of the insight type in the location, given by segments of the path.
+// e.mutex must be held.
+func (e *Emulator) listInsights(c *gin.Context, segments []string) {
+	parent := strings.Join(segments, "/")
+	var insights []*gcloudInsight
+	for name, insight := range e.insights {
+		if i := strings.LastIndex(name, "/"); i >= 0 && e.sameName(name[:i], parent) {
+			insights = append(insights, insight)
+		}
+	}
+	sort.Slice(insights, func(i, j int) bool { return insights[i].Name < insights[j].Name })
+	start, end, nextPageToken, err := page(c, len(insights), "pageSize")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &recommender.GoogleCloudRecommenderV1ListInsightsResponse{
+		Insights:      insights[start:end],
+		NextPageToken: nextPageToken,
+	})
+}
+
+// getInsight responds with the insight, e.mutex must be held.
+func (e *Emulator) getInsight(c *gin.Context, name string) {
+	for other, insight := range e.insights {
+		if e.sameName(name, other) {
+			c.JSON(http.StatusOK, insight)
+			return
+		}
+	}
+	writeError(c, notFound("Insight %s not found", name))
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonl keeps records in files as JSON Lines, one JSON value per line.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// maxLineSize is the maximal length of a line read by ForEach.
+const maxLineSize = 1024 * 1024
+
+// Appender appends values to the file at path, each as a line of JSON.
+// It is safe for concurrent use, appending and reading are serialized.
+type Appender struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewAppender creates Appender for the file at path.
+// The file is created with the first appended value.
+func NewAppender(path string) *Appender {
+	return &Appender{path: path}
+}
+
+// Append encodes the value as JSON and appends it to the file as one line.
+func (a *Appender) Append(value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ForEach calls do with every non-empty line of the file, the oldest first.
+// Stops at the first error returned by do. A missing file has no lines.
+func (a *Appender) ForEach(do func(line []byte) error) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	file, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := do(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
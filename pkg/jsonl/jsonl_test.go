@@ -0,0 +1,77 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonl
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	ID int `json:"id"`
+}
+
+func TestAppender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonl")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	appender := NewAppender(filepath.Join(dir, "records.jsonl"))
+
+	assert.NoError(t, appender.ForEach(func(line []byte) error {
+		return errors.New("a missing file has no lines")
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, appender.Append(&record{ID: i}))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	err = appender.ForEach(func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		seen[r.ID] = true
+		return nil
+	})
+	assert.NoError(t, err, "Every line should be a whole record")
+	assert.Len(t, seen, 10)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = appender.ForEach(func(line []byte) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err, "The error of the callback should be returned")
+	assert.Equal(t, 1, calls, "Reading should stop at the first error")
+}
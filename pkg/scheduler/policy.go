@@ -0,0 +1,122 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectSelector selects the projects a policy applies to.
+// If IDs are specified, only these projects are selected.
+// If Labels are specified, only projects having all of them are selected.
+// If neither is specified, all projects available for the credentials are selected.
+type ProjectSelector struct {
+	IDs    []string          `json:"ids,omitempty" yaml:"ids,omitempty"`
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// Policy describes recommendations that are applied automatically on schedule,
+// for example idle disk recommendations in projects labelled env=dev every Sunday.
+type Policy struct {
+	// Name identifies the policy in the recorded runs.
+	Name string `json:"name" yaml:"name"`
+	// Schedule is the cron expression in UTC, described in ParseSchedule.
+	Schedule string          `json:"schedule" yaml:"schedule"`
+	Projects ProjectSelector `json:"projects" yaml:"projects"`
+	// Recommenders are IDs of recommenders, for example google.compute.disk.IdleResourceRecommender.
+	// If empty, recommendations of all recommenders are applied.
+	Recommenders []string `json:"recommenders,omitempty" yaml:"recommenders,omitempty"`
+	// Subtypes are recommender subtypes, for example STOP_VM. If empty, all subtypes are applied.
+	Subtypes []string `json:"subtypes,omitempty" yaml:"subtypes,omitempty"`
+	// MinMonthlySavings is the minimum projected monthly savings, in currency units,
+	// of the applied recommendations. If it's positive, recommendations without cost projection are not applied.
+	MinMonthlySavings float64 `json:"minMonthlySavings,omitempty" yaml:"minMonthlySavings,omitempty"`
+	// MaxPerRun is the maximum number of recommendations applied in one run,
+	// the ones with the largest savings are applied first. 0 means no limit.
+	MaxPerRun int `json:"maxPerRun,omitempty" yaml:"maxPerRun,omitempty"`
+
+	schedule *Schedule
+}
+
+// policiesFile is the format of the file with policies.
+type policiesFile struct {
+	Policies []*Policy `json:"policies" yaml:"policies"`
+}
+
+// ParsePolicies parses policies from YAML, or from JSON if isJSON is true,
+// in the form {"policies": [...]}.
+func ParsePolicies(data []byte, isJSON bool) ([]*Policy, error) {
+	var file policiesFile
+	if isJSON {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, policy := range file.Policies {
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("policy %s is defined more than once", policy.Name)
+		}
+		names[policy.Name] = true
+	}
+	return file.Policies, nil
+}
+
+// LoadPolicies reads policies from the file at path.
+// Files with .json extension are parsed as JSON, other files as YAML.
+func LoadPolicies(path string) ([]*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicies(data, strings.ToLower(filepath.Ext(path)) == ".json")
+}
+
+// validate checks the policy and parses its schedule.
+func (p *Policy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy without name")
+	}
+	schedule, err := ParseSchedule(p.Schedule)
+	if err != nil {
+		return fmt.Errorf("policy %s: %v", p.Name, err)
+	}
+	if p.MinMonthlySavings < 0 || p.MaxPerRun < 0 {
+		return fmt.Errorf("policy %s: minMonthlySavings and maxPerRun can't be negative", p.Name)
+	}
+	p.schedule = schedule
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlPolicies = `
+policies:
+  - name: idle-disks
+    schedule: "0 2 * * 0"
+    projects:
+      labels:
+        env: dev
+    recommenders:
+      - google.compute.disk.IdleResourceRecommender
+    minMonthlySavings: 10
+    maxPerRun: 5
+`
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies([]byte(yamlPolicies), false)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(policies)) {
+		policy := policies[0]
+		assert.Equal(t, "idle-disks", policy.Name)
+		assert.Equal(t, map[string]string{"env": "dev"}, policy.Projects.Labels)
+		assert.Equal(t, []string{"google.compute.disk.IdleResourceRecommender"}, policy.Recommenders)
+		assert.Equal(t, 10.0, policy.MinMonthlySavings)
+		assert.Equal(t, 5, policy.MaxPerRun)
+		assert.NotNil(t, policy.schedule, "Schedule should be parsed")
+	}
+
+	jsonPolicies := `{"policies": [{"name": "stop-vms", "schedule": "@daily", "projects": {"ids": ["p"]}, "subtypes": ["STOP_VM"]}]}`
+	policies, err = ParsePolicies([]byte(jsonPolicies), true)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(policies)) {
+		assert.Equal(t, []string{"p"}, policies[0].Projects.IDs)
+		assert.Equal(t, []string{"STOP_VM"}, policies[0].Subtypes)
+	}
+}
+
+func TestParsePoliciesInvalid(t *testing.T) {
+	invalid := []string{
+		`{"policies": [{"schedule": "@daily"}]}`,
+		`{"policies": [{"name": "p", "schedule": "daily"}]}`,
+		`{"policies": [{"name": "p", "schedule": "@daily", "maxPerRun": -1}]}`,
+		`{"policies": [{"name": "p", "schedule": "@daily"}, {"name": "p", "schedule": "@hourly"}]}`,
+		`{"policies": [{"name": "p", "schedule": "@daily", "unknown": 1}]}`,
+	}
+	for _, data := range invalid {
+		_, err := ParsePolicies([]byte(data), true)
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/googleinterns/recomator/pkg/jsonl"
+)
+
+// ApplyResult is the outcome of applying one recommendation in a run.
+// Skipped is set, if the safeguards refused to change the resources,
+// and then Error explains why.
+// Operations describe the changes made, for example the snapshots of deleted disks.
+type ApplyResult struct {
+	Name           string                        `json:"name"`
+	MonthlySavings string                        `json:"monthlySavings,omitempty"`
+	Currency       string                        `json:"currency,omitempty"`
+	Succeeded      bool                          `json:"succeeded"`
+	Skipped        bool                          `json:"skipped,omitempty"`
+	Error          string                        `json:"error,omitempty"`
+	Operations     []*automation.OperationResult `json:"operations,omitempty"`
+}
+
+// RunRecord is the outcome of one run of a policy.
+// NumListed is the number of active recommendations listed in the selected projects,
+// NumMatched is the number of them matching the policy, before MaxPerRun is applied.
+// FailedProjects are the projects, for which recommendations couldn't be listed
+// because of missing permissions or APIs.
+// Error is set, if the run was stopped, for example because listing failed.
+type RunRecord struct {
+	Policy         string         `json:"policy"`
+	StartTime      time.Time      `json:"startTime"`
+	FinishTime     time.Time      `json:"finishTime"`
+	Projects       []string       `json:"projects"`
+	FailedProjects []string       `json:"failedProjects,omitempty"`
+	NumListed      int            `json:"numListed"`
+	NumMatched     int            `json:"numMatched"`
+	Results        []*ApplyResult `json:"results"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// RunRecorder records the outcomes of runs.
+type RunRecorder interface {
+	Record(run *RunRecord) error
+}
+
+// MemoryRunRecorder keeps the recorded runs in memory.
+type MemoryRunRecorder struct {
+	mutex sync.Mutex
+	runs  []*RunRecord
+}
+
+// Record adds the run to the recorded ones.
+func (r *MemoryRunRecorder) Record(run *RunRecord) error {
+	r.mutex.Lock()
+	r.runs = append(r.runs, run)
+	r.mutex.Unlock()
+	return nil
+}
+
+// Runs returns the recorded runs, the oldest first.
+func (r *MemoryRunRecorder) Runs() []*RunRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*RunRecord{}, r.runs...)
+}
+
+// fileRunRecorder appends the runs to a file as JSON Lines.
+type fileRunRecorder struct {
+	file *jsonl.Appender
+}
+
+// NewFileRunRecorder creates RunRecorder appending every run as a line of JSON to the file at path.
+func NewFileRunRecorder(path string) RunRecorder {
+	return &fileRunRecorder{file: jsonl.NewAppender(path)}
+}
+
+func (r *fileRunRecorder) Record(run *RunRecord) error {
+	return r.file.Append(run)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression, evaluated in UTC.
+// The expression has five fields: minute, hour, day of month, month and day of week,
+// for example "0 2 * * 0" is every Sunday at 02:00 UTC.
+// Each field is "*", a value, a range "a-b", or a list of them separated by commas,
+// optionally with a step, like "*/15" or "1-10/2". Sunday is 0 or 7.
+// Descriptors @hourly, @daily, @weekly and @monthly are also accepted.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// if both days and weekdays are restricted, a time matching either of them matches,
+	// like in cron
+	anyDay, anyWeekday bool
+}
+
+var scheduleDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// scheduleField describes the allowed values of a field of the cron expression.
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var (
+	minuteField  = scheduleField{"minute", 0, 59}
+	hourField    = scheduleField{"hour", 0, 23}
+	dayField     = scheduleField{"day of month", 1, 31}
+	monthField   = scheduleField{"month", 1, 12}
+	weekdayField = scheduleField{"day of week", 0, 7}
+)
+
+// ParseSchedule parses the cron expression.
+func ParseSchedule(spec string) (*Schedule, error) {
+	if expanded, ok := scheduleDescriptors[strings.TrimSpace(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q should have 5 fields, has %d", spec, len(fields))
+	}
+	var schedule Schedule
+	var err error
+	if schedule.minutes, err = parseScheduleField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseScheduleField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if schedule.days, err = parseScheduleField(fields[2], dayField); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseScheduleField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays, err = parseScheduleField(fields[4], weekdayField); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays&(1<<7) != 0 { // 7 is also Sunday
+		schedule.weekdays |= 1
+	}
+	schedule.anyDay = strings.HasPrefix(fields[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return &schedule, nil
+}
+
+// parseScheduleField returns the set of values of the field as bits.
+func parseScheduleField(value string, field scheduleField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", field.name, part)
+			}
+		}
+
+		start, end := field.min, field.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", field.name, part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s %q", field.name, part)
+				}
+			} else if step != 1 {
+				end = field.max // "a/n" starts at a
+			}
+		}
+		if start < field.min || end > field.max || start > end {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", field.name, part, field.min, field.max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// maxScheduleSearch bounds the search for the next time, so that schedules
+// that never match, like February 30th, don't loop forever.
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time matching the schedule after t, in UTC.
+// Returns zero time if there's no such time in the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxScheduleSearch)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// Saturday
+	now := time.Date(2020, time.October, 17, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"0 2 * * 0", time.Date(2020, time.October, 18, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * 7", time.Date(2020, time.October, 18, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.October, 17, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2020, time.October, 18, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1 1,6 *", time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2020, time.October, 19, 9, 0, 0, 0, time.UTC)},
+		// day of month or day of week, like in cron
+		{"0 0 20 * 1", time.Date(2020, time.October, 19, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec)
+		if assert.NoError(t, err, test.spec) {
+			assert.Equal(t, test.expected, schedule.Next(now), test.spec)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/recommender/v1"
+)
+
+// activeFilter makes runs list only recommendations that can be applied.
+const activeFilter = "stateInfo.state = ACTIVE"
+
+// Scheduler runs policies on their schedules.
+type Scheduler struct {
+	service  automation.GoogleService
+	policies []*Policy
+	recorder RunRecorder
+	now      func() time.Time
+}
+
+// NewScheduler creates Scheduler applying recommendations with service
+// according to the policies, and recording the runs with recorder.
+// Runs are always logged, recorder can be nil.
+// The policies must have been created by ParsePolicies or LoadPolicies.
+func NewScheduler(service automation.GoogleService, policies []*Policy, recorder RunRecorder) *Scheduler {
+	return &Scheduler{service: service, policies: policies, recorder: recorder, now: time.Now}
+}
+
+// Run runs the policies on their schedules until ctx is done.
+// Policies due at the same time are run one after another.
+// Returns the error of ctx.
+func (s *Scheduler) Run(ctx context.Context) error {
+	next := make(map[*Policy]time.Time)
+	for _, policy := range s.policies {
+		next[policy] = policy.schedule.Next(s.now())
+	}
+	for {
+		var earliest time.Time
+		for _, t := range next {
+			if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+				earliest = t
+			}
+		}
+		if earliest.IsZero() { // no policy will run again
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(earliest.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		for _, policy := range s.policies {
+			if t := next[policy]; t.IsZero() || t.After(s.now()) {
+				continue
+			}
+			s.record(s.RunPolicy(ctx, policy))
+			next[policy] = policy.schedule.Next(s.now())
+		}
+	}
+}
+
+// record records the run. Errors are only logged, so that the scheduler keeps running.
+func (s *Scheduler) record(run *RunRecord) {
+	succeeded := 0
+	for _, result := range run.Results {
+		if result.Succeeded {
+			succeeded++
+		}
+	}
+	log.Printf("Policy %s applied %d of %d recommendations", run.Policy, succeeded, len(run.Results))
+	if run.Error != "" {
+		log.Printf("Run of policy %s stopped: %s", run.Policy, run.Error)
+	}
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.Record(run); err != nil {
+		log.Printf("Error recording run of policy %s: %v", run.Policy, err)
+	}
+}
+
+// RunPolicy lists active recommendations in the projects selected by the policy
+// with ListProjectsRecommendations, and applies the ones matching the policy with Apply.
+// If ctx is canceled, the remaining recommendations are not applied.
+// Safeguards set in ctx by automation.WithSafeguards are respected.
+func (s *Scheduler) RunPolicy(ctx context.Context, policy *Policy) *RunRecord {
+	run := &RunRecord{Policy: policy.Name, StartTime: s.now(), Results: []*ApplyResult{}}
+	defer func() { run.FinishTime = s.now() }()
+
+	projects, err := s.selectProjects(ctx, policy.Projects)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	run.Projects = projects
+
+	result, err := automation.ListProjectsRecommendations(ctx, s.service, projects, activeFilter, 0, &automation.Task{})
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	for _, failed := range result.FailedProjects {
+		run.FailedProjects = append(run.FailedProjects, failed.Project)
+	}
+	run.NumListed = len(result.Recommendations)
+
+	matched := policy.matching(result.Recommendations)
+	run.NumMatched = len(matched)
+	if policy.MaxPerRun > 0 && len(matched) > policy.MaxPerRun {
+		matched = matched[:policy.MaxPerRun]
+	}
+
+	for _, rec := range matched {
+		if err := ctx.Err(); err != nil {
+			run.Error = err.Error()
+			break
+		}
+		flattened := automation.FlattenRecommendation(rec)
+		applyResult := &ApplyResult{Name: rec.Name, MonthlySavings: flattened.MonthlySavings, Currency: flattened.Currency}
+		operations, err := automation.Apply(ctx, s.service, rec, &automation.Task{})
+		applyResult.Operations = operations
+		if err != nil {
+			applyResult.Error = err.Error()
+			applyResult.Skipped = automation.IsSkipped(err)
+		} else {
+			applyResult.Succeeded = true
+		}
+		run.Results = append(run.Results, applyResult)
+	}
+	return run
+}
+
+// selectProjects returns the projects selected by selector.
+func (s *Scheduler) selectProjects(ctx context.Context, selector ProjectSelector) ([]string, error) {
+	if len(selector.Labels) == 0 && len(selector.IDs) > 0 {
+		return selector.IDs, nil
+	}
+	labelled, err := s.service.ListProjectsByLabels(ctx, selector.Labels)
+	if err != nil || len(selector.IDs) == 0 {
+		return labelled, err
+	}
+	ids := make(map[string]bool)
+	for _, id := range selector.IDs {
+		ids[id] = true
+	}
+	var projects []string
+	for _, project := range labelled {
+		if ids[project] {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+// matchesAny checks whether value is equal to one of values, ignoring case.
+// Empty values match everything.
+func matchesAny(value string, values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matching returns the recommendations matching the policy, the largest savings first.
+func (p *Policy) matching(recs []*recommender.GoogleCloudRecommenderV1Recommendation) []*recommender.GoogleCloudRecommenderV1Recommendation {
+	minNanos := int64(math.Round(p.MinMonthlySavings * 1e9))
+	savings := make(map[*recommender.GoogleCloudRecommenderV1Recommendation]int64)
+	var result []*recommender.GoogleCloudRecommenderV1Recommendation
+	for _, rec := range recs {
+		flattened := automation.FlattenRecommendation(rec)
+		if !matchesAny(flattened.Recommender, p.Recommenders) || !matchesAny(flattened.Subtype, p.Subtypes) {
+			continue
+		}
+		nanos := int64(math.MinInt64) // recommendations without cost projection are last
+		if _, projected, ok := automation.MonthlySavings(rec); ok {
+			nanos = projected
+		}
+		if minNanos > 0 && nanos < minNanos {
+			continue
+		}
+		savings[rec] = nanos
+		result = append(result, rec)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return savings[result[i]] > savings[result[j]]
+	})
+	return result
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/recommender/v1"
+)
+
+type schedulerMockService struct {
+	automation.GoogleService
+	mutex   sync.Mutex
+	labels  map[string]string
+	applied []string
+}
+
+func (s *schedulerMockService) ListProjectsByLabels(ctx context.Context, labels map[string]string) ([]string, error) {
+	s.labels = labels
+	return []string{"dev", "other"}, nil
+}
+
+func (s *schedulerMockService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*automation.Requirement, error) {
+	var result []*automation.Requirement
+	for _, group := range permissions {
+		result = append(result, &automation.Requirement{Name: group[0], Satisfied: true})
+	}
+	return result, nil
+}
+
+func (s *schedulerMockService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*automation.Requirement, error) {
+	var result []*automation.Requirement
+	for _, api := range apis {
+		result = append(result, &automation.Requirement{Name: api, Satisfied: true})
+	}
+	return result, nil
+}
+
+func (s *schedulerMockService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (s *schedulerMockService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
+	return []string{}, nil
+}
+
+// scheduledRecommendation returns active recommendation with monthly savings in USD.
+func scheduledRecommendation(project, recommenderID string, savings int64) *recommender.GoogleCloudRecommenderV1Recommendation {
+	return &recommender.GoogleCloudRecommenderV1Recommendation{
+		Name:      fmt.Sprintf("projects/%s/locations/global/recommenders/%s/recommendations/%d", project, recommenderID, savings),
+		Etag:      "etag",
+		StateInfo: &recommender.GoogleCloudRecommenderV1RecommendationStateInfo{State: "ACTIVE"},
+		Content:   &recommender.GoogleCloudRecommenderV1RecommendationContent{},
+		PrimaryImpact: &recommender.GoogleCloudRecommenderV1Impact{
+			CostProjection: &recommender.GoogleCloudRecommenderV1CostProjection{
+				Cost:     &recommender.GoogleTypeMoney{CurrencyCode: "USD", Units: -savings},
+				Duration: "2592000s",
+			},
+		},
+	}
+}
+
+func (s *schedulerMockService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if filter != activeFilter {
+		return nil, errors.New("only active recommendations should be listed")
+	}
+	if project != "dev" {
+		return nil, nil
+	}
+	var recs []*recommender.GoogleCloudRecommenderV1Recommendation
+	for _, savings := range []int64{5, 20, 50, 30} {
+		recs = append(recs, scheduledRecommendation(project, recommenderID, savings))
+	}
+	return recs, nil
+}
+
+func (s *schedulerMockService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	rec := scheduledRecommendation("dev", "", 0)
+	rec.Name = name
+	return rec, nil
+}
+
+func (s *schedulerMockService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	s.mutex.Lock()
+	s.applied = append(s.applied, name)
+	s.mutex.Unlock()
+	return s.MarkRecommendationClaimed(ctx, name, etag)
+}
+
+func TestRunPolicy(t *testing.T) {
+	mock := &schedulerMockService{}
+	policy := &Policy{
+		Name:              "idle-images",
+		Schedule:          "0 2 * * 0",
+		Projects:          ProjectSelector{IDs: []string{"dev"}, Labels: map[string]string{"env": "dev"}},
+		Recommenders:      []string{"google.compute.image.IdleResourceRecommender"},
+		MinMonthlySavings: 10,
+		MaxPerRun:         2,
+	}
+	if !assert.NoError(t, policy.validate()) {
+		return
+	}
+	recorder := &MemoryRunRecorder{}
+	scheduler := NewScheduler(mock, []*Policy{policy}, recorder)
+	run := scheduler.RunPolicy(context.Background(), policy)
+	scheduler.record(run)
+
+	assert.Equal(t, map[string]string{"env": "dev"}, mock.labels)
+	assert.Empty(t, run.Error)
+	assert.Equal(t, []string{"dev"}, run.Projects, "Only labelled projects with the given IDs should be selected")
+	assert.Equal(t, 3, run.NumMatched, "Recommendations of other recommenders and with small savings shouldn't match")
+	expected := []string{
+		"projects/dev/locations/global/recommenders/google.compute.image.IdleResourceRecommender/recommendations/50",
+		"projects/dev/locations/global/recommenders/google.compute.image.IdleResourceRecommender/recommendations/30",
+	}
+	assert.Equal(t, expected, mock.applied, "Largest savings should be applied first, at most MaxPerRun")
+	if assert.Equal(t, 2, len(run.Results)) {
+		assert.True(t, run.Results[0].Succeeded)
+		assert.Equal(t, "50.00", run.Results[0].MonthlySavings)
+	}
+	assert.Equal(t, []*RunRecord{run}, recorder.Runs(), "Run should be recorded")
+}
+
+func TestRunPolicyCanceled(t *testing.T) {
+	mock := &schedulerMockService{}
+	policy := &Policy{Name: "all", Schedule: "@daily", Projects: ProjectSelector{IDs: []string{"dev"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run := NewScheduler(mock, []*Policy{policy}, nil).RunPolicy(ctx, policy)
+	assert.Equal(t, context.Canceled.Error(), run.Error)
+	assert.Empty(t, mock.applied, "Nothing should be applied after cancellation")
+}
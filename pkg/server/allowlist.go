@@ -0,0 +1,489 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/recommender/v1"
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+// anyone is the key of ProjectAllowlist used for users not listed explicitly,
+// and the project allowing all projects.
+const anyone = "*"
+
+// ProjectAllowlist lists the projects that users may act on, keys are emails of users.
+// Projects of the "*" key are allowed for users not listed, the "*" project allows all projects.
+type ProjectAllowlist map[string][]string
+
+// ParseProjectAllowlist parses the allowlist in the format
+// "user@example.com=project-a,project-b;*=shared-project".
+func ParseProjectAllowlist(value string) (ProjectAllowlist, error) {
+	allowlist := make(ProjectAllowlist)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid allowlist entry %q, expected email=project,project", entry)
+		}
+		email := strings.TrimSpace(parts[0])
+		for _, project := range strings.Split(parts[1], ",") {
+			if project = strings.TrimSpace(project); project != "" {
+				allowlist[email] = append(allowlist[email], project)
+			}
+		}
+		if _, ok := allowlist[email]; !ok {
+			allowlist[email] = []string{} // no projects allowed
+		}
+	}
+	return allowlist, nil
+}
+
+// allowed checks whether the user may act on the project.
+func (a ProjectAllowlist) allowed(email, project string) bool {
+	projects, ok := a[email]
+	if !ok {
+		projects = a[anyone]
+	}
+	for _, allowed := range projects {
+		if allowed == anyone || allowed == project {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistAuth restricts users of the embedded AuthorizationService to the projects in the allowlist.
+type allowlistAuth struct {
+	AuthorizationService
+	allowlist ProjectAllowlist
+	mutex     sync.Mutex
+	services  map[string]*restrictedService // key is email of the user
+}
+
+func newAllowlistAuth(auth AuthorizationService, allowlist ProjectAllowlist) AuthorizationService {
+	return &allowlistAuth{AuthorizationService: auth, allowlist: allowlist, services: make(map[string]*restrictedService)}
+}
+
+// GetUser returns the user with GoogleService failing calls for projects not in the allowlist.
+func (a *allowlistAuth) GetUser(email string) (User, bool) {
+	user, ok := a.AuthorizationService.GetUser(email)
+	if !ok {
+		return user, false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	service, ok := a.services[email]
+	if !ok || service.GoogleService != user.service {
+		service = &restrictedService{
+			GoogleService: user.service,
+			email:         email,
+			allowlist:     a.allowlist,
+			checked:       make(map[string]bool),
+		}
+		a.services[email] = service
+	}
+	return User{service: service, email: email}, true
+}
+
+// restrictedService passes to the embedded GoogleService only calls for the projects allowed for the user.
+// Every method taking a project or a recommendation must be overridden here.
+type restrictedService struct {
+	automation.GoogleService
+	email     string
+	allowlist ProjectAllowlist
+	mutex     sync.Mutex
+	checked   map[string]bool // names of recommendations that are allowed
+}
+
+var projectRegexp = regexp.MustCompile("projects/([^/]+)")
+
+func (s *restrictedService) check(project string) error {
+	if s.allowlist.allowed(s.email, project) {
+		return nil
+	}
+	return &googleapi.Error{
+		Code:    http.StatusForbidden,
+		Message: fmt.Sprintf("Project %s is not allowed for %s", project, s.email),
+	}
+}
+
+// checkRecommendation checks that the recommendation is for an allowed project.
+// Recommendation names usually contain project numbers instead of IDs,
+// so the projects of its resources are checked, unless the name contains an allowed project.
+func (s *restrictedService) checkRecommendation(rec *recommender.GoogleCloudRecommenderV1Recommendation) error {
+	match := projectRegexp.FindStringSubmatch(rec.Name)
+	if match == nil {
+		return fmt.Errorf("no project in recommendation name %s", rec.Name)
+	}
+	if s.check(match[1]) == nil {
+		return nil
+	}
+	numResources := 0
+	for _, group := range rec.Content.OperationGroups {
+		for _, operation := range group.Operations {
+			if match := projectRegexp.FindStringSubmatch(operation.Resource); match != nil {
+				numResources++
+				if err := s.check(match[1]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if numResources == 0 {
+		return s.check(match[1])
+	}
+	return nil
+}
+
+// checkInsight checks that the insight is for an allowed project.
+// Like in checkRecommendation, the projects of its target resources are checked,
+// unless the name contains an allowed project.
+func (s *restrictedService) checkInsight(insight *recommender.GoogleCloudRecommenderV1Insight) error {
+	match := projectRegexp.FindStringSubmatch(insight.Name)
+	if match == nil {
+		return fmt.Errorf("no project in insight name %s", insight.Name)
+	}
+	if s.check(match[1]) == nil {
+		return nil
+	}
+	numResources := 0
+	for _, resource := range insight.TargetResources {
+		if match := projectRegexp.FindStringSubmatch(resource); match != nil {
+			numResources++
+			if err := s.check(match[1]); err != nil {
+				return err
+			}
+		}
+	}
+	if numResources == 0 {
+		return s.check(match[1])
+	}
+	return nil
+}
+
+// checkName checks that the recommendation with the given name is for an allowed project.
+// The recommendation is got, unless it has been checked before.
+func (s *restrictedService) checkName(ctx context.Context, name string) error {
+	s.mutex.Lock()
+	checked := s.checked[name]
+	s.mutex.Unlock()
+	if checked {
+		return nil
+	}
+	_, err := s.GetRecommendation(ctx, name)
+	return err
+}
+
+func (s *restrictedService) markChecked(recs ...*recommender.GoogleCloudRecommenderV1Recommendation) {
+	s.mutex.Lock()
+	for _, rec := range recs {
+		s.checked[rec.Name] = true
+	}
+	s.mutex.Unlock()
+}
+
+func (s *restrictedService) ChangeMachineType(ctx context.Context, project, zone, instance, machineType string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.ChangeMachineType(ctx, project, zone, instance, machineType)
+}
+
+func (s *restrictedService) CreateDisk(ctx context.Context, project, zone string, disk *compute.Disk) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.CreateDisk(ctx, project, zone, disk)
+}
+
+func (s *restrictedService) CreateSnapshot(ctx context.Context, project, zone, disk string, snapshot *compute.Snapshot) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.CreateSnapshot(ctx, project, zone, disk, snapshot)
+}
+
+func (s *restrictedService) DeleteSnapshot(ctx context.Context, project, snapshot string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.DeleteSnapshot(ctx, project, snapshot)
+}
+
+func (s *restrictedService) ListSnapshots(ctx context.Context, project, filter string) ([]*compute.Snapshot, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListSnapshots(ctx, project, filter)
+}
+
+func (s *restrictedService) DeleteDisk(ctx context.Context, project, zone, disk string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.DeleteDisk(ctx, project, zone, disk)
+}
+
+func (s *restrictedService) DeleteImage(ctx context.Context, project, image string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.DeleteImage(ctx, project, image)
+}
+
+func (s *restrictedService) GetDisk(ctx context.Context, project, zone, disk string) (*compute.Disk, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetDisk(ctx, project, zone, disk)
+}
+
+func (s *restrictedService) GetSnapshot(ctx context.Context, project, snapshot string) (*compute.Snapshot, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetSnapshot(ctx, project, snapshot)
+}
+
+func (s *restrictedService) GetInstance(ctx context.Context, project, zone, instance string) (*compute.Instance, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetInstance(ctx, project, zone, instance)
+}
+
+func (s *restrictedService) GetRecommendation(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	rec, err := s.GoogleService.GetRecommendation(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRecommendation(rec); err != nil {
+		return nil, err
+	}
+	s.markChecked(rec)
+	return rec, nil
+}
+
+func (s *restrictedService) GetInsight(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Insight, error) {
+	insight, err := s.GoogleService.GetInsight(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkInsight(insight); err != nil {
+		return nil, err
+	}
+	return insight, nil
+}
+
+func (s *restrictedService) ListInsights(ctx context.Context, project, location, insightTypeID string) ([]*recommender.GoogleCloudRecommenderV1Insight, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListInsights(ctx, project, location, insightTypeID)
+}
+
+func (s *restrictedService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*automation.Requirement, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListAPIRequirements(ctx, project, apis)
+}
+
+func (s *restrictedService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*automation.Requirement, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListPermissionRequirements(ctx, project, permissions)
+}
+
+// ListProjects lists only the allowed projects.
+func (s *restrictedService) ListProjects(ctx context.Context) ([]string, error) {
+	projects, err := s.GoogleService.ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.allowedProjects(projects), nil
+}
+
+// ListProjectsByLabels lists only the allowed projects with the labels.
+func (s *restrictedService) ListProjectsByLabels(ctx context.Context, labels map[string]string) ([]string, error) {
+	projects, err := s.GoogleService.ListProjectsByLabels(ctx, labels)
+	if err != nil {
+		return nil, err
+	}
+	return s.allowedProjects(projects), nil
+}
+
+func (s *restrictedService) allowedProjects(projects []string) []string {
+	result := []string{}
+	for _, project := range projects {
+		if s.check(project) == nil {
+			result = append(result, project)
+		}
+	}
+	return result
+}
+
+func (s *restrictedService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	recs, err := s.GoogleService.ListRecommendations(ctx, project, location, recommenderID, filter)
+	if err != nil {
+		return nil, err
+	}
+	s.markChecked(recs...)
+	return recs, nil
+}
+
+func (s *restrictedService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListZonesNames(ctx, project)
+}
+
+func (s *restrictedService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.ListRegionsNames(ctx, project)
+}
+
+func (s *restrictedService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.MarkRecommendationClaimed(ctx, name, etag)
+}
+
+func (s *restrictedService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.MarkRecommendationSucceeded(ctx, name, etag)
+}
+
+func (s *restrictedService) MarkRecommendationFailed(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.MarkRecommendationFailed(ctx, name, etag)
+}
+
+func (s *restrictedService) MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.MarkRecommendationDismissed(ctx, name, etag, reason)
+}
+
+func (s *restrictedService) MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	if err := s.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.MarkRecommendationActive(ctx, name, etag, reason)
+}
+
+func (s *restrictedService) ReleaseAddress(ctx context.Context, project, region, address string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.ReleaseAddress(ctx, project, region, address)
+}
+
+func (s *restrictedService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.StopInstance(ctx, project, zone, instance)
+}
+
+func (s *restrictedService) GetInstanceTemplate(ctx context.Context, project, template string) (*compute.InstanceTemplate, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetInstanceTemplate(ctx, project, template)
+}
+
+func (s *restrictedService) CreateInstanceTemplate(ctx context.Context, project string, template *compute.InstanceTemplate) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.CreateInstanceTemplate(ctx, project, template)
+}
+
+func (s *restrictedService) DeleteInstanceTemplate(ctx context.Context, project, template string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.DeleteInstanceTemplate(ctx, project, template)
+}
+
+func (s *restrictedService) GetInstanceGroupManager(ctx context.Context, project, location, manager string) (*compute.InstanceGroupManager, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetInstanceGroupManager(ctx, project, location, manager)
+}
+
+func (s *restrictedService) SetInstanceGroupManagerTemplate(ctx context.Context, project, location, manager, template string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.SetInstanceGroupManagerTemplate(ctx, project, location, manager, template)
+}
+
+func (s *restrictedService) GetSQLInstance(ctx context.Context, project, instance string) (*sqladmin.DatabaseInstance, error) {
+	if err := s.check(project); err != nil {
+		return nil, err
+	}
+	return s.GoogleService.GetSQLInstance(ctx, project, instance)
+}
+
+func (s *restrictedService) SetSQLActivationPolicy(ctx context.Context, project, instance, policy string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.SetSQLActivationPolicy(ctx, project, instance, policy)
+}
+
+func (s *restrictedService) ChangeSQLTier(ctx context.Context, project, instance, tier string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.ChangeSQLTier(ctx, project, instance, tier)
+}
+
+func (s *restrictedService) StartInstance(ctx context.Context, project, zone, instance string) error {
+	if err := s.check(project); err != nil {
+		return err
+	}
+	return s.GoogleService.StartInstance(ctx, project, zone, instance)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/recommender/v1"
+)
+
+func TestParseProjectAllowlist(t *testing.T) {
+	allowlist, err := ParseProjectAllowlist(" alice@example.com = a, b ;bob@example.com=;*=shared")
+	if assert.NoError(t, err) {
+		expected := ProjectAllowlist{
+			"alice@example.com": {"a", "b"},
+			"bob@example.com":   {},
+			"*":                 {"shared"},
+		}
+		assert.Equal(t, expected, allowlist)
+		assert.True(t, allowlist.allowed("alice@example.com", "b"))
+		assert.False(t, allowlist.allowed("alice@example.com", "shared"), "Listed users get only their projects")
+		assert.False(t, allowlist.allowed("bob@example.com", "shared"), "Bob is allowed no projects")
+		assert.True(t, allowlist.allowed("carol@example.com", "shared"), "Other users get projects of *")
+	}
+
+	allowlist, _ = ParseProjectAllowlist("admin@example.com=*")
+	assert.True(t, allowlist.allowed("admin@example.com", "any"), "* allows all projects")
+	assert.False(t, allowlist.allowed("carol@example.com", "any"), "Other users are allowed nothing")
+
+	_, err = ParseProjectAllowlist("project")
+	assert.Error(t, err, "Entry without email")
+}
+
+// allowlistMockService records the names of recommendations that were marked.
+type allowlistMockService struct {
+	automation.GoogleService
+	marked []string
+}
+
+func (s *allowlistMockService) ListProjects(ctx context.Context) ([]string, error) {
+	return []string{"allowed", "forbidden"}, nil
+}
+
+func (s *allowlistMockService) StopInstance(ctx context.Context, project, zone, instance string) error {
+	return nil
+}
+
+func (s *allowlistMockService) GetRecommendation(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	project := "allowed"
+	if name == "projects/2/recommendation" {
+		project = "forbidden"
+	}
+	return &recommender.GoogleCloudRecommenderV1Recommendation{
+		Name: name,
+		Content: &gcloudContent{
+			OperationGroups: []*gcloudOperationGroup{{
+				Operations: []*recommender.GoogleCloudRecommenderV1Operation{{
+					Resource: "//compute.googleapis.com/projects/" + project + "/zones/zone/instances/instance",
+				}},
+			}},
+		},
+	}, nil
+}
+
+func (s *allowlistMockService) GetInsight(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Insight, error) {
+	project := "allowed"
+	if name == "projects/2/insight" {
+		project = "forbidden"
+	}
+	return &recommender.GoogleCloudRecommenderV1Insight{
+		Name:            name,
+		TargetResources: []string{"//compute.googleapis.com/projects/" + project + "/zones/zone/instances/instance"},
+	}, nil
+}
+
+func (s *allowlistMockService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	s.marked = append(s.marked, name)
+	return nil, nil
+}
+
+func TestRestrictedService(t *testing.T) {
+	ctx := context.Background()
+	mock := &allowlistMockService{}
+	auth := newAllowlistAuth(&mockAuth{users: map[string]User{getToken("email"): {email: "email", service: mock}}},
+		ProjectAllowlist{"email": {"allowed"}})
+	user, ok := auth.GetUser("email")
+	if !assert.True(t, ok) {
+		return
+	}
+	service := user.service
+
+	projects, err := service.ListProjects(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"allowed"}, projects, "Only allowed projects should be listed")
+
+	assert.NoError(t, service.StopInstance(ctx, "allowed", "zone", "instance"))
+	err = service.StopInstance(ctx, "forbidden", "zone", "instance")
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusForbidden, err.(*googleapi.Error).Code)
+	}
+
+	// recommendation names contain project numbers, the resources are checked instead
+	_, err = service.MarkRecommendationClaimed(ctx, "projects/1/recommendation", "etag")
+	assert.NoError(t, err)
+	_, err = service.MarkRecommendationClaimed(ctx, "projects/2/recommendation", "etag")
+	assert.Error(t, err, "Recommendation for forbidden project")
+	assert.Equal(t, []string{"projects/1/recommendation"}, mock.marked)
+
+	_, err = service.GetInsight(ctx, "projects/1/insight")
+	assert.NoError(t, err)
+	_, err = service.GetInsight(ctx, "projects/2/insight")
+	assert.Error(t, err, "Insight for forbidden project")
+
+	again, _ := auth.GetUser("email")
+	assert.True(t, user.service == again.service, "Restricted service should be reused")
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -27,32 +30,65 @@ const (
 	inProgressStatus = "IN PROGRESS"
 	failedStatus     = "FAILED"
 	succeededStatus  = "SUCCEEDED"
+	// the safeguards refused to change a resource, nothing has been changed
+	skippedStatus = "SKIPPED"
+
+	needsReconciliationStatus = "NEEDS RECONCILIATION"
 )
 
-// CheckStatusResponse is the response to recommendations/name/checkStatus method
+const canceledMessage = "applying the recommendation was canceled"
+
+// CheckStatusResponse is the response to recommendations/name/checkStatus method.
+// If applying failed after some changes had been made,
+// Rollback contains the outcome of reverting them.
+// Results describe the changes made by the operations, for example the names of created snapshots,
+// they're saved with the request, so they're also returned after a restart.
 type CheckStatusResponse struct {
-	Status       string `json:"status"`
-	ErrorMessage string `json:"errorMessage,omitempty"`
+	Status       string                        `json:"status"`
+	ErrorMessage string                        `json:"errorMessage,omitempty"`
+	Rollback     *automation.RollbackResult    `json:"rollback,omitempty"`
+	Results      []*automation.OperationResult `json:"results,omitempty"`
+}
+
+// ApplyParams are the parameters of apply request saved in RequestStore.
+type ApplyParams struct {
+	Name string `json:"name"`
 }
 
 type applyRequestHandler struct {
-	service automation.GoogleService
-	name    string
-	err     error
-	task    automation.Task
+	service    automation.GoogleService
+	safeguards *automation.Safeguards
+	snapshots  *automation.SnapshotOptions
+	name       string
+	results    []*automation.OperationResult
+	err        error
+	task       automation.Task
 }
 
-// NewApplyRequestHandler creates new applyRequestHandler
-func NewApplyRequestHandler(service automation.GoogleService, name string) RequestHandler {
-	return &applyRequestHandler{service: service, name: name}
+// NewApplyRequestHandler creates new applyRequestHandler.
+// safeguards, if not nil, are checked before changing resources,
+// snapshots, if not nil, configure the created snapshots.
+func NewApplyRequestHandler(service automation.GoogleService, name string, safeguards *automation.Safeguards,
+	snapshots *automation.SnapshotOptions) RequestHandler {
+	return &applyRequestHandler{service: service, name: name, safeguards: safeguards, snapshots: snapshots}
 }
 
-func (h *applyRequestHandler) Start() {
+func (h *applyRequestHandler) Start(ctx context.Context) {
 	h.task.SetNumberOfSubtasks(1) // 1 call to ApplyByName
-	h.err = automation.ApplyByName(h.service, h.name, h.task.GetNextSubtask())
+	ctx = automation.WithSafeguards(ctx, h.safeguards)
+	ctx = automation.WithSnapshotOptions(ctx, h.snapshots)
+	h.results, h.err = automation.ApplyByName(ctx, h.service, h.name, h.task.GetNextSubtask())
 	h.task.SetAllDone()
 }
 
+func (h *applyRequestHandler) Kind() string {
+	return applyRequestKind
+}
+
+func (h *applyRequestHandler) Params() interface{} {
+	return ApplyParams{Name: h.name}
+}
+
 func (h *applyRequestHandler) GetResponse() (Response, bool) {
 	done, all := h.task.GetProgress()
 	var response interface{}
@@ -61,15 +97,30 @@ func (h *applyRequestHandler) GetResponse() (Response, bool) {
 		response = CheckStatusResponse{Status: inProgressStatus}
 	} else {
 		finished = true
-		if h.err != nil {
-			response = CheckStatusResponse{Status: failedStatus, ErrorMessage: h.err.Error()}
-		} else {
-			response = CheckStatusResponse{Status: succeededStatus}
-		}
+		response = finishedStatus(h.results, h.err)
 	}
 	return Response{Content: response}, finished
 }
 
+// finishedStatus returns the status of the finished apply, results and err are returned by applying.
+func finishedStatus(results []*automation.OperationResult, err error) CheckStatusResponse {
+	if err == nil {
+		return CheckStatusResponse{Status: succeededStatus, Results: results}
+	}
+	response := CheckStatusResponse{Status: failedStatus, ErrorMessage: err.Error(), Results: results}
+	if automation.IsSkipped(err) {
+		response.Status = skippedStatus
+	}
+	if errors.Is(err, context.Canceled) {
+		response.ErrorMessage = canceledMessage
+	}
+	var applyErr *automation.ApplyError
+	if errors.As(err, &applyErr) {
+		response.Rollback = applyErr.Rollback
+	}
+	return response
+}
+
 func getApplyHandler(service *SharedService) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		name := c.Query("name")
@@ -81,7 +132,7 @@ func getApplyHandler(service *SharedService) func(c *gin.Context) {
 		}
 
 		err = service.requests.StartProcessing(RequestInfo{user.email, name},
-			NewApplyRequestHandler(user.service, name))
+			NewApplyRequestHandler(user.service, name, service.safeguards, service.snapshotOptions.WithUser(user.email)))
 		if err != nil {
 			sendError(c, err)
 			return
@@ -90,6 +141,27 @@ func getApplyHandler(service *SharedService) func(c *gin.Context) {
 	}
 }
 
+// getCancelApplyHandler cancels applying the recommendation.
+// The operation in progress is finished first and the changes already made are reverted,
+// the final status can be checked with checkStatus method.
+func getCancelApplyHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		if !service.requests.Cancel(RequestInfo{user.email, name}) {
+			sendError(c, fmt.Errorf("Recommendation %s is not being applied by %s", name, user.email), http.StatusNotFound)
+			return
+		}
+		c.String(http.StatusOK, "")
+	}
+}
+
 func getCheckStatusHandler(service *SharedService) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		name := c.Query("name")
@@ -107,7 +179,7 @@ func getCheckStatusHandler(service *SharedService) func(c *gin.Context) {
 			return
 		}
 
-		rec, err := user.service.GetRecommendation(name)
+		rec, err := user.service.GetRecommendation(c.Request.Context(), name)
 		if err != nil {
 			sendError(c, err)
 		} else {
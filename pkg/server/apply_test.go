@@ -14,6 +14,7 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -30,7 +31,7 @@ type mockApply struct {
 	wait  bool // doesn't finish applying until wait is true
 }
 
-func (s *mockApply) GetRecommendation(name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+func (s *mockApply) GetRecommendation(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
 	s.mutex.Lock()
 	s.names = append(s.names, name)
 	s.mutex.Unlock()
@@ -54,11 +55,11 @@ func (s *mockApply) StopWaiting() {
 
 func TestApplySimple(t *testing.T) {
 	mock := &mockApply{wait: true}
-	handler := NewApplyRequestHandler(mock, "name")
+	handler := NewApplyRequestHandler(mock, "name", nil, nil)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		handler.Start()
+		handler.Start(context.Background())
 		wg.Done()
 	}()
 
@@ -80,3 +81,48 @@ func TestApplySimple(t *testing.T) {
 
 	assert.Equal(t, mock.names, []string{"name"})
 }
+
+func TestApplyRollbackInResponse(t *testing.T) {
+	rollback := &automation.RollbackResult{
+		Succeeded: true,
+		Actions:   []*automation.RollbackAction{{Description: "start instance", Succeeded: true}},
+	}
+	handler := &applyRequestHandler{err: &automation.ApplyError{Err: fmt.Errorf("quota exceeded"), Rollback: rollback}}
+	handler.task.SetAllDone()
+
+	resp, done := handler.GetResponse()
+	assert.True(t, done, "Should be done already")
+	status, ok := resp.Content.(CheckStatusResponse)
+	assert.True(t, ok, "Response should be of type CheckStatusResponse")
+	assert.Equal(t, failedStatus, status.Status, "Status should be failed")
+	assert.Equal(t, "quota exceeded", status.ErrorMessage, "Original error should be returned")
+	assert.Equal(t, rollback, status.Rollback, "Outcome of the rollback should be returned")
+}
+
+func TestApplySkippedStatus(t *testing.T) {
+	handler := &applyRequestHandler{err: &automation.SkippedError{Resource: "disk", Reason: "labelled recomator-skip=true"}}
+	handler.task.SetAllDone()
+
+	resp, done := handler.GetResponse()
+	assert.True(t, done, "Should be done already")
+	status, ok := resp.Content.(CheckStatusResponse)
+	assert.True(t, ok, "Response should be of type CheckStatusResponse")
+	assert.Equal(t, skippedStatus, status.Status, "Status should be skipped")
+	assert.Contains(t, status.ErrorMessage, "recomator-skip", "Reason of skipping should be returned")
+}
+
+func TestApplyResultsInResponse(t *testing.T) {
+	results := []*automation.OperationResult{
+		{Action: automation.ActionCreateSnapshot, Project: "project", Location: "zone", Resource: "disk", SnapshotName: "snapshot"},
+		{Action: automation.ActionDeleteDisk, Project: "project", Location: "zone", Resource: "disk", SnapshotName: "snapshot"},
+	}
+	handler := &applyRequestHandler{results: results}
+	handler.task.SetAllDone()
+
+	resp, done := handler.GetResponse()
+	assert.True(t, done, "Should be done already")
+	status, ok := resp.Content.(CheckStatusResponse)
+	assert.True(t, ok, "Response should be of type CheckStatusResponse")
+	assert.Equal(t, succeededStatus, status.Status, "Status should be succeeded")
+	assert.Equal(t, results, status.Results, "Results of the operations should be returned")
+}
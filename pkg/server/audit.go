@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/audit"
+)
+
+// auditAuth records the changes made by users of the embedded AuthorizationService in the audit log.
+type auditAuth struct {
+	AuthorizationService
+	sink audit.Sink
+}
+
+func newAuditAuth(auth AuthorizationService, sink audit.Sink) AuthorizationService {
+	return &auditAuth{AuthorizationService: auth, sink: sink}
+}
+
+// GetUser returns the user with GoogleService recording changes with the email of the user.
+func (a *auditAuth) GetUser(email string) (User, bool) {
+	user, ok := a.AuthorizationService.GetUser(email)
+	if !ok {
+		return user, false
+	}
+	return User{service: audit.NewService(user.service, a.sink, email), email: email}, true
+}
+
+// AuditResponse is the response to GET /api/audit method.
+type AuditResponse struct {
+	Entries []*audit.Entry `json:"entries"`
+}
+
+var errAuditNotConfigured = errors.New("the audit log is not configured")
+
+// parseAuditFilter reads the filter from the user, project, recommendation, from and to query parameters,
+// times are in RFC 3339 format.
+func parseAuditFilter(c *gin.Context) (audit.Filter, error) {
+	filter := audit.Filter{Actor: c.Query("user"), Project: c.Query("project"), Recommendation: c.Query("recommendation")}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, fmt.Errorf("invalid from parameter: %v", err)
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, fmt.Errorf("invalid to parameter: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// visibleEntries returns the entries, that the user may read. With the allowlist,
+// these are the user's own entries and the ones in the projects allowed for the user,
+// without it, only the user's own entries, as projects of other users may be inaccessible to the user.
+func visibleEntries(entries []*audit.Entry, email string, allowlist ProjectAllowlist) []*audit.Entry {
+	visible := []*audit.Entry{}
+	for _, entry := range entries {
+		if entry.Actor == email || (allowlist != nil && entry.Project != "" && allowlist.allowed(email, entry.Project)) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+// getAuditHandler returns the entries of the audit log selected by the query parameters,
+// which the user may read.
+func getAuditHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		user, err := authorizeRequest(service.auth, c.Request)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			sendError(c, err, http.StatusBadRequest)
+			return
+		}
+
+		if service.auditSink == nil {
+			sendError(c, errAuditNotConfigured, http.StatusNotImplemented)
+			return
+		}
+		entries, err := audit.Query(service.auditSink, filter)
+		if errors.Is(err, audit.ErrNotQueryable) {
+			sendError(c, err, http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, AuditResponse{Entries: visibleEntries(entries, user.email, service.allowlist)})
+	}
+}
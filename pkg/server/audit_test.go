@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryAudit returns the entries of the audit log returned to the user for the query.
+func queryAudit(t *testing.T, router http.Handler, user, query string) []*audit.Entry {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/audit"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(user))
+	router.ServeHTTP(w, req)
+	var response AuditResponse
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&response), "No error expected")
+	}
+	return response.Entries
+}
+
+func TestAudit(t *testing.T) {
+	service := newMockShared()
+	sink := &audit.MemorySink{}
+	service.auth = newAuditAuth(service.auth, sink)
+	service.auditSink = sink
+	router := SetUpRouter(service)
+	createUser("alice", router)
+	createUser("bob", router)
+
+	dismissed := map[string][]string{
+		"alice": {"projects/project/recs/r1"},
+		"bob":   {"projects/project/recs/r1", "projects/other/recs/r2"},
+	}
+	for code, names := range dismissed {
+		for _, name := range names {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/recommendations/dismiss?name="+name+"&reason=unused", nil)
+			req.Header.Add("Authorization", "Bearer "+getToken(code))
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, "Wrong response code")
+		}
+	}
+
+	// without the allowlist, users read only their own entries
+	assert.Empty(t, queryAudit(t, router, "alice", "?user=bob"), "Entries of other users shouldn't be returned")
+	entries := queryAudit(t, router, "alice", "")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "alice", entries[0].Actor)
+	}
+
+	// with the allowlist, users read the entries in their projects
+	service.allowlist = ProjectAllowlist{"alice": {"project"}, "bob": {"project", "other"}}
+	entries = queryAudit(t, router, "alice", "?user=bob&from=2020-01-01T00:00:00Z")
+	if assert.Len(t, entries, 1, "Only the entry in the project allowed for alice should be returned") {
+		entry := entries[0]
+		assert.Equal(t, "bob", entry.Actor)
+		assert.Equal(t, "MarkRecommendationDismissed", entry.Method)
+		assert.Equal(t, "projects/project/recs/r1", entry.Recommendation)
+		assert.Equal(t, "unused", entry.Value)
+		assert.True(t, entry.Succeeded)
+	}
+	assert.Len(t, queryAudit(t, router, "bob", "?user=alice"), 1)
+	assert.Len(t, queryAudit(t, router, "bob", "?project=other"), 1)
+	assert.Empty(t, queryAudit(t, router, "alice", "?project=other"), "Entries in other projects shouldn't be returned")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/audit?from=yesterday", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "Invalid time should be rejected")
+}
+
+func TestAuditNotConfigured(t *testing.T) {
+	router := SetUpRouter(newMockShared())
+	createUser("alice", router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/audit", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code, "Wrong response code")
+}
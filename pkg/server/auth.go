@@ -17,7 +17,9 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"sync"
@@ -57,16 +59,22 @@ type authorizationService struct {
 	tokenExpirationTime time.Duration
 	mutex               sync.Mutex
 	config              oauth2.Config
-	services            map[string]automation.GoogleService // key is email of the user
+	// services are created from the tokens in the store when they're needed for the first time.
+	services map[string]automation.GoogleService // key is email of the user
+	tokens   TokenStore
 }
 
-// NewAuthorizationService creates new AuthorizationService to access GoogleAPIs
-func NewAuthorizationService(config oauth2.Config) (AuthorizationService, error) {
+// NewAuthorizationService creates new AuthorizationService to access GoogleAPIs.
+// Tokens of users are saved in tokens, if nil, they're kept only in memory.
+func NewAuthorizationService(config oauth2.Config, tokens TokenStore) (AuthorizationService, error) {
 	provider, err := oidc.NewProvider(oauth2.NoContext, "https://accounts.google.com")
 	if err != nil {
 		return nil, err
 	}
-	authService := &authorizationService{tokenExpirationTime: tokenExpiry, services: make(map[string]automation.GoogleService)}
+	if tokens == nil {
+		tokens = NewMemoryTokenStore()
+	}
+	authService := &authorizationService{tokenExpirationTime: tokenExpiry, services: make(map[string]automation.GoogleService), tokens: tokens}
 	authService.verifier = provider.Verifier(&oidc.Config{ClientID: config.ClientID, SkipExpiryCheck: true})
 	authService.config = config
 	return authService, nil
@@ -98,12 +106,18 @@ func (s *authorizationService) CreateUser(authCode string) (string, error) {
 		return "", err
 	}
 
+	if err := s.tokens.SaveToken(email, token); err != nil {
+		return "", err
+	}
+
 	s.mutex.Lock()
 	s.services[email] = service
 	s.mutex.Unlock()
 	return idToken, nil
 }
 
+// GetUser returns the user with GoogleService created at login,
+// or created from the token saved in the store, for example before the server restarted.
 func (s *authorizationService) GetUser(email string) (User, bool) {
 	s.mutex.Lock()
 	service, ok := s.services[email]
@@ -111,7 +125,29 @@ func (s *authorizationService) GetUser(email string) (User, bool) {
 	if ok {
 		return User{service, email}, true
 	}
-	return User{}, false
+
+	token, ok, err := s.tokens.LoadToken(email)
+	if err != nil {
+		log.Printf("Loading token of %s failed: %v", email, err)
+		return User{}, false
+	}
+	if !ok {
+		return User{}, false
+	}
+	service, err = automation.NewGoogleService(context.Background(), &s.config, token)
+	if err != nil {
+		log.Printf("Creating Google service for %s failed: %v", email, err)
+		return User{}, false
+	}
+
+	s.mutex.Lock()
+	if existing, ok := s.services[email]; ok {
+		service = existing // created concurrently
+	} else {
+		s.services[email] = service
+	}
+	s.mutex.Unlock()
+	return User{service, email}, true
 }
 
 // Verifies idToken and returns email if everything suceeded.
@@ -164,7 +200,8 @@ func authorize(authService AuthorizationService, idToken string) (User, error) {
 	return user, nil
 }
 
-// authorizeRequest extracts the token from Authorization header in request
+// authorizeRequest extracts the token from Authorization header in request,
+// or the JWT from X-Goog-IAP-JWT-Assertion header if there's no Authorization header,
 // and uses it to return authorized user using authService.
 func authorizeRequest(authService AuthorizationService, request *http.Request) (User, error) {
 	bearToken := request.Header["Authorization"]
@@ -174,6 +211,8 @@ func authorizeRequest(authService AuthorizationService, request *http.Request) (
 		if len(strArr) == 2 && strArr[0] == "Bearer" {
 			return authorize(authService, strArr[1])
 		}
+	} else if iapToken := request.Header.Get(iapHeader); iapToken != "" {
+		return authorize(authService, iapToken)
 	}
 	return User{}, &googleapi.Error{Code: http.StatusBadRequest, Message: "Authorization header not in the form 'Bearer <token>'"}
 
@@ -0,0 +1,251 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/automation"
+)
+
+// pendingStatus is the status of a recommendation of a batch that hasn't been started yet.
+const pendingStatus = "PENDING"
+
+// maxBatchSize is the maximum number of recommendations in one batch.
+const maxBatchSize = 1000
+
+// BatchApplyRequest contains the body of POST /recommendations/batchApply request.
+type BatchApplyRequest struct {
+	Names []string `json:"names"`
+}
+
+// BatchItemStatus is the status of applying one recommendation of the batch.
+type BatchItemStatus struct {
+	Name string `json:"name"`
+	CheckStatusResponse
+}
+
+// BatchStatusResponse is the response to GET /recommendations/batchApply method.
+// Status is IN PROGRESS until all recommendations are finished, then it's SUCCEEDED
+// if all of them succeeded and FAILED otherwise.
+// Counts contains the number of recommendations with each status.
+type BatchStatusResponse struct {
+	Status          string             `json:"status"`
+	Counts          map[string]int     `json:"counts"`
+	Recommendations []*BatchItemStatus `json:"recommendations"`
+}
+
+// newBatchStatusResponse creates the response with the given statuses of recommendations.
+func newBatchStatusResponse(items []*BatchItemStatus, finished bool) BatchStatusResponse {
+	response := BatchStatusResponse{Status: inProgressStatus, Counts: make(map[string]int), Recommendations: items}
+	for _, item := range items {
+		response.Counts[item.Status]++
+	}
+	if finished {
+		response.Status = succeededStatus
+		if response.Counts[succeededStatus] != len(items) {
+			response.Status = failedStatus
+		}
+	}
+	return response
+}
+
+// interrupted changes the response of the batch interrupted by a restart.
+// Recommendations in progress need reconciliation, the ones not started yet fail.
+func (r *BatchStatusResponse) interrupted() {
+	for i, item := range r.Recommendations {
+		switch item.Status {
+		case inProgressStatus:
+			r.Recommendations[i].CheckStatusResponse = CheckStatusResponse{
+				Status:       needsReconciliationStatus,
+				ErrorMessage: reconciliationMessage,
+			}
+		case pendingStatus:
+			r.Recommendations[i].CheckStatusResponse = CheckStatusResponse{
+				Status:       failedStatus,
+				ErrorMessage: interruptedMessage,
+			}
+		}
+	}
+	*r = newBatchStatusResponse(r.Recommendations, true)
+	if r.Counts[needsReconciliationStatus] != 0 {
+		r.Status = needsReconciliationStatus
+	}
+}
+
+type batchApplyRequestHandler struct {
+	service    automation.GoogleService
+	safeguards *automation.Safeguards
+	snapshots  *automation.SnapshotOptions
+	names      []string
+	mutex      sync.Mutex
+	items      []*BatchItemStatus
+	done       bool
+}
+
+// NewBatchApplyRequestHandler creates new batchApplyRequestHandler.
+// safeguards, if not nil, are checked before changing resources,
+// snapshots, if not nil, configure the created snapshots.
+func NewBatchApplyRequestHandler(service automation.GoogleService, names []string, safeguards *automation.Safeguards,
+	snapshots *automation.SnapshotOptions) RequestHandler {
+	items := make([]*BatchItemStatus, len(names))
+	for i, name := range names {
+		items[i] = &BatchItemStatus{Name: name, CheckStatusResponse: CheckStatusResponse{Status: pendingStatus}}
+	}
+	return &batchApplyRequestHandler{service: service, safeguards: safeguards, snapshots: snapshots, names: names, items: items}
+}
+
+func (h *batchApplyRequestHandler) setStatus(i int, status CheckStatusResponse) {
+	h.mutex.Lock()
+	h.items[i].CheckStatusResponse = status
+	h.mutex.Unlock()
+}
+
+func (h *batchApplyRequestHandler) Start(ctx context.Context) {
+	options := automation.BatchOptions{
+		OnStart: func(i int) {
+			h.setStatus(i, CheckStatusResponse{Status: inProgressStatus})
+		},
+		OnFinish: func(i int, results []*automation.OperationResult, err error) {
+			h.setStatus(i, finishedStatus(results, err))
+		},
+	}
+	ctx = automation.WithSafeguards(ctx, h.safeguards)
+	ctx = automation.WithSnapshotOptions(ctx, h.snapshots)
+	automation.ApplyBatch(ctx, h.service, h.names, options, &automation.Task{})
+	h.mutex.Lock()
+	h.done = true
+	h.mutex.Unlock()
+}
+
+func (h *batchApplyRequestHandler) Kind() string {
+	return batchApplyRequestKind
+}
+
+func (h *batchApplyRequestHandler) Params() interface{} {
+	return BatchApplyRequest{Names: h.names}
+}
+
+func (h *batchApplyRequestHandler) GetResponse() (Response, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	// items are copied, as they're changed while the response is sent
+	items := make([]*BatchItemStatus, len(h.items))
+	for i, item := range h.items {
+		copied := *item
+		items[i] = &copied
+	}
+	return Response{Content: newBatchStatusResponse(items, h.done)}, h.done
+}
+
+func getStartBatchApplyHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var batchRequest BatchApplyRequest
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			sendError(c, fmt.Errorf("Error reading body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		err = json.Unmarshal(body, &batchRequest)
+
+		if err != nil {
+			sendError(c, fmt.Errorf("Error parsing body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if len(batchRequest.Names) == 0 || len(batchRequest.Names) > maxBatchSize {
+			sendError(c, fmt.Errorf("Batch must contain from 1 to %d recommendations", maxBatchSize), http.StatusBadRequest)
+			return
+		}
+
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		handler := NewBatchApplyRequestHandler(user.service, batchRequest.Names, service.safeguards,
+			service.snapshotOptions.WithUser(user.email))
+		requestID, err := StartProcessingWithNewRequestID(&service.requests, user.email, handler)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.String(http.StatusCreated, requestID)
+	}
+}
+
+// getCancelBatchApplyHandler cancels applying the recommendations of the batch.
+// The ones in progress are finished or reverted, the ones not started fail.
+func getCancelBatchApplyHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Query("request_id")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		info := RequestInfo{user.email, id}
+		if kind, ok := service.requests.Kind(info); !ok || kind != batchApplyRequestKind || !service.requests.Cancel(info) {
+			sendError(c, fmt.Errorf("No batch apply request for %s with id %s", user.email, id), http.StatusNotFound)
+			return
+		}
+		c.String(http.StatusOK, "")
+	}
+}
+
+func getBatchStatusHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Query("request_id")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		info := RequestInfo{user.email, id}
+		if kind, ok := service.requests.Kind(info); !ok || kind != batchApplyRequestKind {
+			sendError(c, fmt.Errorf("No batch apply request for %s with id %s", user.email, id), http.StatusNotFound)
+			return
+		}
+		response, ok := service.requests.GetResponse(info)
+
+		if !ok {
+			sendError(c, fmt.Errorf("No batch apply request for %s with id %s", user.email, id), http.StatusNotFound)
+			return
+		}
+
+		if response.Error != nil {
+			sendError(c, response.Error)
+			return
+		}
+
+		c.JSON(http.StatusOK, response.Content)
+	}
+}
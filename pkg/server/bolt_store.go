@@ -0,0 +1,91 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var requestsBucket = []byte("requests")
+
+const boltOpenTimeout = 10 * time.Second
+
+// boltRequestStore keeps records in a BoltDB file.
+// Records are JSON encoded and keyed by the email of the owner and the request ID.
+type boltRequestStore struct {
+	db *bolt.DB
+}
+
+// NewBoltRequestStore opens or creates the BoltDB file at path and returns RequestStore using it.
+// The file can be used by one process at a time.
+func NewBoltRequestStore(path string) (RequestStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(requestsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltRequestStore{db: db}, nil
+}
+
+func boltKey(info RequestInfo) []byte {
+	return []byte(info.email + "\x00" + info.requestID)
+}
+
+func (s *boltRequestStore) Save(record *RequestRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(requestsBucket).Put(boltKey(record.info()), value)
+	})
+}
+
+func (s *boltRequestStore) Delete(info RequestInfo) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(requestsBucket).Delete(boltKey(info))
+	})
+}
+
+func (s *boltRequestStore) List() ([]*RequestRecord, error) {
+	var result []*RequestRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(requestsBucket).ForEach(func(key, value []byte) error {
+			var record RequestRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			result = append(result, &record)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltRequestStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/googleinterns/recomator/pkg/emulator"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/recommender/v1"
+)
+
+// Checks applying a recommendation end to end, with the requests of the server sent to the emulator of Google APIs.
+func TestApplyWithEmulator(t *testing.T) {
+	name := "projects/123/locations/us-central1-a/recommenders/google.compute.instance.MachineTypeRecommender/recommendations/r1"
+	var recommendation recommender.GoogleCloudRecommenderV1Recommendation
+	err := json.Unmarshal([]byte(`{
+		"name": "`+name+`",
+		"content": {"operationGroups": [{"operations": [
+			{"action": "test", "path": "/machineType", "resourceType": "compute.googleapis.com/Instance",
+				"resource": "//compute.googleapis.com/projects/my-project/zones/us-central1-a/instances/vm",
+				"valueMatcher": {"matchesPattern": ".*zones/us-central1-a/machineTypes/n1-standard-4"}},
+			{"action": "replace", "path": "/machineType", "resourceType": "compute.googleapis.com/Instance",
+				"resource": "//compute.googleapis.com/projects/my-project/zones/us-central1-a/instances/vm",
+				"value": "zones/us-central1-a/machineTypes/e2-medium"}
+		]}]}
+	}`), &recommendation)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fake := emulator.New()
+	fake.AddProject("my-project", "123", nil)
+	fake.AddInstance("my-project", "us-central1-a", &compute.Instance{Name: "vm", MachineType: "n1-standard-4"})
+	fake.AddRecommendation(&recommendation)
+	server := httptest.NewServer(fake.Handler())
+	defer server.Close()
+	googleService, err := automation.NewEmulatedGoogleService(context.Background(), server.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser("alice", router)
+	auth := service.auth.(*mockAuth)
+	auth.users[getToken("alice")] = User{email: "alice", service: googleService}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/apply?name="+name, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusCreated, w.Code, "Wrong response code") {
+		return
+	}
+	checkApplySuceeded(t, router, getToken("alice"), name)
+
+	instance, _ := fake.Instance("my-project", "us-central1-a", "vm")
+	assert.True(t, strings.HasSuffix(instance.MachineType, "/e2-medium"), "Machine type should be changed")
+	assert.Equal(t, "RUNNING", instance.Status, "The instance should be started again")
+	applied, _ := fake.Recommendation(name)
+	assert.Equal(t, "SUCCEEDED", applied.StateInfo.State)
+}
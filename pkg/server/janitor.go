@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// RequestsLimits specify how long requests are kept in RequestsMap
+// and how many requests one user can have in progress.
+// Non-positive values disable the corresponding limit.
+type RequestsLimits struct {
+	// FinishedRetention is the time for which the result of a finished request is kept.
+	// Results of applies are deleted earlier, once they're read.
+	FinishedRetention time.Duration
+	// UnfinishedRetention is the time after which a request still in progress
+	// is canceled and deleted.
+	UnfinishedRetention time.Duration
+	// MaxRequestsPerUser is the maximum number of requests in progress per user.
+	MaxRequestsPerUser int
+}
+
+const (
+	defaultFinishedRetention   = time.Hour
+	defaultUnfinishedRetention = 6 * time.Hour
+	defaultMaxRequestsPerUser  = 10
+
+	janitorInterval = time.Minute
+)
+
+// DefaultRequestsLimits returns the limits used if none are configured.
+func DefaultRequestsLimits() RequestsLimits {
+	return RequestsLimits{
+		FinishedRetention:   defaultFinishedRetention,
+		UnfinishedRetention: defaultUnfinishedRetention,
+		MaxRequestsPerUser:  defaultMaxRequestsPerUser,
+	}
+}
+
+// SetLimits changes the limits of the map.
+// They're applied to requests already in the map by the next eviction.
+func (m *RequestsMap) SetLimits(limits RequestsLimits) {
+	m.mutex.Lock()
+	m.limits = limits
+	m.mutex.Unlock()
+}
+
+// expired checks whether the entry should be evicted at time now.
+func (limits *RequestsLimits) expired(entry *requestEntry, now time.Time) bool {
+	if entry.finishTime.IsZero() {
+		return limits.UnfinishedRetention > 0 && now.Sub(entry.startTime) > limits.UnfinishedRetention
+	}
+	return limits.FinishedRetention > 0 && now.Sub(entry.finishTime) > limits.FinishedRetention
+}
+
+// evictExpired cancels and deletes the requests that have been kept longer than allowed by the limits.
+// Returns the number of evicted requests.
+func (m *RequestsMap) evictExpired(now time.Time) int {
+	var expired []RequestInfo
+	m.mutex.Lock()
+	for info, entry := range m.data {
+		if m.limits.expired(entry, now) {
+			expired = append(expired, info)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, info := range expired {
+		m.Delete(info)
+	}
+	return len(expired)
+}
+
+// RunJanitor evicts expired requests every interval, until ctx is done.
+func (m *RequestsMap) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.evictExpired(now)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+// Checks that finished and unfinished requests are evicted after their retention times.
+func TestEvictExpired(t *testing.T) {
+	requestsMap := NewRequestsMap()
+	requestsMap.SetLimits(RequestsLimits{FinishedRetention: time.Hour, UnfinishedRetention: 2 * time.Hour})
+	unfinished := &cancelableHandler{}
+	unfinishedInfo := RequestInfo{"email", "unfinished"}
+	finishedInfo := RequestInfo{"email", "finished"}
+	assert.NoError(t, requestsMap.StartProcessing(unfinishedInfo, unfinished))
+	assert.NoError(t, requestsMap.StartProcessing(finishedInfo, &mockHandler{done: true}))
+
+	start := time.Now()
+	requestsMap.mutex.Lock()
+	requestsMap.data[unfinishedInfo].startTime = start
+	requestsMap.data[finishedInfo].startTime = start
+	requestsMap.data[finishedInfo].finishTime = start
+	requestsMap.mutex.Unlock()
+
+	assert.Equal(t, 0, requestsMap.evictExpired(start.Add(30*time.Minute)), "Nothing should expire yet")
+	assert.Equal(t, 1, requestsMap.evictExpired(start.Add(90*time.Minute)), "Finished request should expire")
+	_, found := requestsMap.GetResponse(finishedInfo)
+	assert.False(t, found, "Finished request should be deleted")
+	_, found = requestsMap.GetResponse(unfinishedInfo)
+	assert.True(t, found, "Unfinished request should still be in map")
+
+	assert.Equal(t, 1, requestsMap.evictExpired(start.Add(3*time.Hour)), "Unfinished request should expire")
+	_, found = requestsMap.GetResponse(unfinishedInfo)
+	assert.False(t, found, "Unfinished request should be deleted")
+	for {
+		if response, _ := unfinished.GetResponse(); response.Error != nil {
+			break
+		}
+	}
+}
+
+// Checks that a user can't start more requests than allowed,
+// but other users are not affected.
+func TestMaxRequestsPerUser(t *testing.T) {
+	requestsMap := NewRequestsMap()
+	requestsMap.SetLimits(RequestsLimits{MaxRequestsPerUser: 2})
+	for i := 0; i < 2; i++ {
+		_, err := StartProcessingWithNewRequestID(&requestsMap, "email", &cancelableHandler{})
+		assert.NoError(t, err, "No error expected")
+	}
+
+	_, err := StartProcessingWithNewRequestID(&requestsMap, "email", &cancelableHandler{})
+	if assert.Error(t, err, "Too many requests in progress") {
+		assert.Equal(t, http.StatusTooManyRequests, err.(*googleapi.Error).Code)
+	}
+	err = requestsMap.StartProcessing(RequestInfo{"email", "id"}, &cancelableHandler{})
+	assert.Error(t, err, "Too many requests in progress")
+
+	_, err = StartProcessingWithNewRequestID(&requestsMap, "other", &cancelableHandler{})
+	assert.NoError(t, err, "Other user's requests are not limited")
+}
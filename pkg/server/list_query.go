@@ -0,0 +1,240 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/recommender/v1"
+)
+
+// Values of orderBy query parameter.
+const (
+	orderBySavings  = "savings"
+	orderByPriority = "priority"
+)
+
+// listQuery filters, sorts and splits into pages the result of a list request.
+// Empty filters match all recommendations, values of the same filter are alternatives.
+type listQuery struct {
+	projects     []string
+	locations    []string
+	recommenders []string
+	subtypes     []string
+	states       []string
+	// minSavings is the minimum monthly savings in nanos, used if hasMinSavings is set.
+	minSavings    int64
+	hasMinSavings bool
+	// currency is the code of the currency, in which savings are compared. Savings in other currencies
+	// are treated as missing. It's required by minSavings and ordering by savings.
+	currency string
+	orderBy  string
+	// pageSize is 0 if the result is not split into pages.
+	pageSize  int
+	pageIndex int
+}
+
+// queryValues returns the values of the query parameter, which can be repeated or comma-separated.
+func queryValues(c *gin.Context, key string) []string {
+	var result []string
+	for _, value := range c.QueryArray(key) {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// parseListQuery parses the query parameters project, location, recommender, subtype, state,
+// minSavings, currency, orderBy, pageSize and pageIndex.
+func parseListQuery(c *gin.Context) (*listQuery, error) {
+	query := &listQuery{
+		projects:     queryValues(c, "project"),
+		locations:    queryValues(c, "location"),
+		recommenders: queryValues(c, "recommender"),
+		subtypes:     queryValues(c, "subtype"),
+		states:       queryValues(c, "state"),
+		currency:     strings.TrimSpace(c.Query("currency")),
+		orderBy:      c.Query("orderBy"),
+	}
+	if value := c.Query("minSavings"); value != "" {
+		minSavings, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid minSavings %s", value)
+		}
+		query.minSavings = int64(math.Round(minSavings * 1e9))
+		query.hasMinSavings = true
+	}
+	switch query.orderBy {
+	case "", orderBySavings, orderByPriority:
+	default:
+		return nil, fmt.Errorf("Unknown orderBy %s, expected %s or %s", query.orderBy, orderBySavings, orderByPriority)
+	}
+	if (query.hasMinSavings || query.orderBy == orderBySavings) && query.currency == "" {
+		return nil, fmt.Errorf("currency is required with minSavings and orderBy=%s", orderBySavings)
+	}
+	var err error
+	if value := c.Query("pageSize"); value != "" {
+		if query.pageSize, err = strconv.Atoi(value); err != nil || query.pageSize <= 0 {
+			return nil, fmt.Errorf("Invalid pageSize %s", value)
+		}
+	}
+	if value := c.Query("pageIndex"); value != "" {
+		if query.pageIndex, err = strconv.Atoi(value); err != nil || query.pageIndex < 0 {
+			return nil, fmt.Errorf("Invalid pageIndex %s", value)
+		}
+	}
+	return query, nil
+}
+
+// matchesAny checks whether value is one of values, or values is empty. The case is ignored.
+func matchesAny(value string, values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks whether the recommendation passes all filters of the query.
+func (q *listQuery) matches(recommendation *recommender.GoogleCloudRecommenderV1Recommendation) bool {
+	fields := automation.FlattenRecommendation(recommendation)
+	if !matchesAny(fields.Project, q.projects) || !matchesAny(fields.Location, q.locations) ||
+		!matchesAny(fields.Recommender, q.recommenders) || !matchesAny(fields.Subtype, q.subtypes) ||
+		!matchesAny(fields.State, q.states) {
+		return false
+	}
+	if q.hasMinSavings {
+		savings, ok := q.savings(recommendation)
+		return ok && savings >= q.minSavings
+	}
+	return true
+}
+
+// savings returns the monthly savings of the recommendation in nanos.
+// Returns false if the recommendation has no cost projection in the currency of the query.
+func (q *listQuery) savings(recommendation *recommender.GoogleCloudRecommenderV1Recommendation) (int64, bool) {
+	currency, savings, ok := automation.MonthlySavings(recommendation)
+	return savings, ok && strings.EqualFold(currency, q.currency)
+}
+
+// apply returns the page of the recommendations passing the filters, in the requested order.
+// FailedProjects and FailedRecommenders are returned on every page.
+func (q *listQuery) apply(result *ListRecommendationsResponse) *ListRecommendationsResponse {
+	recommendations := []*recommender.GoogleCloudRecommenderV1Recommendation{}
+	for _, recommendation := range result.Recommendations {
+		if q.matches(recommendation) {
+			recommendations = append(recommendations, recommendation)
+		}
+	}
+
+	if q.orderBy == orderBySavings {
+		savings := make(map[*recommender.GoogleCloudRecommenderV1Recommendation]int64)
+		for _, recommendation := range recommendations {
+			nanos := int64(math.MinInt64) // recommendations without cost projection in the currency are last
+			if projected, ok := q.savings(recommendation); ok {
+				nanos = projected
+			}
+			savings[recommendation] = nanos
+		}
+		// the largest savings first
+		sort.SliceStable(recommendations, func(i, j int) bool {
+			return savings[recommendations[i]] > savings[recommendations[j]]
+		})
+	}
+
+	if q.orderBy == orderByPriority {
+		// the highest priority first, P1 to P4 are ordered as strings, recommendations without priority are last
+		sort.SliceStable(recommendations, func(i, j int) bool {
+			priorityI, okI := result.Priorities[recommendations[i].Name]
+			priorityJ, okJ := result.Priorities[recommendations[j].Name]
+			if okI != okJ {
+				return okI
+			}
+			return priorityI < priorityJ
+		})
+	}
+
+	response := &ListRecommendationsResponse{
+		FailedProjects:     result.FailedProjects,
+		FailedRecommenders: result.FailedRecommenders,
+		TotalSize:          len(recommendations),
+		PageIndex:          q.pageIndex,
+		PageSize:           q.pageSize,
+	}
+	if q.pageSize == 0 {
+		response.PageSize = len(recommendations)
+		response.NumberOfPages = 1
+		response.Recommendations = recommendations
+		response.Insights = pageInsights(recommendations, result.Insights)
+		response.Priorities = pagePriorities(recommendations, result.Priorities)
+		return response
+	}
+	response.NumberOfPages = (len(recommendations) + q.pageSize - 1) / q.pageSize
+	response.Recommendations = []*recommender.GoogleCloudRecommenderV1Recommendation{}
+	if start := q.pageIndex * q.pageSize; start < len(recommendations) {
+		end := start + q.pageSize
+		if end > len(recommendations) {
+			end = len(recommendations)
+		}
+		response.Recommendations = recommendations[start:end]
+	}
+	response.Insights = pageInsights(response.Recommendations, result.Insights)
+	response.Priorities = pagePriorities(response.Recommendations, result.Priorities)
+	return response
+}
+
+// pageInsights returns the insights of the recommendations on the page.
+func pageInsights(recommendations []*recommender.GoogleCloudRecommenderV1Recommendation,
+	insights map[string][]*recommender.GoogleCloudRecommenderV1Insight) map[string][]*recommender.GoogleCloudRecommenderV1Insight {
+	var result map[string][]*recommender.GoogleCloudRecommenderV1Insight
+	for _, recommendation := range recommendations {
+		if recInsights, ok := insights[recommendation.Name]; ok {
+			if result == nil {
+				result = make(map[string][]*recommender.GoogleCloudRecommenderV1Insight)
+			}
+			result[recommendation.Name] = recInsights
+		}
+	}
+	return result
+}
+
+// pagePriorities returns the priorities of the recommendations on the page.
+func pagePriorities(recommendations []*recommender.GoogleCloudRecommenderV1Recommendation, priorities map[string]string) map[string]string {
+	var result map[string]string
+	for _, recommendation := range recommendations {
+		if priority, ok := priorities[recommendation.Name]; ok {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[recommendation.Name] = priority
+		}
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/recommender/v1"
+)
+
+func queryRecommendation(project, subtype string, savings int64) *recommender.GoogleCloudRecommenderV1Recommendation {
+	rec := &recommender.GoogleCloudRecommenderV1Recommendation{
+		Name:               fmt.Sprintf("projects/%s/locations/zone/recommenders/recommender/recommendations/%s-%d", project, subtype, savings),
+		RecommenderSubtype: subtype,
+		StateInfo:          &gcloudStateInfo{State: "ACTIVE"},
+	}
+	if savings != 0 {
+		rec.PrimaryImpact = &recommender.GoogleCloudRecommenderV1Impact{
+			CostProjection: &recommender.GoogleCloudRecommenderV1CostProjection{
+				Cost:     &recommender.GoogleTypeMoney{CurrencyCode: "USD", Units: -savings},
+				Duration: "2592000s",
+			},
+		}
+	}
+	return rec
+}
+
+func listWithQuery(t *testing.T, query string) *ListRecommendationsResponse {
+	return listResultWithQuery(t, ListRecommendationsResponse{
+		Recommendations: []*recommender.GoogleCloudRecommenderV1Recommendation{
+			queryRecommendation("a", "STOP_VM", 5),
+			queryRecommendation("a", "CHANGE_MACHINE_TYPE", 20),
+			queryRecommendation("b", "STOP_VM", 10),
+			queryRecommendation("b", "STOP_VM", 0),
+		},
+		Insights: map[string][]*recommender.GoogleCloudRecommenderV1Insight{
+			queryRecommendation("b", "STOP_VM", 10).Name: {{Name: "insight"}},
+		},
+		Priorities: map[string]string{
+			queryRecommendation("a", "STOP_VM", 5).Name:  "P3",
+			queryRecommendation("b", "STOP_VM", 10).Name: "P1",
+			queryRecommendation("b", "STOP_VM", 0).Name:  "P3",
+		},
+	}, query)
+}
+
+// listResultWithQuery reads the result of the finished list request with the query.
+func listResultWithQuery(t *testing.T, result ListRecommendationsResponse, query string) *ListRecommendationsResponse {
+	code := "authcode"
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser(code, router)
+	handler := &mockHandler{done: true, content: result}
+	assert.NoError(t, service.requests.StartProcessing(RequestInfo{code, "1"}, handler))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/recommendations?request_id=1&"+query, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		return nil
+	}
+	var response ListRecommendationsResponse
+	assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&response))
+	return &response
+}
+
+func recommendationNames(response *ListRecommendationsResponse) []string {
+	names := []string{}
+	for _, rec := range response.Recommendations {
+		names = append(names, rec.Name)
+	}
+	return names
+}
+
+func TestListQueryFilters(t *testing.T) {
+	response := listWithQuery(t, "project=b&subtype=stop_vm")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 2, response.TotalSize, "Filters should be case insensitive")
+	}
+
+	response = listWithQuery(t, "project=a,b&minSavings=10&currency=USD")
+	if assert.NotNil(t, response) {
+		expected := []string{
+			"projects/a/locations/zone/recommenders/recommender/recommendations/CHANGE_MACHINE_TYPE-20",
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-10",
+		}
+		assert.Equal(t, expected, recommendationNames(response))
+	}
+}
+
+func TestListQuerySortingAndPages(t *testing.T) {
+	response := listWithQuery(t, "orderBy=savings&currency=USD&pageSize=3&pageIndex=0")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 4, response.TotalSize)
+		assert.Equal(t, 2, response.NumberOfPages)
+		expected := []string{
+			"projects/a/locations/zone/recommenders/recommender/recommendations/CHANGE_MACHINE_TYPE-20",
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-10",
+			"projects/a/locations/zone/recommenders/recommender/recommendations/STOP_VM-5",
+		}
+		assert.Equal(t, expected, recommendationNames(response), "Largest savings should be first")
+		assert.Equal(t, 1, len(response.Insights), "Insights of recommendations on the page should be sent")
+	}
+
+	response = listWithQuery(t, "orderBy=savings&currency=USD&pageSize=3&pageIndex=1")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, []string{"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-0"},
+			recommendationNames(response), "Recommendation without savings should be last")
+		assert.Empty(t, response.Insights, "Insights of recommendations on other pages shouldn't be sent")
+	}
+
+	response = listWithQuery(t, "pageSize=3&pageIndex=5")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, 0, len(response.Recommendations), "Page after the last one should be empty")
+	}
+}
+
+// Checks that savings are compared only in the currency of the query.
+func TestListQueryCurrency(t *testing.T) {
+	inEuros := queryRecommendation("a", "CHANGE_MACHINE_TYPE", 20)
+	inEuros.PrimaryImpact.CostProjection.Cost.CurrencyCode = "EUR"
+	result := ListRecommendationsResponse{Recommendations: []*recommender.GoogleCloudRecommenderV1Recommendation{
+		queryRecommendation("a", "STOP_VM", 5),
+		inEuros,
+		queryRecommendation("b", "STOP_VM", 10),
+	}}
+
+	response := listResultWithQuery(t, result, "minSavings=1&currency=usd")
+	if assert.NotNil(t, response) {
+		expected := []string{
+			"projects/a/locations/zone/recommenders/recommender/recommendations/STOP_VM-5",
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-10",
+		}
+		assert.Equal(t, expected, recommendationNames(response), "Savings in other currencies shouldn't match")
+	}
+
+	response = listResultWithQuery(t, result, "orderBy=savings&currency=USD")
+	if assert.NotNil(t, response) {
+		expected := []string{
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-10",
+			"projects/a/locations/zone/recommenders/recommender/recommendations/STOP_VM-5",
+			inEuros.Name,
+		}
+		assert.Equal(t, expected, recommendationNames(response), "Savings in other currencies should be last")
+	}
+}
+
+func TestListQuerySortingByPriority(t *testing.T) {
+	response := listWithQuery(t, "orderBy=priority&pageSize=3")
+	if assert.NotNil(t, response) {
+		expected := []string{
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-10",
+			"projects/a/locations/zone/recommenders/recommender/recommendations/STOP_VM-5",
+			"projects/b/locations/zone/recommenders/recommender/recommendations/STOP_VM-0",
+		}
+		assert.Equal(t, expected, recommendationNames(response), "Highest priority should be first, the order of equal ones kept")
+		assert.Equal(t, map[string]string{expected[0]: "P1", expected[1]: "P3", expected[2]: "P3"}, response.Priorities,
+			"Priorities of recommendations on the page should be sent")
+	}
+
+	response = listWithQuery(t, "orderBy=priority&pageSize=3&pageIndex=1")
+	if assert.NotNil(t, response) {
+		assert.Equal(t, []string{"projects/a/locations/zone/recommenders/recommender/recommendations/CHANGE_MACHINE_TYPE-20"},
+			recommendationNames(response), "Recommendation without priority should be last")
+		assert.Empty(t, response.Priorities)
+	}
+}
+
+func TestListQueryInvalid(t *testing.T) {
+	for _, query := range []string{"pageSize=0", "pageIndex=-1", "minSavings=many&currency=USD", "orderBy=name",
+		"minSavings=10", "orderBy=savings"} {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request, _ = http.NewRequest("GET", "/api/recommendations?"+query, nil)
+		_, err := parseListQuery(ctx)
+		assert.Error(t, err, "Query %s should be invalid", query)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"google.golang.org/api/recommender/v1"
+)
+
+// markFunc changes the state of the recommendation with the given name and etag.
+type markFunc func(service automation.GoogleService, ctx context.Context, name, etag, reason string) (*recommender.GoogleCloudRecommenderV1Recommendation, error)
+
+// getMarkHandler returns the handler changing the state of the recommendation
+// from the name query parameter with mark. The current etag of the recommendation is used,
+// the optional reason query parameter is saved in its state metadata.
+// The updated recommendation is sent back.
+func getMarkHandler(service *SharedService, mark markFunc) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		reason := c.Query("reason")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		rec, err := user.service.GetRecommendation(ctx, name)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		rec, err = mark(user.service, ctx, name, rec.Etag, reason)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rec)
+	}
+}
+
+// getDismissHandler marks the recommendation dismissed, so that it won't be applied.
+func getDismissHandler(service *SharedService) func(c *gin.Context) {
+	return getMarkHandler(service, automation.GoogleService.MarkRecommendationDismissed)
+}
+
+// getRestoreHandler marks the dismissed recommendation active again.
+func getRestoreHandler(service *SharedService) func(c *gin.Context) {
+	return getMarkHandler(service, automation.GoogleService.MarkRecommendationActive)
+}
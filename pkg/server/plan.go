@@ -0,0 +1,45 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/automation"
+)
+
+// getPlanHandler returns the actions that applying the recommendation would do.
+// Nothing is changed, so the plan is returned right away, not in a separate request.
+func getPlanHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		plan, err := automation.PlanByName(c.Request.Context(), user.service, name)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, plan)
+	}
+}
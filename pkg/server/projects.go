@@ -36,7 +36,7 @@ func getProjectsHandler(service *SharedService) func(c *gin.Context) {
 			return
 		}
 
-		projects, err := user.service.ListProjects()
+		projects, err := user.service.ListProjects(c.Request.Context())
 
 		if err != nil {
 			sendError(c, err)
@@ -17,6 +17,8 @@ limitations under the License.
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -29,10 +31,21 @@ import (
 
 const defaultNumConcurrentCalls = 200
 
-// ListRecommendationsResponse is response to list/recommendations method
+// ListRecommendationsResponse is response to list/recommendations method.
+// Recommendations are the ones on the page with index PageIndex, PageSize is the maximum number of them.
+// TotalSize is the number of recommendations passing the filters on all pages.
+// Insights maps names of the recommendations to their associated insights.
+// Priorities maps names of the recommendations to their priorities, from P1, the highest, to P4.
 type ListRecommendationsResponse struct {
-	Recommendations []*recommender.GoogleCloudRecommenderV1Recommendation `json:"recommendations"`
-	FailedProjects  []*automation.ProjectRequirements                     `json:"failedProjects"`
+	Recommendations    []*recommender.GoogleCloudRecommenderV1Recommendation     `json:"recommendations"`
+	FailedProjects     []*automation.ProjectRequirements                         `json:"failedProjects"`
+	FailedRecommenders []*automation.RecommenderRequirements                     `json:"failedRecommenders,omitempty"`
+	Insights           map[string][]*recommender.GoogleCloudRecommenderV1Insight `json:"insights,omitempty"`
+	Priorities         map[string]string                                         `json:"priorities,omitempty"`
+	TotalSize          int                                                       `json:"totalSize"`
+	NumberOfPages      int                                                       `json:"numberOfPages"`
+	PageIndex          int                                                       `json:"pageIndex"`
+	PageSize           int                                                       `json:"pageSize"`
 }
 
 type listRequestHandler struct {
@@ -40,21 +53,31 @@ type listRequestHandler struct {
 	service            automation.GoogleService
 	task               automation.Task
 	projects           []string
+	filter             string
 	numConcurrentCalls int
 	err                error
 }
 
-// NewListRequestHandler creates new listRequestHandler
-func NewListRequestHandler(service automation.GoogleService, projects []string) RequestHandler {
-	return &listRequestHandler{service: service, projects: projects, numConcurrentCalls: defaultNumConcurrentCalls}
+// NewListRequestHandler creates new listRequestHandler.
+// If filter is not empty, only recommendations matching the Recommender API filter are listed.
+func NewListRequestHandler(service automation.GoogleService, projects []string, filter string) RequestHandler {
+	return &listRequestHandler{service: service, projects: projects, filter: filter, numConcurrentCalls: defaultNumConcurrentCalls}
 }
 
-func (h *listRequestHandler) Start() {
+func (h *listRequestHandler) Start(ctx context.Context) {
 	h.task.SetNumberOfSubtasks(1) // 1 call to ListProjectsRecommendations
-	h.result, h.err = automation.ListProjectsRecommendations(h.service, h.projects, h.numConcurrentCalls, h.task.GetNextSubtask())
+	h.result, h.err = automation.ListProjectsRecommendations(ctx, h.service, h.projects, h.filter, h.numConcurrentCalls, h.task.GetNextSubtask())
 	h.task.SetAllDone()
 }
 
+func (h *listRequestHandler) Kind() string {
+	return listRequestKind
+}
+
+func (h *listRequestHandler) Params() interface{} {
+	return ListRequest{Projects: h.projects, Filter: h.filter}
+}
+
 func (h *listRequestHandler) GetResponse() (Response, bool) {
 	done, all := h.task.GetProgress()
 	if done < all {
@@ -64,13 +87,18 @@ func (h *listRequestHandler) GetResponse() (Response, bool) {
 		return Response{Error: h.err}, true
 	}
 	return Response{Content: ListRecommendationsResponse{
-		Recommendations: h.result.Recommendations,
-		FailedProjects:  h.result.FailedProjects}}, true
+		Recommendations:    h.result.Recommendations,
+		FailedProjects:     h.result.FailedProjects,
+		FailedRecommenders: h.result.FailedRecommenders,
+		Insights:           h.result.Insights,
+		Priorities:         h.result.Priorities}}, true
 }
 
-// ListRequest contains the body of POST /recommendations request
+// ListRequest contains the body of POST /recommendations request.
+// Filter is a Recommender API filter expression, for example "stateInfo.state = ACTIVE".
 type ListRequest struct {
 	Projects []string `json:"projects"`
+	Filter   string   `json:"filter,omitempty"`
 }
 
 func getStartListingHandler(service *SharedService) func(c *gin.Context) {
@@ -97,14 +125,45 @@ func getStartListingHandler(service *SharedService) func(c *gin.Context) {
 			return
 		}
 
-		handler := NewListRequestHandler(user.service, listRequest.Projects)
-		requestID := StartProcessingWithNewRequestID(&service.requests, user.email, handler)
+		handler := NewListRequestHandler(user.service, listRequest.Projects, listRequest.Filter)
+		requestID, err := StartProcessingWithNewRequestID(&service.requests, user.email, handler)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
 		c.String(http.StatusCreated, requestID)
 	}
 }
 
+// getCancelListingHandler cancels listing recommendations and deletes the request.
+func getCancelListingHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Query("request_id")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		if !service.requests.Delete(RequestInfo{user.email, id}) {
+			sendError(c, fmt.Errorf("No request for %s with id %s", user.email, id), http.StatusNotFound)
+			return
+		}
+		c.String(http.StatusOK, "")
+	}
+}
+
+// getListHandler returns the progress of listing, or the result once it's finished.
+// The result can be filtered, sorted and split into pages, as described in parseListQuery.
 func getListHandler(service *SharedService) func(c *gin.Context) {
 	return func(c *gin.Context) {
+		query, err := parseListQuery(c)
+		if err != nil {
+			sendError(c, err, http.StatusBadRequest)
+			return
+		}
+
 		id := c.Query("request_id")
 		user, err := authorizeRequest(service.auth, c.Request)
 
@@ -125,6 +184,132 @@ func getListHandler(service *SharedService) func(c *gin.Context) {
 			return
 		}
 
-		c.JSON(http.StatusOK, response.Content)
+		if progress, ok := response.Content.(Progress); ok {
+			c.JSON(http.StatusOK, progress)
+			return
+		}
+
+		result, err := listResult(id, response.Content)
+		if err != nil {
+			sendError(c, err, http.StatusBadRequest)
+			return
+		}
+		c.JSON(http.StatusOK, query.apply(result))
+	}
+}
+
+// finishedListResponse returns the result of the finished list request with the id from the request_id query parameter.
+// If the request can't be found or isn't finished, sends the error and returns false.
+func finishedListResponse(service *SharedService, c *gin.Context) (*ListRecommendationsResponse, bool) {
+	id := c.Query("request_id")
+	user, err := authorizeRequest(service.auth, c.Request)
+
+	if err != nil {
+		sendError(c, err)
+		return nil, false
+	}
+
+	response, ok := service.requests.GetResponse(RequestInfo{user.email, id})
+
+	if !ok {
+		sendError(c, fmt.Errorf("No request for %s with id %s", user.email, id), http.StatusNotFound)
+		return nil, false
+	}
+
+	if response.Error != nil {
+		sendError(c, response.Error)
+		return nil, false
+	}
+
+	if _, ok := response.Content.(Progress); ok {
+		sendError(c, fmt.Errorf("Listing recommendations with id %s is not finished", id), http.StatusConflict)
+		return nil, false
+	}
+
+	result, err := listResult(id, response.Content)
+	if err != nil {
+		sendError(c, err, http.StatusBadRequest)
+		return nil, false
+	}
+	return result, true
+}
+
+// listResult returns the result of the finished list request from the content of its response.
+func listResult(id string, content interface{}) (*ListRecommendationsResponse, error) {
+	switch content := content.(type) {
+	case ListRecommendationsResponse:
+		return &content, nil
+	case json.RawMessage: // restored from RequestStore
+		var result ListRecommendationsResponse
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields() // results of other requests are rejected
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("Request with id %s is not listing recommendations", id)
+		}
+		return &result, nil
+	default:
+		return nil, fmt.Errorf("Request with id %s is not listing recommendations", id)
+	}
+}
+
+// getSummaryHandler returns projected monthly savings of the listed recommendations.
+func getSummaryHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		result, ok := finishedListResponse(service, c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, automation.SummarizeSavings(result.Recommendations))
+	}
+}
+
+// Parts of the export of a list request.
+const (
+	recommendationsPart = "recommendations"
+	failedProjectsPart  = "failedProjects"
+)
+
+var exportContentTypes = map[string]string{
+	automation.ExportCSV:   "text/csv",
+	automation.ExportJSONL: "application/x-ndjson",
+}
+
+// getExportHandler sends the listed recommendations as a file in the format from the format query parameter,
+// csv or jsonl. If the part query parameter is failedProjects, requirements of the projects
+// for which recommendations couldn't be listed are sent instead.
+func getExportHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", automation.ExportCSV)
+		contentType, ok := exportContentTypes[format]
+		if !ok {
+			sendError(c, fmt.Errorf("Unknown format %s, expected %s or %s", format, automation.ExportCSV, automation.ExportJSONL),
+				http.StatusBadRequest)
+			return
+		}
+		part := c.DefaultQuery("part", recommendationsPart)
+		if part != recommendationsPart && part != failedProjectsPart {
+			sendError(c, fmt.Errorf("Unknown part %s, expected %s or %s", part, recommendationsPart, failedProjectsPart),
+				http.StatusBadRequest)
+			return
+		}
+
+		result, ok := finishedListResponse(service, c)
+		if !ok {
+			return
+		}
+
+		var buffer bytes.Buffer
+		var err error
+		if part == recommendationsPart {
+			err = automation.ExportRecommendations(&buffer, format, result.Recommendations, result.Priorities)
+		} else {
+			err = automation.ExportFailedProjects(&buffer, format, result.FailedProjects)
+		}
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part+"."+format))
+		c.Data(http.StatusOK, contentType, buffer.Bytes())
 	}
 }
@@ -14,11 +14,13 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"sync"
 	"testing"
 
 	"github.com/googleinterns/recomator/pkg/automation"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/recommender/v1"
 )
 
 type mockService struct {
@@ -35,7 +37,7 @@ func (s *mockService) StopWaiting() {
 	s.mutex.Unlock()
 }
 
-func (s *mockService) ListRegionsNames(project string) ([]string, error) {
+func (s *mockService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	s.mutex.Lock()
 	s.projectsRegions = append(s.projectsRegions, project)
 	s.mutex.Unlock()
@@ -51,30 +53,34 @@ func (s *mockService) ListRegionsNames(project string) ([]string, error) {
 	return []string{}, nil
 }
 
-func (s *mockService) ListZonesNames(project string) ([]string, error) {
+func (s *mockService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	s.mutex.Lock()
 	s.projectsZones = append(s.projectsZones, project)
 	s.mutex.Unlock()
 	return []string{}, nil
 }
 
-func (s *mockService) ListAPIRequirements(project string, apis []string) ([]*automation.Requirement, error) {
+func (s *mockService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	return []*recommender.GoogleCloudRecommenderV1Recommendation{}, nil
+}
+
+func (s *mockService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*automation.Requirement, error) {
 	return []*automation.Requirement{}, nil
 }
 
-func (s *mockService) ListPermissionRequirements(project string, permissions [][]string) ([]*automation.Requirement, error) {
+func (s *mockService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*automation.Requirement, error) {
 	return []*automation.Requirement{}, nil
 }
 
 func TestListingRecommendations(t *testing.T) {
 	projects = []string{"some", "projects"}
 	mock := &mockService{wait: true} // we set this parameter for ListRegionsNames to wait until we check that progress is returned
-	handler := NewListRequestHandler(mock, projects)
+	handler := NewListRequestHandler(mock, projects, "")
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		handler.Start()
+		handler.Start(context.Background())
 		wg.Done()
 	}()
 
@@ -99,3 +105,10 @@ func TestListingRecommendations(t *testing.T) {
 	assert.ElementsMatch(t, projects, mock.projectsRegions, "ListRegionsNames should be called for all projects")
 	assert.ElementsMatch(t, projects, mock.projectsZones, "ListZonesNames should be called for all projects")
 }
+
+// Checks that the filter is saved with the parameters of the request.
+func TestListRequestParams(t *testing.T) {
+	filter := "stateInfo.state = ACTIVE"
+	handler := NewListRequestHandler(&mockService{}, []string{"p"}, filter)
+	assert.Equal(t, ListRequest{Projects: []string{"p"}, Filter: filter}, handler.Params())
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package server
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
 	"sync"
@@ -35,8 +38,13 @@ type Response struct {
 
 // RequestHandler is the type that can start request processing and get results
 type RequestHandler interface {
-	// Start method starts doing the request
-	Start()
+	// Start method starts doing the request.
+	// ctx is canceled, when the request is canceled by the user.
+	Start(ctx context.Context)
+	// Kind returns the type of the request, for example "list".
+	Kind() string
+	// Params returns the parameters of the request, saved in RequestStore with its state.
+	Params() interface{}
 	// GetResponse returns the current response and whether the request is already done.
 	// For example, while request is still in proccess Response will contain some progress info,
 	// and after request is done, Response will contain result, second value will be `true`.
@@ -55,70 +63,246 @@ type Progress struct {
 	NumberOfBatches  int `json:"numberOfBatches"`
 }
 
+// requestEntry is the handler of the request together with the function canceling it
+// and the record saved in RequestStore.
+// finishTime is zero while the request is in progress.
+type requestEntry struct {
+	handler    RequestHandler
+	cancel     context.CancelFunc
+	record     *RequestRecord
+	lastSave   time.Time
+	startTime  time.Time
+	finishTime time.Time
+}
+
+// progressSaveInterval is the minimum time between saving progress of the request.
+const progressSaveInterval = 10 * time.Second
+
 // RequestsMap contains current requests and handles getting response for them,
 // deleting, adding new requests.
+// The requests are saved in the store, so that they can be restored after restart.
 type RequestsMap struct {
-	data  map[RequestInfo]RequestHandler
-	mutex sync.Mutex
+	data   map[RequestInfo]*requestEntry
+	mutex  sync.Mutex
+	store  RequestStore
+	limits RequestsLimits
 }
 
-// NewRequestsMap creates new RequestsMap
+// NewRequestsMap creates new RequestsMap keeping the requests in memory,
+// with the default limits.
 func NewRequestsMap() RequestsMap {
-	return RequestsMap{data: make(map[RequestInfo]RequestHandler)}
+	return RequestsMap{
+		data:   make(map[RequestInfo]*requestEntry),
+		store:  NewMemoryRequestStore(),
+		limits: DefaultRequestsLimits(),
+	}
+}
+
+// UseStore makes the map save requests in store and adds the requests saved there before.
+// Finished requests are restored with their results. Requests interrupted by the restart
+// are finished: applies are marked as needing reconciliation, other requests fail.
+func (m *RequestsMap) UseStore(store RequestStore) error {
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.store = store
+	for _, record := range records {
+		if !record.Done {
+			finishInterruptedRecord(record)
+			if err := store.Save(record); err != nil {
+				return err
+			}
+		}
+		m.data[record.info()] = &requestEntry{
+			handler:    &storedRequestHandler{record: record},
+			cancel:     func() {},
+			record:     record,
+			startTime:  record.StartTime,
+			finishTime: record.UpdateTime,
+		}
+	}
+	return nil
+}
+
+// saveRecord saves the record of the request, unless it has been deleted.
+// Errors are only logged, as requests can be processed without the store.
+// Must be called with m.mutex locked.
+func (m *RequestsMap) saveRecord(info RequestInfo, entry *requestEntry) {
+	if m.data[info] != entry {
+		return
+	}
+	entry.record.UpdateTime = time.Now()
+	entry.lastSave = entry.record.UpdateTime
+	if err := m.store.Save(entry.record); err != nil {
+		log.Printf("Error saving request %s of %s: %v", info.requestID, info.email, err)
+	}
+}
+
+// saveResponse updates the record of the request with the response and saves it.
+// Progress is saved at most once per progressSaveInterval.
+func (m *RequestsMap) saveResponse(info RequestInfo, entry *requestEntry, response Response, done bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if entry.record.Done {
+		return
+	}
+	if !done {
+		if time.Since(entry.lastSave) < progressSaveInterval {
+			return
+		}
+		entry.record.Progress = marshalContent(response.Content)
+	} else {
+		setRecordResponse(entry.record, response)
+	}
+	m.saveRecord(info, entry)
 }
 
 func (m *RequestsMap) deleteRequest(info RequestInfo) {
 	m.mutex.Lock()
 	delete(m.data, info)
+	if err := m.store.Delete(info); err != nil {
+		log.Printf("Error deleting request %s of %s: %v", info.requestID, info.email, err)
+	}
 	m.mutex.Unlock()
 }
 
-// StartProcessing starts the processing of the request.
-// If such request is already in the map, returns error.
-func (m *RequestsMap) StartProcessing(info RequestInfo, handler RequestHandler) error {
+// errRequestExists is returned by startProcessing, if the request is already in the map.
+var errRequestExists = &googleapi.Error{Message: "The request was already added", Code: http.StatusMethodNotAllowed}
+
+// startProcessing starts the processing of the request.
+// Returns errRequestExists if such request is already in the map,
+// or error with http.StatusTooManyRequests if the user has too many requests in progress.
+func (m *RequestsMap) startProcessing(info RequestInfo, handler RequestHandler) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	_, ok := m.data[info]
-	if !ok {
-		m.data[info] = handler
-		go handler.Start()
-		return nil
+	if _, ok := m.data[info]; ok {
+		return errRequestExists
 	}
-	return &googleapi.Error{Message: "The request was already added", Code: http.StatusMethodNotAllowed}
+	if m.limits.MaxRequestsPerUser > 0 && m.countInProgress(info.email) >= m.limits.MaxRequestsPerUser {
+		return &googleapi.Error{
+			Message: fmt.Sprintf("Too many requests in progress, at most %d are allowed", m.limits.MaxRequestsPerUser),
+			Code:    http.StatusTooManyRequests,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entry := &requestEntry{handler: handler, cancel: cancel, record: newRequestRecord(info, handler), startTime: time.Now()}
+	m.data[info] = entry
+	m.saveRecord(info, entry)
+	go func() {
+		handler.Start(ctx)
+		cancel() // releases resources of ctx
+		m.mutex.Lock()
+		entry.finishTime = time.Now()
+		m.mutex.Unlock()
+		response, done := handler.GetResponse()
+		m.saveResponse(info, entry, response, done)
+	}()
+	return nil
+}
+
+// countInProgress returns the number of user's requests in progress.
+// Must be called with m.mutex locked.
+func (m *RequestsMap) countInProgress(email string) int {
+	result := 0
+	for info, entry := range m.data {
+		if info.email == email && entry.finishTime.IsZero() {
+			result++
+		}
+	}
+	return result
+}
+
+// StartProcessing starts the processing of the request.
+// If such request is already in the map, or the user has too many requests in progress, returns error.
+func (m *RequestsMap) StartProcessing(info RequestInfo, handler RequestHandler) error {
+	return m.startProcessing(info, handler)
+}
+
+// deletedOnRead checks whether finished requests of the kind are deleted once their response is read.
+// Applies are identified by the name of the recommendation, so they're deleted
+// to let the recommendation be applied again. Other requests are kept,
+// so that their results can be read again, until they expire.
+func deletedOnRead(kind string) bool {
+	return kind == applyRequestKind
 }
 
 // GetResponse returns response if request is in process or finished.
-// If request is finished, deletes it from the map.
+// If apply request is finished, deletes it from the map.
 // If there's no such request returns false in second value.
 func (m *RequestsMap) GetResponse(info RequestInfo) (Response, bool) {
 	m.mutex.Lock()
-	handler, ok := m.data[info]
+	entry, ok := m.data[info]
 	m.mutex.Unlock()
 	if ok {
-		response, done := handler.GetResponse()
+		response, done := entry.handler.GetResponse()
 		if done {
-			m.deleteRequest(info)
+			if deletedOnRead(entry.handler.Kind()) {
+				m.deleteRequest(info)
+			}
+		} else {
+			m.saveResponse(info, entry, response, done)
 		}
 		return response, true
 	}
 	return Response{}, false
 }
 
-// StartProcessingWithNewRequestID finds unused request ID and calls StartProcessing method of RequestsMap
-func StartProcessingWithNewRequestID(requests *RequestsMap, email string, handler RequestHandler) string {
+// Kind returns the kind of the request.
+// If there's no such request returns false in second value.
+func (m *RequestsMap) Kind(info RequestInfo) (string, bool) {
+	m.mutex.Lock()
+	entry, ok := m.data[info]
+	m.mutex.Unlock()
+	if !ok {
+		return "", false
+	}
+	return entry.handler.Kind(), true
+}
+
+// Cancel cancels the request, but keeps it in the map,
+// so that its final response can still be read.
+// Returns false if there's no such request.
+func (m *RequestsMap) Cancel(info RequestInfo) bool {
+	m.mutex.Lock()
+	entry, ok := m.data[info]
+	m.mutex.Unlock()
+	if ok {
+		entry.cancel()
+	}
+	return ok
+}
+
+// Delete cancels the request and deletes it from the map.
+// Returns false if there's no such request.
+func (m *RequestsMap) Delete(info RequestInfo) bool {
+	m.mutex.Lock()
+	entry, ok := m.data[info]
+	m.mutex.Unlock()
+	if ok {
+		entry.cancel()
+		m.deleteRequest(info)
+	}
+	return ok
+}
+
+// StartProcessingWithNewRequestID finds unused request ID and calls StartProcessing method of RequestsMap.
+// Returns error if the user has too many requests in progress.
+func StartProcessingWithNewRequestID(requests *RequestsMap, email string, handler RequestHandler) (string, error) {
 	lengthOfID := 20
 	generator := rand.New(rand.NewSource(time.Now().UnixNano()))
 	requestID := randomString(lengthOfID, generator)
 
 	for {
-		err := requests.StartProcessing(RequestInfo{email, requestID}, handler)
-		// StartProcessing will only fail if we had a collision
-		if err != nil {
-			// create new requestID to avoid collision (most likely this code will never be reached as collisions are almost impossible)
-			requestID = randomString(lengthOfID, generator)
-		} else {
-			break
+		err := requests.startProcessing(RequestInfo{email, requestID}, handler)
+		if err != errRequestExists {
+			return requestID, err
 		}
+		// create new requestID to avoid collision (most likely this code will never be reached as collisions are almost impossible)
+		requestID = randomString(lengthOfID, generator)
 	}
-	return requestID
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -31,7 +32,15 @@ type mockHandler struct {
 	content interface{}
 }
 
-func (h *mockHandler) Start() {
+func (h *mockHandler) Start(ctx context.Context) {
+}
+
+func (h *mockHandler) Kind() string {
+	return "mock"
+}
+
+func (h *mockHandler) Params() interface{} {
+	return nil
 }
 
 const inProgressResponse = "request in progresss"
@@ -125,3 +134,48 @@ func TestMultipleRequests(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// cancelableHandler finishes only when its context is canceled.
+type cancelableHandler struct {
+	mockHandler
+}
+
+func (h *cancelableHandler) Start(ctx context.Context) {
+	<-ctx.Done()
+	h.mutex.Lock()
+	h.err = ctx.Err()
+	h.mutex.Unlock()
+	h.SetDone()
+}
+
+func TestCancelRequest(t *testing.T) {
+	handler := &cancelableHandler{}
+	requestsMap := NewRequestsMap()
+	info := RequestInfo{"email", "1"}
+	err := requestsMap.StartProcessing(info, handler)
+	assert.NoError(t, err, "No error expected")
+
+	assert.False(t, requestsMap.Cancel(RequestInfo{"email", "2"}), "No such request")
+	assert.True(t, requestsMap.Cancel(info), "Request should be canceled")
+	for {
+		resp, found := requestsMap.GetResponse(info)
+		assert.True(t, found, "Canceled request should be in map until it's finished")
+		if resp.Error != nil {
+			assert.Equal(t, context.Canceled, resp.Error, "Request should finish because of cancellation")
+			break
+		}
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	handler := &cancelableHandler{}
+	requestsMap := NewRequestsMap()
+	info := RequestInfo{"email", "1"}
+	err := requestsMap.StartProcessing(info, handler)
+	assert.NoError(t, err, "No error expected")
+
+	assert.True(t, requestsMap.Delete(info), "Request should be deleted")
+	_, found := requestsMap.GetResponse(info)
+	assert.False(t, found, "Deleted request shouldn't be in map")
+	assert.False(t, requestsMap.Delete(info), "Request was already deleted")
+}
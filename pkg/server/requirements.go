@@ -17,6 +17,7 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -44,12 +45,20 @@ func NewCheckRequestHandler(service automation.GoogleService, projects []string)
 	return &checkRequestHandler{service: service, projects: projects}
 }
 
-func (h *checkRequestHandler) Start() {
+func (h *checkRequestHandler) Start(ctx context.Context) {
 	h.task.SetNumberOfSubtasks(1) // 1 call to ListRequirements
-	h.result, h.err = automation.ListRequirements(h.service, h.projects, h.task.GetNextSubtask())
+	h.result, h.err = automation.ListRequirements(ctx, h.service, h.projects, h.task.GetNextSubtask())
 	h.task.SetAllDone()
 }
 
+func (h *checkRequestHandler) Kind() string {
+	return requirementsRequestKind
+}
+
+func (h *checkRequestHandler) Params() interface{} {
+	return CheckRequest{Projects: h.projects}
+}
+
 func (h *checkRequestHandler) GetResponse() (Response, bool) {
 	done, all := h.task.GetProgress()
 	if done < all {
@@ -92,7 +101,11 @@ func getStartCheckingHandler(service *SharedService) func(c *gin.Context) {
 		}
 
 		handler := NewCheckRequestHandler(user.service, checkRequest.Projects)
-		requestID := StartProcessingWithNewRequestID(&service.requests, user.email, handler)
+		requestID, err := StartProcessingWithNewRequestID(&service.requests, user.email, handler)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
 		c.String(http.StatusCreated, requestID)
 	}
 }
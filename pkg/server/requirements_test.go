@@ -13,6 +13,7 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -34,7 +35,7 @@ func (s *mockRequirementsService) StopWaiting() {
 	s.mutex.Unlock()
 }
 
-func (s *mockRequirementsService) ListAPIRequirements(project string, apis []string) ([]*automation.Requirement, error) {
+func (s *mockRequirementsService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*automation.Requirement, error) {
 	s.mutex.Lock()
 	s.projectsAPI = append(s.projectsAPI, project)
 	s.mutex.Unlock()
@@ -47,14 +48,22 @@ func (s *mockRequirementsService) ListAPIRequirements(project string, apis []str
 		}
 		s.mutex.Unlock()
 	}
-	return []*automation.Requirement{}, nil
+	result := []*automation.Requirement{}
+	for _, api := range apis {
+		result = append(result, &automation.Requirement{Name: api, Satisfied: true})
+	}
+	return result, nil
 }
 
-func (s *mockRequirementsService) ListPermissionRequirements(project string, permissions [][]string) ([]*automation.Requirement, error) {
+func (s *mockRequirementsService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*automation.Requirement, error) {
 	s.mutex.Lock()
 	s.projectsPermissions = append(s.projectsPermissions, project)
 	s.mutex.Unlock()
-	return []*automation.Requirement{}, nil
+	result := []*automation.Requirement{}
+	for _, group := range permissions {
+		result = append(result, &automation.Requirement{Name: group[0], Satisfied: true})
+	}
+	return result, nil
 }
 
 func TestCheckingRequirements(t *testing.T) {
@@ -65,7 +74,7 @@ func TestCheckingRequirements(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		handler.Start()
+		handler.Start(context.Background())
 		wg.Done()
 	}()
 
@@ -86,6 +95,10 @@ func TestCheckingRequirements(t *testing.T) {
 	response, ok := resp.Content.(CheckRequirementsResponse)
 	assert.True(t, ok, "Should be of type CheckRequirementsResponse")
 	assert.Equal(t, len(projects), len(response.ProjectsRequirements), "Requirements should be checked for all projects")
-	assert.ElementsMatch(t, projects, mock.projectsAPI, "ListAPIRequirements should be called for all projects")
-	assert.ElementsMatch(t, projects, mock.projectsPermissions, "ListPermissionRequirements should be called for all projects")
+	// requirements are checked for the projects and then for the recommenders
+	assert.ElementsMatch(t, append(projects, projects...), mock.projectsAPI, "ListAPIRequirements should be called for all projects")
+	assert.ElementsMatch(t, append(projects, projects...), mock.projectsPermissions, "ListPermissionRequirements should be called for all projects")
+	for _, requirements := range response.ProjectsRequirements {
+		assert.Empty(t, requirements.Recommenders, "All recommenders should be available")
+	}
 }
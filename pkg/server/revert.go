@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/audit"
+	"github.com/googleinterns/recomator/pkg/automation"
+)
+
+// RevertParams are the parameters of revert request saved in RequestStore.
+type RevertParams struct {
+	Name string `json:"name"`
+}
+
+type revertRequestHandler struct {
+	service automation.GoogleService
+	// sink and actor record reverting in the audit log
+	sink  audit.Sink
+	actor string
+	name  string
+	// results of applying the recommendation, which are reverted
+	applied []*automation.OperationResult
+	results []*automation.OperationResult
+	err     error
+	task    automation.Task
+}
+
+// NewRevertRequestHandler creates new revertRequestHandler reverting the changes described by applied,
+// made by applying the recommendation with the given name. Reverting by actor is recorded in sink,
+// so that the same changes aren't reverted again.
+func NewRevertRequestHandler(service automation.GoogleService, sink audit.Sink, actor, name string, applied []*automation.OperationResult) RequestHandler {
+	return &revertRequestHandler{service: service, sink: sink, actor: actor, name: name, applied: applied}
+}
+
+func (h *revertRequestHandler) Start(ctx context.Context) {
+	h.task.SetNumberOfSubtasks(1) // 1 call to Revert
+	start := time.Now()
+	h.results, h.err = automation.Revert(ctx, h.service, h.name, h.applied, h.task.GetNextSubtask())
+	if err := audit.RecordRevert(h.sink, h.actor, h.name, start, h.applied, h.results, h.err); err != nil {
+		log.Printf("Error writing audit log entry for reverting %s: %v", h.name, err)
+	}
+	h.task.SetAllDone()
+}
+
+func (h *revertRequestHandler) Kind() string {
+	return revertRequestKind
+}
+
+func (h *revertRequestHandler) Params() interface{} {
+	return RevertParams{Name: h.name}
+}
+
+func (h *revertRequestHandler) GetResponse() (Response, bool) {
+	done, all := h.task.GetProgress()
+	if done < all {
+		return Response{Content: CheckStatusResponse{Status: inProgressStatus}}, false
+	}
+	return Response{Content: finishedStatus(h.results, h.err)}, true
+}
+
+var errRevertNeedsAudit = errors.New("reverting recommendations requires the audit log, in which the applied changes are recorded")
+
+// getStartRevertHandler starts reverting the changes made by the last successful apply
+// of the recommendation, which are read from the audit log, or from the results kept in memory
+// if the audit log can't be queried.
+// Responds with the ID of the request, with which the status can be checked.
+func getStartRevertHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Query("name")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		if service.resultsSink == nil {
+			sendError(c, errRevertNeedsAudit, http.StatusNotImplemented)
+			return
+		}
+		applied, err := audit.AppliedResults(service.resultsSink, name)
+		if errors.Is(err, audit.ErrNotQueryable) {
+			sendError(c, errRevertNeedsAudit, http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		if len(applied) == 0 {
+			sendError(c, fmt.Errorf("No recorded changes made by applying %s", name), http.StatusNotFound)
+			return
+		}
+
+		requestID, err := StartProcessingWithNewRequestID(&service.requests, user.email,
+			NewRevertRequestHandler(user.service, service.resultsSink, user.email, name, applied))
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		c.String(http.StatusCreated, requestID)
+	}
+}
+
+func getRevertStatusHandler(service *SharedService) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Query("request_id")
+		user, err := authorizeRequest(service.auth, c.Request)
+
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+
+		response, ok := service.requests.GetResponse(RequestInfo{user.email, id})
+
+		if !ok {
+			sendError(c, fmt.Errorf("No request for %s with id %s", user.email, id), http.StatusNotFound)
+			return
+		}
+
+		if response.Error != nil {
+			sendError(c, response.Error)
+			return
+		}
+
+		c.JSON(http.StatusOK, response.Content)
+	}
+}
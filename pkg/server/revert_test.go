@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/audit"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func (s *mockGoogleService) StartInstance(ctx context.Context, project, zone, instance string) error {
+	return nil
+}
+
+// revertStopped reverts as alice the recommendation, applying which stopped an instance,
+// and checks that the instance is started.
+func revertStopped(t *testing.T, router *gin.Engine, name string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/revert?name="+name, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusCreated, w.Code, "Wrong response code") {
+		return
+	}
+	requestID := w.Body.String()
+
+	for {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/recommendations/revert?request_id="+requestID, nil)
+		req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+		router.ServeHTTP(w, req)
+		if !assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+			return
+		}
+		var resp CheckStatusResponse
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&resp), "No error expected")
+		if resp.Status == inProgressStatus {
+			continue
+		}
+		assert.Equal(t, succeededStatus, resp.Status, "Reverting should succeed")
+		if assert.Len(t, resp.Results, 1) {
+			assert.Equal(t, automation.ActionStartInstance, resp.Results[0].Action)
+		}
+		return
+	}
+}
+
+func TestRevert(t *testing.T) {
+	service := newMockShared()
+	sink := &audit.MemorySink{}
+	service.auth = newAuditAuth(service.auth, sink)
+	service.auditSink = sink
+	service.resultsSink = sink
+	router := SetUpRouter(service)
+	createUser("alice", router)
+
+	name := "projects/project/recs/r1"
+	sink.Write(&audit.Entry{
+		Actor:          "scheduler",
+		Recommendation: name,
+		Method:         "MarkRecommendationSucceeded",
+		Succeeded:      true,
+		Results: []*automation.OperationResult{
+			{Action: automation.ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "vm", StoppedInstances: []string{"vm"}},
+		},
+	})
+
+	revertStopped(t, router, name)
+
+	entries, _ := sink.Query(audit.Filter{Actor: "alice", Recommendation: name})
+	if assert.Len(t, entries, 2, "Starting the instance and reverting should be recorded") {
+		assert.Equal(t, "StartInstance", entries[0].Method)
+		assert.Equal(t, audit.RevertMethod, entries[1].Method)
+		assert.True(t, entries[1].Succeeded)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/revert?name="+name, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Reverted changes shouldn't be reverted again")
+}
+
+func TestRevertWithoutRecordedChanges(t *testing.T) {
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser("alice", router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/revert?name=projects/project/recs/r1", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code, "Reverting should require the audit log")
+
+	service.resultsSink = &audit.MemorySink{}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/recommendations/revert?name=projects/project/recs/r1", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Recommendation without recorded changes can't be reverted")
+}
+
+func TestRevertWithoutQueryableAudit(t *testing.T) {
+	var logged bytes.Buffer
+	service, err := NewSharedService(oauth2.Config{}, Options{Auth: newMockShared().auth, AuditSink: audit.NewCloudLoggingSink(&logged)})
+	if !assert.NoError(t, err, "No error expected") {
+		return
+	}
+	router := SetUpRouter(service)
+	createUser("alice", router)
+
+	name := "projects/project/recs/r1"
+	service.resultsSink.Write(&audit.Entry{
+		Actor:          "scheduler",
+		Recommendation: name,
+		Method:         "MarkRecommendationSucceeded",
+		Succeeded:      true,
+		Results: []*automation.OperationResult{
+			{Action: automation.ActionStopInstance, Project: "project", Location: "us-east1-b", Resource: "vm", StoppedInstances: []string{"vm"}},
+		},
+	})
+	revertStopped(t, router, name)
+	assert.Contains(t, logged.String(), audit.RevertMethod, "Reverting should be written to the audit log")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/revert?name="+name, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken("alice"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Reverted changes shouldn't be reverted again")
+}
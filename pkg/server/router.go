@@ -17,10 +17,13 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/googleinterns/recomator/pkg/audit"
+	"github.com/googleinterns/recomator/pkg/automation"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/recommender/v1"
@@ -71,27 +74,117 @@ func SetUpRouter(service *SharedService) *gin.Engine {
 
 	router.GET("/api/recommendations", getListHandler(service))
 
+	router.DELETE("/api/recommendations", getCancelListingHandler(service))
+
+	router.GET("/api/recommendations/summary", getSummaryHandler(service))
+
+	router.GET("/api/recommendations/export", getExportHandler(service))
+
 	router.POST("/api/recommendations/apply", getApplyHandler(service))
 
+	router.POST("/api/recommendations/cancel", getCancelApplyHandler(service))
+
+	router.POST("/api/recommendations/batchApply", getStartBatchApplyHandler(service))
+
+	router.GET("/api/recommendations/batchApply", getBatchStatusHandler(service))
+
+	router.POST("/api/recommendations/batchApply/cancel", getCancelBatchApplyHandler(service))
+
+	router.POST("/api/recommendations/plan", getPlanHandler(service))
+
+	router.POST("/api/recommendations/dismiss", getDismissHandler(service))
+
+	router.POST("/api/recommendations/restore", getRestoreHandler(service))
+
 	router.GET("/api/recommendations/checkStatus", getCheckStatusHandler(service))
+
+	router.POST("/api/recommendations/revert", getStartRevertHandler(service))
+
+	router.GET("/api/recommendations/revert", getRevertStatusHandler(service))
+
+	router.GET("/api/audit", getAuditHandler(service))
 	return router
 }
 
 // SharedService is the struct that contains authorization service
 // and information about currently processed requests.
 type SharedService struct {
-	auth     AuthorizationService
-	requests RequestsMap
+	auth            AuthorizationService
+	requests        RequestsMap
+	safeguards      *automation.Safeguards
+	snapshotOptions *automation.SnapshotOptions
+	auditSink       audit.Sink
+	// resultsSink records the results of applies and reverts, it's queried by AppliedResults
+	resultsSink audit.Sink
+	allowlist   ProjectAllowlist
+}
+
+// Options configure SharedService. Zero values mean defaults.
+type Options struct {
+	// RequestStore is where the requests are saved, the ones saved there before are restored.
+	// If nil, requests are kept only in memory.
+	RequestStore RequestStore
+	// RequestsLimits limit how long requests are kept and how many a user can start.
+	// If nil, DefaultRequestsLimits are used.
+	RequestsLimits *RequestsLimits
+	// TokenStore is where OAuth tokens of users are saved, so that they don't have to log in again.
+	// If nil, tokens are kept only in memory.
+	TokenStore TokenStore
+	// Auth is used to authorize users, if nil, users log in with OAuth configured by conf.
+	// For example, it may be created by NewServiceAccountAuthorizationService.
+	Auth AuthorizationService
+	// ProjectAllowlist lists the projects users may act on. If nil, they're not restricted.
+	ProjectAllowlist ProjectAllowlist
+	// Safeguards are checked before resources are changed by applying recommendations.
+	// If nil, resources are changed without checks.
+	Safeguards *automation.Safeguards
+	// SnapshotOptions configure the snapshots created before deleting disks,
+	// they're labelled with the email of the user. If nil, the defaults are used.
+	SnapshotOptions *automation.SnapshotOptions
+	// AuditSink is where changes made by users are recorded. If nil, they're not recorded.
+	// GET /api/audit queries it, if it implements audit.Querier. Users read there the entries
+	// in the projects allowed for them by ProjectAllowlist, or only their own ones without it.
+	AuditSink audit.Sink
 }
 
 // NewSharedService creates new sharedService to access GoogleAPIs.
-func NewSharedService(conf oauth2.Config) (*SharedService, error) {
+// Expired requests are evicted in the background, as long as the server runs.
+func NewSharedService(conf oauth2.Config, options Options) (*SharedService, error) {
 	var service SharedService
-	auth, err := NewAuthorizationService(conf)
-	if err != nil {
-		return nil, err
+	auth := options.Auth
+	if auth == nil {
+		var err error
+		if auth, err = NewAuthorizationService(conf, options.TokenStore); err != nil {
+			return nil, err
+		}
 	}
+	if options.ProjectAllowlist != nil {
+		auth = newAllowlistAuth(auth, options.ProjectAllowlist)
+	}
+	service.resultsSink = options.AuditSink
+	if !audit.Queryable(options.AuditSink) {
+		// without the audit log that can be queried, the results of applies are kept in memory to be reverted
+		service.resultsSink = audit.NewResultsSink()
+		if options.AuditSink != nil {
+			service.resultsSink = audit.NewMultiSink(service.resultsSink, options.AuditSink)
+		}
+	}
+	// calls refused by the allowlist are recorded too
+	auth = newAuditAuth(auth, service.resultsSink)
 	service.auth = auth
+	service.auditSink = options.AuditSink
+	service.allowlist = options.ProjectAllowlist
+	service.safeguards = options.Safeguards
+	service.snapshotOptions = options.SnapshotOptions
 	service.requests = NewRequestsMap()
+	if options.RequestsLimits != nil {
+		service.requests.SetLimits(*options.RequestsLimits)
+	}
+	if options.RequestStore != nil {
+		if err := service.requests.UseStore(options.RequestStore); err != nil {
+			return nil, err
+		}
+	}
+	go service.requests.RunJanitor(context.Background(), janitorInterval)
 	return &service, nil
 }
@@ -18,6 +18,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -102,38 +103,54 @@ var emptyRecommendation = recommender.GoogleCloudRecommenderV1Recommendation{
 
 var projects = []string{"project"}
 
-func (s *mockGoogleService) ListProjects() ([]string, error) {
+func (s *mockGoogleService) ListProjects(ctx context.Context) ([]string, error) {
 	return projects, nil
 }
 
-func (s *mockGoogleService) ListZonesNames(project string) ([]string, error) {
+func (s *mockGoogleService) ListZonesNames(ctx context.Context, project string) ([]string, error) {
 	return []string{}, nil
 }
 
-func (s *mockGoogleService) ListRegionsNames(project string) ([]string, error) {
+func (s *mockGoogleService) ListRegionsNames(ctx context.Context, project string) ([]string, error) {
 	return []string{}, nil
 }
 
-func (s *mockGoogleService) ListAPIRequirements(project string, apis []string) ([]*automation.Requirement, error) {
+func (s *mockGoogleService) ListRecommendations(ctx context.Context, project, location, recommenderID, filter string) ([]*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	return []*recommender.GoogleCloudRecommenderV1Recommendation{}, nil
+}
+
+func (s *mockGoogleService) ListAPIRequirements(ctx context.Context, project string, apis []string) ([]*automation.Requirement, error) {
 	return []*automation.Requirement{}, nil
 }
 
-func (s *mockGoogleService) ListPermissionRequirements(project string, permissions [][]string) ([]*automation.Requirement, error) {
+func (s *mockGoogleService) ListPermissionRequirements(ctx context.Context, project string, permissions [][]string) ([]*automation.Requirement, error) {
 	return []*automation.Requirement{}, nil
 }
 
-func (s *mockGoogleService) GetRecommendation(name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+func (s *mockGoogleService) GetRecommendation(ctx context.Context, name string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
 	rec := emptyRecommendation
 	rec.Name = name
 	return &rec, nil
 }
 
-func (s *mockGoogleService) MarkRecommendationClaimed(name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
-	return s.GetRecommendation(name)
+func (s *mockGoogleService) MarkRecommendationClaimed(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	return s.GetRecommendation(ctx, name)
+}
+
+func (s *mockGoogleService) MarkRecommendationSucceeded(ctx context.Context, name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	return s.GetRecommendation(ctx, name)
+}
+
+func (s *mockGoogleService) MarkRecommendationDismissed(ctx context.Context, name, etag, reason string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	rec, _ := s.GetRecommendation(ctx, name)
+	rec.StateInfo = &gcloudStateInfo{State: "DISMISSED", StateMetadata: map[string]string{"reason": reason}}
+	return rec, nil
 }
 
-func (s *mockGoogleService) MarkRecommendationSucceeded(name, etag string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
-	return s.GetRecommendation(name)
+func (s *mockGoogleService) MarkRecommendationActive(ctx context.Context, name, etag, reason string) (*recommender.GoogleCloudRecommenderV1Recommendation, error) {
+	rec, _ := s.GetRecommendation(ctx, name)
+	rec.StateInfo = &gcloudStateInfo{State: "ACTIVE"}
+	return rec, nil
 }
 
 func newMockShared() *SharedService {
@@ -400,3 +417,247 @@ func TestRequirements(t *testing.T) {
 		break
 	}
 }
+
+func TestPlan(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/plan?name=name", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		return
+	}
+	var resp automation.ApplyPlan
+	err := newDecoder(w.Body.Bytes()).Decode(&resp)
+	assert.NoError(t, err, "No error expected")
+	assert.Equal(t, "name", resp.Recommendation, "Plan should be for the requested recommendation")
+	assert.Equal(t, 0, len(resp.Actions), "No actions expected for a recommendation without operations")
+}
+
+func TestDismissAndRestore(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/dismiss?name=name&reason=not+needed", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		var rec recommender.GoogleCloudRecommenderV1Recommendation
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&rec), "No error expected")
+		assert.Equal(t, "DISMISSED", rec.StateInfo.State)
+		assert.Equal(t, "not needed", rec.StateInfo.StateMetadata["reason"], "Reason should be passed")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/recommendations/restore?name=name", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		var rec recommender.GoogleCloudRecommenderV1Recommendation
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&rec), "No error expected")
+		assert.Equal(t, "ACTIVE", rec.StateInfo.State)
+	}
+}
+
+func TestCancelListing(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/recommendations?request_id=unknown", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "No such request")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/recommendations", strings.NewReader(`{"projects": ["project"]}`))
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusCreated, w.Code, "Wrong response code") {
+		return
+	}
+	requestID := w.Body.String()
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/recommendations?request_id="+requestID, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "Request should be canceled")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/recommendations?request_id="+requestID, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Canceled request should be deleted")
+}
+
+func TestCancelApplyNotFound(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/cancel?name=name", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Recommendation is not being applied")
+}
+
+func TestBatchApply(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+	names := []string{"projects/1/first", "projects/1/second", "projects/2/third"}
+	bytes, err := json.Marshal(BatchApplyRequest{Names: names})
+	assert.NoError(t, err, "Should be no error")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/batchApply", strings.NewReader(string(bytes)))
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, http.StatusCreated, w.Code, "Wrong response code") {
+		return
+	}
+	requestID := w.Body.String()
+
+	for {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/recommendations/batchApply?request_id="+requestID, nil)
+		req.Header.Add("Authorization", "Bearer "+getToken(code))
+		router.ServeHTTP(w, req)
+		if !assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+			break
+		}
+		var resp BatchStatusResponse
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&resp), "No error expected")
+		if resp.Status == inProgressStatus {
+			continue
+		}
+		assert.Equal(t, succeededStatus, resp.Status, "All recommendations should succeed")
+		assert.Equal(t, map[string]int{succeededStatus: 3}, resp.Counts)
+		if assert.Equal(t, 3, len(resp.Recommendations)) {
+			assert.Equal(t, "projects/2/third", resp.Recommendations[2].Name, "Order of names should be kept")
+		}
+		break
+	}
+}
+
+func TestBatchApplyEmpty(t *testing.T) {
+	code := "authcode"
+	router := SetUpRouter(newMockShared())
+	createUser(code, router)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/recommendations/batchApply", strings.NewReader(`{"names":[]}`))
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "Empty batch should be rejected")
+}
+
+// Checks that requests of other kinds can't be read or canceled as batch applies.
+func TestBatchApplyOtherKind(t *testing.T) {
+	code := "authcode"
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser(code, router)
+	handler := &mockHandler{}
+	requestID, err := StartProcessingWithNewRequestID(&service.requests, code, handler)
+	if !assert.NoError(t, err, "No error expected") {
+		return
+	}
+	handler.SetDone()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/recommendations/batchApply?request_id="+requestID, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Request of another kind shouldn't be returned")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/recommendations/batchApply/cancel?request_id="+requestID, nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "Request of another kind shouldn't be canceled")
+
+	_, ok := service.requests.GetResponse(RequestInfo{code, requestID})
+	assert.True(t, ok, "Request should be kept")
+}
+
+func TestSummary(t *testing.T) {
+	code := "authcode"
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser(code, router)
+	rec := emptyRecommendation
+	rec.PrimaryImpact = &recommender.GoogleCloudRecommenderV1Impact{
+		CostProjection: &recommender.GoogleCloudRecommenderV1CostProjection{
+			Cost:     &recommender.GoogleTypeMoney{CurrencyCode: "USD", Units: -3, Nanos: -500000000},
+			Duration: "2592000s",
+		},
+	}
+	handler := &mockHandler{done: true, content: ListRecommendationsResponse{
+		Recommendations: []*recommender.GoogleCloudRecommenderV1Recommendation{&rec, &rec},
+	}}
+	assert.NoError(t, service.requests.StartProcessing(RequestInfo{code, "1"}, handler))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/recommendations/summary?request_id=1", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		var summary automation.SavingsSummary
+		assert.NoError(t, newDecoder(w.Body.Bytes()).Decode(&summary))
+		assert.Equal(t, []*automation.Money{{CurrencyCode: "USD", Units: 7}}, summary.Total)
+	}
+
+	other := &mockHandler{}
+	assert.NoError(t, service.requests.StartProcessing(RequestInfo{code, "2"}, other))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/recommendations/summary?request_id=2", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "Mock request doesn't list recommendations")
+}
+
+func TestExport(t *testing.T) {
+	code := "authcode"
+	service := newMockShared()
+	router := SetUpRouter(service)
+	createUser(code, router)
+	handler := &mockHandler{done: true, content: ListRecommendationsResponse{
+		Recommendations: []*recommender.GoogleCloudRecommenderV1Recommendation{&emptyRecommendation},
+		FailedProjects: []*automation.ProjectRequirements{{
+			Project:      "failed",
+			Requirements: []*automation.Requirement{{Name: "permission", ErrorMessage: "denied"}},
+		}},
+	}}
+	assert.NoError(t, service.requests.StartProcessing(RequestInfo{code, "1"}, handler))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/recommendations/export?request_id=1&format=csv", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Equal(t, 2, len(lines), "Header and one recommendation expected")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/recommendations/export?request_id=1&format=jsonl&part=failedProjects", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	if assert.Equal(t, http.StatusOK, w.Code, "Wrong response code") {
+		assert.JSONEq(t, `{"project":"failed","requirement":"permission","errorMessage":"denied"}`, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/recommendations/export?request_id=1&format=xml", nil)
+	req.Header.Add("Authorization", "Bearer "+getToken(code))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "Unknown format")
+}
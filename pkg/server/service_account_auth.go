@@ -0,0 +1,175 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc"
+	"github.com/googleinterns/recomator/pkg/automation"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	iapIssuer  = "https://cloud.google.com/iap"
+	iapKeysURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+
+	// iapHeader is the header with the JWT signed by Identity-Aware Proxy.
+	iapHeader = "X-Goog-IAP-JWT-Assertion"
+)
+
+// ServiceAccountConfig configures AuthorizationService, in which all calls to Google APIs
+// are made with the credentials of the server, not of the users.
+// At least one of IDTokenAudience and IAPAudience must be set.
+type ServiceAccountConfig struct {
+	// CredentialsFile is the path of the service account key file,
+	// Application Default Credentials are used if it's empty.
+	CredentialsFile string
+	// IDTokenAudience is the audience of Google-signed ID tokens in the Authorization header,
+	// for example the OAuth client ID. ID tokens are not accepted if it's empty.
+	IDTokenAudience string
+	// IAPAudience is the audience of JWTs in the X-Goog-IAP-JWT-Assertion header,
+	// for example "/projects/PROJECT_NUMBER/apps/PROJECT_ID". They're not accepted if it's empty.
+	IAPAudience string
+	// AllowedEmails restricts the users to these emails and domains, for example
+	// "alice@example.com" or "example.com". All users with verified emails are accepted if it's empty.
+	AllowedEmails []string
+	// APIEndpoint is the URL of an emulator of Google APIs, like the one started by cmd/fake-service.
+	// If it's set, requests are sent there without credentials instead of to Google APIs.
+	APIEndpoint string
+}
+
+type serviceAccountAuthService struct {
+	service       automation.GoogleService
+	verifiers     []*oidc.IDTokenVerifier
+	allowedEmails []string
+}
+
+// NewServiceAccountAuthorizationService creates AuthorizationService, in which users don't log in,
+// but are identified by ID tokens or IAP JWTs, and all of them share the server's GoogleService.
+func NewServiceAccountAuthorizationService(ctx context.Context, config ServiceAccountConfig) (AuthorizationService, error) {
+	var service automation.GoogleService
+	var err error
+	if config.APIEndpoint != "" {
+		service, err = automation.NewEmulatedGoogleService(ctx, config.APIEndpoint)
+	} else {
+		var clientOptions []option.ClientOption
+		if config.CredentialsFile != "" {
+			clientOptions = append(clientOptions, option.WithCredentialsFile(config.CredentialsFile))
+		}
+		service, err = automation.NewGoogleServiceWithOptions(ctx, clientOptions...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	authService := &serviceAccountAuthService{service: service, allowedEmails: config.AllowedEmails}
+	if config.IDTokenAudience != "" {
+		provider, err := oidc.NewProvider(ctx, "https://accounts.google.com")
+		if err != nil {
+			return nil, err
+		}
+		authService.verifiers = append(authService.verifiers, provider.Verifier(&oidc.Config{ClientID: config.IDTokenAudience}))
+	}
+	if config.IAPAudience != "" {
+		keySet := oidc.NewRemoteKeySet(context.Background(), iapKeysURL)
+		authService.verifiers = append(authService.verifiers, oidc.NewVerifier(iapIssuer, keySet,
+			&oidc.Config{ClientID: config.IAPAudience, SupportedSigningAlgs: []string{oidc.ES256}}))
+	}
+	if len(authService.verifiers) == 0 {
+		return nil, fmt.Errorf("audience of ID tokens or IAP JWTs must be specified")
+	}
+	return authService, nil
+}
+
+// ParseAllowedEmails parses comma-separated emails and domains, like "alice@example.com,example.com".
+func ParseAllowedEmails(value string) []string {
+	var emails []string
+	for _, email := range strings.Split(value, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, strings.ToLower(strings.TrimPrefix(email, "@")))
+		}
+	}
+	return emails
+}
+
+// emailAllowed checks whether the email is one of allowedEmails, or in one of the domains there.
+// All emails are allowed if allowedEmails is empty.
+func emailAllowed(email string, allowedEmails []string) bool {
+	if len(allowedEmails) == 0 {
+		return true
+	}
+	email = strings.ToLower(email)
+	for _, allowed := range allowedEmails {
+		if strings.Contains(allowed, "@") {
+			if email == allowed {
+				return true
+			}
+		} else if strings.HasSuffix(email, "@"+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthCodeURL returns empty URL, as users don't log in.
+func (s *serviceAccountAuthService) AuthCodeURL(options ...oauth2.AuthCodeOption) string {
+	return ""
+}
+
+// CreateUser always fails, as users don't log in.
+func (s *serviceAccountAuthService) CreateUser(authCode string) (string, error) {
+	return "", &googleapi.Error{Code: http.StatusBadRequest,
+		Message: "Logging in is not supported, the server uses its own credentials"}
+}
+
+// Verify checks that the token is an ID token or IAP JWT with verified email, which is allowed, and returns the email.
+func (s *serviceAccountAuthService) Verify(rawToken string) (string, error) {
+	var lastErr error
+	for _, verifier := range s.verifiers {
+		idToken, err := verifier.Verify(oauth2.NoContext, rawToken)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var claims struct {
+			Email         string `json:"email"`
+			EmailVerified *bool  `json:"email_verified"`
+		}
+		if err := idToken.Claims(&claims); err != nil {
+			return "", fmt.Errorf("Extracting email failed:" + err.Error())
+		}
+		if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+			return "", &googleapi.Error{Code: http.StatusUnauthorized, Message: "Token without verified email"}
+		}
+		if !emailAllowed(claims.Email, s.allowedEmails) {
+			return "", &googleapi.Error{Code: http.StatusForbidden, Message: fmt.Sprintf("User %s is not allowed", claims.Email)}
+		}
+		return claims.Email, nil
+	}
+	return "", &googleapi.Error{Code: http.StatusUnauthorized, Message: lastErr.Error()}
+}
+
+// GetUser returns the user with the server's GoogleService.
+func (s *serviceAccountAuthService) GetUser(email string) (User, bool) {
+	return User{service: s.service, email: email}, true
+}
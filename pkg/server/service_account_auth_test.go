@@ -0,0 +1,33 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailAllowed(t *testing.T) {
+	allowed := ParseAllowedEmails(" Alice@other.com, @example.com ,")
+	assert.Equal(t, []string{"alice@other.com", "example.com"}, allowed)
+	assert.True(t, emailAllowed("alice@other.com", allowed))
+	assert.True(t, emailAllowed("Bob@Example.com", allowed), "Users of listed domains should be allowed")
+	assert.False(t, emailAllowed("carol@other.com", allowed), "Only listed users of other domains should be allowed")
+	assert.False(t, emailAllowed("eve@notexample.com", allowed), "Domains should match entirely")
+	assert.True(t, emailAllowed("anyone@any.com", nil), "Everyone should be allowed without restriction")
+}
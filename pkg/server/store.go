@@ -0,0 +1,232 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Kinds of requests saved in RequestStore.
+const (
+	listRequestKind         = "list"
+	requirementsRequestKind = "requirements"
+	applyRequestKind        = "apply"
+	batchApplyRequestKind   = "batchApply"
+	revertRequestKind       = "revert"
+)
+
+// RequestRecord is the state of the request saved in RequestStore.
+// Progress is the last response sent while the request was in progress.
+// When the request is done, Result contains the content of the final response,
+// or ErrorMessage and ErrorCode describe the error.
+type RequestRecord struct {
+	Email     string          `json:"email"`
+	RequestID string          `json:"requestId"`
+	Kind      string          `json:"kind"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Progress  json.RawMessage `json:"progress,omitempty"`
+	Done      bool            `json:"done"`
+	Result    json.RawMessage `json:"result,omitempty"`
+
+	ErrorMessage string `json:"errorMessage,omitempty"`
+	ErrorCode    int    `json:"errorCode,omitempty"`
+
+	// NeedsReconciliation is set for applies, reverts and batches interrupted by a server restart.
+	// Some of the changes might have been made, so the resources should be checked by the user.
+	NeedsReconciliation bool `json:"needsReconciliation,omitempty"`
+
+	StartTime  time.Time `json:"startTime"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+func (r *RequestRecord) info() RequestInfo {
+	return RequestInfo{r.Email, r.RequestID}
+}
+
+// RequestStore persists the state of requests, so that it's not lost when the server restarts.
+type RequestStore interface {
+	// Save adds the record or replaces the one saved before for the same request.
+	Save(record *RequestRecord) error
+	// Delete deletes the record of the request, if it exists.
+	Delete(info RequestInfo) error
+	// List returns all saved records.
+	List() ([]*RequestRecord, error)
+	// Close releases the resources used by the store.
+	Close() error
+}
+
+// memoryRequestStore keeps records in memory, so they're lost on restart.
+// It's used when no persistent store is configured.
+type memoryRequestStore struct {
+	mutex   sync.Mutex
+	records map[RequestInfo]RequestRecord
+}
+
+// NewMemoryRequestStore creates RequestStore keeping records in memory.
+func NewMemoryRequestStore() RequestStore {
+	return &memoryRequestStore{records: make(map[RequestInfo]RequestRecord)}
+}
+
+func (s *memoryRequestStore) Save(record *RequestRecord) error {
+	s.mutex.Lock()
+	s.records[record.info()] = *record
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryRequestStore) Delete(info RequestInfo) error {
+	s.mutex.Lock()
+	delete(s.records, info)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryRequestStore) List() ([]*RequestRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var result []*RequestRecord
+	for _, record := range s.records {
+		record := record
+		result = append(result, &record)
+	}
+	return result, nil
+}
+
+func (s *memoryRequestStore) Close() error {
+	return nil
+}
+
+// interruptedMessage is the error of requests, other than applies, interrupted by a restart.
+const interruptedMessage = "the request was interrupted by a server restart, please start it again"
+
+// reconciliationMessage is the error message of applies interrupted by a restart.
+const reconciliationMessage = "the server was restarted while applying the recommendation, " +
+	"some of the changes might have been made, please check the state of the resources"
+
+func newRequestRecord(info RequestInfo, handler RequestHandler) *RequestRecord {
+	now := time.Now()
+	return &RequestRecord{
+		Email:      info.email,
+		RequestID:  info.requestID,
+		Kind:       handler.Kind(),
+		Params:     marshalContent(handler.Params()),
+		StartTime:  now,
+		UpdateTime: now,
+	}
+}
+
+// marshalContent encodes the content in JSON. Returns nil if that fails,
+// as the content is always created by the server, that won't happen in practice.
+func marshalContent(content interface{}) json.RawMessage {
+	result, err := json.Marshal(content)
+	if err != nil {
+		log.Printf("Error encoding request content: %v", err)
+		return nil
+	}
+	return result
+}
+
+// setRecordResponse marks the record done and saves the final response in it.
+func setRecordResponse(record *RequestRecord, response Response) {
+	record.Done = true
+	record.Progress = nil
+	if response.Error != nil {
+		record.ErrorMessage = response.Error.Error()
+		if googleErr, ok := response.Error.(*googleapi.Error); ok {
+			record.ErrorMessage = googleErr.Message
+			record.ErrorCode = googleErr.Code
+		}
+		return
+	}
+	record.Result = marshalContent(response.Content)
+}
+
+// finishInterruptedRecord marks the record of request interrupted by a restart done.
+// Applies and reverts are marked as needing reconciliation, other requests fail.
+// In batches, only the recommendations in progress need reconciliation.
+func finishInterruptedRecord(record *RequestRecord) {
+	switch record.Kind {
+	case applyRequestKind, revertRequestKind:
+		record.NeedsReconciliation = true
+		setRecordResponse(record, Response{Content: CheckStatusResponse{
+			Status:       needsReconciliationStatus,
+			ErrorMessage: reconciliationMessage,
+		}})
+		return
+	case batchApplyRequestKind:
+		response := interruptedBatchResponse(record)
+		record.NeedsReconciliation = response.Status == needsReconciliationStatus
+		setRecordResponse(record, Response{Content: response})
+		return
+	}
+	setRecordResponse(record, Response{Error: &googleapi.Error{
+		Code:    http.StatusServiceUnavailable,
+		Message: interruptedMessage,
+	}})
+}
+
+// interruptedBatchResponse returns the final response of the batch interrupted by a restart,
+// created from the last saved progress. If there's none, all recommendations might have been started.
+func interruptedBatchResponse(record *RequestRecord) BatchStatusResponse {
+	var response BatchStatusResponse
+	if len(record.Progress) == 0 || json.Unmarshal(record.Progress, &response) != nil {
+		var params BatchApplyRequest
+		if err := json.Unmarshal(record.Params, &params); err != nil {
+			log.Printf("Error decoding params of request %s: %v", record.RequestID, err)
+		}
+		response.Recommendations = nil
+		for _, name := range params.Names {
+			response.Recommendations = append(response.Recommendations,
+				&BatchItemStatus{Name: name, CheckStatusResponse: CheckStatusResponse{Status: inProgressStatus}})
+		}
+	}
+	response.interrupted()
+	return response
+}
+
+// storedRequestHandler returns the response of the request restored from RequestStore.
+type storedRequestHandler struct {
+	record *RequestRecord
+}
+
+func (h *storedRequestHandler) Start(ctx context.Context) {}
+
+func (h *storedRequestHandler) Kind() string {
+	return h.record.Kind
+}
+
+func (h *storedRequestHandler) Params() interface{} {
+	return h.record.Params
+}
+
+func (h *storedRequestHandler) GetResponse() (Response, bool) {
+	if h.record.ErrorMessage != "" {
+		if h.record.ErrorCode != 0 {
+			return Response{Error: &googleapi.Error{Code: h.record.ErrorCode, Message: h.record.ErrorMessage}}, true
+		}
+		return Response{Error: errors.New(h.record.ErrorMessage)}, true
+	}
+	return Response{Content: h.record.Result}, true
+}
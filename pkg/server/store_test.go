@@ -0,0 +1,161 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func newTestBoltStore(t *testing.T) (RequestStore, string, func()) {
+	dir, err := ioutil.TempDir("", "recomator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "requests.db")
+	store, err := NewBoltRequestStore(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, path, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltRequestStore(t *testing.T) {
+	store, _, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	record := &RequestRecord{Email: "email", RequestID: "1", Kind: listRequestKind, Params: json.RawMessage(`{"projects":["p"]}`)}
+	assert.NoError(t, store.Save(record))
+	record.Done = true
+	assert.NoError(t, store.Save(record), "Saving should replace the record")
+	assert.NoError(t, store.Save(&RequestRecord{Email: "email", RequestID: "2"}))
+
+	records, err := store.List()
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, RequestInfo{"email", "1"}, records[0].info())
+		assert.True(t, records[0].Done)
+		assert.JSONEq(t, `{"projects":["p"]}`, string(records[0].Params))
+	}
+
+	assert.NoError(t, store.Delete(RequestInfo{"email", "1"}))
+	records, err = store.List()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(records), "Only one record should be left")
+	}
+}
+
+// Checks that finished requests are saved and restored by a new map.
+func TestRequestsMapSavesRequests(t *testing.T) {
+	store, path, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	requests := NewRequestsMap()
+	assert.NoError(t, requests.UseStore(store))
+	info := RequestInfo{"email", "1"}
+	handler := &cancelableHandler{}
+	assert.NoError(t, requests.StartProcessing(info, handler))
+	requests.Cancel(info)
+	for {
+		records, err := store.List()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if len(records) == 1 && records[0].Done {
+			assert.Equal(t, "mock", records[0].Kind)
+			assert.Equal(t, "context canceled", records[0].ErrorMessage)
+			break
+		}
+	}
+
+	store.Close()
+	store, err := NewBoltRequestStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	restored := NewRequestsMap()
+	assert.NoError(t, restored.UseStore(store))
+	response, found := restored.GetResponse(info)
+	assert.True(t, found, "Request should be restored")
+	assert.EqualError(t, response.Error, "context canceled")
+	_, found = restored.GetResponse(info)
+	assert.True(t, found, "Result should be kept after getting the final response")
+	assert.True(t, restored.Delete(info))
+	records, err := store.List()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, len(records), "Request should be deleted from store")
+	}
+}
+
+// Checks that requests interrupted by a restart are finished when restored.
+func TestRestoreInterruptedRequests(t *testing.T) {
+	store := NewMemoryRequestStore()
+	store.Save(&RequestRecord{Email: "email", RequestID: "name", Kind: applyRequestKind})
+	store.Save(&RequestRecord{Email: "email", RequestID: "1", Kind: listRequestKind})
+
+	requests := NewRequestsMap()
+	assert.NoError(t, requests.UseStore(store))
+
+	response, found := requests.GetResponse(RequestInfo{"email", "name"})
+	if assert.True(t, found, "Apply should be restored") {
+		var status CheckStatusResponse
+		assert.NoError(t, json.Unmarshal(response.Content.(json.RawMessage), &status))
+		assert.Equal(t, needsReconciliationStatus, status.Status)
+	}
+
+	response, found = requests.GetResponse(RequestInfo{"email", "1"})
+	if assert.True(t, found, "Listing should be restored") {
+		googleErr, ok := response.Error.(*googleapi.Error)
+		if assert.True(t, ok, "googleapi.Error expected") {
+			assert.Equal(t, http.StatusServiceUnavailable, googleErr.Code)
+		}
+	}
+}
+
+// Checks that in a batch interrupted by a restart only the recommendations
+// in progress need reconciliation.
+func TestRestoreInterruptedBatch(t *testing.T) {
+	progress := marshalContent(newBatchStatusResponse([]*BatchItemStatus{
+		{Name: "done", CheckStatusResponse: CheckStatusResponse{Status: succeededStatus}},
+		{Name: "started", CheckStatusResponse: CheckStatusResponse{Status: inProgressStatus}},
+		{Name: "pending", CheckStatusResponse: CheckStatusResponse{Status: pendingStatus}},
+	}, false))
+	store := NewMemoryRequestStore()
+	store.Save(&RequestRecord{Email: "email", RequestID: "1", Kind: batchApplyRequestKind, Progress: progress})
+
+	requests := NewRequestsMap()
+	assert.NoError(t, requests.UseStore(store))
+
+	response, found := requests.GetResponse(RequestInfo{"email", "1"})
+	if assert.True(t, found, "Batch should be restored") {
+		var status BatchStatusResponse
+		assert.NoError(t, json.Unmarshal(response.Content.(json.RawMessage), &status))
+		assert.Equal(t, needsReconciliationStatus, status.Status)
+		expected := map[string]int{succeededStatus: 1, needsReconciliationStatus: 1, failedStatus: 1}
+		assert.Equal(t, expected, status.Counts)
+	}
+}
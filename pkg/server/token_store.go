@@ -0,0 +1,167 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore keeps OAuth tokens of users, so that their GoogleServices can be
+// created again after the server restarts or by another instance of the server.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// SaveToken saves the token of the user, replacing the previous one.
+	SaveToken(email string, token *oauth2.Token) error
+	// LoadToken returns the token of the user, or false if there is none.
+	LoadToken(email string) (*oauth2.Token, bool, error)
+}
+
+// storedToken is the part of oauth2.Token that is saved.
+// The refresh token is enough to get new access tokens,
+// the access token is kept so that it's not requested again before it expires.
+func storedToken(token *oauth2.Token) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
+}
+
+type memoryTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+// NewMemoryTokenStore creates TokenStore keeping the tokens in memory,
+// they're lost when the server stops. The tokens never leave the process,
+// so they're not encrypted.
+func NewMemoryTokenStore() TokenStore {
+	return &memoryTokenStore{tokens: make(map[string]*oauth2.Token)}
+}
+
+func (s *memoryTokenStore) SaveToken(email string, token *oauth2.Token) error {
+	s.mutex.Lock()
+	s.tokens[email] = storedToken(token)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryTokenStore) LoadToken(email string) (*oauth2.Token, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token, ok := s.tokens[email]
+	if !ok {
+		return nil, false, nil
+	}
+	copied := *token
+	return &copied, true, nil
+}
+
+type fileTokenStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewFileTokenStore creates TokenStore keeping every token in a separate file in dir,
+// encrypted with AES-GCM using key, which must be 16, 24 or 32 bytes long.
+// Instances of the server sharing dir and key can serve the same users.
+func NewFileTokenStore(dir string, key []byte) (TokenStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileTokenStore{dir: dir, aead: aead}, nil
+}
+
+// path returns the path of the file with the user's token.
+// The email is hashed, so that it's not visible in the file name.
+func (s *fileTokenStore) path(email string) string {
+	hash := sha256.Sum256([]byte(email))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+".token")
+}
+
+func (s *fileTokenStore) SaveToken(email string, token *oauth2.Token) error {
+	plaintext, err := json.Marshal(storedToken(token))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// email is authenticated, so that the token can't be moved to a file of another user
+	ciphertext := s.aead.Seal(nonce, nonce, plaintext, []byte(email))
+
+	// the file is replaced atomically, so that other instances never read a partial token
+	file, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(ciphertext); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path(email))
+}
+
+func (s *fileTokenStore) LoadToken(email string) (*oauth2.Token, bool, error) {
+	data, err := ioutil.ReadFile(s.path(email))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, false, fmt.Errorf("token of %s is corrupted", email)
+	}
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(email))
+	if err != nil {
+		return nil, false, fmt.Errorf("decrypting token of %s failed: %v", email, err)
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(plaintext, token); err != nil {
+		return nil, false, err
+	}
+	return token, true, nil
+}
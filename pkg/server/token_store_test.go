@@ -0,0 +1,110 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/googleinterns/recomator/pkg/automation"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+var testTokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "access",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh",
+		Expiry:       time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func newTestTokenDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "recomator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir, cleanup := newTestTokenDir(t)
+	defer cleanup()
+	store, err := NewFileTokenStore(dir, testTokenKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, ok, err := store.LoadToken("email")
+	assert.NoError(t, err)
+	assert.False(t, ok, "No token saved yet")
+
+	assert.NoError(t, store.SaveToken("email", testToken()))
+	token, ok, err := store.LoadToken("email")
+	if assert.NoError(t, err) && assert.True(t, ok) {
+		assert.Equal(t, testToken(), token)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if assert.Equal(t, 1, len(files), "Only the token file should be left") {
+		data, _ := ioutil.ReadFile(files[0])
+		assert.NotContains(t, string(data), "refresh", "Token should be encrypted")
+		assert.NotContains(t, files[0], "email", "Email shouldn't be in the file name")
+	}
+
+	// another store sharing the directory, for example of another instance of the server
+	other, err := NewFileTokenStore(dir, testTokenKey)
+	if assert.NoError(t, err) {
+		_, ok, err := other.LoadToken("email")
+		assert.NoError(t, err)
+		assert.True(t, ok, "Token should be shared between stores")
+	}
+
+	wrongKey, err := NewFileTokenStore(dir, []byte("fedcba9876543210fedcba9876543210"))
+	if assert.NoError(t, err) {
+		_, _, err := wrongKey.LoadToken("email")
+		assert.Error(t, err, "Token shouldn't be decrypted with another key")
+	}
+
+	_, err = NewFileTokenStore(dir, []byte("short"))
+	assert.Error(t, err, "Invalid key length")
+}
+
+// Checks that GetUser creates GoogleService from the saved token,
+// for example after restart, and reuses it later.
+func TestGetUserFromTokenStore(t *testing.T) {
+	store := NewMemoryTokenStore()
+	auth := &authorizationService{services: make(map[string]automation.GoogleService), tokens: store}
+
+	_, ok := auth.GetUser("email")
+	assert.False(t, ok, "User never logged in")
+
+	assert.NoError(t, store.SaveToken("email", testToken()))
+	user, ok := auth.GetUser("email")
+	if assert.True(t, ok, "User should be created from the saved token") {
+		assert.Equal(t, "email", user.email)
+		assert.NotNil(t, user.service)
+		again, _ := auth.GetUser("email")
+		assert.True(t, user.service == again.service, "GoogleService should be reused")
+	}
+}